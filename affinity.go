@@ -0,0 +1,101 @@
+package nap
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+)
+
+// session tracks the physical db last used by the reads of a context.
+type session struct {
+	last   int32 // Index of the last used physical db, -1 if none
+	sticky bool
+}
+
+type sessionKey struct{}
+
+// WithReadSession returns a copy of ctx whose reads are spread by weighted
+// random selection while avoiding the physical db used by the previous
+// read of the session, smoothing bursts of related reads across replicas.
+func WithReadSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{last: -1})
+}
+
+// WithStickySession is like WithReadSession but sends every read of the
+// session to the same physical db while it stays eligible.
+func WithStickySession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{last: -1, sticky: true})
+}
+
+// SetWeight sets the weight of the physical db at index i used by weighted
+// selection, such as within read sessions. Once weights are set, reads
+// without a selector are balanced across slaves by smooth weighted
+// round-robin. Physical dbs with a zero weight are only picked when no
+// other is eligible. The default weight is 1.
+func (db *DB) SetWeight(i int, weight int) {
+	if weight < 0 {
+		weight = 0
+	}
+
+	db.updateTopology(func(t *topology) error {
+		if i >= 0 && i < len(t.weights) {
+			t.weights[i] = weight
+			t.schedule = smoothWeighted(slaveWeights(t.weights))
+		}
+		return nil
+	})
+}
+
+// Weight returns the weight of the physical db at index i.
+func (db *DB) Weight(i int) int {
+	if t := db.topology(); i >= 0 && i < len(t.weights) {
+		return t.weights[i]
+	}
+	return 1
+}
+
+// sessionIndex returns the index of the physical db a read of s with ctx
+// goes to.
+func (db *DB) sessionIndex(ctx context.Context, s *session) int {
+	nodes := db.eligible(ctx)
+	last := int(atomic.LoadInt32(&s.last))
+
+	if s.sticky {
+		for _, i := range nodes {
+			if i == last {
+				return i
+			}
+		}
+	} else if len(nodes) > 1 {
+		for k, i := range nodes {
+			if i == last {
+				nodes = append(nodes[:k:k], nodes[k+1:]...)
+				break
+			}
+		}
+	}
+
+	i := db.weightedRandom(nodes)
+	atomic.StoreInt32(&s.last, int32(i))
+	return i
+}
+
+// weightedRandom picks one of nodes with a probability proportional to its weight.
+func (db *DB) weightedRandom(nodes []int) int {
+	total := 0
+	for _, i := range nodes {
+		total += db.Weight(i)
+	}
+
+	if total == 0 {
+		return nodes[rand.Intn(len(nodes))]
+	}
+
+	n := rand.Intn(total)
+	for _, i := range nodes {
+		if n -= db.Weight(i); n < 0 {
+			return i
+		}
+	}
+	return nodes[len(nodes)-1]
+}
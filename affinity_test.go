@@ -0,0 +1,62 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestReadSession(t *testing.T) {
+	db := wrap(make([]*sql.DB, 4), nil)
+	ctx := WithReadSession(context.Background())
+
+	last := -1
+	for i := 0; i < 100; i++ {
+		n := db.readIndex(ctx)
+		if n == 0 || n == last {
+			t.Fatalf("Read %d routed to %d after %d", i, n, last)
+		}
+		last = n
+	}
+}
+
+func TestStickySession(t *testing.T) {
+	db := wrap(make([]*sql.DB, 4), nil)
+	ctx := WithStickySession(context.Background())
+
+	first := db.readIndex(ctx)
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(ctx); n != first {
+			t.Fatalf("Sticky read routed to %d instead of %d", n, first)
+		}
+	}
+
+	db.StartDrill(Drill{SlavesDown: []int{first}})
+	if n := db.readIndex(ctx); n == first {
+		t.Errorf("Sticky read routed to down slave %d", n)
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	db := wrap(make([]*sql.DB, 4), nil)
+	db.SetWeight(1, 3)
+	db.SetWeight(2, 1)
+	db.SetWeight(3, 0)
+
+	counts := map[int]int{}
+	for i := 0; i < 4000; i++ {
+		counts[db.weightedRandom([]int{1, 2, 3})]++
+	}
+
+	if counts[3] != 0 {
+		t.Errorf("Zero weight node picked %d times", counts[3])
+	}
+
+	if ratio := float64(counts[1]) / float64(counts[2]); ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("Unexpected weighted distribution: %v", counts)
+	}
+
+	if w := db.Weight(0); w != 1 {
+		t.Errorf("Unexpected default weight: %d", w)
+	}
+}
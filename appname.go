@@ -0,0 +1,91 @@
+package nap
+
+import (
+	"net/url"
+	"strings"
+)
+
+// AppNameStatement returns the statement tagging a new connection with the
+// application name name, such as PostgresAppName.
+type AppNameStatement func(name string) string
+
+// PostgresAppName is an AppNameStatement setting the application_name of
+// PostgreSQL connections, as reported by pg_stat_activity.
+func PostgresAppName(name string) string {
+	return "SET application_name = '" + strings.Replace(name, "'", "''", -1) + "'"
+}
+
+// SetApplicationName tags the new connections to each physical db opened by
+// nap with an application name made of service and the role of the physical
+// db, such as "billing/slave", by running the statement returned by stmt,
+// so that DBAs can attribute server side load to nap roles at a glance.
+// Since connections are tagged when opened, those already open aren't
+// tagged until recycled. Connections failing to be tagged are discarded.
+// For drivers tagging connections by their DSN instead, such as MySQL, see
+// AppNameDSNs.
+func (db *DB) SetApplicationName(service string, stmt AppNameStatement) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tag := appNameTag{service, stmt}
+	db.appNames.Store(tag)
+	for i, c := range db.topology().connectors {
+		tag.apply(c, i)
+	}
+}
+
+// appNameTag is the application name set with SetApplicationName.
+type appNameTag struct {
+	service string
+	stmt    AppNameStatement
+}
+
+// apply tags the new connections of c, of the physical db at index i, with
+// the application name of its role, if set.
+func (tag appNameTag) apply(c *connector, i int) {
+	if c == nil || tag.stmt == nil {
+		return
+	}
+
+	name := appName(tag.service, i)
+	c.init.Store(tag.stmt(name))
+	c.appName.Store(name)
+}
+
+// ApplicationName returns the application name the connections to the
+// physical db at index i are tagged with, or "" if not tagged.
+func (db *DB) ApplicationName(i int) string {
+	if c, err := db.connector(i); err == nil {
+		name, _ := c.appName.Load().(string)
+		return name
+	}
+	return ""
+}
+
+// AppNameDSNs rewrites the semicolon separated data source names passed to
+// Open so that each tags its connections with an application name made of
+// service and the role of its physical db, as SetApplicationName does, by
+// calling tag with each data source name and its application name, such as
+// MySQLAppName.
+func AppNameDSNs(dataSourceNames, service string, tag func(dsn, name string) string) string {
+	dsns := strings.Split(dataSourceNames, ";")
+	for i, dsn := range dsns {
+		dsns[i] = tag(dsn, appName(service, i))
+	}
+	return strings.Join(dsns, ";")
+}
+
+// MySQLAppName adds the program_name connection attribute to a DSN of the
+// github.com/go-sql-driver/mysql driver, as reported by
+// performance_schema.session_connect_attrs.
+func MySQLAppName(dsn, name string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "connectionAttributes=" + url.QueryEscape("program_name:"+name)
+}
+
+func appName(service string, i int) string {
+	return service + "/" + roleLabels(i)["role"]
+}
@@ -0,0 +1,45 @@
+package nap
+
+import "testing"
+
+func TestSetApplicationName(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetApplicationName("billing", func(name string) string {
+		return "CREATE TEMP TABLE app AS SELECT '" + name + "' AS name"
+	})
+
+	for i, want := range []string{"billing/master", "billing/slave"} {
+		if got := db.ApplicationName(i); got != want {
+			t.Errorf("Unexpected application name of %d. Got: %q, Want: %q", i, got, want)
+		}
+
+		var name string
+		if err = db.topology().pdbs[i].QueryRow("SELECT name FROM app").Scan(&name); err != nil || name != want {
+			t.Errorf("Connection to %d not tagged: %q, %v", i, name, err)
+		}
+	}
+
+	db.SetApplicationName("billing", func(name string) string { return "NOT SQL" })
+	db.topology().pdbs[1].SetMaxIdleConns(0)
+	if err = db.topology().pdbs[1].Ping(); err == nil {
+		t.Error("Expected connections failing to be tagged to be discarded")
+	}
+}
+
+func TestAppNameDSNs(t *testing.T) {
+	got := AppNameDSNs("tcp(m)/db;tcp(s)/db?tls=true", "billing", MySQLAppName)
+	want := "tcp(m)/db?connectionAttributes=program_name%3Abilling%2Fmaster;" +
+		"tcp(s)/db?tls=true&connectionAttributes=program_name%3Abilling%2Fslave"
+	if got != want {
+		t.Errorf("Unexpected DSNs. Got: %q, Want: %q", got, want)
+	}
+
+	if got := PostgresAppName("o'neil/slave"); got != "SET application_name = 'o''neil/slave'" {
+		t.Errorf("Unexpected statement: %s", got)
+	}
+}
@@ -0,0 +1,142 @@
+package nap
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// ArgsFormatter renders query args wherever nap reports them, such as
+// in hooks, logs and traces, so that sensitive or bulky values can be
+// handled uniformly.
+type ArgsFormatter interface {
+	FormatArgs(args []interface{}) string
+}
+
+// ArgsFormatterFunc is an adapter to allow the use of ordinary functions
+// as an ArgsFormatter.
+type ArgsFormatterFunc func(args []interface{}) string
+
+// FormatArgs calls f(args).
+func (f ArgsFormatterFunc) FormatArgs(args []interface{}) string {
+	return f(args)
+}
+
+// ArgsPolicy is the default ArgsFormatter. Values of the Redact types are
+// replaced by a placeholder, values of the Hash types by a short SHA-256
+// digest, and strings and blobs longer than MaxLen are truncated.
+// Types are matched on the dynamic type of each arg, so defining
+// dedicated types such as `type Email string` marks PII.
+type ArgsPolicy struct {
+	MaxLen int            // Maximum rendered length of strings and blobs, 0 for no limit
+	Redact []reflect.Type // Types whose values are never rendered
+	Hash   []reflect.Type // Types whose values are rendered as a digest
+}
+
+// DefaultArgsFormatter is used until one is set with DB.SetArgsFormatter.
+var DefaultArgsFormatter ArgsFormatter = ArgsPolicy{MaxLen: 64}
+
+// FormatArgs implements the ArgsFormatter interface.
+func (p ArgsPolicy) FormatArgs(args []interface{}) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			parts[i] = named.Name + "=" + p.format(named.Value)
+		} else {
+			parts[i] = p.format(arg)
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (p ArgsPolicy) format(arg interface{}) string {
+	t := reflect.TypeOf(arg)
+	if matchType(p.Redact, t) {
+		return "<redacted>"
+	}
+
+	if matchType(p.Hash, t) {
+		sum := sha256.Sum256([]byte(fmt.Sprint(arg)))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+
+	switch v := arg.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		if p.MaxLen > 0 && len(v) > p.MaxLen {
+			return fmt.Sprintf("<%d bytes>", len(v))
+		}
+		return fmt.Sprintf("%q", v)
+	case string:
+		return fmt.Sprintf("%q", p.truncate(v))
+	default:
+		return p.truncate(fmt.Sprint(v))
+	}
+}
+
+func (p ArgsPolicy) truncate(s string) string {
+	if p.MaxLen <= 0 || len(s) <= p.MaxLen {
+		return s
+	}
+
+	n := p.MaxLen
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+func matchType(types []reflect.Type, t reflect.Type) bool {
+	for _, u := range types {
+		if u == t {
+			return true
+		}
+	}
+	return false
+}
+
+// argsFormatter wraps an ArgsFormatter so that any implementation can be
+// stored in an atomic.Value.
+type argsFormatter struct{ ArgsFormatter }
+
+// SetArgsFormatter sets the ArgsFormatter used to render query args, as
+// the FormattedArgs of the QueryInfo passed to the query, close and
+// AfterQuery or OnError hooks. If f is nil, DefaultArgsFormatter is used.
+func (db *DB) SetArgsFormatter(f ArgsFormatter) {
+	db.formatter.Store(argsFormatter{f})
+}
+
+// ArgsFormatter returns the ArgsFormatter used to render query args.
+func (db *DB) ArgsFormatter() ArgsFormatter {
+	if f, _ := db.formatter.Load().(argsFormatter); f.ArgsFormatter != nil {
+		return f.ArgsFormatter
+	}
+	return DefaultArgsFormatter
+}
+
+// formatArgs renders args with the ArgsFormatter for the hooks reporting
+// them, if any are set, so that operations not reported don't pay for it.
+// Args are copied so that they don't escape.
+func (db *DB) formatArgs(args []interface{}) string {
+	if len(args) == 0 || !db.reportsArgs() {
+		return ""
+	}
+	return db.ArgsFormatter().FormatArgs(append([]interface{}(nil), args...))
+}
+
+// reportsArgs reports whether hooks reporting args are set.
+func (db *DB) reportsArgs() bool {
+	if hook, _ := db.queryHook.Load().(QueryHook); hook != nil {
+		return true
+	}
+	if hook, _ := db.closeQuery.Load().(QueryHook); hook != nil {
+		return true
+	}
+	h, _ := db.hooks.Load().(*Hooks)
+	return h != nil && (h.AfterQuery != nil || h.OnError != nil)
+}
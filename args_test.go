@@ -0,0 +1,91 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type email string
+
+type password string
+
+func TestArgsPolicy(t *testing.T) {
+	p := ArgsPolicy{
+		MaxLen: 8,
+		Redact: []reflect.Type{reflect.TypeOf(password(""))},
+		Hash:   []reflect.Type{reflect.TypeOf(email(""))},
+	}
+
+	got := p.FormatArgs([]interface{}{
+		1,
+		"short",
+		"a long string",
+		make([]byte, 32),
+		nil,
+		password("hunter2"),
+		sql.Named("who", email("jane@example.com")),
+	})
+
+	want := `[1, "short", "a long s…", <32 bytes>, NULL, <redacted>, who=sha256:`
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("Unexpected formatting. Got: %s, Want prefix: %s", got, want)
+	}
+
+	if strings.Contains(got, "jane") || strings.Contains(got, "hunter2") {
+		t.Errorf("Sensitive args leaked: %s", got)
+	}
+}
+
+func TestSetArgsFormatter(t *testing.T) {
+	db := &DB{}
+	if got := db.ArgsFormatter().FormatArgs([]interface{}{"x"}); got != `["x"]` {
+		t.Errorf("Expected the default args formatter. Got: %s", got)
+	}
+
+	db.SetArgsFormatter(ArgsFormatterFunc(func([]interface{}) string { return "-" }))
+	if got := db.ArgsFormatter().FormatArgs([]interface{}{1}); got != "-" {
+		t.Errorf("Custom args formatter not used. Got: %s", got)
+	}
+}
+
+func TestFormattedArgs(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	calls := 0
+	db.SetArgsFormatter(ArgsFormatterFunc(func(args []interface{}) string {
+		calls++
+		return fmt.Sprintf("%d args", len(args))
+	}))
+
+	db.QueryRow("SELECT ?", 1).Scan(new(int))
+	if calls != 0 {
+		t.Errorf("Args formatted without hooks: %d calls", calls)
+	}
+
+	var got, closed []string
+	db.SetQueryHook(func(ctx context.Context, info QueryInfo) {
+		got = append(got, info.FormattedArgs)
+	})
+	db.SetCloseQueryHook(func(ctx context.Context, info QueryInfo) {
+		closed = append(closed, info.FormattedArgs)
+	})
+
+	db.Exec("SELECT ?, ?", 1, 2)
+	db.QueryRow("SELECT ?", 1).Scan(new(int))
+	db.QueryRow("SELECT 1").Scan(new(int))
+
+	if want := []string{"2 args", "1 args", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected formatted args. Got: %q, Want: %q", got, want)
+	}
+	if want := []string{"1 args", ""}; !reflect.DeepEqual(closed, want) {
+		t.Errorf("Unexpected formatted args of the close hook. Got: %q, Want: %q", closed, want)
+	}
+}
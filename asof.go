@@ -0,0 +1,124 @@
+package nap
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type asOfKey struct{}
+
+// asOfReadKey marks the contexts of reads rewritten to run as of a timestamp.
+type asOfReadKey struct{}
+
+// WithReadAsOf returns a copy of ctx whose reads run as of ts, for engines
+// supporting time-travel queries such as CockroachDB. Reads are rewritten
+// by the AsOfRewriter set with DB.SetAsOfRewriter, and since they can't
+// observe replication lag, those rewritten are balanced across the master
+// and the slaves alike. Prepared statements can't be rewritten and are
+// routed as usual.
+func WithReadAsOf(ctx context.Context, ts time.Time) context.Context {
+	return context.WithValue(ctx, asOfKey{}, ts)
+}
+
+// AsOfRewriter rewrites a read to run as of ts, reporting whether it could.
+type AsOfRewriter func(query string, ts time.Time) (string, bool)
+
+// CockroachAsOf is an AsOfRewriter adding an AS OF SYSTEM TIME clause after
+// the FROM clause of single SELECT statements, as supported by CockroachDB.
+// Queries with subqueries or literals in their FROM clause aren't rewritten.
+func CockroachAsOf(query string, ts time.Time) (string, bool) {
+	return insertAsOf(query, "AS OF SYSTEM TIME '"+ts.UTC().Format("2006-01-02 15:04:05.999999")+"'")
+}
+
+// SetAsOfRewriter sets the AsOfRewriter of the reads of contexts set up with
+// WithReadAsOf. If r is nil, these reads run as usual.
+func (db *DB) SetAsOfRewriter(r AsOfRewriter) {
+	db.asOf.Store(r)
+}
+
+// readAsOf rewrites a read with ctx to run as of the timestamp of ctx, if
+// any, marking the returned ctx so that the read may go to any node.
+func (db *DB) readAsOf(ctx context.Context, query string) (context.Context, string) {
+	ts, ok := ctx.Value(asOfKey{}).(time.Time)
+	if !ok {
+		return ctx, query
+	}
+
+	r, _ := db.asOf.Load().(AsOfRewriter)
+	if r == nil {
+		return ctx, query
+	}
+
+	if q, ok := r(query, ts); ok {
+		return context.WithValue(ctx, asOfReadKey{}, true), q
+	}
+	return ctx, query
+}
+
+// readsAsOf reports whether a read with ctx was rewritten to run as of
+// a timestamp.
+func readsAsOf(ctx context.Context) bool {
+	return ctx.Value(asOfReadKey{}) != nil
+}
+
+// fromClauseEnd lists the keywords ending a FROM clause.
+var fromClauseEnd = []string{"WHERE", "GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT", "OFFSET", "FOR"}
+
+// insertAsOf inserts clause after the FROM clause of query.
+func insertAsOf(query, clause string) (string, bool) {
+	q := strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+	upper := strings.ToUpper(q)
+	if !strings.HasPrefix(upper, "SELECT") || indexWord(upper, "AS OF", 0) >= 0 {
+		return query, false
+	}
+
+	for _, op := range []string{"UNION", "INTERSECT", "EXCEPT"} {
+		if indexWord(upper, op, 0) >= 0 {
+			return query, false
+		}
+	}
+
+	from := indexWord(upper, "FROM", 0)
+	if from < 0 {
+		return query, false
+	}
+
+	end := len(q)
+	for _, kw := range fromClauseEnd {
+		if i := indexWord(upper, kw, from); i >= 0 && i < end {
+			end = i
+		}
+	}
+
+	if strings.ContainsAny(q[from:end], "('\"`") {
+		return query, false
+	}
+
+	head, tail := strings.TrimRight(q[:end], " \t\r\n"), q[end:]
+	if tail == "" {
+		return head + " " + clause, true
+	}
+	return head + " " + clause + " " + tail, true
+}
+
+// indexWord returns the index of the first occurrence of word in s at or
+// after start delimited by whitespace or the end of s, or -1.
+func indexWord(s, word string, start int) int {
+	for i := start; i+len(word) <= len(s); i++ {
+		if s[i:i+len(word)] != word {
+			continue
+		}
+
+		before := i == 0 || isSpace(s[i-1])
+		after := i+len(word) == len(s) || isSpace(s[i+len(word)])
+		if before && after {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,83 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCockroachAsOf(t *testing.T) {
+	ts := time.Date(2020, 5, 1, 10, 30, 0, 500000000, time.UTC)
+	clause := "AS OF SYSTEM TIME '2020-05-01 10:30:00.5'"
+
+	for query, want := range map[string]string{
+		"SELECT * FROM t":                                  "SELECT * FROM t " + clause,
+		"select a FROM t WHERE a > ?;":                     "select a FROM t " + clause + " WHERE a > ?",
+		"SELECT * FROM a JOIN b ON a.id = b.id ORDER BY 1": "SELECT * FROM a JOIN b ON a.id = b.id " + clause + " ORDER BY 1",
+		"SELECT x FROM t\nLIMIT 1":                         "SELECT x FROM t " + clause + " LIMIT 1",
+		"SELECT id FROM t WHERE id IN (SELECT 1)":          "SELECT id FROM t " + clause + " WHERE id IN (SELECT 1)",
+	} {
+		if got, ok := CockroachAsOf(query, ts); !ok || got != want {
+			t.Errorf("Unexpected rewrite of %q. Got: %q, %t, Want: %q", query, got, ok, want)
+		}
+	}
+
+	for _, query := range []string{
+		"INSERT INTO t VALUES (1)",
+		"SELECT 1",
+		"SELECT * FROM (SELECT 1) s",
+		"SELECT * FROM t AS OF SYSTEM TIME '-1s'",
+		"SELECT 1 FROM a UNION SELECT 2 FROM b",
+	} {
+		if got, ok := CockroachAsOf(query, ts); ok || got != query {
+			t.Errorf("Unexpected rewrite of %q: %q", query, got)
+		}
+	}
+}
+
+func TestReadAsOf(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var queries []string
+	db.SetAsOfRewriter(func(query string, ts time.Time) (string, bool) {
+		queries = append(queries, query)
+		return query, query != "SELECT 0"
+	})
+
+	ctx := WithReadAsOf(context.Background(), time.Now())
+	nodes := map[int]bool{}
+	for i := 0; i < 6; i++ {
+		rows, err := db.QueryContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[rows.Node()] = true
+		rows.Close()
+	}
+
+	if len(nodes) != 3 {
+		t.Errorf("As of reads not balanced across every node: %v", nodes)
+	}
+
+	for i := 0; i < 4; i++ {
+		row := db.QueryRowContext(ctx, "SELECT 0")
+		if row.Scan(new(int)); row.Node() == 0 {
+			t.Errorf("Read which couldn't be rewritten went to the master")
+		}
+	}
+
+	if len(queries) != 10 {
+		t.Errorf("Unexpected number of rewritten reads. Got: %d, Want: 10", len(queries))
+	}
+
+	for i := 0; i < 4; i++ {
+		row := db.QueryRow("SELECT 1")
+		if row.Scan(new(int)); row.Node() == 0 {
+			t.Errorf("Read without a timestamp went to the master")
+		}
+	}
+}
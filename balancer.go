@@ -0,0 +1,64 @@
+package nap
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// BalancerState is a serializable snapshot of the internal state of the
+// read balancer, meant for debugging uneven traffic distribution.
+type BalancerState struct {
+	Policy   string              `json:"policy"`
+	Counter  uint64              `json:"counter"`            // Reads balanced so far
+	Selector string              `json:"selector,omitempty"` // Default read selector
+	Nodes    []BalancerNodeState `json:"nodes"`
+}
+
+// BalancerNodeState is the balancer state of a single physical db.
+type BalancerNodeState struct {
+	Index    int     `json:"index"`
+	Labels   Labels  `json:"labels"`
+	Eligible bool    `json:"eligible"` // Whether reads without a context selector may go to it
+	Weight   int     `json:"weight"`
+	Healthy  bool    `json:"healthy"`
+	Health   float64 `json:"health"` // Smoothed health check success rate
+	Score    float64 `json:"score"`  // Score combining the signals of the physical db
+}
+
+// BalancerState returns a snapshot of the read balancer state.
+func (db *DB) BalancerState() BalancerState {
+	sel := db.readSelector(context.Background())
+	state := BalancerState{
+		Policy:   "round-robin",
+		Counter:  atomic.LoadUint64(&db.count),
+		Selector: sel.String(),
+		Nodes:    make([]BalancerNodeState, len(db.topology().pdbs)),
+	}
+
+	if name := db.policyName(); name != "" {
+		state.Policy = name
+	} else if fn, _ := db.scoring.Load().(ScoreFunc); fn != nil {
+		state.Policy = "scored"
+	} else if len(db.topology().schedule) > 0 {
+		state.Policy = "smooth-weighted"
+	}
+
+	eligible := map[int]bool{}
+	for _, i := range db.eligible(context.Background()) {
+		eligible[i] = true
+	}
+
+	for i := range state.Nodes {
+		state.Nodes[i] = BalancerNodeState{
+			Index:    i,
+			Labels:   db.Labels(i),
+			Eligible: eligible[i],
+			Weight:   db.Weight(i),
+			Healthy:  db.Healthy(i),
+			Health:   db.HealthScore(i),
+			Score:    db.Score(i),
+		}
+	}
+
+	return state
+}
@@ -0,0 +1,35 @@
+package nap
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+)
+
+func TestBalancerState(t *testing.T) {
+	db := wrap(make([]*sql.DB, 3), nil)
+	db.SetLabels(2, Labels{"zone": "eu"})
+	db.slave(3)
+	db.slave(3)
+
+	state := db.BalancerState()
+	if state.Counter != 2 || state.Policy != "round-robin" {
+		t.Errorf("Unexpected balancer state: %+v", state)
+	}
+
+	for i, want := range []bool{false, true, true} {
+		if got := state.Nodes[i].Eligible; got != want {
+			t.Errorf("Node %d eligible = %t, want %t", i, got, want)
+		}
+	}
+
+	db.SetReadSelector(MustParseSelector("zone=eu"))
+	state = db.BalancerState()
+	if state.Selector != "zone=eu" || state.Nodes[1].Eligible || !state.Nodes[2].Eligible {
+		t.Errorf("Default selector not reflected: %+v", state)
+	}
+
+	if _, err := json.Marshal(state); err != nil {
+		t.Errorf("Balancer state not serializable: %s", err)
+	}
+}
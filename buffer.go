@@ -0,0 +1,223 @@
+package nap
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// ErrBufferFull is returned by DB.QueryBuffered when rows exceed the memory
+// limits of their buffer and can't spill to disk.
+var ErrBufferFull = errors.New("nap: buffer full")
+
+func init() {
+	gob.Register(time.Time{}) // Spilled along the basic types of driver values
+}
+
+// BufferOptions limits the memory buffering the rows of DB.QueryBuffered.
+// Zero limits are unlimited.
+type BufferOptions struct {
+	MaxRows  int    // Max rows kept in memory
+	MaxBytes int64  // Max bytes of the values kept in memory
+	SpillDir string // Directory of the temporary file rows beyond the limits spill to, or ErrBufferFull if empty
+}
+
+// BufferedRows are rows read ahead of their consumer by DB.QueryBuffered.
+// Unlike Rows, they don't hold the connection they were read from.
+type BufferedRows struct {
+	columns []string
+	mem     [][]interface{} // Rows kept in memory
+	spill   *os.File        // Rows spilled beyond the memory limits, if any
+	dec     *gob.Decoder
+	rows    *sql.Rows // Replaying the buffered rows
+	node    int
+}
+
+// QueryBuffered executes a query that returns rows, like QueryContext, and
+// reads them all ahead of their consumer, so that a slow consumer doesn't
+// hold the connection open. Rows are kept in memory within the limits of
+// opts, and spill to a temporary file beyond them, removed on Close.
+func (db *DB) QueryBuffered(ctx context.Context, opts BufferOptions, query string, args ...interface{}) (*BufferedRows, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	b := &BufferedRows{node: rows.Node()}
+	if b.columns, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+
+	if err = b.fill(rows, opts); err == nil {
+		err = rows.Err()
+	}
+	if err == nil {
+		err = b.rewind()
+	}
+	if err == nil {
+		b.rows, err = memoDB.QueryContext(context.Background(), "", bufferedSource{b})
+	}
+
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// fill reads rows into b, within the limits of opts.
+func (b *BufferedRows) fill(rows *Rows, opts BufferOptions) error {
+	var enc *gob.Encoder
+	var w *bufio.Writer
+	var size int64
+	for rows.Next() {
+		row := make([]interface{}, len(b.columns))
+		dest := make([]interface{}, len(row))
+		for k := range row {
+			dest[k] = &row[k]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		if enc == nil {
+			for _, d := range dest {
+				size += valueSize(d)
+			}
+
+			if (opts.MaxRows <= 0 || len(b.mem) < opts.MaxRows) && (opts.MaxBytes <= 0 || size <= opts.MaxBytes) {
+				b.mem = append(b.mem, row)
+				continue
+			}
+
+			if opts.SpillDir == "" {
+				return ErrBufferFull
+			}
+
+			f, err := ioutil.TempFile(opts.SpillDir, "nap-rows-")
+			if err != nil {
+				return err
+			}
+			b.spill, w = f, bufio.NewWriter(f)
+			enc = gob.NewEncoder(w)
+		}
+
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	if w != nil {
+		return w.Flush()
+	}
+	return nil
+}
+
+// rewind prepares the spilled rows, if any, for reading.
+func (b *BufferedRows) rewind() error {
+	if b.spill == nil {
+		return nil
+	}
+
+	if _, err := b.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	b.dec = gob.NewDecoder(bufio.NewReader(b.spill))
+	return nil
+}
+
+// Node returns the index of the physical db which served the query.
+func (b *BufferedRows) Node() int {
+	return b.node
+}
+
+// Spilled reports whether rows spilled to disk.
+func (b *BufferedRows) Spilled() bool {
+	return b.spill != nil
+}
+
+// Columns returns the column names.
+func (b *BufferedRows) Columns() []string {
+	return b.columns
+}
+
+// Next prepares the next result row for reading with Scan, like
+// (*sql.Rows).Next.
+func (b *BufferedRows) Next() bool {
+	return b.rows.Next()
+}
+
+// Scan copies the columns in the current row into the values pointed at
+// by dest, like (*sql.Rows).Scan, since the buffered rows are scanned by
+// database/sql exactly like the rows of a physical db.
+func (b *BufferedRows) Scan(dest ...interface{}) error {
+	return b.rows.Scan(dest...)
+}
+
+// Err returns the error, if any, that was encountered while reading spilled
+// rows.
+func (b *BufferedRows) Err() error {
+	return b.rows.Err()
+}
+
+// Close releases the rows, removing their spill file, if any.
+func (b *BufferedRows) Close() error {
+	var err error
+	if b.rows != nil {
+		err = b.rows.Close()
+	}
+
+	b.mem, b.dec = nil, nil
+	if b.spill == nil {
+		return err
+	}
+
+	f := b.spill
+	b.spill = nil
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// bufferedSource is the driver.Rows of the rows buffered in its
+// BufferedRows, replayed through memoDB.
+type bufferedSource struct {
+	b *BufferedRows
+}
+
+func (s bufferedSource) Columns() []string { return s.b.columns }
+func (s bufferedSource) Close() error      { return nil }
+
+func (s bufferedSource) Next(dest []driver.Value) error {
+	b := s.b
+	var row []interface{}
+	switch {
+	case len(b.mem) > 0:
+		row, b.mem = b.mem[0], b.mem[1:]
+	case b.dec == nil:
+		return io.EOF
+	default:
+		if err := b.dec.Decode(&row); err != nil {
+			b.dec = nil
+			return err // Including io.EOF
+		}
+	}
+
+	for k, v := range row {
+		dest[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestQueryBuffered(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec("CREATE TABLE t (i INTEGER, s TEXT, f REAL, n TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = db.Exec("INSERT INTO t VALUES (?, ?, ?, NULL)", i, "row", 0.5); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	if _, err = db.QueryBuffered(ctx, BufferOptions{MaxRows: 5}, "SELECT * FROM t"); err != ErrBufferFull {
+		t.Errorf("Want ErrBufferFull, got: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "nap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := db.QueryBuffered(ctx, BufferOptions{MaxBytes: 10, SpillDir: dir}, "SELECT * FROM t ORDER BY i")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Spilled() {
+		t.Error("Rows beyond the limits not spilled")
+	}
+
+	// The connection was released, so the single one is available
+	if _, err = db.Exec("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for b.Next() {
+		var i int32 // Converted like database/sql does
+		var s string
+		var f float32
+		var null sql.NullString
+		if err = b.Scan(&i, &s, &f, &null); err != nil {
+			t.Fatal(err)
+		}
+		if int(i) != n || s != "row" || f != 0.5 || null.Valid {
+			t.Errorf("Unexpected row: %d, %q, %v, %+v", i, s, f, null)
+		}
+		n++
+	}
+
+	if err = b.Err(); err != nil || n != 10 {
+		t.Errorf("Rows read: %d, %v", n, err)
+	}
+
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Error("Spill file not removed")
+	}
+}
@@ -0,0 +1,141 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+)
+
+type bulkKey struct{}
+
+// WithBulkLane returns a copy of ctx whose operations run in the bulk lane
+// set with SetBulkLane, such as those of backfills, migrations and exports.
+func WithBulkLane(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bulkKey{}, true)
+}
+
+// SetBulkLane sets the budget of connections of the bulk lane of each
+// physical db opened by Open. Operations with a context returned by
+// WithBulkLane are routed as usual, but run on a separate pool of at most n
+// connections to the physical db they are routed to, so that they don't
+// compete with interactive operations for slots of the main pools, nor
+// count against their checkout timeout. Prepared statements run their SQL
+// on the bulk lane without being prepared there. Physical dbs not opened
+// by Open have no bulk lane. If n <= 0, the bulk lane is closed and bulk
+// operations share the main pools, which is the default.
+func (db *DB) SetBulkLane(n int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	atomic.StoreInt32(&db.bulkConns, int32(n))
+	db.bulk.Range(func(k, v interface{}) bool {
+		if n > 0 {
+			v.(*sql.DB).SetMaxOpenConns(n)
+		} else {
+			db.bulk.Delete(k)
+			go v.(*sql.DB).Close() // Waits for the operations in flight
+		}
+		return true
+	})
+}
+
+// BulkLaneStats returns the stats of the bulk lane pool of the physical db
+// at index i, reporting false if it has none open.
+func (db *DB) BulkLaneStats(i int) (sql.DBStats, bool) {
+	if t := db.topology(); i >= 0 && i < len(t.pdbs) {
+		if v, ok := db.bulk.Load(t.pdbs[i]); ok {
+			return v.(*sql.DB).Stats(), true
+		}
+	}
+	return sql.DBStats{}, false
+}
+
+// lanePDB returns the pool operations with ctx run on for the physical db
+// at index i.
+func (db *DB) lanePDB(ctx context.Context, i int) *sql.DB {
+	if pdb := db.bulkPDB(ctx, i); pdb != nil {
+		return pdb
+	}
+	return db.topology().pdb(i)
+}
+
+// bulkPDB returns the bulk lane pool of the physical db at index i if ctx
+// runs in it, opening it on first use, or nil otherwise.
+func (db *DB) bulkPDB(ctx context.Context, i int) *sql.DB {
+	if !db.bulkLane(ctx) {
+		return nil
+	}
+
+	t := db.topology()
+	if i < 0 || i >= len(t.pdbs) || t.connectors[i] == nil {
+		return nil
+	}
+
+	if v, ok := db.bulk.Load(t.pdbs[i]); ok {
+		return v.(*sql.DB)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if v, ok := db.bulk.Load(t.pdbs[i]); ok {
+		return v.(*sql.DB)
+	}
+
+	n := atomic.LoadInt32(&db.bulkConns)
+	select {
+	case <-db.done():
+		return nil
+	default:
+		if n <= 0 {
+			return nil
+		}
+	}
+
+	pdb := sql.OpenDB(laneConnector{t.connectors[i]})
+	pdb.SetMaxOpenConns(int(n))
+	db.bulk.Store(t.pdbs[i], pdb)
+	return pdb
+}
+
+// bulkLane reports whether operations with ctx run in the bulk lane.
+func (db *DB) bulkLane(ctx context.Context) bool {
+	return atomic.LoadInt32(&db.bulkConns) > 0 && ctx.Value(bulkKey{}) != nil
+}
+
+// execMaster runs s on the master, in the bulk lane if ctx runs in it.
+func (s *Stmt) execMaster(ctx context.Context, set *stmtSet, args []interface{}) (sql.Result, error) {
+	if pdb := s.db.bulkPDB(ctx, 0); pdb != nil {
+		return pdb.ExecContext(ctx, s.db.preparedSQL(s.query), args...)
+	}
+	return set.stmts[0].ExecContext(ctx, args...)
+}
+
+// closeBulk closes the bulk lane pool of pdb, if any, once the operations
+// in flight on it are done.
+func (db *DB) closeBulk(pdb *sql.DB) {
+	db.mu.Lock()
+	v, ok := db.bulk.LoadAndDelete(pdb)
+	db.mu.Unlock()
+
+	if ok {
+		v.(*sql.DB).Close()
+	}
+}
+
+// laneConnector dials the connections of a lane with the connector of the
+// main pool of a physical db, which it doesn't close.
+type laneConnector struct {
+	c *connector
+}
+
+// Connect implements the driver.Connector interface.
+func (l laneConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return l.c.Connect(ctx)
+}
+
+// Driver implements the driver.Connector interface.
+func (l laneConnector) Driver() driver.Driver {
+	return l.c.Driver()
+}
@@ -0,0 +1,92 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBulkLane(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	// Interactive operations hold every connection of the main pools.
+	tx, err := db.Master().Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	conn, err := db.topology().pdbs[1].Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	timeout := func(ctx context.Context) context.Context {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	bulk := WithBulkLane(context.Background())
+	if err = db.QueryRowContext(timeout(bulk), "SELECT 1").Scan(new(int)); err != context.DeadlineExceeded {
+		t.Errorf("Want context.DeadlineExceeded without a bulk lane, got: %v", err)
+	}
+
+	db.SetBulkLane(1)
+	if _, ok := db.BulkLaneStats(1); ok {
+		t.Error("Bulk lane opened before its first use")
+	}
+
+	row := db.QueryRowContext(timeout(bulk), "SELECT 1")
+	if err = row.Scan(new(int)); err != nil || row.Node() != 1 {
+		t.Errorf("Bulk read failed on %d: %v", row.Node(), err)
+	}
+	if err = stmt.QueryRowContext(timeout(bulk)).Scan(new(int)); err != nil {
+		t.Errorf("Bulk statement read failed: %v", err)
+	}
+	rows, err := stmt.QueryContext(timeout(bulk))
+	if err != nil {
+		t.Errorf("Bulk statement read failed: %v", err)
+	} else {
+		rows.Close()
+	}
+	if _, err = db.ExecContext(timeout(bulk), "SELECT 1"); err != nil {
+		t.Errorf("Bulk write failed: %v", err)
+	}
+	if _, err = stmt.ExecContext(timeout(bulk)); err != nil {
+		t.Errorf("Bulk statement write failed: %v", err)
+	}
+
+	btx, err := db.BeginTx(timeout(bulk), nil)
+	if err != nil {
+		t.Fatalf("Bulk transaction failed: %v", err)
+	}
+	btx.Rollback()
+
+	if s, ok := db.BulkLaneStats(0); !ok || s.MaxOpenConnections != 1 || s.OpenConnections != 1 {
+		t.Errorf("Unexpected bulk lane stats: %+v, %t", s, ok)
+	}
+	if err = db.QueryRowContext(timeout(context.Background()), "SELECT 1").Scan(new(int)); err != context.DeadlineExceeded {
+		t.Errorf("Want context.DeadlineExceeded outside the bulk lane, got: %v", err)
+	}
+
+	db.SetBulkLane(0)
+	if _, ok := db.BulkLaneStats(0); ok {
+		t.Error("Bulk lane still open once closed")
+	}
+	if err = db.QueryRowContext(timeout(bulk), "SELECT 1").Scan(new(int)); err != context.DeadlineExceeded {
+		t.Errorf("Want context.DeadlineExceeded once the bulk lane is closed, got: %v", err)
+	}
+}
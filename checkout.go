@@ -0,0 +1,82 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolExhausted is returned when no physical db could hand out a
+// connection within the configured connection checkout timeout.
+var ErrPoolExhausted = errors.New("nap: connection pool exhausted")
+
+// PoolExhaustedError describes a failed connection checkout.
+// It matches ErrPoolExhausted with errors.Is.
+type PoolExhaustedError struct {
+	Nodes []int         // Indexes of the physical dbs that were tried
+	Stats []sql.DBStats // Pool stats of each tried physical db
+}
+
+// Error implements the error interface.
+func (e *PoolExhaustedError) Error() string {
+	nodes := make([]string, len(e.Nodes))
+	for i, n := range e.Nodes {
+		nodes[i] = fmt.Sprintf("%d (%d/%d in use)", n, e.Stats[i].InUse, e.Stats[i].MaxOpenConnections)
+	}
+	return ErrPoolExhausted.Error() + ": " + strings.Join(nodes, ", ")
+}
+
+// Is reports whether target is ErrPoolExhausted.
+func (e *PoolExhaustedError) Is(target error) bool {
+	return target == ErrPoolExhausted
+}
+
+func (db *DB) checkoutTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&db.checkout))
+}
+
+// checkoutConn waits at most the checkout timeout for a connection
+// to the physical db at index i.
+func (db *DB) checkoutConn(ctx context.Context, i int) (*sql.Conn, error) {
+	cctx, cancel := context.WithTimeout(ctx, db.checkoutTimeout())
+	defer cancel()
+
+	pdb := db.topology().pdb(i)
+	conn, err := pdb.Conn(cctx)
+	if err != nil && ctx.Err() == nil && cctx.Err() == context.DeadlineExceeded {
+		return nil, &PoolExhaustedError{
+			Nodes: []int{i},
+			Stats: []sql.DBStats{pdb.Stats()},
+		}
+	}
+
+	return conn, err
+}
+
+// checkoutSlave checks out a connection for a read, overflowing to the
+// next eligible physical db each time the checkout timeout is exceeded.
+func (db *DB) checkoutSlave(ctx context.Context) (*sql.Conn, int, error) {
+	nodes := db.readNodes(ctx)
+	exhausted := &PoolExhaustedError{}
+	for _, i := range nodes {
+		conn, err := db.checkoutConn(ctx, i)
+		if e, ok := err.(*PoolExhaustedError); ok {
+			exhausted.Nodes = append(exhausted.Nodes, e.Nodes...)
+			exhausted.Stats = append(exhausted.Stats, e.Stats...)
+			continue
+		}
+		return conn, i, err
+	}
+
+	return nil, nodes[0], exhausted
+}
+
+// release returns conn to its pool once the rows or row obtained from it
+// are closed, without blocking the caller.
+func release(conn *sql.Conn) {
+	go conn.Close()
+}
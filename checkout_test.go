@@ -0,0 +1,81 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnCheckoutTimeout(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	db.SetConnCheckoutTimeout(10 * time.Millisecond)
+
+	ctx := context.Background()
+	held, err := db.topology().pdbs[1].Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One busy slave overflows to the other one.
+	for i := 0; i < 4; i++ {
+		rows, err := db.QueryContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatalf("Unexpected error with a free slave: %s", err)
+		}
+		rows.Close()
+	}
+
+	other, err := db.topology().pdbs[2].Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	_, err = db.QueryContext(ctx, "SELECT 1")
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("Want ErrPoolExhausted, got: %v", err)
+	}
+
+	if e := err.(*PoolExhaustedError); len(e.Nodes) != 2 || e.Stats[0].InUse != 1 {
+		t.Errorf("Unexpected pool stats attached: %+v", e)
+	}
+
+	held.Close()
+
+	var n int
+	if err = db.QueryRowContext(ctx, "SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Errorf("QueryRow failed after release. Got: %d, %v", n, err)
+	}
+}
+
+func TestConnCheckoutTimeoutMaster(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	db.SetConnCheckoutTimeout(10 * time.Millisecond)
+
+	if _, err = db.Exec("CREATE TABLE t (a INT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	held, err := db.Master().Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	if _, err = db.Exec("INSERT INTO t VALUES (1)"); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Want ErrPoolExhausted, got: %v", err)
+	}
+}
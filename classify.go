@@ -0,0 +1,112 @@
+package nap
+
+import "strings"
+
+// sqlClass is what nap tells of a statement from its SQL, classified once
+// by classifySQL for every routing decision depending on it.
+type sqlClass struct {
+	write  bool     // Whether it writes or locks rows, so must run on the master
+	tables []string // Tables following FROM and JOIN, lower cased and unquoted, including those of subqueries
+}
+
+// readVerbs lists the keywords starting statements which don't write.
+var readVerbs = map[string]bool{
+	"SELECT": true, "WITH": true, "VALUES": true, "TABLE": true, "SHOW": true,
+	"EXPLAIN": true, "DESCRIBE": true, "DESC": true,
+}
+
+// writeVerbs lists the keywords of statements writing anywhere in a read,
+// such as in a CTE, in EXPLAIN ANALYZE or to lock rows with FOR UPDATE.
+var writeVerbs = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true,
+}
+
+// clauseEnds lists the keywords ending a FROM list.
+var clauseEnds = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "WINDOW": true, "ORDER": true, "LIMIT": true,
+	"OFFSET": true, "FOR": true, "UNION": true, "INTERSECT": true, "EXCEPT": true,
+}
+
+// classifySQL classifies query in a single pass over the tokens of its
+// normalized SQL. Statements writing or locking rows are those not starting
+// with a reading keyword, such as DDL, and reads such as INSERT … RETURNING,
+// SELECT … FOR UPDATE or FOR SHARE, or a write wrapped in a CTE.
+func classifySQL(query string) sqlClass {
+	var c sqlClass
+	tokens := sqlTokens(normalizeSQL(query))
+
+	first := true
+	depth, from := 0, -1 // Depth of the FROM list being read, if any
+	expect := false      // Set when a table is expected next
+	for k, tok := range tokens {
+		upper := strings.ToUpper(tok)
+		if first && tok != "(" {
+			c.write, first = !readVerbs[upper], false
+		}
+
+		switch {
+		case writeVerbs[upper], upper == "INTO": // SELECT … INTO creates a table
+			c.write = true
+		case upper == "FOR" && k+1 < len(tokens):
+			if next := strings.ToUpper(tokens[k+1]); next == "SHARE" || next == "NO" || next == "KEY" {
+				c.write = true
+			}
+		case upper == "LOCK" && k+1 < len(tokens) && strings.EqualFold(tokens[k+1], "IN"):
+			c.write = true // LOCK IN SHARE MODE
+		}
+
+		switch {
+		case tok == "(":
+			depth++
+			expect = false
+		case tok == ")":
+			if depth--; depth < from {
+				from = -1
+			}
+		case tok == ",":
+			expect = from == depth
+		case upper == "FROM":
+			from, expect = depth, true
+		case upper == "JOIN":
+			expect = true
+		case clauseEnds[upper]:
+			if from == depth {
+				from = -1
+			}
+			expect = false
+		case expect && (upper == "ONLY" || upper == "LATERAL"):
+		case expect:
+			c.tables = append(c.tables, unquoteIdent(tok))
+			expect = false
+		}
+	}
+	return c
+}
+
+// sqlTokens splits normalized SQL into words, parentheses and commas.
+func sqlTokens(query string) []string {
+	var tokens []string
+	start := -1
+	for i := 0; i <= len(query); i++ {
+		if i < len(query) && !strings.ContainsRune(" (),;", rune(query[i])) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			tokens = append(tokens, query[start:i])
+			start = -1
+		}
+		if i < len(query) && query[i] != ' ' && query[i] != ';' {
+			tokens = append(tokens, query[i:i+1])
+		}
+	}
+	return tokens
+}
+
+// unquoteIdent lower cases ident and strips the quotes of its parts.
+func unquoteIdent(ident string) string {
+	return strings.ToLower(strings.NewReplacer(`"`, "", "`", "", "[", "", "]", "").Replace(ident))
+}
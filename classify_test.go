@@ -0,0 +1,50 @@
+package nap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifySQL(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SELECT * FROM users WHERE id = 1":                                             false,
+		"(SELECT 1) UNION (SELECT 2)":                                                  false,
+		"WITH recent AS (SELECT * FROM orders) SELECT * FROM recent":                   false,
+		"SELECT replace(name, 'a', 'b') FROM users":                                    false,
+		"SELECT * FROM users WHERE note = 'for update'":                                false,
+		`SELECT "update" FROM events`:                                                  false,
+		"EXPLAIN SELECT * FROM users":                                                  false,
+		"insert into users (name) values ('x') returning id":                           true,
+		"UPDATE users SET name = 'x' WHERE id = 1 RETURNING *":                         true,
+		"DELETE FROM users WHERE id = 1 RETURNING id":                                  true,
+		"SELECT * FROM users WHERE id = 1 FOR UPDATE":                                  true,
+		"SELECT * FROM users FOR NO KEY UPDATE SKIP LOCKED":                            true,
+		"SELECT * FROM users FOR SHARE":                                                true,
+		"SELECT * FROM users FOR KEY SHARE":                                            true,
+		"SELECT * FROM users LOCK IN SHARE MODE":                                       true,
+		"WITH gone AS (DELETE FROM users WHERE id = 1 RETURNING *) SELECT * FROM gone": true,
+		"SELECT * INTO archive FROM users":                                             true,
+		"EXPLAIN ANALYZE UPDATE users SET name = 'x'":                                  true,
+		"/* cid=1 */ CALL refresh()":                                                   true,
+		"CREATE TABLE users (id INTEGER)":                                              true,
+	} {
+		if got := classifySQL(query).write; got != want {
+			t.Errorf("Unexpected write classification of %q. Got: %v, Want: %v", query, got, want)
+		}
+	}
+}
+
+func TestClassifySQLTables(t *testing.T) {
+	for query, want := range map[string][]string{
+		"SELECT * FROM payments WHERE id = 1":                                    {"payments"},
+		`select a.x FROM public."Payments" a JOIN users u ON a.uid = u.id, logs`: {`public.payments`, "users", "logs"},
+		"SELECT * FROM a, b AS c WHERE x IN (SELECT y FROM d) ORDER BY 1":        {"a", "b", "d"},
+		"SELECT * FROM (SELECT * FROM a) s LEFT JOIN LATERAL (SELECT 1) l ON 1":  {"a"},
+		"SELECT 'FROM x' /* FROM y */ FROM ONLY `z`; -- JOIN w":                  {"z"},
+		"SELECT 1": nil,
+	} {
+		if got := classifySQL(query).tables; !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected tables of %q. Got: %q, Want: %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync/atomic"
+)
+
+// ResetFunc cleans up the session state of a driver connection, such as
+// temporary tables or roles, before it is reused from the pool.
+type ResetFunc func(ctx context.Context, conn driver.Conn) error
+
+// ResetStatements returns a ResetFunc executing stmts in order, such as
+// "DISCARD ALL" or "RESET ROLE".
+func ResetStatements(stmts ...string) ResetFunc {
+	return func(ctx context.Context, conn driver.Conn) error {
+		for _, stmt := range stmts {
+			if err := execDriver(ctx, conn, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SetConnResetter sets the ResetFunc run on connections to the physical db
+// at index i before they are reused from the pool, so session state can't
+// leak across requests. Connections failing to reset are discarded.
+// It is only supported on physical dbs opened by Open.
+func (db *DB) SetConnResetter(i int, fn ResetFunc) error {
+	c, err := db.connector(i)
+	if err != nil {
+		return err
+	}
+	c.reset.Store(fn)
+	return nil
+}
+
+func (db *DB) connector(i int) (*connector, error) {
+	if t := db.topology(); i >= 0 && i < len(t.connectors) && t.connectors[i] != nil {
+		return t.connectors[i], nil
+	}
+	return nil, fmt.Errorf("nap: physical db %d wasn't opened by nap", i)
+}
+
+// openDB opens a physical db whose driver connections are wrapped
+// so that nap can hook into their lifecycle.
+func openDB(driverName, dsn string) (*sql.DB, *connector, error) {
+	probe, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	drv := probe.Driver()
+	probe.Close()
+
+	return openDriver(drv, dsn)
+}
+
+// openDriver opens a physical db with drv, like openDB.
+func openDriver(drv driver.Driver, dsn string) (*sql.DB, *connector, error) {
+	c := &connector{driver: drv}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		var err error
+		if c.base, err = dc.OpenConnector(dsn); err != nil {
+			return nil, nil, err
+		}
+	}
+	c.creds.Store(&credentials{dsn: dsn, base: c.base})
+
+	return sql.OpenDB(c), c, nil
+}
+
+// connector implements driver.Connector on top of the registered driver.
+type connector struct {
+	driver  driver.Driver
+	base    driver.Connector // Set when the driver implements driver.DriverContext
+	reset   atomic.Value     // ResetFunc
+	init    atomic.Value     // Statement run on new connections
+	appName atomic.Value     // Application name set by init
+	dialMax atomic.Value     // time.Duration bounding dials
+	creds   atomic.Value     // *credentials new connections are dialed with
+	gc      atomic.Value     // *statementGC of the connections
+	orphans uint64           // Orphaned statements deallocated, accessed atomically
+	dials   dialStats
+}
+
+// Connect implements the driver.Connector interface.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	creds := c.credentials()
+	ci, err := c.dial(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	if stmt, _ := c.init.Load().(string); stmt != "" {
+		if err = execDriver(ctx, ci, stmt); err != nil {
+			ci.Close()
+			return nil, err
+		}
+	}
+	cn := &conn{Conn: ci, connector: c, creds: creds, turn: rand.Float64()}
+	if gc, _ := c.gc.Load().(*statementGC); gc != nil {
+		cn.stmts = newStmtTracker()
+	}
+	return cn, nil
+}
+
+// Driver implements the driver.Connector interface.
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the driver's connectors, if closable, when the
+// physical db is closed.
+func (c *connector) Close() error {
+	var err error
+	if creds := c.credentials(); creds.base != c.base {
+		err = creds.close()
+	}
+	if closer, ok := c.base.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// conn wraps a driver connection, forwarding the optional driver interfaces
+// to it the same way database/sql would when they aren't implemented.
+type conn struct {
+	driver.Conn
+	connector *connector
+	creds     *credentials // Dialed with
+	turn      float64      // Fraction of the rotation slice before being retired
+	stmts     *stmtTracker // Set when statements are collected
+}
+
+// DriverConn returns the driver connection of dc, the connection passed to
+// the function of sql.Conn.Raw, unwrapping it when it belongs to a physical
+// db opened by Open, so that driver specific methods can be called on it.
+// Other connections are returned as is.
+func DriverConn(dc interface{}) interface{} {
+	if c, ok := dc.(*conn); ok {
+		return c.Conn
+	}
+	return dc
+}
+
+// ResetSession implements the driver.SessionResetter interface.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		if err := r.ResetSession(ctx); err != nil {
+			return err
+		}
+	}
+
+	if fn, _ := c.connector.reset.Load().(ResetFunc); fn != nil {
+		if err := fn(ctx, c.Conn); err != nil {
+			return driver.ErrBadConn
+		}
+	}
+	return nil
+}
+
+// IsValid implements the driver.Validator interface, reporting false once
+// retired by a credential rotation.
+func (c *conn) IsValid() bool {
+	if c.retired() {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// Ping implements the driver.Pinger interface.
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// CheckNamedValue implements the driver.NamedValueChecker interface.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// PrepareContext implements the driver.ConnPrepareContext interface.
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		si, err := p.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return c.track(si, query), nil
+	}
+
+	si, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		si.Close()
+		return nil, ctx.Err()
+	}
+	return c.track(si, query), nil
+}
+
+// BeginTx implements the driver.ConnBeginTx interface.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.beginTx(ctx, opts)
+	if slot, ok := ctx.Value(txSlotKey{}).(*txSlot); ok && err == nil {
+		slot.claimed = true
+		return &guardedTx{Tx: tx, slot: slot}, nil
+	}
+	return tx, err
+}
+
+func (c *conn) beginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("sql: driver does not support non-default isolation level")
+	}
+
+	if opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support read-only transactions")
+	}
+
+	tx, err := c.Conn.Begin()
+	if err == nil && ctx.Err() != nil {
+		tx.Rollback()
+		return nil, ctx.Err()
+	}
+	return tx, err
+}
+
+// ExecContext implements the driver.ExecerContext interface.
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+
+	if e, ok := c.Conn.(driver.Execer); ok {
+		dargs, err := namedValueToValue(args)
+		if err != nil {
+			return nil, err
+		}
+		return e.Exec(query, dargs)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+// QueryContext implements the driver.QueryerContext interface.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+
+	if q, ok := c.Conn.(driver.Queryer); ok {
+		dargs, err := namedValueToValue(args)
+		if err != nil {
+			return nil, err
+		}
+		return q.Query(query, dargs)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func namedValueToValue(named []driver.NamedValue) ([]driver.Value, error) {
+	dargs := make([]driver.Value, len(named))
+	for n, param := range named {
+		if len(param.Name) > 0 {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		dargs[n] = param.Value
+	}
+	return dargs, nil
+}
+
+// execDriver executes a statement without arguments directly on a driver connection.
+func execDriver(ctx context.Context, ci driver.Conn, query string) error {
+	if e, ok := ci.(driver.ExecerContext); ok {
+		if _, err := e.ExecContext(ctx, query, nil); err != driver.ErrSkip {
+			return err
+		}
+	}
+
+	stmt, err := (&conn{Conn: ci}).PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if s, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = s.ExecContext(ctx, nil)
+	} else {
+		_, err = stmt.Exec(nil)
+	}
+	return err
+}
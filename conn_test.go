@@ -0,0 +1,108 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestSetConnResetter(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	err = db.SetConnResetter(0, ResetStatements("DROP TABLE IF EXISTS temp.scratch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.Exec("CREATE TEMP TABLE scratch (a INT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_temp_master WHERE name = 'scratch'").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 0 {
+		t.Error("Temporary table leaked into the next use of the connection")
+	}
+}
+
+func TestSetConnResetterDiscards(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec("CREATE TABLE t (a INT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	resets := 0
+	db.SetConnResetter(0, func(ctx context.Context, conn driver.Conn) error {
+		resets++
+		return errors.New("boom")
+	})
+
+	// The connection holding t is discarded and a fresh one is dialed.
+	if _, err = db.Exec("INSERT INTO t VALUES (1)"); err == nil {
+		t.Error("Expected the query to run on a fresh connection without t")
+	}
+
+	if resets != 1 {
+		t.Errorf("Unexpected number of resets: %d", resets)
+	}
+}
+
+func TestSetConnResetterWrapped(t *testing.T) {
+	pdb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ := Wrap(pdb)
+	defer db.Close()
+
+	if err = db.SetConnResetter(0, ResetStatements("SELECT 1")); err == nil {
+		t.Error("Expected error setting a resetter on a wrapped db")
+	}
+}
+
+func TestDriverConn(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c, err := db.Master().Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err = c.Raw(func(dc interface{}) error {
+		if _, ok := DriverConn(dc).(*sqlite3.SQLiteConn); !ok {
+			t.Errorf("Unexpected driver connection: %T", DriverConn(dc))
+		}
+		if DriverConn(DriverConn(dc)) != DriverConn(dc) {
+			t.Error("Unwrapped connection not returned as is")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
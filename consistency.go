@@ -0,0 +1,226 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Consistency is the consistency mode of the reads following writes.
+type Consistency uint8
+
+// Consistency modes.
+const (
+	// Eventual reads may go to slaves lagging behind the writes done right
+	// before.
+	Eventual Consistency = iota
+
+	// ReadYourWrites reads go to the master for the freshness window
+	// following any write done through the DB.
+	ReadYourWrites
+
+	// ReadYourContextWrites reads go to the master for the freshness window
+	// following the writes done through the DB with the same context set up
+	// with WithStickyMaster, such as those of a request.
+	ReadYourContextWrites
+)
+
+type stickyKey struct{}
+
+// WithStickyMaster returns a copy of ctx tracking its writes, so that its
+// reads go to the master for the freshness window following them, as set
+// with SetConsistency and ReadYourContextWrites.
+func WithStickyMaster(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stickyKey{}, new(int64))
+}
+
+type slaveKey struct{}
+
+// UseMaster returns a copy of ctx whose reads go to the master, such as
+// right after a write, like WithNode with index 0.
+func UseMaster(ctx context.Context) context.Context {
+	return WithNode(ctx, 0)
+}
+
+// UseSlave returns a copy of ctx whose reads go to a slave as if no write
+// was done right before, overriding UseMaster, WithNode and the freshness
+// window of SetConsistency. Reads still fall back to the master when no
+// slave is in rotation.
+func UseSlave(ctx context.Context) context.Context {
+	return context.WithValue(WithNode(ctx, -1), slaveKey{}, true)
+}
+
+// SetConsistency sets the consistency mode of the reads following the
+// writes done with DB.Exec or Stmt.Exec, and the freshness window during
+// which they go to the master. Writes done in transactions aren't tracked.
+// The default is Eventual.
+func (db *DB) SetConsistency(c Consistency, window time.Duration) {
+	atomic.StoreInt64(&db.freshness, int64(window))
+	atomic.StoreInt32(&db.consistent, int32(c))
+}
+
+// wrote tracks a write done with ctx.
+func (db *DB) wrote(ctx context.Context) {
+	switch Consistency(atomic.LoadInt32(&db.consistent)) {
+	case ReadYourWrites:
+		atomic.StoreInt64(&db.lastWrite, time.Now().UnixNano())
+	case ReadYourContextWrites:
+		if last, ok := ctx.Value(stickyKey{}).(*int64); ok {
+			atomic.StoreInt64(last, time.Now().UnixNano())
+		}
+	}
+}
+
+// fresh reports whether a read with ctx must go to the master to observe
+// the writes done right before.
+func (db *DB) fresh(ctx context.Context) bool {
+	var last *int64
+	switch Consistency(atomic.LoadInt32(&db.consistent)) {
+	case ReadYourWrites:
+		last = &db.lastWrite
+	case ReadYourContextWrites:
+		last, _ = ctx.Value(stickyKey{}).(*int64)
+	}
+
+	if last == nil {
+		return false
+	}
+
+	at := atomic.LoadInt64(last)
+	return at != 0 && time.Now().UnixNano()-at < atomic.LoadInt64(&db.freshness)
+}
+
+// LagProbe returns the replication lag of a slave.
+type LagProbe func(ctx context.Context, db *sql.DB) (time.Duration, error)
+
+// PostgresLag is a LagProbe returning the time since the last transaction
+// replayed by a Postgres standby. It overestimates the lag of idle masters.
+func PostgresLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	var secs float64
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)").Scan(&secs)
+	return time.Duration(secs * float64(time.Second)), err
+}
+
+// MySQLLag is a LagProbe returning the Seconds_Behind_Master of SHOW SLAVE
+// STATUS on a MySQL replica.
+func MySQLLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return 0, err
+	}
+
+	values := make([]interface{}, len(columns))
+	var secs sql.NullInt64
+	for i, c := range columns {
+		if values[i] = new(sql.RawBytes); c == "Seconds_Behind_Master" {
+			values[i] = &secs
+		}
+	}
+
+	if err = rows.Scan(values...); err != nil {
+		return 0, err
+	}
+	if !secs.Valid {
+		return 0, errors.New("nap: replication stopped")
+	}
+	return time.Duration(secs.Int64) * time.Second, nil
+}
+
+// SetLagProbe sets the probe measuring the replication lag of each slave
+// every interval in the background, until the DB is closed. Slaves lagging
+// more than max are out of rotation until they catch up, and so are those
+// failing to be probed. If probe is nil or interval <= 0, lag is no longer
+// probed and stops taking slaves out of rotation, which is the default.
+func (db *DB) SetLagProbe(probe LagProbe, interval, max time.Duration) {
+	if probe == nil {
+		interval = 0
+	}
+
+	db.lagProbe.Store(probe)
+	atomic.StoreInt64(&db.lagMax, int64(max))
+	atomic.StoreInt64(&db.lagEvery, int64(interval))
+	db.startLagProbe()
+}
+
+// Lag returns the last replication lag probed of the slave at index i, or
+// a negative duration if unknown or failing to be probed.
+func (db *DB) Lag(i int) time.Duration {
+	return time.Duration(atomic.LoadInt64(&db.health(i).lag) - 1)
+}
+
+// lagging reports whether the slave at index i lags too much to be in
+// rotation.
+func (db *DB) lagging(i int) bool {
+	if atomic.LoadInt64(&db.lagEvery) <= 0 {
+		return false
+	}
+
+	lag := db.Lag(i)
+	return lag < 0 || lag > time.Duration(atomic.LoadInt64(&db.lagMax))
+}
+
+func (db *DB) startLagProbe() {
+	if atomic.LoadInt64(&db.lagEvery) > 0 && atomic.CompareAndSwapInt32(&db.lagProbing, 0, 1) {
+		go db.probeLag()
+	}
+}
+
+// probeLag measures the lag of slaves until disabled or the DB is closed.
+func (db *DB) probeLag() {
+	for {
+		every := time.Duration(atomic.LoadInt64(&db.lagEvery))
+		probe, _ := db.lagProbe.Load().(LagProbe)
+		if every <= 0 || probe == nil {
+			atomic.StoreInt32(&db.lagProbing, 0)
+			db.startLagProbe() // Re-enabled concurrently
+			return
+		}
+
+		db.measureLag(probe, every, every)
+
+		select {
+		case <-db.done():
+			return
+		case <-time.After(every):
+		}
+	}
+}
+
+// measureLag probes every slave concurrently, waiting at most timeout, or
+// shares the lag measured by other DBs during the last interval.
+func (db *DB) measureLag(probe LagProbe, interval, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t := db.topology()
+	scatter(len(t.pdbs), func(i int) error {
+		if i == 0 {
+			return nil
+		}
+
+		var stored int64 // Unknown
+		lag, err := db.sharedProbe(t, i, "lag", interval, func() (interface{}, error) {
+			return probe(ctx, t.pdbs[i])
+		})
+		if err == nil && lag.(time.Duration) >= 0 {
+			stored = int64(lag.(time.Duration)) + 1
+		}
+		atomic.StoreInt64(&t.health(i).lag, stored)
+		return nil
+	})
+}
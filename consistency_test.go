@@ -0,0 +1,142 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsistency(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err = db.ExecContext(ctx, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if i := db.readIndex(ctx); i == 0 {
+		t.Error("Read routed to the master with eventual consistency")
+	}
+
+	db.SetConsistency(ReadYourWrites, time.Hour)
+	if i := db.readIndex(ctx); i == 0 {
+		t.Error("Read routed to the master before any write")
+	}
+	if _, err = db.ExecContext(ctx, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if i := db.readIndex(ctx); i != 0 {
+		t.Errorf("Read routed to %d instead of the master after a write", i)
+	}
+
+	db.SetConsistency(ReadYourWrites, time.Nanosecond)
+	if i := db.readIndex(ctx); i == 0 {
+		t.Error("Read routed to the master after the freshness window")
+	}
+
+	db.SetConsistency(ReadYourContextWrites, time.Hour)
+	sticky := WithStickyMaster(ctx)
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if _, err = stmt.ExecContext(sticky); err != nil {
+		t.Fatal(err)
+	}
+	if i := db.readIndex(sticky); i != 0 {
+		t.Errorf("Read routed to %d instead of the master after a write of its context", i)
+	}
+	if i := db.readIndex(ctx); i == 0 {
+		t.Error("Read routed to the master after a write of another context")
+	}
+}
+
+func TestLagProbe(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if lag := db.Lag(1); lag >= 0 {
+		t.Errorf("Unexpected lag before probing: %v", lag)
+	}
+
+	t1, t2, t3 := db.topology().pdbs[1], db.topology().pdbs[2], db.topology().pdbs[3]
+	probe := func(ctx context.Context, pdb *sql.DB) (time.Duration, error) {
+		switch pdb {
+		case t1:
+			return time.Millisecond, nil
+		case t2:
+			return time.Minute, nil
+		case t3:
+			return 0, errors.New("replication stopped")
+		}
+		return 0, errors.New("probed the master")
+	}
+
+	db.SetLagProbe(probe, time.Hour, time.Second)
+	deadline := time.Now().Add(time.Second)
+	for db.Lag(2) < 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if lag := db.Lag(1); lag != time.Millisecond {
+		t.Errorf("Unexpected lag: %v", lag)
+	}
+	if lag := db.Lag(3); lag >= 0 {
+		t.Errorf("Unexpected lag of a slave failing to be probed: %v", lag)
+	}
+
+	for i := 0; i < 6; i++ {
+		if n := db.readIndex(context.Background()); n != 1 {
+			t.Fatalf("Read routed to %d instead of the slave in rotation", n)
+		}
+	}
+
+	db.SetLagProbe(nil, 0, 0)
+	if !db.Healthy(2) || !db.Healthy(3) {
+		t.Error("Lagging slaves out of rotation without lag probe")
+	}
+}
+
+func TestUseMasterUseSlave(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := UseMaster(context.Background())
+	if i := db.readIndex(ctx); i != 0 {
+		t.Errorf("Read routed to %d instead of the master", i)
+	}
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Node() != 0 {
+		t.Errorf("Statement read routed to %d instead of the master", rows.Node())
+	}
+	rows.Close()
+
+	db.SetConsistency(ReadYourWrites, time.Hour)
+	db.Exec("SELECT 1")
+	if i := db.readIndex(UseSlave(ctx)); i != 1 {
+		t.Errorf("Read routed to %d instead of the slave", i)
+	}
+}
@@ -0,0 +1,114 @@
+package nap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a copy of ctx whose queries carry id, so they
+// can be matched with the application call that issued them.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by ctx, if any.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// IDGenerator generates correlation IDs.
+type IDGenerator func() string
+
+// RandomID is an IDGenerator returning 16 random hex characters.
+func RandomID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// SetIDGenerator sets the IDGenerator used to assign correlation IDs to
+// queries whose context doesn't carry one. If gen is nil, which is the
+// default, only queries with an ID set with WithCorrelationID carry one.
+func (db *DB) SetIDGenerator(gen IDGenerator) {
+	db.idgen.Store(gen)
+}
+
+// SetCorrelationComments sets whether the correlation IDs of non prepared
+// queries are prepended to their SQL as a comment, such as /* cid=abc */,
+// so they show up in the server logs of the physical db that ran them.
+func (db *DB) SetCorrelationComments(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&db.comments, v)
+}
+
+// QueryError wraps the errors of queries carrying a correlation ID.
+type QueryError struct {
+	CorrelationID string
+	Node          int // Index of the physical db the query ran on
+	Err           error
+}
+
+// Error implements the error interface.
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("nap: query %s on physical db %d: %s", e.CorrelationID, e.Node, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// correlate returns ctx with a correlation ID, generating one if needed.
+func (db *DB) correlate(ctx context.Context) context.Context {
+	if CorrelationID(ctx) != "" {
+		return ctx
+	}
+
+	if gen, _ := db.idgen.Load().(IDGenerator); gen != nil {
+		return WithCorrelationID(ctx, gen())
+	}
+	return ctx
+}
+
+// rewrite returns the SQL sent for the non prepared query of an op with ctx.
+func (db *DB) rewrite(ctx context.Context, op Op, query string) string {
+	query = db.routed(ctx, op, db.hinted(ctx, op, query))
+
+	var parts []string
+	if atomic.LoadInt32(&db.comments) != 0 {
+		if id := CorrelationID(ctx); id != "" {
+			parts = append(parts, "cid="+stripComment(id))
+		}
+	}
+
+	if atomic.LoadInt32(&db.tagComment) != 0 {
+		parts = commentTags(ctx, parts)
+	}
+
+	if len(parts) > 0 {
+		query = "/* " + strings.Join(parts, " ") + " */ " + query
+	}
+	return db.planHinted(ctx, query)
+}
+
+// queryError wraps err with the correlation ID of ctx, if any.
+func queryError(ctx context.Context, node int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if id := CorrelationID(ctx); id != "" {
+		return &QueryError{CorrelationID: id, Node: node, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCorrelationID(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithCorrelationID(context.Background(), "req-1")
+	_, err = db.QueryContext(ctx, "SELECT nope")
+
+	var qerr *QueryError
+	if !errors.As(err, &qerr) {
+		t.Fatalf("Want *QueryError, got: %v", err)
+	}
+
+	if qerr.CorrelationID != "req-1" || qerr.Node != 1 {
+		t.Errorf("Unexpected query error: %+v", qerr)
+	}
+
+	if _, err = db.Exec("SELECT nope"); errors.As(err, &qerr) {
+		t.Errorf("Queries without correlation ID must not be wrapped: %v", err)
+	}
+
+	db.SetIDGenerator(func() string { return "gen" })
+	if _, err = db.Exec("SELECT nope"); !errors.As(err, &qerr) || qerr.CorrelationID != "gen" {
+		t.Errorf("Generated correlation ID not attached: %v", err)
+	}
+}
+
+func TestCorrelationComments(t *testing.T) {
+	db := &DB{}
+	ctx := WithCorrelationID(context.Background(), "a*/b")
+
+	if got := db.rewrite(ctx, OpQuery, "SELECT 1"); got != "SELECT 1" {
+		t.Errorf("Comment added while disabled: %s", got)
+	}
+
+	db.SetCorrelationComments(true)
+	if got := db.rewrite(ctx, OpQuery, "SELECT 1"); got != "/* cid=ab */ SELECT 1" {
+		t.Errorf("Unexpected commented query: %s", got)
+	}
+
+	if got := db.rewrite(context.Background(), OpQuery, "SELECT 1"); got != "SELECT 1" {
+		t.Errorf("Comment added without correlation ID: %s", got)
+	}
+
+	if id := RandomID(); len(id) != 16 || id == RandomID() {
+		t.Errorf("Unexpected random ID: %s", id)
+	}
+}
@@ -0,0 +1,115 @@
+package nap
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// credentials are the DSN new connections to a physical db are dialed with
+// since a rotation, and the slice of the rotation window over which those
+// dialed before are retired.
+type credentials struct {
+	dsn   string
+	base  driver.Connector // Set when the driver implements driver.DriverContext
+	start time.Time
+	slice time.Duration
+}
+
+// RotateCredentials switches the physical dbs opened by Open to dialing new
+// connections with the semicolon separated dataSourceNames, in the order of
+// their indexes, such as once the credentials in them are rotated by
+// a secrets manager. The connections dialed before are proactively retired
+// over window instead of at their ConnMaxLifetime: window is sliced across
+// the physical dbs whose DSN changed, in index order, and the connections of
+// each are retired at random times within its slice, as they are checked out
+// or released, so that neither a pool nor the topology empties out at once.
+// SetSlaves and slave resolvers match slaves by their rotated DSNs from
+// then on. Physical dbs are left untouched if an error is returned.
+func (db *DB) RotateCredentials(dataSourceNames string, window time.Duration) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t := db.topology()
+	dsns := strings.Split(dataSourceNames, ";")
+	if len(dsns) != len(t.pdbs) {
+		return fmt.Errorf("nap: %d DSNs for %d physical dbs", len(dsns), len(t.pdbs))
+	}
+
+	rotated := make([]*credentials, len(dsns))
+	var changed []int
+	fail := func(err error) error {
+		for _, i := range changed {
+			rotated[i].close()
+		}
+		return err
+	}
+
+	for i, dsn := range dsns {
+		c, err := db.connector(i)
+		if err != nil {
+			return fail(err)
+		}
+		if dsn == c.dsn() {
+			continue
+		}
+
+		creds := &credentials{dsn: dsn}
+		if dc, ok := c.driver.(driver.DriverContext); ok {
+			if creds.base, err = dc.OpenConnector(dsn); err != nil {
+				return fail(err)
+			}
+		}
+		rotated[i] = creds
+		changed = append(changed, i)
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	now, slice := time.Now(), window/time.Duration(len(changed))
+	for k, i := range changed {
+		rotated[i].start, rotated[i].slice = now.Add(time.Duration(k)*slice), slice
+		c := t.connectors[i]
+		if old := c.credentials(); old.base != c.base {
+			old.close() // Dialed with by no new connection
+		}
+		c.creds.Store(rotated[i])
+	}
+	return nil
+}
+
+// credentials returns the credentials new connections are dialed with.
+func (c *connector) credentials() *credentials {
+	return c.creds.Load().(*credentials)
+}
+
+// dsn returns the DSN new connections are dialed with.
+func (c *connector) dsn() string {
+	return c.credentials().dsn
+}
+
+// close closes the driver's connector of creds, if closable.
+func (creds *credentials) close() error {
+	if closer, ok := creds.base.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// retired reports whether c was dialed with credentials since rotated and
+// its turn to be retired came.
+func (c *conn) retired() bool {
+	if c.connector == nil {
+		return false
+	}
+
+	creds := c.connector.credentials()
+	if creds == c.creds {
+		return false
+	}
+	return time.Now().After(creds.start.Add(time.Duration(c.turn * float64(creds.slice))))
+}
@@ -0,0 +1,223 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// credsDriver opens SQLite in-memory connections, counting those open by
+// the DSN they were dialed with.
+type credsDriver struct {
+	mu   sync.Mutex
+	open map[string]int
+}
+
+func (d *credsDriver) Open(name string) (driver.Conn, error) {
+	c, err := (&sqlite3.SQLiteDriver{}).Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.open[name]++
+	return credsConn{c.(*sqlite3.SQLiteConn), d, name}, nil
+}
+
+func (d *credsDriver) opened(name string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.open[name]
+}
+
+type credsConn struct {
+	*sqlite3.SQLiteConn
+	driver *credsDriver
+	dsn    string
+}
+
+func (c credsConn) Close() error {
+	c.driver.mu.Lock()
+	c.driver.open[c.dsn]--
+	c.driver.mu.Unlock()
+	return c.SQLiteConn.Close()
+}
+
+var creds = &credsDriver{open: map[string]int{}}
+
+func init() {
+	sql.Register("sqlite3_creds", creds)
+}
+
+// checkout checks out n connections of pdb at once, releasing them.
+func checkout(t *testing.T, pdb *sql.DB, n int) {
+	conns := make([]*sql.Conn, n)
+	for k := range conns {
+		var err error
+		if conns[k], err = pdb.Conn(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err = conns[k].PingContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func TestRotateCredentials(t *testing.T) {
+	db, err := Open("sqlite3_creds", "user:old@master;user:old@slave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxIdleConns(3)
+	for _, pdb := range db.topology().pdbs {
+		checkout(t, pdb, 3)
+	}
+	if n := creds.opened("user:old@slave"); n != 3 {
+		t.Fatalf("Want 3 connections open, got: %d", n)
+	}
+
+	if err = db.RotateCredentials("user:new@master", time.Second); err == nil || !strings.Contains(err.Error(), "1 DSNs for 2") {
+		t.Errorf("Unexpected error rotating too few DSNs: %v", err)
+	}
+	if err = db.RotateCredentials("user:new@master;user:new@slave", 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// The slave is rotated in the second half of the window.
+	checkout(t, db.topology().pdbs[1], 3)
+	if n := creds.opened("user:new@slave"); n != 0 {
+		t.Errorf("Slave connections rotated before their slice: %d", n)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	for i, pdb := range db.topology().pdbs {
+		checkout(t, pdb, 3) // Retired as released
+		checkout(t, pdb, 3)
+		for _, dsn := range []string{"user:old@", "user:new@"} {
+			dsn += map[int]string{0: "master", 1: "slave"}[i]
+			if n, want := creds.opened(dsn), map[bool]int{true: 3}[strings.HasPrefix(dsn, "user:new")]; n != want {
+				t.Errorf("Want %d connections open with %s, got: %d", want, dsn, n)
+			}
+		}
+	}
+
+	// Unchanged DSNs are left untouched.
+	if err = db.RotateCredentials("user:new@master;user:new@slave", 0); err != nil {
+		t.Fatal(err)
+	}
+	checkout(t, db.Master(), 3)
+	if n := creds.opened("user:new@master"); n != 3 {
+		t.Errorf("Want 3 connections open, got: %d", n)
+	}
+
+	wrapped, err := Wrap(db.Master())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wrapped.RotateCredentials("user:new@master", 0); err == nil {
+		t.Error("Want an error rotating the credentials of a wrapped physical db")
+	}
+}
+
+// closingDriver is a credsDriver whose connectors count their closes.
+type closingDriver struct {
+	*credsDriver
+	mu     sync.Mutex
+	closed map[string]int
+}
+
+func (d *closingDriver) OpenConnector(name string) (driver.Connector, error) {
+	if strings.Contains(name, "invalid") {
+		return nil, errors.New("invalid DSN")
+	}
+	return &closingConnector{d, name}, nil
+}
+
+type closingConnector struct {
+	driver *closingDriver
+	dsn    string
+}
+
+func (c *closingConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *closingConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *closingConnector) Close() error {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	c.driver.closed[c.dsn]++
+	return nil
+}
+
+func (d *closingDriver) closes(name string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed[name]
+}
+
+var closing = &closingDriver{credsDriver: &credsDriver{open: map[string]int{}}, closed: map[string]int{}}
+
+func init() {
+	sql.Register("sqlite3_closing", closing)
+}
+
+func TestRotateCredentialsTopology(t *testing.T) {
+	closing.mu.Lock()
+	closing.closed = map[string]int{}
+	closing.mu.Unlock()
+
+	db, err := Open("sqlite3_closing", "v1@master;v1@slave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := db.Stats()[1].DSNHash
+
+	if err = db.RotateCredentials("v2@master;invalid@slave", 0); err == nil {
+		t.Error("Want an error rotating to an invalid DSN")
+	}
+	if n := closing.closes("v2@master"); n != 1 {
+		t.Errorf("Connector opened before the error closed %d times", n)
+	}
+
+	for _, dsns := range []string{"v2@master;v2@slave", "v3@master;v3@slave"} {
+		if err = db.RotateCredentials(dsns, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := closing.closes("v2@slave"); n != 1 {
+		t.Errorf("Connector of an earlier rotation closed %d times", n)
+	}
+	if db.Stats()[1].DSNHash == hash {
+		t.Error("DSN hash not rotated")
+	}
+
+	slave := db.topology().pdbs[1]
+	if err = db.SetSlaves([]string{"v3@slave"}); err != nil {
+		t.Fatal(err)
+	}
+	if tp := db.topology(); len(tp.pdbs) != 2 || tp.pdbs[1] != slave {
+		t.Error("Rotated slave replaced by SetSlaves")
+	}
+
+	db.Close()
+	if n := closing.closes("v3@slave"); n != 1 {
+		t.Errorf("Connector of the last rotation closed %d times", n)
+	}
+}
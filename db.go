@@ -6,6 +6,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -14,8 +15,90 @@ import (
 // forming a single master multiple slaves topology.
 // Reads and writes are automatically directed to the correct physical db.
 type DB struct {
-	pdbs  []*sql.DB // Physical databases
-	count uint64    // Monotonically incrementing counter on each query
+	topo       atomic.Value  // *topology of the physical databases
+	count      uint64        // Monotonically incrementing counter on each query
+	checkout   int64         // Connection checkout timeout in nanoseconds
+	formatter  atomic.Value  // ArgsFormatter used to render query args
+	selector   atomic.Value  // Default Selector for reads
+	mu         sync.Mutex    // Serializes configuration changes
+	opening    sync.Once     // Initializes closed
+	closing    sync.Once     // Closes closed
+	closed     chan struct{} // Closed by Close to stop background work
+	accounts   sync.Map      // Caller labels to their *account
+	hook       atomic.Value  // RouteHook
+	queryHook  atomic.Value  // QueryHook
+	hooks      atomic.Value  // *Hooks
+	probeKey   atomic.Value  // ProbeKey of shared probes
+	memoBound  int32         // Set while memo ages are bounded by lags, accessed atomically
+	closeHook  atomic.Value  // RouteHook called when rows are released
+	closeQuery atomic.Value  // QueryHook called when rows are released
+	queries    atomic.Value  // []string names of registered queries
+	mirror     mirror        // Read traffic mirroring
+	timeout    int64         // Default statement timeout in nanoseconds
+	rtimeout   int64         // Default timeout of reads in nanoseconds, overriding timeout
+	wtimeout   int64         // Default timeout of writes in nanoseconds, overriding timeout
+	hint       atomic.Value  // TimeoutHint
+	idgen      atomic.Value  // IDGenerator
+	comments   int32         // Set when correlation IDs are added to SQL comments
+	tagComment int32         // Set when tags are added to SQL comments
+	drill      atomic.Value  // *Drill in progress
+	retries    atomic.Value  // RetryPolicy of reads
+	asOf       atomic.Value  // AsOfRewriter
+	generation uint64        // Bumped to invalidate prepared statements
+	fairness   atomic.Value  // *fairness tracking the distribution of reads
+	recorder   atomic.Value  // *recorder of the last statements
+	metrics    atomic.Value  // *metrics emitted of routed operations
+	norows     int32         // Set when QueryRow rechecks no rows on the master
+	minSlaves  int32         // Minimum number of healthy slaves for writes
+	preference int32         // Default ReadPreference
+	stmtRoute  int32         // StmtRouting of prepared statements
+	serverPool atomic.Value  // serverPool query collecting server side connections
+	rttEvery   int64         // Interval between round trip time probes in nanoseconds
+	rttProbing int32         // Set while round trip times are probed
+	policy     atomic.Value  // HealthPolicy
+	failback   atomic.Value  // trafficPolicy of readmitted slaves
+	proxyHint  atomic.Value  // ProxyHint of proxy mode
+	stmts      sync.Map      // Open *Stmt prepared with Prepare
+	usage      atomic.Value  // *utilization of the physical dbs
+	guards     atomic.Value  // map[string]ResultGuard by tier
+	pushSink   atomic.Value  // snapshotSink snapshots are pushed to
+	pushEvery  int64         // Interval between snapshot pushes in nanoseconds
+	pushing    int32         // Set while snapshots are pushed
+	checkEvery int64         // Interval between health checks in nanoseconds
+	checkWait  int64         // Timeout of health checks in nanoseconds
+	checking   int32         // Set while health is checked
+	balancer   atomic.Value  // balancerPolicy of reads
+	tokens     atomic.Value  // TokenQueries of consistency tokens
+	consistent int32         // Consistency of reads, accessed atomically
+	freshness  int64         // Freshness window of writes, accessed atomically
+	lastWrite  int64         // Unix nanoseconds of the last write, accessed atomically
+	lagProbe   atomic.Value  // LagProbe of slaves
+	lagEvery   int64         // Interval of lag probes, accessed atomically
+	lagMax     int64         // Max lag in rotation, accessed atomically
+	lagProbing int32         // Set while probing lag, accessed atomically
+	scoring    atomic.Value  // ScoreFunc of reads
+	resolver   atomic.Value  // SlaveResolver of the slaves
+	refresh    int64         // Interval of slave resolutions, accessed atomically
+	refreshing int32         // Set while resolving slaves, accessed atomically
+	isolation  atomic.Value  // map[string]sql.IsolationLevel of reads by caller
+	frozen     int64         // Unix nanoseconds of the freeze, 0 if not frozen, accessed atomically
+	inList     atomic.Value  // INListRewriter of non prepared operations
+	prewarmed  sync.Map      // SQL of prewarmed statements to their *stmtSet
+	tableLags  atomic.Value  // map[string]time.Duration of the max lags of reads by table
+	promoted   int64         // Unix nanoseconds the grace period of the last promotion ends, accessed atomically
+	planHint   atomic.Value  // PlanHinter
+	planHints  atomic.Value  // map[string][]string of plan hints by fingerprint
+	bulkConns  int32         // Connection budget of bulk lanes, accessed atomically
+	bulk       sync.Map      // Main pools to the *sql.DB of their bulk lane
+	txs        txLimit       // Transactions in flight on the master
+	overrides  atomic.Value  // map[string]RouteOverride by fingerprint
+	rollout    atomic.Value  // *rollout of the last BalancerChange
+	plans      atomic.Value  // *planCache of the plans of reads
+	next       atomic.Value  // *DB handed over to
+	shadow     atomic.Value  // *shadow policy evaluated
+	appNames   atomic.Value  // appNameTag of the connections
+	gcEvery    int64         // Interval of statement collections, accessed atomically
+	collecting int32         // Set while statements are collected, accessed atomically
 }
 
 // Wrap wrapping origin *sql.DB connects
@@ -24,18 +107,26 @@ func Wrap(db ...*sql.DB) (*DB, error) {
 	if len(db) == 0 {
 		return nil, errors.New("nap: no *sql.DB for wrapping")
 	}
-	return &DB{pdbs: db}, nil
+	return wrap(db, nil), nil
+}
+
+func wrap(pdbs []*sql.DB, connectors []*connector) *DB {
+	db := &DB{}
+	db.topo.Store(newTopology(pdbs, connectors))
+	return db
 }
 
 // Open concurrently opens each underlying physical db.
 // dataSourceNames must be a semi-comma separated list of DSNs with the first
-// one being used as the master and the rest as slaves.
+// one being used as the master and the rest as slaves. Their driver
+// connections are wrapped by nap, and unwrapped with DriverConn.
 func Open(driverName, dataSourceNames string) (*DB, error) {
 	conns := strings.Split(dataSourceNames, ";")
-	db := &DB{pdbs: make([]*sql.DB, len(conns))}
+	pdbs := make([]*sql.DB, len(conns))
+	connectors := make([]*connector, len(conns))
 
-	err := scatter(len(db.pdbs), func(i int) (err error) {
-		db.pdbs[i], err = sql.Open(driverName, conns[i])
+	err := scatter(len(pdbs), func(i int) (err error) {
+		pdbs[i], connectors[i], err = openDB(driverName, conns[i])
 		return err
 	})
 
@@ -43,16 +134,30 @@ func Open(driverName, dataSourceNames string) (*DB, error) {
 		return nil, err
 	}
 
-	return db, nil
+	return wrap(pdbs, connectors), nil
 }
 
 // Close closes all physical databases concurrently, releasing any open resources.
+// Background work, such as scheduled maintenance, is stopped.
 func (db *DB) Close() error {
-	return scatter(len(db.pdbs), func(i int) error {
-		return db.pdbs[i].Close()
+	db.closing.Do(func() { close(db.done()) })
+	for _, set := range db.dropPrewarmed() {
+		set.close()
+	}
+
+	pdbs := db.topology().pdbs
+	return scatter(len(pdbs), func(i int) error {
+		db.closeBulk(pdbs[i])
+		return pdbs[i].Close()
 	})
 }
 
+// done returns a channel which is closed when the DB is closed.
+func (db *DB) done() chan struct{} {
+	db.opening.Do(func() { db.closed = make(chan struct{}) })
+	return db.closed
+}
+
 // Driver returns the physical database's underlying driver.
 func (db *DB) Driver() driver.Driver {
 	return db.Master().Driver()
@@ -60,7 +165,7 @@ func (db *DB) Driver() driver.Driver {
 
 // Begin starts a transaction on the master. The isolation level is dependent on the driver.
 func (db *DB) Begin() (*sql.Tx, error) {
-	return db.Master().Begin()
+	return db.BeginTx(context.Background(), nil)
 }
 
 // BeginTx starts a transaction.
@@ -69,104 +174,337 @@ func (db *DB) Begin() (*sql.Tx, error) {
 // Tx.Commit will return an error if the context provided to BeginTx is canceled.
 // The provided TxOptions is optional and may be nil if defaults should be used.
 // If a non-default isolation level is used that the driver doesn't support, an error will be returned.
+// Read-only transactions go to the physical db reads with ctx go to, and others to the master.
 func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	return db.Master().BeginTx(ctx, opts)
+	if n := db.successor(); n != nil {
+		return n.BeginTx(ctx, opts)
+	}
+
+	if opts != nil && opts.ReadOnly {
+		if db.proxied() != nil {
+			return db.beginMaster(ctx, opts)
+		}
+		if i := db.readIndex(ctx); i != 0 {
+			return db.lanePDB(ctx, i).BeginTx(ctx, opts)
+		}
+		return db.beginMaster(ctx, opts)
+	}
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+	return db.beginMaster(ctx, opts)
 }
 
 // Exec executes a query without returning any rows.
 // The args are for any placeholder parameters in the query.
 // Exec uses the master as the underlying physical db.
 func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return db.Master().Exec(query, args...)
+	return db.ExecContext(context.Background(), query, args...)
 }
 
 // ExecContext executes a query without returning any rows.
 // The args are for any placeholder parameters in the query.
 // Exec uses the master as the underlying physical db.
 func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	return db.Master().ExecContext(ctx, query, args...)
+	if n := db.successor(); n != nil {
+		return n.ExecContext(ctx, query, args...)
+	}
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	ctx, err := db.override(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := db.charge(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = db.correlate(ctx)
+	query, args = db.rewriteINLists(query, args)
+	ctx = db.beforeQuery(ctx, OpExec, query)
+	ctx, cancel := db.statementContext(ctx, OpExec)
+	defer cancel()
+
+	start, q := time.Now(), db.rewrite(ctx, OpExec, query)
+	res, err := db.exec(ctx, q, args)
+	err = queryError(ctx, 0, err)
+	db.finish(ctx, acct, &QueryInfo{Op: OpExec, SQL: q, Args: len(args), FormattedArgs: db.formatArgs(args), Attempt: 1, Err: err}, start)
+	db.wrote(ctx)
+	ResetMemo(ctx)
+
+	return res, err
+}
+
+func (db *DB) exec(ctx context.Context, query string, args []interface{}) (sql.Result, error) {
+	if db.checkoutTimeout() <= 0 || db.bulkLane(ctx) {
+		return db.lanePDB(ctx, 0).ExecContext(ctx, query, args...)
+	}
+
+	conn, err := db.checkoutConn(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.ExecContext(ctx, query, args...)
+}
+
+// queryWrite runs query, which writes or locks rows, on the master as
+// ExecContext does, returning its rows.
+func (db *DB) queryWrite(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	ctx, err := db.override(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := db.charge(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = db.correlate(ctx)
+	query, args = db.rewriteINLists(query, args)
+	ctx = db.beforeQuery(ctx, OpQuery, query)
+	ctx, cancel := db.statementContext(ctx, OpQuery)
+
+	start, q := time.Now(), db.rewrite(ctx, OpQuery, query)
+	rows, err := db.lanePDB(ctx, 0).QueryContext(ctx, q, args...)
+	db.wrote(ctx)
+	ResetMemo(ctx)
+
+	info := QueryInfo{Op: OpQuery, SQL: q, Args: len(args), FormattedArgs: db.formatArgs(args), Attempt: 1}
+	if err != nil {
+		cancel()
+		info.Err = queryError(ctx, 0, err)
+		db.finish(ctx, acct, &info, start)
+		return nil, info.Err
+	}
+
+	db.finish(ctx, acct, &info, start)
+	return db.newRows(ctx, rows, &info, start, cancel), nil
 }
 
 // Ping verifies if a connection to each physical database is still alive,
 // establishing a connection if necessary.
+// Each result feeds the health signal of its physical db.
 func (db *DB) Ping() error {
-	return scatter(len(db.pdbs), func(i int) error {
-		return db.pdbs[i].Ping()
-	})
+	return db.PingContext(context.Background())
 }
 
 // PingContext verifies if a connection to each physical database is still
 // alive, establishing a connection if necessary.
+// Each result feeds the health signal of its physical db.
 func (db *DB) PingContext(ctx context.Context) error {
-	return scatter(len(db.pdbs), func(i int) error {
-		return db.pdbs[i].PingContext(ctx)
+	if n := db.successor(); n != nil {
+		return n.PingContext(ctx)
+	}
+
+	t := db.topology()
+	return scatter(len(t.pdbs), func(i int) error {
+		err := t.pdbs[i].PingContext(ctx)
+		if ctx.Err() == nil {
+			t.health(i).observe(db.healthPolicy(), err == nil)
+		}
+		return err
 	})
 }
 
 // Prepare creates a prepared statement for later queries or executions
 // on each physical database, concurrently.
+// Logical replicas failing to prepare it are skipped by the statement.
 func (db *DB) Prepare(query string) (*Stmt, error) {
-	stmts := make([]*sql.Stmt, len(db.pdbs))
-
-	err := scatter(len(db.pdbs), func(i int) (err error) {
-		stmts[i], err = db.pdbs[i].Prepare(query)
-		return err
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &Stmt{db: db, stmts: stmts}, nil
+	return db.PrepareContext(context.Background(), query)
 }
 
 // PrepareContext creates a prepared statement for later queries or executions
 // on each physical database, concurrently.
+// Logical replicas failing to prepare it are skipped by the statement.
+// Statements prewarmed for query with Prewarm are taken over instead.
 // The provided context is used for the preparation of the statement, not for
 // the execution of the statement.
 func (db *DB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
-	stmts := make([]*sql.Stmt, len(db.pdbs))
-
-	err := scatter(len(db.pdbs), func(i int) (err error) {
-		stmts[i], err = db.pdbs[i].PrepareContext(ctx, query)
-		return err
-	})
+	if n := db.successor(); n != nil {
+		return n.PrepareContext(ctx, query)
+	}
 
-	if err != nil {
-		return nil, err
+	set := db.prewarmedSet(query)
+	if set == nil {
+		var err error
+		if set, err = db.prepareSet(ctx, query); err != nil {
+			return nil, err
+		}
 	}
 
-	return &Stmt{db: db, stmts: stmts}, nil
+	s := &Stmt{db: db, query: query, class: classifySQL(query)}
+	s.set.Store(set)
+	db.stmts.Store(s, struct{}{})
+	return s, nil
 }
 
 // Query executes a query that returns rows, typically a SELECT.
 // The args are for any placeholder parameters in the query.
 // Query uses a slave as the physical db.
-func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return db.Slave().Query(query, args...)
+func (db *DB) Query(query string, args ...interface{}) (*Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
 }
 
 // QueryContext executes a query that returns rows, typically a SELECT.
 // The args are for any placeholder parameters in the query.
 // QueryContext uses a slave as the physical db.
-func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	return db.Slave().QueryContext(ctx, query, args...)
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if n := db.successor(); n != nil {
+		return n.QueryContext(ctx, query, args...)
+	}
+
+	ctx, err := db.override(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, key, ok := memoOf(ctx, query, args); ok {
+		e, err := m.load(ctx, key, db.memoExpiry(), func() (*Rows, error) { return db.queryContext(ctx, query, args) })
+		if err != nil {
+			return nil, err
+		}
+		return db.memoRows(ctx, e, &QueryInfo{Op: OpQuery, SQL: query, Args: len(args), FormattedArgs: db.formatArgs(args)})
+	}
+	return db.queryContext(ctx, query, args)
+}
+
+// queryContext runs query with ctx, whose route overrides are applied.
+func (db *DB) queryContext(ctx context.Context, query string, args []interface{}) (*Rows, error) {
+	acct, err := db.charge(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = db.correlate(ctx)
+	query, args = db.rewriteINLists(query, args)
+	ctx = db.beforeQuery(ctx, OpQuery, query)
+	ctx, query = db.readAsOf(ctx, query)
+	ctx = db.tableFreshness(ctx, query)
+	ctx, cancel := db.statementContext(ctx, OpQuery)
+
+	start, q := time.Now(), db.rewrite(ctx, OpQuery, query)
+	rows, node, err := db.query(ctx, q, args)
+	node, attempt, err := db.retry(ctx, node, err, func(i int) (err error) {
+		rows, err = db.queryNode(ctx, i, q, args)
+		return err
+	})
+
+	info := QueryInfo{Op: OpQuery, SQL: q, Args: len(args), FormattedArgs: db.formatArgs(args), Node: node, Attempt: attempt}
+	if err != nil {
+		cancel()
+		info.Err = queryError(ctx, node, err)
+		db.finish(ctx, acct, &info, start)
+		return nil, info.Err
+	}
+
+	db.mirrorRead(start, query, args)
+	db.cachePlan(node, q, args)
+	db.finish(ctx, acct, &info, start)
+
+	return db.newRows(ctx, rows, &info, start, cancel), nil
+}
+
+func (db *DB) query(ctx context.Context, query string, args []interface{}) (*sql.Rows, int, error) {
+	if _, ok := db.readIsolation(ctx); ok || db.checkoutTimeout() <= 0 || db.bulkLane(ctx) {
+		node := db.readIndex(ctx)
+		rows, err := db.queryNode(ctx, node, query, args)
+		return rows, node, err
+	}
+
+	conn, node, err := db.checkoutSlave(ctx)
+	if err != nil {
+		return nil, node, err
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	release(conn)
+
+	return rows, node, err
 }
 
 // QueryRow executes a query that is expected to return at most one row.
 // QueryRow always return a non-nil value.
 // Errors are deferred until Row's Scan method is called.
 // QueryRow uses a slave as the physical db.
-func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
-	return db.Slave().QueryRow(query, args...)
+func (db *DB) QueryRow(query string, args ...interface{}) *Row {
+	return db.QueryRowContext(context.Background(), query, args...)
 }
 
 // QueryRowContext executes a query that is expected to return at most one row.
 // QueryRowContext always return a non-nil value.
 // Errors are deferred until Row's Scan method is called.
 // QueryRowContext uses a slave as the physical db.
-func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	return db.Slave().QueryRowContext(ctx, query, args...)
+// Since a Row can't carry ErrPoolExhausted, QueryRowContext waits for
+// a connection as usual when every slave exceeds the checkout timeout.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	if n := db.successor(); n != nil {
+		return n.QueryRowContext(ctx, query, args...)
+	}
+
+	ctx, err := db.override(ctx, query)
+	if m, key, ok := memoOf(ctx, query, args); ok && err == nil {
+		e, err := m.load(ctx, key, db.memoExpiry(), func() (*Rows, error) { return db.queryContext(ctx, query, args) })
+		return db.memoRow(ctx, e, err, &QueryInfo{Op: OpQueryRow, SQL: query, Args: len(args), FormattedArgs: db.formatArgs(args)})
+	}
+
+	var acct *account
+	if err == nil {
+		acct, err = db.charge(ctx)
+	}
+	if err != nil {
+		return db.newRow(ctx, errRow(db.Master(), err), &QueryInfo{Op: OpQueryRow, SQL: query, Args: len(args), FormattedArgs: db.formatArgs(args), Err: err}, time.Now(), nil)
+	}
+
+	ctx = db.correlate(ctx)
+	query, args = db.rewriteINLists(query, args)
+	ctx = db.beforeQuery(ctx, OpQueryRow, query)
+	ctx, query = db.readAsOf(ctx, query)
+	ctx = db.tableFreshness(ctx, query)
+	ctx, cancel := db.statementContext(ctx, OpQueryRow)
+
+	start, q := time.Now(), db.rewrite(ctx, OpQueryRow, query)
+	row, node := db.queryRow(ctx, q, args)
+	node, attempt, err := db.retry(ctx, node, row.Err(), func(i int) error {
+		row = db.queryRowNode(ctx, i, q, args)
+		return row.Err()
+	})
+
+	info := QueryInfo{Op: OpQueryRow, SQL: q, Args: len(args), FormattedArgs: db.formatArgs(args), Node: node, Attempt: attempt, Err: err}
+	db.mirrorRead(start, query, args)
+	if err == nil {
+		db.cachePlan(node, q, args)
+	}
+	db.finish(ctx, acct, &info, start)
+
+	r := db.newRow(ctx, row, &info, start, cancel)
+	r.recheck = db.recheckRow(ctx, node, query, args)
+	return r
+}
+
+func (db *DB) queryRow(ctx context.Context, query string, args []interface{}) (*sql.Row, int) {
+	if _, ok := db.readIsolation(ctx); !ok && db.checkoutTimeout() > 0 && !db.bulkLane(ctx) {
+		if conn, node, err := db.checkoutSlave(ctx); err == nil {
+			row := conn.QueryRowContext(ctx, query, args...)
+			release(conn)
+			return row, node
+		}
+	}
+
+	node := db.readIndex(ctx)
+	return db.queryRowNode(ctx, node, query, args), node
 }
 
 // SetMaxIdleConns sets the maximum number of connections in the idle
@@ -175,9 +513,7 @@ func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interfa
 // new MaxIdleConns will be reduced to match the MaxOpenConns limit
 // If n <= 0, no idle connections are retained.
 func (db *DB) SetMaxIdleConns(n int) {
-	for i := range db.pdbs {
-		db.pdbs[i].SetMaxIdleConns(n)
-	}
+	db.SetMaxIdleConnsContext(context.Background(), n)
 }
 
 // SetMaxOpenConns sets the maximum number of open connections
@@ -187,28 +523,144 @@ func (db *DB) SetMaxIdleConns(n int) {
 // the new MaxOpenConns limit. If n <= 0, then there is no limit on the number
 // of open connections. The default is 0 (unlimited).
 func (db *DB) SetMaxOpenConns(n int) {
-	for i := range db.pdbs {
-		db.pdbs[i].SetMaxOpenConns(n)
-	}
+	db.SetMaxOpenConnsContext(context.Background(), n)
 }
 
 // SetConnMaxLifetime sets the maximum amount of time a connection may be reused.
 // Expired connections may be closed lazily before reuse.
 // If d <= 0, connections are reused forever.
 func (db *DB) SetConnMaxLifetime(d time.Duration) {
-	for i := range db.pdbs {
-		db.pdbs[i].SetConnMaxLifetime(d)
-	}
+	db.SetConnMaxLifetimeContext(context.Background(), d)
+}
+
+// SetConnCheckoutTimeout sets the maximum amount of time a query waits for a
+// free connection on a physical db, independently of the query's own context.
+// Reads that exceed it overflow to the remaining slaves and fail with
+// ErrPoolExhausted once every slave was tried; writes fail right away.
+// If d <= 0, queries wait inside database/sql as usual. The default is 0.
+func (db *DB) SetConnCheckoutTimeout(d time.Duration) {
+	atomic.StoreInt64(&db.checkout, int64(d))
 }
 
-// Master returns the master physical database
+// Master returns the master physical database, or nil if the DB has none.
 func (db *DB) Master() *sql.DB {
-	return db.pdbs[0]
+	return db.topology().pdb(0)
 }
 
-// Slave returns one of the physical databases which is a slave
+// Slave returns one of the physical databases which is a slave,
+// or matches the selector set with SetReadSelector.
+// It returns the master when reads fall back to it, or nil if the DB has none.
 func (db *DB) Slave() *sql.DB {
-	return db.pdbs[db.slave(len(db.pdbs))]
+	return db.topology().pdb(db.readIndex(context.Background()))
+}
+
+// ErrNoSlaves is returned by SlaveDB when no slave is in rotation.
+var ErrNoSlaves = errors.New("nap: no slave in rotation")
+
+// ErrClosed is returned by MasterDB and SlaveDB once the DB is closed.
+var ErrClosed = errors.New("nap: database is closed")
+
+// MasterDB is like Master, returning ErrClosed once the DB is closed and
+// an error if the DB has no physical db, such as before being opened.
+func (db *DB) MasterDB() (*sql.DB, error) {
+	return db.checkedPDB(func(*topology) (int, error) { return 0, nil })
+}
+
+// SlaveDB is like Slave, returning ErrNoSlaves rather than the master when
+// no slave is in rotation, such as with zero slaves or all being drained,
+// and ErrClosed once the DB is closed. It ignores the freshness window of
+// SetConsistency, like reads with UseSlave.
+func (db *DB) SlaveDB() (*sql.DB, error) {
+	return db.checkedPDB(func(t *topology) (int, error) {
+		if i := db.readIndex(UseSlave(context.Background())); i > 0 && i < len(t.pdbs) {
+			return i, nil
+		}
+		return 0, ErrNoSlaves
+	})
+}
+
+// checkedPDB returns the physical db at the index fn returns in the current
+// topology, which must not be empty, unless the DB is closed.
+func (db *DB) checkedPDB(fn func(t *topology) (int, error)) (*sql.DB, error) {
+	select {
+	case <-db.done():
+		return nil, ErrClosed
+	default:
+	}
+
+	t := db.topology()
+	if len(t.pdbs) == 0 {
+		return nil, errors.New("nap: no physical db")
+	}
+
+	i, err := fn(t)
+	if err != nil {
+		return nil, err
+	}
+	return t.pdbs[i], nil
+}
+
+// ForEachSlave calls fn with the index and physical db of each slave in
+// order, stopping at the first error returned by fn or when ctx is done.
+func (db *DB) ForEachSlave(ctx context.Context, fn func(i int, db *sql.DB) error) error {
+	if n := db.successor(); n != nil {
+		return n.ForEachSlave(ctx, fn)
+	}
+
+	pdbs := db.topology().pdbs
+	for i := 1; i < len(pdbs); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(i, pdbs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachSlaveParallel is like ForEachSlave but calls fn for up to n slaves
+// concurrently. Every slave is visited unless ctx is done, and the errors of
+// the failing slaves, or ctx's for those not visited, are returned as a
+// *MultiError.
+// If n <= 0, every slave is visited concurrently.
+func (db *DB) ForEachSlaveParallel(ctx context.Context, n int, fn func(i int, db *sql.DB) error) error {
+	if next := db.successor(); next != nil {
+		return next.ForEachSlaveParallel(ctx, n, fn)
+	}
+
+	pdbs := db.topology().pdbs
+	slaves := len(pdbs) - 1
+	if slaves <= 0 {
+		return nil
+	}
+
+	if n <= 0 || n > slaves {
+		n = slaves
+	}
+
+	errs := make([]error, len(pdbs))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+
+	for i := 1; i <= slaves; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = fn(i, pdbs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return multiError(errs)
 }
 
 func (db *DB) slave(n int) int {
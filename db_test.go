@@ -1,7 +1,11 @@
 package nap
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"testing/quick"
 
@@ -20,7 +24,7 @@ func TestOpen(t *testing.T) {
 		t.Error(err)
 	}
 
-	if want, got := 3, len(db.pdbs); want != got {
+	if want, got := 3, len(db.topology().pdbs); want != got {
 		t.Errorf("Unexpected number of physical dbs. Got: %d, Want: %d", got, want)
 	}
 }
@@ -35,8 +39,14 @@ func TestClose(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err = db.Ping(); err.Error() != "sql: database is closed" {
-		t.Errorf("Physical dbs were not closed correctly. Got: %s", err)
+	var m *MultiError
+	if err = db.Ping(); !errors.As(err, &m) || len(m.Errors) != 3 {
+		t.Fatalf("Physical dbs were not closed correctly. Got: %v", err)
+	}
+	for i, err := range m.Errors {
+		if err.Index != i || err.Err.Error() != "sql: database is closed" {
+			t.Errorf("Physical db %d was not closed correctly. Got: %s", i, err)
+		}
 	}
 }
 
@@ -56,7 +66,7 @@ func TestWrap(t *testing.T) {
 		t.Error(err)
 	}
 
-	if want, got := 1, len(wdb.pdbs); want != got {
+	if want, got := 1, len(wdb.topology().pdbs); want != got {
 		t.Errorf("Unexpected number of physical dbs. Got: %d, Want: %d", got, want)
 	}
 }
@@ -81,3 +91,290 @@ func TestSlave(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestForEachSlave(t *testing.T) {
+	db := wrap(make([]*sql.DB, 4), nil)
+
+	var visited []int
+	err := db.ForEachSlave(context.Background(), func(i int, _ *sql.DB) error {
+		visited = append(visited, i)
+		if i == 2 {
+			return errors.New("stop")
+		}
+		return nil
+	})
+
+	if err == nil || len(visited) != 2 || visited[0] != 1 || visited[1] != 2 {
+		t.Errorf("Unexpected sequential visit: %v, err: %v", visited, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = db.ForEachSlave(ctx, func(int, *sql.DB) error { return nil }); err != context.Canceled {
+		t.Errorf("Want context.Canceled, got: %v", err)
+	}
+}
+
+func TestForEachSlaveParallel(t *testing.T) {
+	db := wrap(make([]*sql.DB, 9), nil)
+
+	var running, peak, calls int32
+	err := db.ForEachSlaveParallel(context.Background(), 3, func(i int, _ *sql.DB) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		atomic.AddInt32(&calls, 1)
+		if i%2 == 0 {
+			return fmt.Errorf("slave %d", i)
+		}
+		return nil
+	})
+
+	if calls != 8 || peak > 3 {
+		t.Errorf("Unexpected parallel visit. Calls: %d, Peak concurrency: %d", calls, peak)
+	}
+
+	var m *MultiError
+	if !errors.As(err, &m) || len(m.Errors) != 4 {
+		t.Fatalf("Want errors of the 4 failing slaves, got: %v", err)
+	}
+	for k, err := range m.Errors {
+		if i := 2 * (k + 1); err.Index != i || err.Role != "slave" || err.Err.Error() != fmt.Sprintf("slave %d", i) {
+			t.Errorf("Unexpected error of a failing slave: %v", err)
+		}
+	}
+}
+
+func TestForwardingAllocs(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var n int
+	base := testing.AllocsPerRun(100, func() {
+		db.Slave().QueryRow("SELECT ?, ?", 1000, "a").Scan(&n, new(string))
+	})
+
+	// Forwarding args must not copy them, leaving only the Row wrapper.
+	got := testing.AllocsPerRun(100, func() {
+		db.QueryRow("SELECT ?, ?", 1000, "a").Scan(&n, new(string))
+	})
+
+	if got > base+1 {
+		t.Errorf("Unexpected allocations. Got: %v, Want at most: %v", got, base+1)
+	}
+}
+
+func benchmarkDB(b *testing.B) *DB {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	db.SetMaxIdleConns(4)
+	return db
+}
+
+// The SQL benchmarks are baselines running the same queries directly
+// on the physical dbs.
+
+func BenchmarkSQLExec(b *testing.B) {
+	db := benchmarkDB(b)
+	defer db.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Master().Exec("SELECT ?", i); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExec(b *testing.B) {
+	db := benchmarkDB(b)
+	defer db.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Exec("SELECT ?", i); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSQLQuery(b *testing.B) {
+	db := benchmarkDB(b)
+	defer db.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rows, err := db.Slave().Query("SELECT ?", i)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rows.Close()
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	db := benchmarkDB(b)
+	defer db.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rows, err := db.Query("SELECT ?", i)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rows.Close()
+	}
+}
+
+func BenchmarkSQLQueryRow(b *testing.B) {
+	db := benchmarkDB(b)
+	defer db.Close()
+
+	var n int
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := db.Slave().QueryRow("SELECT ?", i).Scan(&n); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueryRow(b *testing.B) {
+	db := benchmarkDB(b)
+	defer db.Close()
+
+	var n int
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := db.QueryRow("SELECT ?", i).Scan(&n); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStmtQueryRow(b *testing.B) {
+	db := benchmarkDB(b)
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT ?")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var n int
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := stmt.QueryRow(i).Scan(&n); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBeginTxReadOnly(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	for i, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE node (i INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pdb.Exec("INSERT INTO node VALUES (?)", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := func(ctx context.Context, opts *sql.TxOptions) int {
+		tx, err := db.BeginTx(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		var i int
+		if err = tx.QueryRow("SELECT i FROM node").Scan(&i); err != nil {
+			t.Fatal(err)
+		}
+		return i
+	}
+
+	ctx := context.Background()
+	if i := node(ctx, &sql.TxOptions{ReadOnly: true}); i != 1 {
+		t.Errorf("Read-only transaction began on %d instead of the slave", i)
+	}
+	if i := node(UseMaster(ctx), &sql.TxOptions{ReadOnly: true}); i != 0 {
+		t.Errorf("Read-only transaction began on %d instead of the master", i)
+	}
+	if i := node(ctx, nil); i != 0 {
+		t.Errorf("Transaction began on %d instead of the master", i)
+	}
+}
+
+func TestCheckedAccessors(t *testing.T) {
+	empty := &DB{}
+	if empty.Master() != nil || empty.Slave() != nil {
+		t.Error("Physical dbs returned by an empty DB")
+	}
+	if _, err := empty.MasterDB(); err == nil {
+		t.Error("Master returned by an empty DB")
+	}
+
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pdb, err := db.MasterDB(); err != nil || pdb != db.topology().pdbs[0] {
+		t.Errorf("Unexpected master: %v", err)
+	}
+	if pdb, err := db.SlaveDB(); err != nil || pdb != db.topology().pdbs[1] {
+		t.Errorf("Unexpected slave: %v", err)
+	}
+
+	// Negative and stale indexes, such as of removed slaves, are out of range.
+	for _, i := range []int{-1, 2} {
+		db.SetWeight(i, 3)
+		if w := db.Weight(i); w != 1 {
+			t.Errorf("Unexpected weight of %d: %d", i, w)
+		}
+		if l := db.Labels(i); l["role"] != "slave" {
+			t.Errorf("Unexpected labels of %d: %v", i, l)
+		}
+		if db.Healthy(i) || db.HealthScore(i) != 0 || db.Lag(i) >= 0 {
+			t.Errorf("Physical db %d out of range reported healthy", i)
+		}
+		if err = db.SetConnResetter(i, ResetStatements("SELECT 1")); err == nil {
+			t.Errorf("Connection resetter set on %d", i)
+		}
+	}
+
+	db.StartDrill(Drill{SlavesDown: []int{1}})
+	if _, err = db.SlaveDB(); err != ErrNoSlaves {
+		t.Errorf("Want ErrNoSlaves, got: %v", err)
+	}
+	db.StopDrill()
+
+	db.Close()
+	if _, err = db.MasterDB(); err != ErrClosed {
+		t.Errorf("Want ErrClosed, got: %v", err)
+	}
+	if _, err = db.SlaveDB(); err != ErrClosed {
+		t.Errorf("Want ErrClosed, got: %v", err)
+	}
+}
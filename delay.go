@@ -0,0 +1,61 @@
+package nap
+
+import (
+	"context"
+	"time"
+)
+
+// Delayed replicas carry the "delay" label set to their replication delay,
+// such as "1h0m0s".
+const delayLabel = "delay"
+
+type nodeKey struct{}
+
+// WithNode returns a copy of ctx whose reads go to the physical db at
+// index i, whatever its role, labels or health, such as for point-in-time
+// inspection on a delayed replica. These reads aren't retried elsewhere.
+func WithNode(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, nodeKey{}, i)
+}
+
+// SetDelay marks the slave at index i as intentionally delayed by d, such
+// as a replica kept an hour behind for disaster recovery, or unmarks it if
+// d <= 0. Delayed replicas are excluded from balancing and from selectors
+// which don't require the delay label, such as "delay=1h0m0s", but are
+// still health checked and reachable with WithNode.
+func (db *DB) SetDelay(i int, d time.Duration) {
+	value := ""
+	if d > 0 {
+		value = d.String()
+	}
+	db.setLabel(i, delayLabel, value)
+}
+
+// Delay returns the intentional replication delay of the physical db at
+// index i, or 0 if it isn't delayed.
+func (db *DB) Delay(i int) time.Duration {
+	d, _ := time.ParseDuration(db.labelsOf(i)[delayLabel])
+	return d
+}
+
+// delayed reports whether the physical db at index i is a delayed replica.
+func (db *DB) delayed(i int) bool {
+	return db.labelsOf(i)[delayLabel] != ""
+}
+
+// nodeIndex returns the index of the physical db the reads of ctx were
+// directed to with WithNode, if valid.
+func (db *DB) nodeIndex(ctx context.Context) (int, bool) {
+	i, ok := ctx.Value(nodeKey{}).(int)
+	return i, ok && i >= 0 && i < len(db.topology().pdbs)
+}
+
+// requiresDelay reports whether sel explicitly selects delayed replicas.
+func requiresDelay(sel Selector) bool {
+	for _, r := range sel {
+		if r.Key == delayLabel && !r.Not && r.Value != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelayedReplica(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetDelay(2, time.Hour)
+	if d := db.Delay(2); d != time.Hour {
+		t.Errorf("Unexpected delay. Got: %s, Want: %s", d, time.Hour)
+	}
+
+	for i := 0; i < 4; i++ {
+		if row := db.QueryRow("SELECT 1"); row.Scan(new(int)) != nil || row.Node() != 1 {
+			t.Errorf("Read balanced to node %d instead of 1", row.Node())
+		}
+	}
+
+	ctx := WithSelector(context.Background(), MustParseSelector("role=slave"))
+	if nodes := db.eligible(ctx); len(nodes) != 1 || nodes[0] != 1 {
+		t.Errorf("Delayed replica matched a selector not requiring it: %v", nodes)
+	}
+
+	ctx = WithSelector(context.Background(), MustParseSelector("delay=1h0m0s"))
+	if nodes := db.eligible(ctx); len(nodes) != 1 || nodes[0] != 2 {
+		t.Errorf("Delayed replica not matched by its delay: %v", nodes)
+	}
+
+	row := db.QueryRowContext(WithNode(context.Background(), 2), "SELECT 1")
+	if err = row.Scan(new(int)); err != nil || row.Node() != 2 {
+		t.Errorf("Read not directed to the delayed replica: %d, %v", row.Node(), err)
+	}
+
+	db.SetDelay(2, 0)
+	if db.Delay(2) != 0 || !db.balanced(2) {
+		t.Error("Replica still delayed after unmarking it")
+	}
+}
+
+func TestWithNodeNotRetried(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetReadRetryPolicy(RetryPolicy{Attempts: 2})
+	db.topology().pdbs[1].Close()
+
+	row := db.QueryRowContext(WithNode(context.Background(), 1), "SELECT 1")
+	if err = row.Scan(new(int)); err == nil || row.Node() != 1 {
+		t.Errorf("Read directed to a node retried elsewhere: %d, %v", row.Node(), err)
+	}
+}
@@ -0,0 +1,86 @@
+package nap
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+)
+
+// SetDialTimeout bounds how long establishing each new connection to the
+// physical dbs opened by Open may take, including those dialed by the pool
+// in the background, so that slow DNS or TLS to one replica can't hold
+// callers. Dials are also abandoned once the context of the operation
+// waiting for them is done, even with drivers ignoring it, in which case
+// the connection is closed once established. If d <= 0, dials are only
+// bounded by the context of their operation, which is the default.
+func (db *DB) SetDialTimeout(d time.Duration) {
+	for _, c := range db.topology().connectors {
+		if c != nil {
+			c.dialMax.Store(d)
+		}
+	}
+}
+
+// dialStats are the stats of the dials of a connector, accessed atomically.
+type dialStats struct {
+	count   uint64
+	errors  uint64
+	latency int64 // Smoothed latency in nanoseconds
+}
+
+// observe records a dial which took d and failed with err, if not nil.
+func (s *dialStats) observe(d time.Duration, err error) {
+	atomic.AddUint64(&s.count, 1)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+
+	old := atomic.LoadInt64(&s.latency)
+	if old == 0 {
+		atomic.StoreInt64(&s.latency, int64(d))
+		return
+	}
+	atomic.StoreInt64(&s.latency, int64(latencyAlpha*float64(d)+(1-latencyAlpha)*float64(old)))
+}
+
+// dial establishes a driver connection with creds, bounded by the dial
+// timeout and ctx.
+func (c *connector) dial(ctx context.Context, creds *credentials) (driver.Conn, error) {
+	if d, _ := c.dialMax.Load().(time.Duration); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	type dialed struct {
+		ci  driver.Conn
+		err error
+	}
+
+	start := time.Now()
+	done := make(chan dialed, 1)
+	go func() {
+		var r dialed
+		if creds.base != nil {
+			r.ci, r.err = creds.base.Connect(ctx)
+		} else {
+			r.ci, r.err = c.driver.Open(creds.dsn)
+		}
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		c.dials.observe(time.Since(start), r.err)
+		return r.ci, r.err
+	case <-ctx.Done():
+		c.dials.observe(time.Since(start), ctx.Err())
+		go func() {
+			if r := <-done; r.ci != nil {
+				r.ci.Close() // Established after being abandoned
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
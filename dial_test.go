@@ -0,0 +1,96 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// slowDriver opens SQLite in-memory connections after its delay, ignoring
+// the context of dials like drivers not implementing driver.DriverContext.
+type slowDriver struct {
+	delay  int64 // Accessed atomically
+	closed int32 // Connections closed, accessed atomically
+}
+
+func (d *slowDriver) Open(name string) (driver.Conn, error) {
+	time.Sleep(time.Duration(atomic.LoadInt64(&d.delay)))
+	c, err := (&sqlite3.SQLiteDriver{}).Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return slowConn{c.(*sqlite3.SQLiteConn), d}, nil
+}
+
+type slowConn struct {
+	*sqlite3.SQLiteConn
+	driver *slowDriver
+}
+
+func (c slowConn) Close() error {
+	atomic.AddInt32(&c.driver.closed, 1)
+	return c.SQLiteConn.Close()
+}
+
+var slow = &slowDriver{}
+
+func init() {
+	sql.Register("sqlite3_slow", slow)
+}
+
+func TestDial(t *testing.T) {
+	db, err := Open("sqlite3_slow", "master;slave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.QueryRow("SELECT 1").Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if s := db.Stats()[1]; s.Dials != 1 || s.DialErrors != 0 || s.DialLatency <= 0 {
+		t.Errorf("Unexpected dial stats: %+v", s)
+	}
+
+	db.SetMaxIdleConns(0)
+	closed := atomic.LoadInt32(&slow.closed)
+	atomic.StoreInt64(&slow.delay, int64(100*time.Millisecond))
+	defer atomic.StoreInt64(&slow.delay, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err = db.QueryRowContext(ctx, "SELECT 1").Scan(new(int)); err != context.DeadlineExceeded {
+		t.Errorf("Want context.DeadlineExceeded from a slow dial, got: %v", err)
+	}
+	if d := time.Since(start); d >= 100*time.Millisecond {
+		t.Errorf("Slow dial not abandoned with its context: %s", d)
+	}
+
+	db.SetDialTimeout(10 * time.Millisecond)
+	start = time.Now()
+	if err = db.QueryRow("SELECT 1").Scan(new(int)); err != context.DeadlineExceeded {
+		t.Errorf("Want context.DeadlineExceeded from a slow dial, got: %v", err)
+	}
+	if d := time.Since(start); d >= 100*time.Millisecond {
+		t.Errorf("Slow dial not bounded by the dial timeout: %s", d)
+	}
+
+	if s := db.Snapshot().Nodes[1].Pool; s.Dials != 3 || s.DialErrors != 2 {
+		t.Errorf("Unexpected dial stats in the snapshot: %+v", s)
+	}
+
+	// Abandoned connections are closed once established.
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&slow.closed) < closed+2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Abandoned connections not closed: %d closed", atomic.LoadInt32(&slow.closed)-closed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,118 @@
+package nap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrDrill is returned for writes refused by a failover drill.
+var ErrDrill = errors.New("nap: write refused by failover drill")
+
+// Drill describes a simulated failure, used to rehearse failover runbooks
+// against real application code in staging.
+type Drill struct {
+	MasterDown bool  `json:"master_down"` // Writes fail with ErrDrill as if the master was lost
+	SlavesDown []int `json:"slaves_down"` // Indexes of the slaves taken out of rotation
+}
+
+// StartDrill starts simulating the failures described by d, replacing any
+// drill in progress.
+func (db *DB) StartDrill(d Drill) {
+	d.SlavesDown = append([]int(nil), d.SlavesDown...)
+	db.drill.Store(&d)
+}
+
+// StopDrill stops the drill in progress, if any.
+func (db *DB) StopDrill() {
+	db.drill.Store((*Drill)(nil))
+}
+
+// ActiveDrill returns the drill in progress, if any.
+func (db *DB) ActiveDrill() (Drill, bool) {
+	if d := db.activeDrill(); d != nil {
+		return Drill{MasterDown: d.MasterDown, SlavesDown: append([]int(nil), d.SlavesDown...)}, true
+	}
+	return Drill{}, false
+}
+
+// drillState is the state served by DrillHandler.
+type drillState struct {
+	Active bool `json:"active"`
+	Drill
+}
+
+// DrillHandler returns an admin handler starting the drill of the JSON
+// request body on PUT, such as {"master_down":true,"slaves_down":[2]},
+// stopping the drill in progress on DELETE, and serving it as JSON, such
+// as {"active":true,"master_down":true,"slaves_down":[2]}.
+func (db *DB) DrillHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var d Drill
+			err := json.NewDecoder(r.Body).Decode(&d)
+			if err == nil {
+				err = db.checkDrill(d)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			db.StartDrill(d)
+		case http.MethodDelete:
+			db.StopDrill()
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var state drillState
+		state.Drill, state.Active = db.ActiveDrill()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+}
+
+// checkDrill returns an error if d takes down physical dbs which aren't
+// slaves.
+func (db *DB) checkDrill(d Drill) error {
+	n := len(db.topology().pdbs)
+	for _, i := range d.SlavesDown {
+		if i <= 0 || i >= n {
+			return fmt.Errorf("nap: drill of %d, not a slave", i)
+		}
+	}
+	return nil
+}
+
+func (db *DB) activeDrill() *Drill {
+	d, _ := db.drill.Load().(*Drill)
+	return d
+}
+
+// drilledDown reports whether the slave at index i is down in a drill.
+func (db *DB) drilledDown(i int) bool {
+	if d := db.activeDrill(); d != nil {
+		for _, j := range d.SlavesDown {
+			if i == j {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writable returns an error if writes must be refused.
+func (db *DB) writable() error {
+	if d := db.activeDrill(); d != nil && d.MasterDown {
+		return ErrDrill
+	}
+	if err := db.frozenError(); err != nil {
+		return err
+	}
+	return db.replicated()
+}
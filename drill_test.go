@@ -0,0 +1,107 @@
+package nap
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDrill(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	slaves := []int{2}
+	db.StartDrill(Drill{MasterDown: true, SlavesDown: slaves})
+	slaves[0] = 1
+
+	if _, err = db.Exec("SELECT 1"); err != ErrDrill {
+		t.Errorf("Want ErrDrill from Exec, got: %v", err)
+	}
+
+	if _, err = db.Begin(); err != ErrDrill {
+		t.Errorf("Want ErrDrill from Begin, got: %v", err)
+	}
+
+	if err = db.ExecScript(context.Background(), "SELECT 1"); err != ErrDrill {
+		t.Errorf("Want ErrDrill from ExecScript, got: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(context.Background()); n != 1 {
+			t.Fatalf("Read routed to %d during the drill", n)
+		}
+	}
+
+	if d, ok := db.ActiveDrill(); !ok || !d.MasterDown || d.SlavesDown[0] != 2 {
+		t.Errorf("Unexpected active drill: %+v, %t", d, ok)
+	}
+
+	db.StopDrill()
+	if _, ok := db.ActiveDrill(); ok {
+		t.Error("Drill still active")
+	}
+
+	if _, err = db.Exec("SELECT 1"); err != nil {
+		t.Errorf("Write refused after the drill: %s", err)
+	}
+
+	if !db.Healthy(2) {
+		t.Error("Slave still down after the drill")
+	}
+}
+
+func TestDrillHandler(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	serve := func(method, body string) (int, drillState) {
+		rec := httptest.NewRecorder()
+		db.DrillHandler().ServeHTTP(rec, httptest.NewRequest(method, "/", strings.NewReader(body)))
+
+		var state drillState
+		if rec.Code == 200 {
+			if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, state
+	}
+
+	if code, state := serve("GET", ""); code != 200 || state.Active {
+		t.Errorf("Unexpected state: %d, %+v", code, state)
+	}
+
+	code, state := serve("PUT", `{"master_down":true,"slaves_down":[2]}`)
+	if want := (Drill{MasterDown: true, SlavesDown: []int{2}}); code != 200 || !state.Active || !reflect.DeepEqual(state.Drill, want) {
+		t.Errorf("Unexpected state once started: %d, %+v", code, state)
+	}
+	if _, err = db.Exec("SELECT 1"); err != ErrDrill {
+		t.Errorf("Want ErrDrill, got: %v", err)
+	}
+
+	if code, _ := serve("PUT", `{"slaves_down":[0]}`); code != 400 {
+		t.Errorf("Unexpected status of a drill of the master: %d", code)
+	}
+	if code, _ := serve("PUT", `{`); code != 400 {
+		t.Errorf("Unexpected status of a malformed drill: %d", code)
+	}
+	if d, ok := db.ActiveDrill(); !ok || !d.MasterDown {
+		t.Errorf("Drill replaced by an invalid one: %+v", d)
+	}
+
+	if code, state := serve("DELETE", ""); code != 200 || state.Active {
+		t.Errorf("Unexpected state once stopped: %d, %+v", code, state)
+	}
+	if code, _ := serve("POST", ""); code != 405 {
+		t.Errorf("Unexpected status of POST: %d", code)
+	}
+}
@@ -0,0 +1,298 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+// NewConnector opens a DB like Open and returns a driver.Connector routing
+// the statements of a plain *sql.DB through it, for libraries expecting
+// the standard interfaces, such as sqlx or ORMs:
+//
+//	sqldb := sql.OpenDB(connector)
+//
+// Queries writing or locking rows, such as INSERT … RETURNING or
+// SELECT … FOR UPDATE, go to the master like Exec does, since ORMs issue
+// them as queries. The DB is closed along with the *sql.DB.
+func NewConnector(driverName, dataSourceNames string) (driver.Connector, error) {
+	db, err := Open(driverName, dataSourceNames)
+	if err != nil {
+		return nil, err
+	}
+	return &dbConnector{db: db, owned: true}, nil
+}
+
+// Connector returns a driver.Connector routing the statements of a plain
+// *sql.DB through db, like NewConnector, without closing db along with it.
+func (db *DB) Connector() driver.Connector {
+	return &dbConnector{db: db}
+}
+
+// dbConnector connects to a DB as a whole. Its connections hold no physical
+// connection, except while in a transaction, so that every statement is
+// routed by the DB: writes go to the master and reads to the slaves, with
+// contexts honored as by the DB itself.
+type dbConnector struct {
+	db    *DB
+	owned bool // Whether db is closed along with the connector
+}
+
+// Connect implements the driver.Connector interface.
+func (c *dbConnector) Connect(context.Context) (driver.Conn, error) {
+	return &dbConn{db: c.db}, nil
+}
+
+// Driver implements the driver.Connector interface.
+func (c *dbConnector) Driver() driver.Driver {
+	return dbDriver{c}
+}
+
+// Close closes the DB when owned, when the *sql.DB is closed.
+func (c *dbConnector) Close() error {
+	if c.owned {
+		return c.db.Close()
+	}
+	return nil
+}
+
+// dbDriver is the driver of a dbConnector, ignoring the names it opens.
+type dbDriver struct {
+	c *dbConnector
+}
+
+// Open implements the driver.Driver interface.
+func (d dbDriver) Open(string) (driver.Conn, error) {
+	return d.c.Connect(context.Background())
+}
+
+// dbConn is a connection of a dbConnector.
+type dbConn struct {
+	db *DB
+	tx *sql.Tx // Transaction in progress, if any
+}
+
+// Prepare implements the driver.Conn interface.
+func (c *dbConn) Prepare(query string) (driver.Stmt, error) {
+	return &dbStmt{conn: c, query: query}, nil
+}
+
+// PrepareContext implements the driver.ConnPrepareContext interface.
+// Statements aren't prepared on the physical dbs, as the physical db each
+// execution goes to isn't known ahead.
+func (c *dbConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	return c.Prepare(query)
+}
+
+// Close implements the driver.Conn interface.
+func (c *dbConn) Close() error {
+	if c.tx != nil {
+		c.tx.Rollback()
+		c.tx = nil
+	}
+	return nil
+}
+
+// Begin implements the driver.Conn interface.
+func (c *dbConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements the driver.ConnBeginTx interface, beginning the
+// transaction on the physical db the DB begins it on.
+func (c *dbConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("nap: transaction already in progress")
+	}
+
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.IsolationLevel(opts.Isolation), ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	return dbTx{c}, nil
+}
+
+// ExecContext implements the driver.ExecerContext interface.
+func (c *dbConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.tx != nil {
+		return c.tx.ExecContext(ctx, query, namedArgs(args)...)
+	}
+	return c.db.ExecContext(ctx, query, namedArgs(args)...)
+}
+
+// QueryContext implements the driver.QueryerContext interface.
+func (c *dbConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.tx != nil {
+		rows, err := c.tx.QueryContext(ctx, query, namedArgs(args)...)
+		if err != nil {
+			return nil, err
+		}
+		return newDBRows(rows)
+	}
+
+	run := c.db.QueryContext
+	if classifySQL(query).write {
+		run = c.db.queryWrite // Such as INSERT … RETURNING or SELECT … FOR UPDATE
+	}
+	rows, err := run(ctx, query, namedArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return newDBRows(rows)
+}
+
+// Ping implements the driver.Pinger interface.
+func (c *dbConn) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// ResetSession implements the driver.SessionResetter interface.
+func (c *dbConn) ResetSession(context.Context) error {
+	return nil
+}
+
+// CheckNamedValue implements the driver.NamedValueChecker interface,
+// leaving args to be converted by the physical dbs.
+func (c *dbConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+// namedArgs converts driver args back to the args of database/sql.
+func namedArgs(args []driver.NamedValue) []interface{} {
+	converted := make([]interface{}, len(args))
+	for k, a := range args {
+		if converted[k] = a.Value; a.Name != "" {
+			converted[k] = sql.Named(a.Name, a.Value)
+		}
+	}
+	return converted
+}
+
+// dbTx is the transaction in progress of a dbConn.
+type dbTx struct {
+	c *dbConn
+}
+
+// Commit implements the driver.Tx interface.
+func (t dbTx) Commit() error {
+	tx := t.c.tx
+	t.c.tx = nil
+	return tx.Commit()
+}
+
+// Rollback implements the driver.Tx interface.
+func (t dbTx) Rollback() error {
+	tx := t.c.tx
+	t.c.tx = nil
+	return tx.Rollback()
+}
+
+// dbStmt is a statement of a dbConn, run as its queries.
+type dbStmt struct {
+	conn  *dbConn
+	query string
+}
+
+// Close implements the driver.Stmt interface.
+func (s *dbStmt) Close() error {
+	return nil
+}
+
+// NumInput implements the driver.Stmt interface, leaving args to be
+// checked by the physical dbs.
+func (s *dbStmt) NumInput() int {
+	return -1
+}
+
+// Exec implements the driver.Stmt interface.
+func (s *dbStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valueArgs(args))
+}
+
+// Query implements the driver.Stmt interface.
+func (s *dbStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valueArgs(args))
+}
+
+// ExecContext implements the driver.StmtExecContext interface.
+func (s *dbStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+// QueryContext implements the driver.StmtQueryContext interface.
+func (s *dbStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+// valueArgs converts positional driver args to named ones.
+func valueArgs(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for k, v := range args {
+		named[k] = driver.NamedValue{Ordinal: k + 1, Value: v}
+	}
+	return named
+}
+
+// rowsReader reads *sql.Rows and Rows alike.
+type rowsReader interface {
+	Columns() ([]string, error)
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
+}
+
+// dbRows are the driver rows of the rows of a dbConn.
+type dbRows struct {
+	rows    rowsReader
+	columns []string
+	dest    []interface{}
+	values  []interface{}
+}
+
+func newDBRows(rows rowsReader) (*dbRows, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	r := &dbRows{rows: rows, columns: columns}
+	r.values = make([]interface{}, len(columns))
+	r.dest = make([]interface{}, len(columns))
+	for k := range r.values {
+		r.dest[k] = &r.values[k]
+	}
+	return r, nil
+}
+
+// Columns implements the driver.Rows interface.
+func (r *dbRows) Columns() []string {
+	return r.columns
+}
+
+// Close implements the driver.Rows interface.
+func (r *dbRows) Close() error {
+	return r.rows.Close()
+}
+
+// Next implements the driver.Rows interface.
+func (r *dbRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	if err := r.rows.Scan(r.dest...); err != nil {
+		return err
+	}
+	for k, v := range r.values {
+		dest[k] = v
+	}
+	return nil
+}
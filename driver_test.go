@@ -0,0 +1,102 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestConnector(t *testing.T) {
+	c, err := NewConnector("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqldb := sql.OpenDB(c)
+	defer sqldb.Close()
+
+	db := c.(*dbConnector).db
+	db.SetMaxOpenConns(1)
+	for i, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE node (i INTEGER, s TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pdb.Exec("INSERT INTO node VALUES (?, 'pdb')", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var i int
+	if err = sqldb.QueryRow("SELECT i FROM node").Scan(&i); err != nil || i != 1 {
+		t.Errorf("Read routed to %d instead of the slave: %v", i, err)
+	}
+	if err = sqldb.QueryRowContext(UseMaster(context.Background()), "SELECT i FROM node").Scan(&i); err != nil || i != 0 {
+		t.Errorf("Read routed to %d instead of the master: %v", i, err)
+	}
+
+	if _, err = sqldb.Exec("INSERT INTO node VALUES (?, :s)", 2, sql.Named("s", "named")); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := sqldb.Prepare("SELECT COUNT(*) FROM node WHERE i >= ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var n int
+	if err = stmt.QueryRowContext(UseMaster(context.Background()), 0).Scan(&n); err != nil || n != 2 {
+		t.Errorf("Write not routed to the master: %d, %v", n, err)
+	}
+	if err = stmt.QueryRow(0).Scan(&n); err != nil || n != 1 {
+		t.Errorf("Statement read not routed to the slave: %d, %v", n, err)
+	}
+
+	tx, err := sqldb.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("DELETE FROM node"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.QueryRow("SELECT COUNT(*) FROM node").Scan(&n); err != nil || n != 0 {
+		t.Errorf("Read of the transaction not routed to it: %d, %v", n, err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if rows, err := sqldb.Query("INSERT INTO node VALUES (3, 'query')"); err != nil {
+		t.Fatal(err)
+	} else {
+		for rows.Next() {
+		}
+		rows.Close()
+	}
+	if err = stmt.QueryRowContext(UseMaster(context.Background()), 3).Scan(&n); err != nil || n != 1 {
+		t.Errorf("Write issued as a query not routed to the master: %d, %v", n, err)
+	}
+
+	rows, err := sqldb.Query("SELECT i, s FROM node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var s string
+		if err = rows.Scan(&i, &s); err != nil || s != "pdb" {
+			t.Errorf("Unexpected row: %d, %q, %v", i, s, err)
+		}
+	}
+	if err = rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sqldb.Ping(); err != nil {
+		t.Error(err)
+	}
+
+	sqldb.Close()
+	if db.Ping() == nil {
+		t.Error("DB not closed along with the connector")
+	}
+}
@@ -0,0 +1,184 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PlanComparison holds the plans of a query on each physical db.
+type PlanComparison struct {
+	Query string
+	Plans []NodePlan // Plan on each physical db, the master's first
+}
+
+// NodePlan is the plan of a query on a physical db.
+type NodePlan struct {
+	Index   int
+	Plan    []string // Rows returned by EXPLAIN, with columns separated by tabs
+	Cost    float64  // Total estimated cost of the first plan node, if reported
+	Err     error
+	Differs bool     // Set when the plan differs from the master's, ignoring estimates
+	Diff    []string // Lines of the master's plan removed ("- ") or added ("+ ")
+}
+
+// Divergent returns the indexes of the slaves whose plan differs from the
+// master's or couldn't be explained.
+func (c *PlanComparison) Divergent() []int {
+	var nodes []int
+	for _, p := range c.Plans[1:] {
+		if p.Differs || p.Err != nil {
+			nodes = append(nodes, p.Index)
+		}
+	}
+	return nodes
+}
+
+// ComparePlans runs EXPLAIN for query on the master and each slave
+// concurrently and compares the plans of the slaves to the master's, to
+// catch replicas with stale statistics or divergent indexes. Plans are
+// compared ignoring estimates, such as Postgres' cost, rows and width, which
+// are reported by Cost instead. Errors explaining the query on a physical
+// db are reported by its NodePlan.
+func (db *DB) ComparePlans(ctx context.Context, query string, args ...interface{}) (*PlanComparison, error) {
+	pdbs := db.topology().pdbs
+	c := &PlanComparison{Query: query, Plans: make([]NodePlan, len(pdbs))}
+
+	scatter(len(pdbs), func(i int) error {
+		p := &c.Plans[i]
+		p.Index = i
+		p.Plan, p.Err = explain(ctx, pdbs[i], query, args)
+		p.Cost = planCost(p.Plan)
+		return nil
+	})
+
+	if err := c.Plans[0].Err; err != nil {
+		return c, err
+	}
+
+	master := normalizePlan(c.Plans[0].Plan)
+	for i := range c.Plans[1:] {
+		p := &c.Plans[i+1]
+		if p.Err == nil {
+			p.Diff = diffLines(master, normalizePlan(p.Plan))
+			p.Differs = len(p.Diff) > 0
+		}
+	}
+
+	return c, nil
+}
+
+// explain returns the rows of EXPLAIN for query on pdb.
+func explain(ctx context.Context, pdb *sql.DB, query string, args []interface{}) ([]string, error) {
+	rows, err := pdb.QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []string
+	values := make([]interface{}, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range dest {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		line := make([]string, len(values))
+		for i, v := range values {
+			switch v := v.(type) {
+			case nil:
+				line[i] = "NULL"
+			case []byte:
+				line[i] = string(v)
+			default:
+				line[i] = fmt.Sprint(v)
+			}
+		}
+		plan = append(plan, strings.Join(line, "\t"))
+	}
+
+	return plan, rows.Err()
+}
+
+var (
+	// Postgres estimates, such as "(cost=0.00..35.50 rows=2550 width=4)".
+	planEstimates = regexp.MustCompile(`\s*\((cost|actual)=[^)]*\)`)
+	planCostRange = regexp.MustCompile(`cost=[0-9.]+\.\.([0-9.]+)`)
+)
+
+// planCost returns the total estimated cost of the first node of plan.
+func planCost(plan []string) float64 {
+	if len(plan) == 0 {
+		return 0
+	}
+
+	if m := planCostRange.FindStringSubmatch(plan[0]); m != nil {
+		cost, _ := strconv.ParseFloat(m[1], 64)
+		return cost
+	}
+	return 0
+}
+
+// normalizePlan strips estimates from plan.
+func normalizePlan(plan []string) []string {
+	normalized := make([]string, len(plan))
+	for i, line := range plan {
+		normalized[i] = planEstimates.ReplaceAllString(line, "")
+	}
+	return normalized
+}
+
+// diffLines returns the lines removed from a ("- ") and added by b ("+ "),
+// based on their longest common subsequence.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff, i = append(diff, "- "+a[i]), i+1
+		default:
+			diff, j = append(diff, "+ "+b[j]), j+1
+		}
+	}
+
+	for ; i < len(a); i++ {
+		diff = append(diff, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+ "+b[j])
+	}
+	return diff
+}
@@ -0,0 +1,78 @@
+package nap
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComparePlans(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	for _, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE t (id INTEGER, name TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = db.topology().pdbs[2].Exec("CREATE INDEX t_name ON t (name)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// SQLite reports the plan of a query with EXPLAIN QUERY PLAN.
+	c, err := db.ComparePlans(context.Background(), "QUERY PLAN SELECT id FROM t WHERE name = ?", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Plans) != 3 || len(c.Plans[0].Plan) == 0 {
+		t.Fatalf("Unexpected plans: %+v", c.Plans)
+	}
+
+	if c.Plans[1].Differs || len(c.Plans[1].Diff) != 0 {
+		t.Errorf("Identical plan reported as different: %v", c.Plans[1].Diff)
+	}
+
+	if !c.Plans[2].Differs || !strings.Contains(strings.Join(c.Plans[2].Diff, "\n"), "t_name") {
+		t.Errorf("Plan using a divergent index not reported: %v", c.Plans[2].Diff)
+	}
+
+	if nodes := c.Divergent(); !reflect.DeepEqual(nodes, []int{2}) {
+		t.Errorf("Unexpected divergent nodes. Got: %v, Want: [2]", nodes)
+	}
+
+	if _, err = db.ComparePlans(context.Background(), "QUERY PLAN SELECT * FROM missing"); err == nil {
+		t.Error("Expected error explaining on the master")
+	}
+}
+
+func TestPlanEstimates(t *testing.T) {
+	master := []string{
+		"Seq Scan on t  (cost=0.00..35.50 rows=2550 width=4)",
+		"  Filter: (id > 1)",
+	}
+	slave := []string{
+		"Index Scan using t_pkey on t  (cost=0.15..8.17 rows=1 width=4)",
+		"  Filter: (id > 1)",
+	}
+
+	if cost := planCost(master); cost != 35.5 {
+		t.Errorf("Unexpected cost. Got: %v, Want: 35.5", cost)
+	}
+
+	stale := []string{"Seq Scan on t  (cost=0.00..1.01 rows=1 width=4)", "  Filter: (id > 1)"}
+	if diff := diffLines(normalizePlan(master), normalizePlan(stale)); len(diff) != 0 {
+		t.Errorf("Plans differing by estimates only reported as different: %v", diff)
+	}
+
+	want := []string{"- Seq Scan on t", "+ Index Scan using t_pkey on t"}
+	if diff := diffLines(normalizePlan(master), normalizePlan(slave)); !reflect.DeepEqual(diff, want) {
+		t.Errorf("Unexpected diff. Got: %q, Want: %q", diff, want)
+	}
+}
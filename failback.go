@@ -0,0 +1,90 @@
+package nap
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// SetFailbackRamp makes reads with a selector, such as those preferring
+// same-zone replicas, shift back gradually to the matching slaves readmitted
+// by the health subsystem, rather than instantly, so that a zone recovering
+// from a zone-wide issue isn't overloaded again right away. Once readmitted,
+// a slave gets a share of the reads matching it growing linearly over d,
+// the rest going where they went while it was out of rotation. Only balanced
+// reads ramp up, unlike retries or the reads of sessions. Each
+// readmission randomly stretches or shrinks d by up to the jitter fraction,
+// such as 0.2 for 20%, so that slaves recovering together don't ramp up in
+// lockstep. If d <= 0, readmitted slaves get their share of reads instantly,
+// which is the default. It is the same as SetFailbackPolicy with
+// a LinearRamp.
+func (db *DB) SetFailbackRamp(d time.Duration, jitter float64) {
+	if d <= 0 {
+		db.SetFailbackPolicy(nil)
+		return
+	}
+	db.SetFailbackPolicy(LinearRamp{Duration: d, Jitter: jitter})
+}
+
+// SetFailbackPolicy is like SetFailbackRamp, shifting reads back to the
+// readmitted slaves with p, each readmission starting a shift. If p is nil,
+// readmitted slaves get their share of reads instantly, which is the default.
+func (db *DB) SetFailbackPolicy(p TrafficPolicy) {
+	db.failback.Store(trafficPolicy{p})
+}
+
+// failingBack returns the nodes a read matching nodes goes to, leaving out
+// those ramping up since readmitted. If all are, the read goes where it went
+// while they were out of rotation, to the other balanced slaves, if any.
+func (db *DB) failingBack(nodes []int) []int {
+	if p, _ := db.failback.Load().(trafficPolicy); p.TrafficPolicy == nil {
+		return nodes
+	}
+
+	var up []int
+	for _, i := range nodes {
+		if db.failedBack(i) {
+			up = append(up, i)
+		}
+	}
+	if len(up) > 0 {
+		return up
+	}
+
+	matched := make(map[int]bool, len(nodes))
+	for _, i := range nodes {
+		matched[i] = true
+	}
+
+	var others []int
+	for i, n := 1, len(db.topology().pdbs); i < n; i++ {
+		if !matched[i] && db.balanced(i) {
+			others = append(others, i)
+		}
+	}
+	if len(others) > 0 {
+		return others
+	}
+	return nodes
+}
+
+// failedBack reports whether a read matching the slave at index i may go to
+// it, as it ramps up since its last readmission.
+func (db *DB) failedBack(i int) bool {
+	p, _ := db.failback.Load().(trafficPolicy)
+	if p.TrafficPolicy == nil || i == 0 {
+		return true
+	}
+
+	h := db.health(i)
+	readmitted := atomic.LoadInt64(&h.readmitted)
+	if readmitted == 0 {
+		return true
+	}
+
+	return shifted(p, TrafficShift{
+		Node:    i,
+		Elapsed: time.Duration(time.Now().UnixNano() - readmitted),
+		Jitter:  math.Float64frombits(atomic.LoadUint64(&h.jitter)),
+	})
+}
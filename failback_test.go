@@ -0,0 +1,51 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFailbackRamp(t *testing.T) {
+	db := wrap(make([]*sql.DB, 3), nil)
+	db.SetLabels(1, Labels{"zone": "eu"})
+	ctx := WithSelector(context.Background(), MustParseSelector("zone=eu"))
+
+	h := db.health(1)
+	for h.observe(DefaultHealthPolicy, false); db.Healthy(1); h.observe(DefaultHealthPolicy, false) {
+	}
+	for !db.Healthy(1) {
+		h.observe(DefaultHealthPolicy, true)
+	}
+
+	if i := db.readIndex(ctx); i != 1 {
+		t.Fatalf("Read not shifted back instantly without ramp: %d", i)
+	}
+
+	db.SetFailbackRamp(time.Hour, 0.2)
+	for k := 0; k < 100; k++ {
+		if i := db.readIndex(ctx); i == 1 {
+			t.Fatal("Read shifted back right after readmission")
+		}
+	}
+
+	atomic.StoreInt64(&h.readmitted, time.Now().Add(-30*time.Minute).UnixNano())
+	atomic.StoreUint64(&h.jitter, 0)
+
+	shifted := 0
+	for k := 0; k < 1000; k++ {
+		if db.failedBack(1) {
+			shifted++
+		}
+	}
+	if shifted < 400 || shifted > 600 {
+		t.Errorf("Unexpected share of reads half way through the ramp: %d/1000", shifted)
+	}
+
+	atomic.StoreInt64(&h.readmitted, time.Now().Add(-2*time.Hour).UnixNano())
+	if i := db.readIndex(ctx); i != 1 {
+		t.Errorf("Read not shifted back once ramped up: %d", i)
+	}
+}
@@ -0,0 +1,182 @@
+package nap
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// fairnessBuckets is the number of buckets a fairness window slides by.
+const fairnessBuckets = 10
+
+// FairnessReport describes how reads were distributed across the physical
+// dbs over the last fairness window.
+type FairnessReport struct {
+	Window time.Duration // Duration covered by the report
+	Reads  uint64        // Reads routed during the window
+	Nodes  []NodeShare   // Share of each physical db
+	Unfair bool          // Set when any physical db deviates beyond the tolerance
+}
+
+// NodeShare is the share of reads of a physical db.
+// Shares and expected shares are relative to the reads served by the
+// physical dbs eligible to default balancing, which are expected to
+// receive reads in proportion to their weights. Other physical dbs, such
+// as those in a tier, have no expected share and are never unfair.
+type NodeShare struct {
+	Index     int     // Index of the physical db
+	Reads     uint64  // Reads routed to the physical db during the window
+	Eligible  bool    // Set when eligible to default balancing
+	Share     float64 // Fraction of the reads of eligible physical dbs
+	Expected  float64 // Fraction expected from the weights
+	Deviation float64 // Share - Expected
+	Unfair    bool    // Set when |Deviation| exceeds the tolerance
+}
+
+// SetFairnessWindow enables tracking the distribution of reads over a
+// sliding window of duration d, as reported by FairnessReport.
+// If d <= 0, tracking is disabled. It is disabled by default.
+func (db *DB) SetFairnessWindow(d time.Duration) {
+	if d <= 0 {
+		db.fairness.Store((*fairness)(nil))
+		return
+	}
+
+	f := &fairness{bucket: int64(d / fairnessBuckets)}
+	if f.bucket <= 0 {
+		f.bucket = 1
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for i := range f.buckets {
+		f.buckets[i].counts = make([]uint64, len(db.topology().pdbs))
+	}
+	db.fairness.Store(f)
+}
+
+// FairnessReport reports how reads were distributed across the physical
+// dbs over the fairness window, flagging those whose share deviates from
+// their expected share by more than tolerance, such as 0.1 for 10 points.
+// The report is empty unless enabled with SetFairnessWindow.
+func (db *DB) FairnessReport(tolerance float64) FairnessReport {
+	f, _ := db.fairness.Load().(*fairness)
+	if f == nil {
+		return FairnessReport{}
+	}
+
+	reads := f.reads(time.Now())
+	eligible := db.eligible(context.Background())
+
+	var weights, served uint64
+	shares := make([]NodeShare, len(reads))
+	for i := range shares {
+		shares[i] = NodeShare{Index: i, Reads: reads[i]}
+	}
+
+	for _, i := range eligible {
+		if i >= len(reads) {
+			continue
+		}
+		shares[i].Eligible = true
+		weights += uint64(db.Weight(i))
+		served += reads[i]
+	}
+
+	report := FairnessReport{
+		Window: time.Duration(f.bucket * fairnessBuckets),
+		Nodes:  shares,
+	}
+
+	for i := range shares {
+		report.Reads += reads[i]
+
+		s := &shares[i]
+		if !s.Eligible || served == 0 {
+			continue
+		}
+
+		s.Share = float64(s.Reads) / float64(served)
+		if weights > 0 {
+			s.Expected = float64(db.Weight(i)) / float64(weights)
+		}
+
+		s.Deviation = s.Share - s.Expected
+		s.Unfair = math.Abs(s.Deviation) > tolerance
+		report.Unfair = report.Unfair || s.Unfair
+	}
+
+	return report
+}
+
+// recordRead counts a read routed to the physical db at index node.
+func (db *DB) recordRead(node int) {
+	if f, _ := db.fairness.Load().(*fairness); f != nil {
+		f.record(time.Now(), node)
+	}
+}
+
+// fairness counts reads per physical db in buckets forming a sliding window.
+type fairness struct {
+	bucket  int64 // Duration of a bucket in nanoseconds
+	buckets [fairnessBuckets]fairnessBucket
+}
+
+type fairnessBucket struct {
+	epoch  int64    // Index of the bucket duration the counts are for
+	counts []uint64 // Reads of each physical db
+}
+
+// record counts a read to node at now. Counts racing with a bucket
+// being recycled may be lost, which is fine for a report.
+func (f *fairness) record(now time.Time, node int) {
+	epoch := now.UnixNano() / f.bucket
+	b := &f.buckets[epoch%fairnessBuckets]
+
+	if old := atomic.LoadInt64(&b.epoch); old != epoch && atomic.CompareAndSwapInt64(&b.epoch, old, epoch) {
+		for i := range b.counts {
+			atomic.StoreUint64(&b.counts[i], 0)
+		}
+	}
+
+	if node < len(b.counts) {
+		atomic.AddUint64(&b.counts[node], 1)
+	}
+}
+
+// reindexed returns a copy of f for physical dbs coming from the indexes
+// from, -1 for added ones, which start with no reads.
+func (f *fairness) reindexed(from []int) *fairness {
+	ff := &fairness{bucket: f.bucket}
+	for k := range f.buckets {
+		b, bb := &f.buckets[k], &ff.buckets[k]
+		bb.epoch = atomic.LoadInt64(&b.epoch)
+		bb.counts = make([]uint64, len(from))
+		for i, j := range from {
+			if j >= 0 && j < len(b.counts) {
+				bb.counts[i] = atomic.LoadUint64(&b.counts[j])
+			}
+		}
+	}
+	return ff
+}
+
+// reads sums the reads of each physical db over the window ending at now.
+func (f *fairness) reads(now time.Time) []uint64 {
+	epoch := now.UnixNano() / f.bucket
+	reads := make([]uint64, len(f.buckets[0].counts))
+
+	for i := range f.buckets {
+		b := &f.buckets[i]
+		if e := atomic.LoadInt64(&b.epoch); e <= epoch-fairnessBuckets || e > epoch {
+			continue
+		}
+
+		for j := range reads {
+			reads[j] += atomic.LoadUint64(&b.counts[j])
+		}
+	}
+	return reads
+}
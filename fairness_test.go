@@ -0,0 +1,109 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFairnessReport(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if r := db.FairnessReport(0.1); r.Reads != 0 || r.Nodes != nil {
+		t.Errorf("Unexpected report while disabled: %+v", r)
+	}
+
+	db.SetFairnessWindow(time.Minute)
+	for i := 0; i < 20; i++ {
+		db.QueryRow("SELECT 1").Scan(new(int))
+	}
+
+	r := db.FairnessReport(0.1)
+	if r.Reads != 20 || r.Window != time.Minute || r.Unfair {
+		t.Fatalf("Unexpected report of balanced reads: %+v", r)
+	}
+
+	if n := r.Nodes[0]; n.Eligible || n.Reads != 0 {
+		t.Errorf("Unexpected share of the master: %+v", n)
+	}
+
+	for _, n := range r.Nodes[1:] {
+		if !n.Eligible || n.Reads != 10 || n.Share != 0.5 || n.Expected != 0.5 {
+			t.Errorf("Unexpected share of slave %d: %+v", n.Index, n)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		db.recordRead(1)
+	}
+
+	r = db.FairnessReport(0.1)
+	if !r.Unfair || !r.Nodes[1].Unfair || !r.Nodes[2].Unfair {
+		t.Errorf("Concentrated reads not flagged: %+v", r)
+	}
+
+	db.SetWeight(1, 3)
+	if r = db.FairnessReport(0.1); r.Unfair || r.Nodes[1].Expected != 0.75 {
+		t.Errorf("Weights not taken into account: %+v", r)
+	}
+}
+
+func TestFairnessMembership(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetFairnessWindow(time.Minute)
+	if err = db.AddSlave(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{1, 2, 2} {
+		if err = db.QueryRowContext(WithNode(context.Background(), i), "SELECT 1").Scan(new(int)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := db.FairnessReport(0.1)
+	if len(r.Nodes) != 3 || r.Nodes[1].Reads != 1 || r.Nodes[2].Reads != 2 || !r.Nodes[2].Eligible {
+		t.Fatalf("Unexpected report once a slave was added: %+v", r)
+	}
+
+	if err = db.RemoveSlave(1); err != nil {
+		t.Fatal(err)
+	}
+	r = db.FairnessReport(0.1)
+	if len(r.Nodes) != 2 || r.Nodes[1].Reads != 2 || r.Reads != 2 {
+		t.Errorf("Reads not moved with the remaining slave: %+v", r)
+	}
+}
+
+func TestFairnessWindow(t *testing.T) {
+	f := &fairness{bucket: int64(time.Second)}
+	for i := range f.buckets {
+		f.buckets[i].counts = make([]uint64, 2)
+	}
+
+	now := time.Unix(1000, 0)
+	f.record(now, 0)
+	f.record(now.Add(5*time.Second), 1)
+	f.record(now.Add(5*time.Second), 1)
+
+	if reads := f.reads(now.Add(9 * time.Second)); reads[0] != 1 || reads[1] != 2 {
+		t.Errorf("Unexpected reads within the window: %v", reads)
+	}
+
+	if reads := f.reads(now.Add(10 * time.Second)); reads[0] != 0 || reads[1] != 2 {
+		t.Errorf("Unexpected reads once the first bucket slid out: %v", reads)
+	}
+
+	f.record(now.Add(10*time.Second), 0)
+	if reads := f.reads(now.Add(10 * time.Second)); reads[0] != 1 {
+		t.Errorf("Recycled bucket not reset: %v", reads)
+	}
+}
@@ -0,0 +1,222 @@
+package nap
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// utilizationBuckets is the number of buckets a utilization window slides by.
+const utilizationBuckets = 12
+
+// Utilization describes the load of each physical db over the last
+// utilization window, meant for autoscalers forecasting the read load to
+// decide when to add replicas. Its fields are stable.
+type Utilization struct {
+	Window time.Duration     `json:"window"` // Duration covered by the report
+	Step   time.Duration     `json:"step"`   // Duration of each point
+	Nodes  []NodeUtilization `json:"nodes"`
+}
+
+// NodeUtilization is the utilization of a physical db.
+type NodeUtilization struct {
+	Index    int                `json:"index"`
+	Role     string             `json:"role"`
+	InUse    int                `json:"in_use"`    // Connections currently in use
+	QPS      float64            `json:"qps"`       // Operations per second over the window
+	Latency  time.Duration      `json:"latency"`   // Mean latency over the window
+	QPSTrend float64            `json:"qps_trend"` // Change of the QPS per second, by least squares over the points
+	Points   []UtilizationPoint `json:"points"`    // Oldest first, ending with the current step
+}
+
+// UtilizationPoint is the utilization of a physical db during a step of
+// the window.
+type UtilizationPoint struct {
+	Start   time.Time     `json:"start"`
+	Ops     uint64        `json:"ops"`     // Operations finished during the step
+	Latency time.Duration `json:"latency"` // Mean latency of the operations
+	InUse   int           `json:"in_use"`  // Connections in use when the step started, -1 if unknown
+}
+
+// SetUtilizationWindow enables tracking the utilization of each physical db
+// over a sliding window of duration d, as reported by Utilization.
+// If d <= 0, tracking is disabled. It is disabled by default.
+func (db *DB) SetUtilizationWindow(d time.Duration) {
+	if d <= 0 {
+		db.usage.Store((*utilization)(nil))
+		return
+	}
+
+	u := &utilization{bucket: int64(d / utilizationBuckets)}
+	if u.bucket <= 0 {
+		u.bucket = 1
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	n := len(db.topology().pdbs)
+	for i := range u.buckets {
+		u.buckets[i] = utilizationBucket{
+			epoch:   -1,
+			ops:     make([]uint64, n),
+			latency: make([]int64, n),
+			inUse:   make([]int64, n),
+		}
+	}
+	db.usage.Store(u)
+}
+
+// Utilization reports the utilization of each physical db over the
+// utilization window. The report is empty unless enabled with
+// SetUtilizationWindow.
+func (db *DB) Utilization() Utilization {
+	u, _ := db.usage.Load().(*utilization)
+	if u == nil {
+		return Utilization{}
+	}
+
+	now := time.Now()
+	report := Utilization{
+		Window: time.Duration(u.bucket * utilizationBuckets),
+		Step:   time.Duration(u.bucket),
+		Nodes:  make([]NodeUtilization, len(u.buckets[0].ops)),
+	}
+
+	pdbs := db.topology().pdbs
+	for i := range report.Nodes {
+		n := &report.Nodes[i]
+		n.Index, n.Role, n.InUse = i, roleLabels(i)["role"], -1
+		if i < len(pdbs) {
+			n.InUse = pdbs[i].Stats().InUse
+		}
+
+		var ops uint64
+		var latency int64
+		n.Points = u.points(now, i)
+		for _, p := range n.Points {
+			ops += p.Ops
+			latency += int64(p.Latency) * int64(p.Ops)
+		}
+
+		n.QPS = float64(ops) / report.Window.Seconds()
+		if ops > 0 {
+			n.Latency = time.Duration(latency / int64(ops))
+		}
+		n.QPSTrend = qpsTrend(n.Points, report.Step)
+	}
+
+	return report
+}
+
+// recordUtilization records an operation which took d on the physical db
+// at index node.
+func (db *DB) recordUtilization(node int, d time.Duration) {
+	if u, _ := db.usage.Load().(*utilization); u != nil {
+		u.record(time.Now(), node, d, db.topology().pdbs)
+	}
+}
+
+// utilization tracks operations per physical db in buckets forming
+// a sliding window.
+type utilization struct {
+	bucket  int64 // Duration of a bucket in nanoseconds
+	buckets [utilizationBuckets]utilizationBucket
+}
+
+type utilizationBucket struct {
+	epoch   int64    // Index of the bucket duration the counts are for
+	ops     []uint64 // Operations of each physical db
+	latency []int64  // Total latency of each physical db in nanoseconds
+	inUse   []int64  // Connections in use when the bucket started, -1 if unknown
+}
+
+// record records an operation to node at now, sampling the connections in
+// use by pdbs when starting a bucket. Operations racing with a bucket being
+// recycled may be lost, which is fine for a report.
+func (u *utilization) record(now time.Time, node int, d time.Duration, pdbs []*sql.DB) {
+	epoch := now.UnixNano() / u.bucket
+	b := &u.buckets[epoch%utilizationBuckets]
+
+	if old := atomic.LoadInt64(&b.epoch); old != epoch && atomic.CompareAndSwapInt64(&b.epoch, old, epoch) {
+		for i := range b.ops {
+			inUse := int64(-1)
+			if i < len(pdbs) {
+				inUse = int64(pdbs[i].Stats().InUse)
+			}
+
+			atomic.StoreUint64(&b.ops[i], 0)
+			atomic.StoreInt64(&b.latency[i], 0)
+			atomic.StoreInt64(&b.inUse[i], inUse)
+		}
+	}
+
+	if node < len(b.ops) {
+		atomic.AddUint64(&b.ops[node], 1)
+		atomic.AddInt64(&b.latency[node], int64(d))
+	}
+}
+
+// reindexed returns a copy of u for physical dbs coming from the indexes
+// from, -1 for added ones, which keep their counts.
+func (u *utilization) reindexed(from []int) *utilization {
+	uu := &utilization{bucket: u.bucket}
+	for k := range u.buckets {
+		b, bb := &u.buckets[k], &uu.buckets[k]
+		bb.epoch = atomic.LoadInt64(&b.epoch)
+		bb.ops = make([]uint64, len(from))
+		bb.latency = make([]int64, len(from))
+		bb.inUse = make([]int64, len(from))
+		for i, j := range from {
+			bb.inUse[i] = -1
+			if j >= 0 && j < len(b.ops) {
+				bb.ops[i] = atomic.LoadUint64(&b.ops[j])
+				bb.latency[i] = atomic.LoadInt64(&b.latency[j])
+				bb.inUse[i] = atomic.LoadInt64(&b.inUse[j])
+			}
+		}
+	}
+	return uu
+}
+
+// points returns the points of node over the window ending at now.
+func (u *utilization) points(now time.Time, node int) []UtilizationPoint {
+	epoch := now.UnixNano() / u.bucket
+	points := make([]UtilizationPoint, utilizationBuckets)
+
+	for k := range points {
+		e := epoch - utilizationBuckets + 1 + int64(k)
+		p := &points[k]
+		p.Start, p.InUse = time.Unix(0, e*u.bucket), -1
+
+		b := &u.buckets[e%utilizationBuckets]
+		if atomic.LoadInt64(&b.epoch) != e {
+			continue
+		}
+
+		p.Ops = atomic.LoadUint64(&b.ops[node])
+		p.InUse = int(atomic.LoadInt64(&b.inUse[node]))
+		if p.Ops > 0 {
+			p.Latency = time.Duration(atomic.LoadInt64(&b.latency[node]) / int64(p.Ops))
+		}
+	}
+	return points
+}
+
+// qpsTrend returns the slope of the QPS of points a step apart, in QPS per
+// second, fitted by least squares. The current step, still in progress,
+// is left out.
+func qpsTrend(points []UtilizationPoint, step time.Duration) float64 {
+	points = points[:len(points)-1]
+	n := float64(len(points))
+	if n < 2 {
+		return 0
+	}
+
+	var sx, sy, sxx, sxy float64
+	for k, p := range points {
+		x, y := float64(k)*step.Seconds(), float64(p.Ops)/step.Seconds()
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	return (n*sxy - sx*sy) / (n*sxx - sx*sx)
+}
@@ -0,0 +1,78 @@
+package nap
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestUtilization(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if u := db.Utilization(); u.Nodes != nil {
+		t.Fatalf("Utilization reported while disabled: %+v", u)
+	}
+
+	db.SetUtilizationWindow(time.Hour)
+	db.Exec("SELECT 1")
+	db.QueryRow("SELECT 1").Scan(new(int))
+	db.QueryRow("SELECT 1").Scan(new(int))
+
+	u := db.Utilization()
+	if u.Window != time.Hour || u.Step != 5*time.Minute || len(u.Nodes) != 2 {
+		t.Fatalf("Unexpected utilization: %+v", u)
+	}
+
+	for i, want := range []uint64{1, 2} {
+		n := u.Nodes[i]
+		if len(n.Points) != utilizationBuckets {
+			t.Fatalf("Unexpected points of %d: %+v", i, n.Points)
+		}
+
+		if got := n.Points[len(n.Points)-1]; got.Ops != want || got.Latency <= 0 || got.InUse != 0 {
+			t.Errorf("Unexpected current point of %d: %+v", i, got)
+		}
+		if n.Latency <= 0 || n.QPS != float64(want)/3600 || n.Role != []string{"master", "slave"}[i] {
+			t.Errorf("Unexpected utilization of %d: %+v", i, n)
+		}
+	}
+
+	db.SetUtilizationWindow(0)
+	if u := db.Utilization(); u.Nodes != nil {
+		t.Errorf("Utilization reported once disabled: %+v", u)
+	}
+}
+
+func TestUtilizationTrend(t *testing.T) {
+	u := &utilization{bucket: int64(time.Second)}
+	for i := range u.buckets {
+		u.buckets[i] = utilizationBucket{epoch: -1, ops: make([]uint64, 1), latency: make([]int64, 1), inUse: make([]int64, 1)}
+	}
+
+	start := time.Unix(1000, 0)
+	for k := 0; k < utilizationBuckets; k++ {
+		for n := 0; n < 10*k; n++ {
+			u.record(start.Add(time.Duration(k)*time.Second), 0, time.Millisecond, nil)
+		}
+	}
+
+	points := u.points(start.Add((utilizationBuckets-1)*time.Second), 0)
+	for k, p := range points {
+		if p.Ops != uint64(10*k) || p.InUse != -1 {
+			t.Fatalf("Unexpected point %d: %+v", k, p)
+		}
+	}
+
+	if trend := qpsTrend(points, time.Second); math.Abs(trend-10) > 1e-9 {
+		t.Errorf("Unexpected trend. Got: %f, Want: 10", trend)
+	}
+
+	points = u.points(start.Add(100*time.Second), 0)
+	if trend := qpsTrend(points, time.Second); trend != 0 || points[0].Ops != 0 {
+		t.Errorf("Expired points reported: %+v", points)
+	}
+}
@@ -0,0 +1,100 @@
+package nap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrFrozen is returned for writes refused while the DB is frozen.
+var ErrFrozen = errors.New("nap: write refused by freeze")
+
+// FrozenError describes a write refused while the DB is frozen.
+type FrozenError struct {
+	Since time.Time // Time the DB was frozen at
+}
+
+// Error implements the error interface.
+func (e *FrozenError) Error() string {
+	return fmt.Sprintf("%s since %s", ErrFrozen, e.Since.Format(time.RFC3339))
+}
+
+// Is reports whether target is ErrFrozen.
+func (e *FrozenError) Is(target error) bool {
+	return target == ErrFrozen
+}
+
+// Freeze refuses all writes with a *FrozenError until Unfreeze, such as
+// during a planned maintenance of the master, while reads go on being
+// served. QueryRow reads aren't rechecked on the master while frozen.
+// Freezing a frozen DB keeps the time it was frozen at.
+func (db *DB) Freeze() {
+	if atomic.CompareAndSwapInt64(&db.frozen, 0, time.Now().UnixNano()) {
+		db.onFreeze(true)
+	}
+}
+
+// Unfreeze accepts writes again after Freeze.
+func (db *DB) Unfreeze() {
+	if atomic.SwapInt64(&db.frozen, 0) != 0 {
+		db.onFreeze(false)
+	}
+}
+
+// Frozen returns the time the DB was frozen at, if frozen.
+func (db *DB) Frozen() (time.Time, bool) {
+	if since := atomic.LoadInt64(&db.frozen); since != 0 {
+		return time.Unix(0, since), true
+	}
+	return time.Time{}, false
+}
+
+// frozenError returns a *FrozenError if the DB is frozen.
+func (db *DB) frozenError() error {
+	if since, ok := db.Frozen(); ok {
+		return &FrozenError{Since: since}
+	}
+	return nil
+}
+
+func (db *DB) onFreeze(frozen bool) {
+	if h, _ := db.hooks.Load().(*Hooks); h != nil && h.OnFreeze != nil {
+		h.OnFreeze(frozen)
+	}
+}
+
+// freezeState is the state served by FreezeHandler.
+type freezeState struct {
+	Frozen bool       `json:"frozen"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// FreezeHandler returns an admin handler freezing the DB on POST and
+// unfreezing it on DELETE, and serving whether it is frozen as JSON, such
+// as {"frozen":true,"since":"2006-01-02T15:04:05Z"}.
+func (db *DB) FreezeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			db.Freeze()
+		case http.MethodDelete:
+			db.Unfreeze()
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var state freezeState
+		if since, ok := db.Frozen(); ok {
+			state.Frozen, state.Since = true, &since
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+}
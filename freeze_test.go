@@ -0,0 +1,92 @@
+package nap
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var events []bool
+	db.SetHooks(Hooks{OnFreeze: func(frozen bool) { events = append(events, frozen) }})
+
+	db.Freeze()
+	since, ok := db.Frozen()
+	db.Freeze()
+	if again, _ := db.Frozen(); !ok || !again.Equal(since) {
+		t.Errorf("Unexpected freeze: %v, %t", again, ok)
+	}
+
+	var frozen *FrozenError
+	if _, err = db.Exec("SELECT 1"); !errors.Is(err, ErrFrozen) || !errors.As(err, &frozen) || !frozen.Since.Equal(since) {
+		t.Errorf("Want a *FrozenError from Exec, got: %v", err)
+	}
+	if _, err = db.Begin(); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Want ErrFrozen from Begin, got: %v", err)
+	}
+
+	if err = db.QueryRow("SELECT 1").Scan(new(int)); err != nil {
+		t.Errorf("Read refused while frozen: %s", err)
+	}
+
+	if s := db.Snapshot(); !s.Frozen {
+		t.Error("Freeze missing from the snapshot")
+	}
+	if stats := db.Stats(); !stats[0].Frozen || stats[1].Frozen {
+		t.Errorf("Unexpected freeze in stats: %+v", stats)
+	}
+
+	db.Unfreeze()
+	db.Unfreeze()
+	if _, err = db.Exec("SELECT 1"); err != nil {
+		t.Errorf("Write refused after unfreezing: %s", err)
+	}
+
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Errorf("Unexpected freeze events: %v", events)
+	}
+}
+
+func TestFreezeHandler(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	serve := func(method string) (int, freezeState) {
+		rec := httptest.NewRecorder()
+		db.FreezeHandler().ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+
+		var state freezeState
+		if rec.Code == 200 {
+			if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, state
+	}
+
+	if code, state := serve("GET"); code != 200 || state.Frozen || state.Since != nil {
+		t.Errorf("Unexpected state: %d, %+v", code, state)
+	}
+	if code, state := serve("POST"); code != 200 || !state.Frozen || state.Since == nil {
+		t.Errorf("Unexpected state once frozen: %d, %+v", code, state)
+	}
+	if _, ok := db.Frozen(); !ok {
+		t.Error("DB not frozen")
+	}
+	if code, state := serve("DELETE"); code != 200 || state.Frozen {
+		t.Errorf("Unexpected state once unfrozen: %d, %+v", code, state)
+	}
+	if code, _ := serve("PUT"); code != 405 {
+		t.Errorf("Unexpected status of PUT: %d", code)
+	}
+}
@@ -0,0 +1,88 @@
+package nap
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type tableFreshnessKey struct{}
+
+// SetTableFreshness requires the reads of table to go to slaves lagging
+// behind by at most maxLag, such as 100ms for payments, as probed with
+// SetLagProbe, falling back to the master when none does, including while
+// lags are unknown. Reads are classified by the tables following the FROM
+// and JOIN keywords of their SQL, matching table with or without its
+// schema, case insensitively, and the strictest requirement of the tables
+// they read applies. Reads directed to a node with WithNode or UseSlave
+// aren't constrained. If maxLag < 0, reads of table are no longer
+// constrained, which is the default.
+func (db *DB) SetTableFreshness(table string, maxLag time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, _ := db.tableLags.Load().(map[string]time.Duration)
+	lags := make(map[string]time.Duration, len(old)+1)
+	for k, v := range old {
+		lags[k] = v
+	}
+
+	if table = strings.ToLower(table); maxLag >= 0 {
+		lags[table] = maxLag
+	} else {
+		delete(lags, table)
+	}
+	db.tableLags.Store(lags)
+}
+
+// tableFreshness returns a copy of ctx carrying the max lag of the slaves
+// a read of query may go to, if it reads tables with freshness requirements.
+func (db *DB) tableFreshness(ctx context.Context, query string) context.Context {
+	if lags, _ := db.tableLags.Load().(map[string]time.Duration); len(lags) == 0 {
+		return ctx
+	}
+	return db.tablesFreshness(ctx, classifySQL(query).tables)
+}
+
+// tablesFreshness is like tableFreshness for a read of tables.
+func (db *DB) tablesFreshness(ctx context.Context, tables []string) context.Context {
+	lags, _ := db.tableLags.Load().(map[string]time.Duration)
+	if len(lags) == 0 {
+		return ctx
+	}
+
+	bound, ok := time.Duration(0), false
+	for _, table := range tables {
+		lag, found := lags[table]
+		if !found {
+			if dot := strings.LastIndexByte(table, '.'); dot >= 0 {
+				lag, found = lags[table[dot+1:]]
+			}
+		}
+
+		if found && (!ok || lag < bound) {
+			bound, ok = lag, true
+		}
+	}
+
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, tableFreshnessKey{}, bound)
+}
+
+// freshIndex returns the index of the physical db a read with ctx coming
+// with the max lag of its tables goes to, reporting false if it has none.
+func (db *DB) freshIndex(ctx context.Context) (int, bool) {
+	bound, ok := ctx.Value(tableFreshnessKey{}).(time.Duration)
+	if !ok {
+		return 0, false
+	}
+
+	for _, i := range db.readNodes(ctx) {
+		if lag := db.Lag(i); i == 0 || lag >= 0 && lag <= bound {
+			return i, true
+		}
+	}
+	return 0, true
+}
@@ -0,0 +1,95 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTableFreshness(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	pdbs := db.topology().pdbs
+	for _, pdb := range pdbs {
+		if _, err = pdb.Exec("CREATE TABLE payments (id INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lags := []int64{0, int64(time.Second), int64(10 * time.Millisecond)}
+	db.SetLagProbe(func(_ context.Context, pdb *sql.DB) (time.Duration, error) {
+		for i := range pdbs {
+			if pdbs[i] == pdb {
+				return time.Duration(atomic.LoadInt64(&lags[i])), nil
+			}
+		}
+		return 0, nil
+	}, time.Millisecond, time.Hour)
+	for db.Lag(1) < 0 || db.Lag(2) < 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	db.SetTableFreshness("Payments", 100*time.Millisecond)
+	reads := func(ctx context.Context, query string) map[int]int {
+		nodes := map[int]int{}
+		for i := 0; i < 6; i++ {
+			rows, err := db.QueryContext(ctx, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes[rows.Node()]++
+			rows.Close()
+		}
+		return nodes
+	}
+
+	if nodes := reads(context.Background(), "SELECT * FROM payments"); len(nodes) != 1 || nodes[2] != 6 {
+		t.Errorf("Reads of a fresh table not routed to the fresh slave: %v", nodes)
+	}
+	if nodes := reads(context.Background(), "SELECT 1"); len(nodes) != 2 || nodes[0] != 0 {
+		t.Errorf("Reads of other tables not balanced: %v", nodes)
+	}
+	if nodes := reads(UseSlave(context.Background()), "SELECT * FROM payments"); nodes[0] != 0 || nodes[2] == 6 {
+		t.Errorf("Reads of UseSlave constrained: %v", nodes)
+	}
+
+	stmt, err := db.Prepare("SELECT id FROM main.payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	row := stmt.QueryRow()
+	if row.Scan(new(int)); row.Node() != 2 {
+		t.Errorf("Statement read of a fresh table routed to %d", row.Node())
+	}
+
+	// Not prepared on the fresh slave, such as a logical replica
+	set := stmt.load()
+	stmt.set.Store(&stmtSet{gen: set.gen, stmts: []*sql.Stmt{set.stmts[0], set.stmts[1], nil}, refs: 1})
+	row = stmt.QueryRow()
+	if row.Scan(new(int)); row.Node() != 0 {
+		t.Errorf("Statement read of a fresh table not prepared on the fresh slave routed to %d", row.Node())
+	}
+	stmt.set.Store(set)
+
+	atomic.StoreInt64(&lags[2], int64(time.Second))
+	for db.Lag(2) != time.Second {
+		time.Sleep(time.Millisecond)
+	}
+	if nodes := reads(context.Background(), "SELECT * FROM payments"); nodes[0] != 6 {
+		t.Errorf("Reads of a fresh table not falling back to the master: %v", nodes)
+	}
+
+	db.SetTableFreshness("payments", -1)
+	if nodes := reads(context.Background(), "SELECT * FROM payments"); nodes[0] != 0 {
+		t.Errorf("Reads of an unconstrained table went to the master: %v", nodes)
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/iqoption/nap
 
-go 1.12
+go 1.15
 
 require github.com/mattn/go-sqlite3 v1.11.0
@@ -0,0 +1,142 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrBigResult is returned by the reads of slaves aborted by a ResultGuard.
+var ErrBigResult = errors.New("nap: result exceeds guard")
+
+// BigResultError describes a read of a slave exceeding its ResultGuard.
+type BigResultError struct {
+	Node  int    // Index of the slave
+	Tier  string // Tier of the slave, if any
+	Rows  int    // Rows read so far
+	Bytes int64  // Approximate bytes scanned so far
+}
+
+// Error implements the error interface.
+func (e *BigResultError) Error() string {
+	return fmt.Sprintf("%s: %d rows, %d bytes from physical db %d", ErrBigResult, e.Rows, e.Bytes, e.Node)
+}
+
+// Is reports whether target is ErrBigResult.
+func (e *BigResultError) Is(target error) bool {
+	return target == ErrBigResult
+}
+
+// ResultGuard limits the results of the reads of slaves, to catch
+// accidental unbounded SELECTs before they evict the buffer cache of
+// a replica. Bytes are approximated from the scanned values.
+type ResultGuard struct {
+	MaxRows  int   // Maximum number of rows, 0 for no limit
+	MaxBytes int64 // Maximum number of bytes scanned, 0 for no limit
+
+	// Warn, if set, is called once with the *BigResultError of a read
+	// exceeding the guard, which then goes on. Otherwise the read is
+	// aborted, its Rows failing with the error. It must not block.
+	Warn func(ctx context.Context, err error)
+}
+
+// SetResultGuard sets the guard of the results of Query reads on the slaves
+// of tier, or on those in no tier if tier is empty. The master and QueryRow
+// reads aren't guarded. If g has no limit, the slaves of tier are no
+// longer guarded, which is the default.
+func (db *DB) SetResultGuard(tier string, g ResultGuard) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, _ := db.guards.Load().(map[string]ResultGuard)
+	guards := make(map[string]ResultGuard, len(old)+1)
+	for k, v := range old {
+		guards[k] = v
+	}
+
+	if g.MaxRows > 0 || g.MaxBytes > 0 {
+		guards[tier] = g
+	} else {
+		delete(guards, tier)
+	}
+	db.guards.Store(guards)
+}
+
+// resultGuard returns the guard of the results of the physical db at
+// index node, if any.
+func (db *DB) resultGuard(node int) *guard {
+	guards, _ := db.guards.Load().(map[string]ResultGuard)
+	if len(guards) == 0 || node == 0 {
+		return nil
+	}
+
+	tier := db.Tier(node)
+	if g, ok := guards[tier]; ok {
+		return &guard{ResultGuard: g, progress: BigResultError{Node: node, Tier: tier}}
+	}
+	return nil
+}
+
+// guard tracks the size of the result of a read against its ResultGuard.
+type guard struct {
+	ResultGuard
+	progress BigResultError // Size of the result so far
+	exceeded bool
+	aborted  error // Set once aborted
+}
+
+// check reports whether the read may go on, warning or aborting it once
+// exceeding the guard.
+func (g *guard) check(ctx context.Context, rows *sql.Rows) bool {
+	if g.aborted != nil {
+		return false
+	}
+
+	if g.exceeded || !(g.MaxRows > 0 && g.progress.Rows > g.MaxRows || g.MaxBytes > 0 && g.progress.Bytes > g.MaxBytes) {
+		return true
+	}
+
+	g.exceeded = true
+	err := g.progress
+	if g.Warn != nil {
+		g.Warn(ctx, &err)
+		return true
+	}
+
+	g.aborted = &err
+	rows.Close()
+	return false
+}
+
+// scanned adds the approximate size of the values scanned into dest.
+func (g *guard) scanned(dest []interface{}) {
+	for _, d := range dest {
+		g.progress.Bytes += valueSize(d)
+	}
+}
+
+// valueSize returns the approximate size in bytes of the value scanned
+// into dest.
+func valueSize(dest interface{}) int64 {
+	switch d := dest.(type) {
+	case *[]byte:
+		return int64(len(*d))
+	case *sql.RawBytes:
+		return int64(len(*d))
+	case *string:
+		return int64(len(*d))
+	case *sql.NullString:
+		return int64(len(d.String))
+	case *interface{}:
+		switch v := (*d).(type) {
+		case []byte:
+			return int64(len(v))
+		case string:
+			return int64(len(v))
+		case nil:
+			return 0
+		}
+	}
+	return 8
+}
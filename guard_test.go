@@ -0,0 +1,65 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestResultGuard(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetTier(2, "reporting")
+	db.SetResultGuard("", ResultGuard{MaxRows: 2})
+	db.SetResultGuard("reporting", ResultGuard{MaxBytes: 10})
+
+	count := func(ctx context.Context) (int, error) {
+		rows, err := db.QueryContext(ctx, "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 5) SELECT 'abcd' FROM n")
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+
+		n := 0
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				return n, err
+			}
+			n++
+		}
+		return n, rows.Err()
+	}
+
+	n, err := count(context.Background())
+	var berr *BigResultError
+	if !errors.Is(err, ErrBigResult) || !errors.As(err, &berr) || n != 2 || berr.Rows != 3 || berr.Node != 1 {
+		t.Fatalf("Unexpected result of a guarded read: %d, %v", n, err)
+	}
+
+	n, err = count(WithTier(context.Background(), "reporting"))
+	if !errors.As(err, &berr) || n != 3 || berr.Bytes != 12 || berr.Tier != "reporting" {
+		t.Fatalf("Unexpected result of a guarded tier read: %d, %v", n, err)
+	}
+
+	var warned []error
+	db.SetResultGuard("", ResultGuard{MaxRows: 2, Warn: func(ctx context.Context, err error) {
+		warned = append(warned, err)
+	}})
+	if n, err = count(context.Background()); err != nil || n != 5 || len(warned) != 1 {
+		t.Errorf("Unexpected result of a warned read: %d, %v, %v", n, err, warned)
+	}
+
+	if n, err = count(WithNode(context.Background(), 0)); err != nil || n != 5 {
+		t.Errorf("Reads of the master guarded: %d, %v", n, err)
+	}
+
+	db.SetResultGuard("", ResultGuard{})
+	if n, err = count(context.Background()); err != nil || n != 5 || len(warned) != 1 {
+		t.Errorf("Reads guarded once disabled: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,149 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// handoverPoll is the interval at which Handover checks whether the DB
+// handed over is drained.
+const handoverPoll = 10 * time.Millisecond
+
+// Handover moves the traffic of from to the DB replacing it, such as one
+// rebuilt with an updated topology or configuration, without a gap. The
+// replacement is warmed up first, by pinging each of its physical dbs and
+// preparing the statements open on from. Then the operations of from, its
+// statements included, are all forwarded to the replacement at once, except
+// those configuring or inspecting from and its physical dbs, such as Master,
+// Stats and the setters, until from is drained of the operations and
+// transactions in flight, and closed. Statements prepared on from while it
+// is handed over are prepared on the replacement when first used. From keeps
+// forwarding once closed, so that callers can swap it for the replacement
+// at their own pace. If ctx is done before from is drained, ctx.Err() is
+// returned and from is left open, forwarding, until Handover is called
+// again to resume draining it. If the replacement couldn't be warmed up,
+// its error is returned and from keeps serving its own traffic.
+func Handover(ctx context.Context, from, to *DB) error {
+	if from == to {
+		return errors.New("nap: handover to the same DB")
+	}
+	switch n := from.successor(); {
+	case n == nil:
+		if err := warmUp(ctx, from, to); err != nil {
+			return err
+		}
+	case n != to:
+		return errors.New("nap: DB already handed over")
+	}
+
+	t := time.NewTicker(handoverPoll)
+	defer t.Stop()
+	for !from.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return from.Close()
+}
+
+// warmUp warms to up and forwards the operations of from to it.
+func warmUp(ctx context.Context, from, to *DB) error {
+	if err := to.PingContext(ctx); err != nil {
+		return err
+	}
+
+	var stmts []*Stmt
+	from.stmts.Range(func(k, _ interface{}) bool {
+		stmts = append(stmts, k.(*Stmt))
+		return true
+	})
+
+	next := make([]*Stmt, len(stmts))
+	for k, s := range stmts {
+		n, err := to.PrepareContext(ctx, s.query)
+		if err != nil {
+			for _, n := range next[:k] {
+				n.Close()
+			}
+			return err
+		}
+		next[k] = n
+	}
+
+	from.next.Store(to)
+	for k, s := range stmts {
+		s.handOver(next[k])
+	}
+	return nil
+}
+
+// successor returns the DB db was handed over to, if any.
+func (db *DB) successor() *DB {
+	n, _ := db.next.Load().(*DB)
+	return n
+}
+
+// drained reports whether db has no operation or transaction in flight.
+func (db *DB) drained() bool {
+	if db.TxStats().InFlight > 0 {
+		return false
+	}
+
+	for _, pdb := range db.topology().pdbs {
+		if pdb.Stats().InUse > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// handOver forwards the operations of s to next, closing next instead if
+// s is closed.
+func (s *Stmt) handOver(next *Stmt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if atomic.LoadInt32(&s.closed) != 0 {
+		next.Close()
+		return
+	}
+	s.next.Store(next)
+}
+
+// successor returns the Stmt s was handed over to, if any.
+func (s *Stmt) successor() *Stmt {
+	n, _ := s.next.Load().(*Stmt)
+	return n
+}
+
+// forwarded returns the Stmt the operations of s are forwarded to, if any,
+// preparing it with ctx on the DB of s was handed over to if s was prepared
+// too late to be handed over with it. If that fails, s keeps serving its
+// own operations.
+func (s *Stmt) forwarded(ctx context.Context) *Stmt {
+	if n := s.successor(); n != nil {
+		return n
+	}
+
+	db := s.db.successor()
+	if db == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := s.successor(); n != nil || atomic.LoadInt32(&s.closed) != 0 {
+		return n
+	}
+	n, err := db.PrepareContext(ctx, s.query)
+	if err != nil {
+		return nil
+	}
+	s.next.Store(n)
+	return n
+}
@@ -0,0 +1,128 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestHandover(t *testing.T) {
+	open := func(n int) *DB {
+		db, err := Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		db.SetMaxOpenConns(1)
+		if _, err = db.Exec("CREATE TABLE t (n INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Exec("INSERT INTO t VALUES (?)", n); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	from, to := open(1), open(2)
+
+	if err := Handover(context.Background(), from, from); err == nil {
+		t.Error("Want an error handing a DB over to itself")
+	}
+
+	stmt, err := from.Prepare("SELECT n FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	// As if prepared while from is handed over, missing the warm up.
+	racing, err := from.Prepare("SELECT n FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer racing.Close()
+	from.stmts.Delete(racing)
+
+	tx, err := from.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = Handover(ctx, from, to); err != context.DeadlineExceeded {
+		t.Fatalf("Want context.DeadlineExceeded while a transaction is in flight, got: %v", err)
+	}
+
+	// Operations are forwarded while from drains.
+	read := func(what string, row *Row) {
+		t.Helper()
+		var n int
+		if err := row.Scan(&n); err != nil || n != 2 {
+			t.Errorf("%s not forwarded: %d, %v", what, n, err)
+		}
+	}
+	read("Read", from.QueryRow("SELECT n FROM t"))
+	read("Statement read", stmt.QueryRow())
+	if _, err = from.Exec("UPDATE t SET n = 2"); err != nil {
+		t.Errorf("Write not forwarded: %v", err)
+	}
+	if err = tx.QueryRow("SELECT n FROM t").Scan(new(int)); err != nil {
+		t.Errorf("Transaction in flight failed: %v", err)
+	}
+	tx.Rollback()
+
+	if err = Handover(context.Background(), from, open(3)); err == nil {
+		t.Error("Want an error handing a DB over twice")
+	}
+	if err = Handover(context.Background(), from, to); err != nil {
+		t.Fatalf("Handover failed once drained: %v", err)
+	}
+	if err = from.Master().Ping(); err == nil {
+		t.Error("DB handed over not closed")
+	}
+
+	read("Read", from.QueryRow("SELECT n FROM t"))
+	read("Statement read", stmt.QueryRow())
+	read("Statement missing the warm up read", racing.QueryRow())
+	if err = from.Ping(); err != nil {
+		t.Errorf("Ping not forwarded: %v", err)
+	}
+	if err = from.ExecScript(context.Background(), "UPDATE t SET n = 2; SELECT 1"); err != nil {
+		t.Errorf("Script not forwarded: %v", err)
+	}
+	if err = from.ForEachSlave(context.Background(), func(int, *sql.DB) error { return nil }); err != nil {
+		t.Errorf("Slaves not forwarded: %v", err)
+	}
+	later, err := from.Prepare("SELECT n FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read("Later statement read", later.QueryRow())
+	later.Close()
+}
+
+func TestHandoverWarmUp(t *testing.T) {
+	from, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer from.Close()
+
+	to, err := Open("sqlite3", "/nonexistent/nap.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.Close()
+
+	if err = Handover(context.Background(), from, to); err == nil {
+		t.Error("Want an error handing over to a DB failing to warm up")
+	}
+	if err = from.QueryRow("SELECT 1").Scan(new(int)); err != nil {
+		t.Errorf("Read failed once the handover failed: %v", err)
+	}
+	if from.successor() != nil {
+		t.Error("Operations forwarded once the handover failed")
+	}
+}
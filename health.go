@@ -0,0 +1,182 @@
+package nap
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthPolicy configures how the health of physical dbs is derived from
+// health check results. Each result updates an exponentially weighted
+// moving average of the success rate. A slave is taken out of rotation
+// when the average drops below Evict, and only put back when it climbs to
+// Readmit again, so flapping replicas don't oscillate at every check.
+type HealthPolicy struct {
+	Alpha   float64 // Weight of each new result, in (0, 1]
+	Evict   float64 // Success rate below which a slave leaves rotation
+	Readmit float64 // Success rate at which an evicted slave is readmitted
+}
+
+// DefaultHealthPolicy is used until one is set with DB.SetHealthPolicy.
+// With it, a healthy slave is evicted after two consecutive failures and
+// readmitted after two to four consecutive successes, depending on how
+// long it had been failing.
+var DefaultHealthPolicy = HealthPolicy{Alpha: 0.3, Evict: 0.5, Readmit: 0.75}
+
+// Validate returns an error if p isn't usable.
+func (p HealthPolicy) Validate() error {
+	switch {
+	case p.Alpha <= 0 || p.Alpha > 1:
+		return fmt.Errorf("nap: health alpha %v not in (0, 1]", p.Alpha)
+	case p.Evict < 0 || p.Readmit > 1 || p.Evict > p.Readmit:
+		return fmt.Errorf("nap: health thresholds want 0 <= evict (%v) <= readmit (%v) <= 1", p.Evict, p.Readmit)
+	}
+	return nil
+}
+
+// SetHealthPolicy sets the policy deriving health from health check results.
+func (db *DB) SetHealthPolicy(p HealthPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	db.policy.Store(p)
+	return nil
+}
+
+// SetHealthCheck sets how often every physical db is health checked by
+// pinging it in the background, until the DB is closed, and how long each
+// ping may take before failing. Results update the health of each physical
+// db as set with SetHealthPolicy, taking failing slaves out of rotation for
+// the reads of both DB and Stmt, and readmitting them once they recover.
+// Reads go to the master while no slave is in rotation.
+// If timeout <= 0, pings may take up to interval. If interval <= 0,
+// physical dbs are no longer checked in the background, which is the
+// default, and are only checked by Ping.
+func (db *DB) SetHealthCheck(interval, timeout time.Duration) {
+	atomic.StoreInt64(&db.checkWait, int64(timeout))
+	atomic.StoreInt64(&db.checkEvery, int64(interval))
+	db.startHealthCheck()
+}
+
+func (db *DB) startHealthCheck() {
+	if atomic.LoadInt64(&db.checkEvery) > 0 && atomic.CompareAndSwapInt32(&db.checking, 0, 1) {
+		go db.healthCheck()
+	}
+}
+
+// healthCheck checks the health of the physical dbs until disabled or the
+// DB is closed.
+func (db *DB) healthCheck() {
+	for {
+		every := time.Duration(atomic.LoadInt64(&db.checkEvery))
+		if every <= 0 {
+			atomic.StoreInt32(&db.checking, 0)
+			db.startHealthCheck() // Re-enabled concurrently
+			return
+		}
+
+		timeout := time.Duration(atomic.LoadInt64(&db.checkWait))
+		if timeout <= 0 {
+			timeout = every
+		}
+		db.checkHealth(every, timeout)
+
+		select {
+		case <-db.done():
+			return
+		case <-time.After(every):
+		}
+	}
+}
+
+// checkHealth pings every physical db concurrently, failing those not
+// responding within timeout, or shares the pings done by other DBs during
+// the last interval.
+func (db *DB) checkHealth(interval, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t, p := db.topology(), db.healthPolicy()
+	scatter(len(t.pdbs), func(i int) error {
+		_, err := db.sharedProbe(t, i, "health", interval, func() (interface{}, error) {
+			return nil, t.pdbs[i].PingContext(ctx)
+		})
+		t.health(i).observe(p, err == nil)
+		return nil
+	})
+}
+
+func (db *DB) healthPolicy() HealthPolicy {
+	if p, ok := db.policy.Load().(HealthPolicy); ok {
+		return p
+	}
+	return DefaultHealthPolicy
+}
+
+// Healthy reports whether the physical db at index i is in rotation.
+// Reads fall back to the master when no slave is.
+func (db *DB) Healthy(i int) bool {
+	return db.inRotation(i)
+}
+
+// HealthScore returns the smoothed health check success rate of the
+// physical db at index i, between 0 and 1.
+func (db *DB) HealthScore(i int) float64 {
+	h := db.health(i)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rate
+}
+
+func (db *DB) inRotation(i int) bool {
+	return i == 0 || atomic.LoadInt32(&db.health(i).evicted) == 0 && !db.drilledDown(i) && !db.lagging(i)
+}
+
+func (db *DB) health(i int) *health {
+	return db.topology().health(i)
+}
+
+// health returns the health of the physical db at index i, which is
+// detached and out of rotation if i is out of range.
+func (t *topology) health(i int) *health {
+	if i >= 0 && i < len(t.healths) {
+		return t.healths[i]
+	}
+	return &health{evicted: 1}
+}
+
+// health is the smoothed health signal of a physical db.
+type health struct {
+	mu         sync.Mutex
+	rate       float64 // Smoothed success rate
+	evicted    int32   // Set while out of rotation, accessed atomically
+	readmitted int64   // Unix nanoseconds of the last readmission, accessed atomically
+	jitter     uint64  // Bits of the jitter of the last readmission in [-1, 1], accessed atomically
+	lag        int64   // Replication lag probed plus one, or zero if unknown, accessed atomically
+	latency    int64   // Smoothed read latency in nanoseconds, accessed atomically
+}
+
+// observe updates h with the result of a health check.
+func (h *health) observe(p HealthPolicy, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sample := 0.0
+	if ok {
+		sample = 1
+	}
+	h.rate = p.Alpha*sample + (1-p.Alpha)*h.rate
+
+	switch evicted := atomic.LoadInt32(&h.evicted) == 1; {
+	case !evicted && h.rate < p.Evict:
+		atomic.StoreInt32(&h.evicted, 1)
+	case evicted && h.rate >= p.Readmit:
+		atomic.StoreUint64(&h.jitter, math.Float64bits(2*rand.Float64()-1))
+		atomic.StoreInt64(&h.readmitted, time.Now().UnixNano())
+		atomic.StoreInt32(&h.evicted, 0)
+	}
+}
@@ -0,0 +1,152 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestHealthPolicy(t *testing.T) {
+	p := HealthPolicy{Alpha: 0.5, Evict: 0.3, Readmit: 0.85}
+	h := &health{rate: 1}
+
+	for i, tc := range []struct {
+		ok      bool
+		evicted bool
+	}{
+		{false, false}, // 0.5
+		{true, false},  // 0.75
+		{false, false}, // 0.375
+		{false, true},  // 0.1875
+		{true, true},   // 0.594
+		{true, true},   // 0.797
+		{true, false},  // 0.898
+	} {
+		h.observe(p, tc.ok)
+		if evicted := h.evicted == 1; evicted != tc.evicted {
+			t.Errorf("Check %d: evicted = %t, want %t (rate %.3f)", i, evicted, tc.evicted, h.rate)
+		}
+	}
+
+	for _, invalid := range []HealthPolicy{{}, {Alpha: 2}, {Alpha: 0.5, Evict: 0.9, Readmit: 0.5}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestHealthRotation(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.topology().pdbs[2].Close()
+	for i := 0; i < 2; i++ {
+		db.Ping()
+	}
+
+	if db.Healthy(2) || !db.Healthy(1) {
+		t.Fatalf("Unexpected health: %t, %t", db.Healthy(1), db.Healthy(2))
+	}
+
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(context.Background()); n != 1 {
+			t.Fatalf("Read routed to %d instead of the healthy slave", n)
+		}
+	}
+
+	db.topology().pdbs[1].Close()
+	for i := 0; i < 2; i++ {
+		db.Ping()
+	}
+
+	if n := db.readIndex(context.Background()); n != 0 {
+		t.Errorf("Read routed to %d instead of falling back to the master", n)
+	}
+
+	if nodes := db.eligible(context.Background()); len(nodes) != 1 || nodes[0] != 0 {
+		t.Errorf("Unexpected eligible nodes: %v", nodes)
+	}
+}
+
+// flakyDriver opens SQLite in-memory connections, failing for the DSNs
+// which are down.
+type flakyDriver struct {
+	down sync.Map
+}
+
+func (d *flakyDriver) Open(name string) (driver.Conn, error) {
+	if _, ok := d.down.Load(name); ok {
+		return nil, errors.New("down")
+	}
+	return (&sqlite3.SQLiteDriver{}).Open(":memory:")
+}
+
+var flaky = &flakyDriver{}
+
+func init() {
+	sql.Register("sqlite3_flaky", flaky)
+}
+
+func TestHealthCheck(t *testing.T) {
+	db, err := Open("sqlite3_flaky", "check0;check1;check2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxIdleConns(0)
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	waitHealthy := func(i int, healthy bool) {
+		t.Helper()
+		for start := time.Now(); db.Healthy(i) != healthy; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("Physical db %d not healthy = %t", i, healthy)
+			}
+		}
+	}
+
+	flaky.down.Store("check2", true)
+	defer flaky.down.Delete("check2")
+
+	db.SetHealthCheck(time.Millisecond, time.Second)
+	waitHealthy(2, false)
+
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(context.Background()); n != 1 {
+			t.Fatalf("Read routed to %d instead of the healthy slave", n)
+		}
+		if n := stmt.readIndex(context.Background(), stmt.load()); n != 1 {
+			t.Fatalf("Statement read routed to %d instead of the healthy slave", n)
+		}
+	}
+
+	flaky.down.Store("check1", true)
+	defer flaky.down.Delete("check1")
+	waitHealthy(1, false)
+
+	if n := db.readIndex(context.Background()); n != 0 {
+		t.Errorf("Read routed to %d instead of falling back to the master", n)
+	}
+
+	flaky.down.Delete("check2")
+	waitHealthy(2, true)
+
+	db.SetHealthCheck(0, 0)
+	if n := db.readIndex(context.Background()); n != 2 {
+		t.Errorf("Read routed to %d instead of the readmitted slave", n)
+	}
+}
@@ -0,0 +1,266 @@
+package nap
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Op identifies the kind of a routed operation.
+type Op uint8
+
+// Operations reported to a RouteHook.
+const (
+	OpExec Op = iota
+	OpQuery
+	OpQueryRow
+	OpStmtExec
+	OpStmtQuery
+	OpStmtQueryRow
+)
+
+var opNames = [...]string{"exec", "query", "query_row", "stmt_exec", "stmt_query", "stmt_query_row"}
+
+// String returns the name of op.
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return "unknown"
+}
+
+// write reports whether op runs on the master.
+func (op Op) write() bool {
+	return op == OpExec || op == OpStmtExec
+}
+
+// QueryID identifies a query registered with DB.RegisterQuery.
+// The zero QueryID stands for unregistered queries.
+type QueryID uint32
+
+// RouteHook is called after every routed operation with the QueryID
+// carried by its context, the index of the physical db it ran on, its
+// duration and error. Errors of QueryRow operations which only surface
+// at Scan aren't reported.
+// It is a minimal, allocation free alternative to richer instrumentation,
+// meant for routing metrics at very high rates. It must not block.
+type RouteHook func(op Op, id QueryID, node int, d time.Duration, err error)
+
+// SetRouteHook sets the hook called after every routed operation.
+// If fn is nil, no hook is called.
+func (db *DB) SetRouteHook(fn RouteHook) {
+	db.hook.Store(fn)
+}
+
+// RegisterQuery registers a query name, such as its call site, and returns
+// the QueryID to attach to contexts with WithQueryID.
+func (db *DB) RegisterQuery(name string) QueryID {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	names, _ := db.queries.Load().([]string)
+	names = append(names[:len(names):len(names)], name)
+	db.queries.Store(names)
+
+	return QueryID(len(names))
+}
+
+// QueryName returns the name id was registered with.
+func (db *DB) QueryName(id QueryID) string {
+	names, _ := db.queries.Load().([]string)
+	if id == 0 || int(id) > len(names) {
+		return ""
+	}
+	return names[id-1]
+}
+
+type queryIDKey struct{}
+
+// WithQueryID returns a copy of ctx carrying id. Contexts can be prepared
+// once per call site so no allocation happens per query.
+func WithQueryID(ctx context.Context, id QueryID) context.Context {
+	return context.WithValue(ctx, queryIDKey{}, id)
+}
+
+// QueryInfo describes a routed operation to a QueryHook.
+// Fields may be added over time, so hooks shouldn't rely on its size.
+type QueryInfo struct {
+	Op            Op
+	ID            QueryID // QueryID carried by the context
+	SQL           string  // SQL as sent, or of the prepared statement
+	Normalized    string  // SQL without comments, with literals replaced by ?
+	Args          int     // Number of args
+	Node          int     // Index of the physical db it ran on last
+	Attempt       int     // Number of attempts, more than 1 if retried
+	Duration      time.Duration
+	Err           error
+	CorrelationID string // Correlation ID carried by the context
+	Caller        string // Caller label set with WithCaller
+	FormattedArgs string // Args rendered by the ArgsFormatter of the DB, if hooks are set
+}
+
+// QueryHook is called with a QueryInfo describing a routed operation,
+// as a structured alternative to RouteHook for tracing and logging
+// integrations. It must not block.
+type QueryHook func(ctx context.Context, info QueryInfo)
+
+// SetQueryHook sets the hook called after every routed operation.
+// If fn is nil, no hook is called.
+func (db *DB) SetQueryHook(fn QueryHook) {
+	db.queryHook.Store(fn)
+}
+
+// Hooks instrument every routed operation of DB and Stmt, such as for
+// tracing or slow query logging, and the freezes of the DB. Unset hooks
+// aren't called, and none of them must block.
+type Hooks struct {
+	// BeforeQuery is called before an operation runs query, which is the
+	// SQL given by the caller, returning the context it runs with, such as
+	// one carrying a tracing span.
+	BeforeQuery func(ctx context.Context, op Op, query string) context.Context
+
+	// AfterQuery is called after an operation with the context returned by
+	// BeforeQuery, like a QueryHook.
+	AfterQuery func(ctx context.Context, info QueryInfo)
+
+	// OnError is called after an operation which failed, after AfterQuery.
+	OnError func(ctx context.Context, info QueryInfo)
+
+	// OnFreeze is called when the DB is frozen or unfrozen.
+	OnFreeze func(frozen bool)
+}
+
+// SetHooks sets the hooks called around every routed operation, along the
+// RouteHook and QueryHook, if any.
+func (db *DB) SetHooks(h Hooks) {
+	db.hooks.Store(&h)
+}
+
+// beforeQuery returns the context an operation op with ctx runs query with.
+func (db *DB) beforeQuery(ctx context.Context, op Op, query string) context.Context {
+	if h, _ := db.hooks.Load().(*Hooks); h != nil && h.BeforeQuery != nil {
+		return h.BeforeQuery(ctx, op, query)
+	}
+	return ctx
+}
+
+// finish accounts for an operation with ctx described by info that
+// started at start, then reports it to the hooks.
+func (db *DB) finish(ctx context.Context, acct *account, info *QueryInfo, start time.Time) {
+	d := time.Since(start)
+	acct.add(d)
+
+	if !info.Op.write() {
+		db.recordRead(info.Node)
+		db.recordLatency(info.Node, d)
+		db.observeRollout(info.Node, info.Err)
+	}
+	db.recordUtilization(info.Node, d)
+
+	if r, _ := db.recorder.Load().(*recorder); r != nil {
+		r.record(info, Tags(ctx), start, d)
+	}
+
+	if m, _ := db.metrics.Load().(*metrics); m != nil {
+		m.observe(ctx, info, d)
+	}
+
+	id, _ := ctx.Value(queryIDKey{}).(QueryID)
+	if hook, _ := db.hook.Load().(RouteHook); hook != nil {
+		hook(info.Op, id, info.Node, d, info.Err)
+	}
+
+	if hook, _ := db.queryHook.Load().(QueryHook); hook != nil {
+		info.ID, info.Duration = id, d
+		hook(ctx, describe(ctx, info))
+	}
+
+	if h, _ := db.hooks.Load().(*Hooks); h != nil {
+		info.ID, info.Duration = id, d
+		if h.AfterQuery != nil {
+			h.AfterQuery(ctx, describe(ctx, info))
+		}
+		if h.OnError != nil && info.Err != nil {
+			h.OnError(ctx, describe(ctx, info))
+		}
+	}
+}
+
+// describe completes info with the details carried by ctx.
+func describe(ctx context.Context, info *QueryInfo) QueryInfo {
+	i := *info
+	i.Normalized = normalizeSQL(i.SQL)
+	i.CorrelationID = CorrelationID(ctx)
+	i.Caller, _ = ctx.Value(callerKey{}).(string)
+	return i
+}
+
+// normalizeSQL strips the comments of query, replaces its string and
+// numeric literals by ? and collapses its whitespace, so that queries
+// differing by their literals normalize the same.
+func normalizeSQL(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+			space = true
+			continue
+		case isSpace(c):
+			space = true
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'':
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++ // Escaped quote
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case c >= '0' && c <= '9' && !identByte(b.String()):
+			for i+1 < len(query) && (query[i+1] >= '0' && query[i+1] <= '9' || query[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// identByte reports whether s ends with a byte which may be part of an
+// identifier, such as in t1 or $1.
+func identByte(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[len(s)-1]
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
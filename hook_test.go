@@ -0,0 +1,196 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRouteHook(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type call struct {
+		op   Op
+		id   QueryID
+		node int
+	}
+
+	var calls []call
+	db.SetRouteHook(func(op Op, id QueryID, node int, d time.Duration, err error) {
+		calls = append(calls, call{op, id, node})
+	})
+
+	id := db.RegisterQuery("users.count")
+	if name := db.QueryName(id); name != "users.count" {
+		t.Errorf("Unexpected query name: %q", name)
+	}
+
+	ctx := WithQueryID(context.Background(), id)
+	db.ExecContext(ctx, "SELECT 1")
+	db.QueryRowContext(ctx, "SELECT 1").Scan(new(int))
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	stmt.QueryRow().Scan(new(int))
+
+	want := []call{{OpExec, id, 0}, {OpQueryRow, id, 1}, {OpStmtQueryRow, 0, 1}}
+	if len(calls) != len(want) {
+		t.Fatalf("Unexpected hook calls. Got: %v, Want: %v", calls, want)
+	}
+
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("Unexpected hook call %d. Got: %v, Want: %v", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestRouteHookAllocs(t *testing.T) {
+	db := &DB{}
+	db.SetRouteHook(func(Op, QueryID, int, time.Duration, error) {})
+	ctx, start := WithQueryID(context.Background(), db.RegisterQuery("q")), time.Now()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		db.finish(ctx, nil, &QueryInfo{Op: OpQuery, SQL: "SELECT 1", Node: 1, Attempt: 1}, start)
+	})
+
+	if allocs != 0 {
+		t.Errorf("Route hook allocates %.1f times per call", allocs)
+	}
+}
+
+func TestQueryHook(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var infos []QueryInfo
+	db.SetQueryHook(func(ctx context.Context, info QueryInfo) {
+		infos = append(infos, info)
+	})
+
+	db.SetIDGenerator(func() string { return "cid" })
+	db.SetReadRetryPolicy(RetryPolicy{Attempts: 2})
+	db.topology().pdbs[1].Close()
+	db.topology().pdbs[2].Close()
+
+	ctx := WithCaller(context.Background(), "billing")
+	db.QueryRowContext(ctx, "SELECT ? -- check\n WHERE  'a' = 'a'", 1).Scan(new(int))
+	db.ExecContext(ctx, "SELECT 1")
+
+	want := []QueryInfo{
+		{
+			Op:            OpQueryRow,
+			SQL:           "SELECT ? -- check\n WHERE  'a' = 'a'",
+			Normalized:    "SELECT ? WHERE ? = ?",
+			Args:          1,
+			Attempt:       3,
+			CorrelationID: "cid",
+			Caller:        "billing",
+			FormattedArgs: "[1]",
+		},
+		{
+			Op:            OpExec,
+			SQL:           "SELECT 1",
+			Normalized:    "SELECT ?",
+			Attempt:       1,
+			CorrelationID: "cid",
+			Caller:        "billing",
+		},
+	}
+
+	if len(infos) != len(want) {
+		t.Fatalf("Unexpected hook calls: %+v", infos)
+	}
+
+	for i := range want {
+		got := infos[i]
+		if got.Duration <= 0 {
+			t.Errorf("Missing duration of call %d", i)
+		}
+
+		got.Duration = 0
+		if got != want[i] {
+			t.Errorf("Unexpected info %d.\nGot:  %+v\nWant: %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	for query, want := range map[string]string{
+		"SELECT * FROM t1 WHERE id = 42":            "SELECT * FROM t1 WHERE id = ?",
+		"/* cid=abc */ SELECT $1,\n\t'it''s', 3.14": "SELECT $1, ?, ?",
+		"SELECT x FROM t -- trailing":               "SELECT x FROM t",
+		"UPDATE t SET a = 'x' WHERE b IN (1, 2)":    "UPDATE t SET a = ? WHERE b IN (?, ?)",
+	} {
+		if got := normalizeSQL(query); got != want {
+			t.Errorf("Unexpected normalization of %q. Got: %q, Want: %q", query, got, want)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type spanKey struct{}
+	var before []string
+	var after, failed []QueryInfo
+	db.SetHooks(Hooks{
+		BeforeQuery: func(ctx context.Context, op Op, query string) context.Context {
+			before = append(before, op.String()+" "+query)
+			return context.WithValue(ctx, spanKey{}, query)
+		},
+		AfterQuery: func(ctx context.Context, info QueryInfo) {
+			if ctx.Value(spanKey{}) == nil {
+				t.Error("Context of BeforeQuery not passed to AfterQuery")
+			}
+			after = append(after, info)
+		},
+		OnError: func(ctx context.Context, info QueryInfo) {
+			failed = append(failed, info)
+		},
+	})
+
+	db.Exec("SELECT 1")
+	db.QueryRow("SELECT 2").Scan(new(int))
+	if _, err = db.Query("SELECT * FROM missing"); err == nil {
+		t.Fatal("Query of a missing table succeeded")
+	}
+
+	stmt, err := db.Prepare("SELECT 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	stmt.Exec()
+
+	want := []string{"exec SELECT 1", "query_row SELECT 2", "query SELECT * FROM missing", "stmt_exec SELECT 3"}
+	if len(before) != len(want) || len(after) != len(want) {
+		t.Fatalf("Unexpected hook calls: %v, %v", before, after)
+	}
+	for i := range want {
+		if before[i] != want[i] {
+			t.Errorf("Unexpected BeforeQuery call %d: %s", i, before[i])
+		}
+	}
+
+	if after[1].Node != 1 || after[1].Duration <= 0 || after[3].Node != 0 {
+		t.Errorf("Unexpected AfterQuery calls: %+v", after)
+	}
+	if len(failed) != 1 || failed[0].Op != OpQuery || failed[0].Err == nil {
+		t.Errorf("Unexpected OnError calls: %+v", failed)
+	}
+}
@@ -0,0 +1,282 @@
+package nap
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// INListRewriter rewrites the IN lists of the SQL and args of a non prepared
+// operation, such as into a single array arg, reporting whether it did.
+// Implementations must not modify args.
+type INListRewriter func(query string, args []interface{}) (string, []interface{}, bool)
+
+// SetINListRewriter sets the INListRewriter of the non prepared operations,
+// such as PostgresAnyIN, so that huge IN lists built by callers don't burn
+// CPU on the physical dbs parsing and planning them. Operations are hooked
+// and recorded with their rewritten SQL. If r is nil, IN lists are sent as
+// is, which is the default.
+func (db *DB) SetINListRewriter(r INListRewriter) {
+	db.inList.Store(r)
+}
+
+// rewriteINLists rewrites the IN lists of query and args with the
+// INListRewriter, if any.
+func (db *DB) rewriteINLists(query string, args []interface{}) (string, []interface{}) {
+	r, _ := db.inList.Load().(INListRewriter)
+	if r == nil || len(args) == 0 {
+		return query, args
+	}
+
+	// Copied so that the args of callers don't escape when not rewritten.
+	if q, a, ok := r(query, append([]interface{}(nil), args...)); ok {
+		return q, a
+	}
+	return query, args
+}
+
+// PostgresAnyIN returns an INListRewriter turning the IN lists of at least
+// min $n placeholders into = ANY($n) forms, and the NOT IN ones into <> ALL,
+// with a single Postgres array literal arg, which Postgres casts to an array
+// of the type of the compared expression. Placeholders are renumbered.
+// Queries with dollar quoted strings, named args or lists of placeholders
+// referenced elsewhere, or with values other than nil, numbers, booleans,
+// strings and times, such as []byte, aren't rewritten.
+func PostgresAnyIN(min int) INListRewriter {
+	if min < 1 {
+		min = 1
+	}
+
+	return func(query string, args []interface{}) (string, []interface{}, bool) {
+		for _, arg := range args {
+			if _, ok := arg.(sql.NamedArg); ok {
+				return query, args, false
+			}
+		}
+
+		placeholders, ok := scanPlaceholders(query, len(args))
+		if !ok {
+			return query, args, false
+		}
+
+		uses := make([]int, len(args))
+		for _, p := range placeholders {
+			uses[p.n]++
+		}
+
+		lists := findINLists(query, placeholders, uses, min)
+		if len(lists) == 0 {
+			return query, args, false
+		}
+
+		// Args outside of lists are kept in order, followed by the arrays.
+		listed := make([]bool, len(args))
+		arrays := make([]string, len(lists))
+		for k, l := range lists {
+			elems := make([]string, 0, l.to-l.from)
+			for _, p := range placeholders[l.from:l.to] {
+				elem, ok := arrayElem(args[p.n])
+				if !ok {
+					return query, args, false
+				}
+				elems = append(elems, elem)
+				listed[p.n] = true
+			}
+			arrays[k] = "{" + strings.Join(elems, ",") + "}"
+		}
+
+		renumbered := make([]int, len(args))
+		rewritten := make([]interface{}, 0, len(args))
+		for n, arg := range args {
+			if !listed[n] {
+				renumbered[n] = len(rewritten)
+				rewritten = append(rewritten, arg)
+			}
+		}
+		kept := len(rewritten)
+		for _, a := range arrays {
+			rewritten = append(rewritten, a)
+		}
+
+		var b strings.Builder
+		b.Grow(len(query))
+		last, k := 0, 0
+		for i := 0; i < len(placeholders); i++ {
+			if k < len(lists) && i == lists[k].from {
+				l := lists[k]
+				b.WriteString(query[last:l.start])
+				if l.not {
+					b.WriteString("<> ALL($")
+				} else {
+					b.WriteString("= ANY($")
+				}
+				b.WriteString(strconv.Itoa(kept + k + 1))
+				b.WriteString(")")
+				last, i, k = l.end, l.to-1, k+1
+				continue
+			}
+
+			p := placeholders[i]
+			b.WriteString(query[last:p.start])
+			b.WriteString("$" + strconv.Itoa(renumbered[p.n]+1))
+			last = p.end
+		}
+		b.WriteString(query[last:])
+
+		return b.String(), rewritten, true
+	}
+}
+
+// placeholder is a $n placeholder of a query, referencing the arg at n.
+type placeholder struct {
+	start, end int // Offsets in the query
+	n          int
+}
+
+// scanPlaceholders returns the placeholders of query outside of literals,
+// quoted identifiers and comments, if they all reference one of args and
+// the query has no dollar quoted string.
+func scanPlaceholders(query string, args int) ([]placeholder, bool) {
+	var placeholders []placeholder
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return nil, false
+			}
+			i += end + 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, false
+			}
+			i += end + 3
+		case c == '$':
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+
+			n, err := strconv.Atoi(query[i+1 : j])
+			if err != nil || n < 1 || n > args {
+				return nil, false // Dollar quoted string, or missing arg
+			}
+			placeholders = append(placeholders, placeholder{start: i, end: j, n: n - 1})
+			i = j - 1
+		}
+	}
+	return placeholders, true
+}
+
+// inList is an IN list of consecutive placeholders of a query.
+type inList struct {
+	start, end int  // Offsets of the IN, or NOT IN, and of the end of the list
+	from, to   int  // Range of the placeholders of the list
+	not        bool // Set for NOT IN lists
+}
+
+// findINLists returns the IN lists of at least min placeholders of query,
+// each used once.
+func findINLists(query string, placeholders []placeholder, uses []int, min int) []inList {
+	var lists []inList
+	for i := 0; i < len(placeholders); {
+		open := strings.LastIndexByte(query[:placeholders[i].start], '(')
+		if open < 0 || strings.TrimSpace(query[open+1:placeholders[i].start]) != "" {
+			i++
+			continue
+		}
+
+		keyword := strings.TrimRight(query[:open], " \t\r\n")
+		if len(keyword) < 2 || !strings.EqualFold(keyword[len(keyword)-2:], "IN") ||
+			len(keyword) > 2 && isWordByte(keyword[len(keyword)-3]) {
+			i++
+			continue
+		}
+
+		l := inList{start: len(keyword) - 2, from: i}
+		j := i
+		for ; j < len(placeholders); j++ {
+			between := query[placeholders[j].end:]
+			if j+1 < len(placeholders) {
+				between = query[placeholders[j].end:placeholders[j+1].start]
+			}
+
+			if sep := strings.TrimSpace(between); sep == "," {
+				continue
+			} else if strings.HasPrefix(sep, ")") {
+				l.end = placeholders[j].end + strings.IndexByte(between, ')') + 1
+			}
+			break
+		}
+
+		if l.to = j + 1; j == len(placeholders) {
+			l.to = j
+		}
+		if l.end == 0 || l.to-l.from < min || !usedOnce(placeholders[l.from:l.to], uses) {
+			i = l.to
+			continue
+		}
+
+		before := strings.TrimRight(query[:l.start], " \t\r\n")
+		if len(before) >= 3 && strings.EqualFold(before[len(before)-3:], "NOT") &&
+			(len(before) == 3 || !isWordByte(before[len(before)-4])) {
+			l.start, l.not = len(before)-3, true
+		}
+
+		lists = append(lists, l)
+		i = l.to
+	}
+	return lists
+}
+
+func usedOnce(placeholders []placeholder, uses []int) bool {
+	for _, p := range placeholders {
+		if uses[p.n] != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// arrayElem returns arg formatted as an element of a Postgres array literal.
+func arrayElem(arg interface{}) (string, bool) {
+	v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+	if err != nil {
+		return "", false
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return "NULL", true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return quoteArrayElem(v), true
+	case time.Time:
+		return quoteArrayElem(v.Format(time.RFC3339Nano)), true
+	}
+	return "", false
+}
+
+// quoteArrayElem quotes s as an element of a Postgres array literal.
+func quoteArrayElem(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,119 @@
+package nap
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostgresAnyIN(t *testing.T) {
+	r := PostgresAnyIN(3)
+	ts := time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		query     string
+		args      []interface{}
+		want      string
+		wantArgs  []interface{}
+		rewritten bool
+	}{
+		{
+			query: "SELECT * FROM t WHERE id IN ($1, $2, $3)",
+			args:  []interface{}{1, int64(2), 3},
+			want:  "SELECT * FROM t WHERE id = ANY($1)", wantArgs: []interface{}{"{1,2,3}"}, rewritten: true,
+		},
+		{
+			query: "SELECT * FROM t WHERE a = $1 AND id not in($2,$3,$4) AND b = $5",
+			args:  []interface{}{"a", "x", `"y\`, nil, 5},
+			want:  "SELECT * FROM t WHERE a = $1 AND id <> ALL($3) AND b = $2", wantArgs: []interface{}{"a", 5, `{"x","\"y\\",NULL}`}, rewritten: true,
+		},
+		{
+			query: "SELECT * FROM t WHERE at IN ($1,$2,$3) OR ok IN ($4, $5, $6) OR n IN ($7)",
+			args:  []interface{}{ts, ts, ts, true, false, true, 1.5},
+			want:  "SELECT * FROM t WHERE at = ANY($2) OR ok = ANY($3) OR n IN ($1)",
+			wantArgs: []interface{}{1.5,
+				`{"2020-05-01T10:30:00Z","2020-05-01T10:30:00Z","2020-05-01T10:30:00Z"}`, "{true,false,true}"},
+			rewritten: true,
+		},
+		{query: "SELECT * FROM t WHERE id IN ($1, $2)", args: []interface{}{1, 2}},
+		{query: "SELECT * FROM t WHERE id IN ($1, $2, $3) OR x = $1", args: []interface{}{1, 2, 3}},
+		{query: "SELECT * FROM t WHERE id IN ($1, $2, $3, 4)", args: []interface{}{1, 2, 3}},
+		{query: "SELECT * FROM t WHERE min($1, $2, $3)", args: []interface{}{1, 2, 3}},
+		{query: "SELECT * FROM t WHERE id IN ($1, $2, $3)", args: []interface{}{1, 2, []byte("3")}},
+		{query: "SELECT $$IN ($1, $2, $3)$$, $1, $2, $3", args: []interface{}{1, 2, 3}},
+		{query: "SELECT 'IN ($1, $2, $3)' /* $4 */ -- $5\n", args: []interface{}{1, 2, 3}},
+	} {
+		got, args, ok := r(tt.query, tt.args)
+		if !tt.rewritten {
+			tt.want, tt.wantArgs = tt.query, tt.args
+		}
+		if ok != tt.rewritten || got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("Unexpected rewrite of %q. Got: %q %v, %t, Want: %q %v", tt.query, got, args, ok, tt.want, tt.wantArgs)
+		}
+	}
+}
+
+func TestINListRewriter(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Collapses the lists of 1 into a single placeholder.
+	db.SetINListRewriter(func(query string, args []interface{}) (string, []interface{}, bool) {
+		var kept []interface{}
+		for _, arg := range args {
+			if arg != 1 {
+				kept = append(kept, arg)
+			}
+		}
+		if len(kept) == len(args) {
+			return query, args, false
+		}
+		return strings.Replace(query, "IN (?, ?, ?)", "= 1", 1), kept, true
+	})
+
+	var queries []string
+	db.SetQueryHook(func(ctx context.Context, info QueryInfo) {
+		queries = append(queries, info.SQL)
+	})
+	var before []string
+	db.SetHooks(Hooks{BeforeQuery: func(ctx context.Context, op Op, query string) context.Context {
+		before = append(before, query)
+		return ctx
+	}})
+
+	var n int
+	if err = db.QueryRow("SELECT count(*) WHERE 1 IN (?, ?, ?) AND ? = 2", 1, 1, 1, 2).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("Unexpected rewritten read: %d, %v", n, err)
+	}
+
+	rows, err := db.Query("SELECT 1 WHERE 1 IN (?, ?, ?)", 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if _, err = db.Exec("SELECT 1 WHERE 1 IN (?, ?, ?)", 1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("SELECT 1 WHERE 2 IN (?, ?, ?)", 2, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SELECT count(*) WHERE 1 = 1 AND ? = 2",
+		"SELECT 1 WHERE 1 = 1",
+		"SELECT 1 WHERE 1 = 1",
+		"SELECT 1 WHERE 2 IN (?, ?, ?)",
+	}
+	if !reflect.DeepEqual(queries, want) {
+		t.Errorf("Unexpected rewritten SQL. Got: %q, Want: %q", queries, want)
+	}
+	if !reflect.DeepEqual(before, want) {
+		t.Errorf("Unexpected SQL before queries. Got: %q, Want: %q", before, want)
+	}
+}
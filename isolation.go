@@ -0,0 +1,134 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+)
+
+type isolationKey struct{}
+
+// WithReadIsolation returns a copy of ctx whose reads of slaves run at
+// level, overriding the one set with SetReadIsolation. LevelDefault runs
+// them outside of transactions.
+func WithReadIsolation(ctx context.Context, level sql.IsolationLevel) context.Context {
+	return context.WithValue(ctx, isolationKey{}, level)
+}
+
+// SetReadIsolation sets the isolation level of the reads of slaves labeled
+// with caller by WithCaller, or of the unlabeled ones if caller is empty,
+// such as sql.LevelReadUncommitted for analytics workloads, to reduce the
+// lock contention on replicas. Such reads run in read-only transactions
+// begun at level, which the driver issues as its SET TRANSACTION ISOLATION
+// LEVEL statement, and rolled back once their rows are closed or their row
+// is scanned. They bypass the connection checkout timeout. Reads of the
+// master are left as is. If level is LevelDefault, reads of caller run
+// outside of transactions, which is the default.
+func (db *DB) SetReadIsolation(caller string, level sql.IsolationLevel) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, _ := db.isolation.Load().(map[string]sql.IsolationLevel)
+	levels := make(map[string]sql.IsolationLevel, len(old)+1)
+	for k, v := range old {
+		levels[k] = v
+	}
+
+	if level != sql.LevelDefault {
+		levels[caller] = level
+	} else {
+		delete(levels, caller)
+	}
+	db.isolation.Store(levels)
+}
+
+// readIsolation returns the isolation level of the reads of slaves with
+// ctx, if any.
+func (db *DB) readIsolation(ctx context.Context) (sql.IsolationLevel, bool) {
+	if level, ok := ctx.Value(isolationKey{}).(sql.IsolationLevel); ok {
+		return level, level != sql.LevelDefault
+	}
+
+	levels, _ := db.isolation.Load().(map[string]sql.IsolationLevel)
+	if len(levels) == 0 {
+		return sql.LevelDefault, false
+	}
+
+	caller, _ := ctx.Value(callerKey{}).(string)
+	level, ok := levels[caller]
+	return level, ok
+}
+
+// isolated begins the read-only transaction reads of the physical db at
+// index node run in, if ctx has a read isolation and node is a slave. The
+// transaction is rolled back when ctx, which statementContext made
+// cancelable, is done.
+func (db *DB) isolated(ctx context.Context, node int) (*sql.Tx, error) {
+	level, ok := db.readIsolation(ctx)
+	pdb := db.lanePDB(ctx, node)
+	if !ok || node == 0 || pdb == nil {
+		return nil, nil
+	}
+	return pdb.BeginTx(ctx, &sql.TxOptions{Isolation: level, ReadOnly: true})
+}
+
+// queryNode queries the physical db at index node, in a transaction at the
+// read isolation of ctx, if any.
+func (db *DB) queryNode(ctx context.Context, node int, query string, args []interface{}) (*sql.Rows, error) {
+	tx, err := db.isolated(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		return tx.QueryContext(ctx, query, args...)
+	}
+	return db.lanePDB(ctx, node).QueryContext(ctx, query, args...)
+}
+
+// queryRowNode is like queryNode for a single row.
+func (db *DB) queryRowNode(ctx context.Context, node int, query string, args []interface{}) *sql.Row {
+	tx, err := db.isolated(ctx, node)
+	if err != nil {
+		return errRow(db.topology().pdb(node), err)
+	}
+	if tx != nil {
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	return db.lanePDB(ctx, node).QueryRowContext(ctx, query, args...)
+}
+
+// queryNode runs s on the physical db at index node of set, in a transaction
+// at the read isolation of ctx, if any.
+func (s *Stmt) queryNode(ctx context.Context, set *stmtSet, node int, args []interface{}) (*sql.Rows, error) {
+	tx, err := s.db.isolated(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	if pdb := s.db.bulkPDB(ctx, node); pdb != nil {
+		if tx != nil {
+			return tx.QueryContext(ctx, s.db.preparedSQL(s.query), args...)
+		}
+		return pdb.QueryContext(ctx, s.db.preparedSQL(s.query), args...)
+	}
+	if tx != nil {
+		return tx.StmtContext(ctx, set.stmts[node]).QueryContext(ctx, args...)
+	}
+	return set.stmts[node].QueryContext(ctx, args...)
+}
+
+// queryRowNode is like queryNode for a single row.
+func (s *Stmt) queryRowNode(ctx context.Context, set *stmtSet, node int, args []interface{}) *sql.Row {
+	tx, err := s.db.isolated(ctx, node)
+	if err != nil {
+		return errRow(s.db.topology().pdb(node), err)
+	}
+	if pdb := s.db.bulkPDB(ctx, node); pdb != nil {
+		if tx != nil {
+			return tx.QueryRowContext(ctx, s.db.preparedSQL(s.query), args...)
+		}
+		return pdb.QueryRowContext(ctx, s.db.preparedSQL(s.query), args...)
+	}
+	if tx != nil {
+		return tx.StmtContext(ctx, set.stmts[node]).QueryRowContext(ctx, args...)
+	}
+	return set.stmts[node].QueryRowContext(ctx, args...)
+}
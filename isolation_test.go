@@ -0,0 +1,122 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// isolations records the isolation levels of the transactions begun on
+// sqlite3_isolation connections.
+var isolations = struct {
+	sync.Mutex
+	levels []sql.IsolationLevel
+}{}
+
+type isolationDriver struct{}
+
+func (isolationDriver) Open(name string) (driver.Conn, error) {
+	c, err := (&sqlite3.SQLiteDriver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return isolationConn{c.(*sqlite3.SQLiteConn)}, nil
+}
+
+type isolationConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c isolationConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	isolations.Lock()
+	isolations.levels = append(isolations.levels, sql.IsolationLevel(opts.Isolation))
+	isolations.Unlock()
+	return c.SQLiteConn.BeginTx(ctx, opts)
+}
+
+func init() {
+	sql.Register("sqlite3_isolation", isolationDriver{})
+}
+
+func TestReadIsolation(t *testing.T) {
+	db, err := Open("sqlite3_isolation", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	begun := func() []sql.IsolationLevel {
+		isolations.Lock()
+		defer isolations.Unlock()
+		levels := isolations.levels
+		isolations.levels = nil
+		return levels
+	}
+
+	read := func(ctx context.Context) {
+		rows, err := db.QueryContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+
+		if err = db.QueryRowContext(ctx, "SELECT 1").Scan(new(int)); err != nil {
+			t.Fatal(err)
+		}
+		if err = stmt.QueryRowContext(ctx).Scan(new(int)); err != nil {
+			t.Fatal(err)
+		}
+		if rows, err = stmt.QueryContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	begun()
+	read(context.Background())
+	if levels := begun(); len(levels) != 0 {
+		t.Errorf("Reads without isolation ran in transactions: %v", levels)
+	}
+
+	db.SetReadIsolation("analytics", sql.LevelReadUncommitted)
+	read(context.Background())
+	if levels := begun(); len(levels) != 0 {
+		t.Errorf("Reads of other callers ran in transactions: %v", levels)
+	}
+
+	// Each slave has a single connection, so reads would block on
+	// transactions left open.
+	for i := 0; i < 2; i++ {
+		read(WithCaller(context.Background(), "analytics"))
+	}
+	if levels := begun(); len(levels) != 8 || levels[0] != sql.LevelReadUncommitted {
+		t.Errorf("Unexpected isolation of the reads of the caller: %v", levels)
+	}
+
+	read(UseMaster(WithCaller(context.Background(), "analytics")))
+	if levels := begun(); len(levels) != 0 {
+		t.Errorf("Reads of the master ran in transactions: %v", levels)
+	}
+
+	read(WithReadIsolation(context.Background(), sql.LevelReadCommitted))
+	if levels := begun(); len(levels) != 4 || levels[0] != sql.LevelReadCommitted {
+		t.Errorf("Unexpected isolation of the reads of the context: %v", levels)
+	}
+
+	db.SetReadIsolation("analytics", sql.LevelDefault)
+	read(WithCaller(context.Background(), "analytics"))
+	if levels := begun(); len(levels) != 0 {
+		t.Errorf("Reads of a reset caller ran in transactions: %v", levels)
+	}
+}
@@ -0,0 +1,80 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// WithAdvisoryLock runs fn while holding the Postgres session advisory lock
+// identified by key, acquired with pg_advisory_lock on a connection to the
+// master pinned for the duration of fn. fn may run statements in the locking
+// session with conn. The lock is released once fn returns, even if ctx is
+// canceled, and connections failing to release it are discarded so the lock
+// can't outlive fn.
+func (db *DB) WithAdvisoryLock(ctx context.Context, key int64, fn func(conn *sql.Conn) error) (err error) {
+	if n := db.successor(); n != nil {
+		return n.WithAdvisoryLock(ctx, key, fn)
+	}
+
+	if err := db.writable(); err != nil {
+		return err
+	}
+
+	conn, err := db.Master().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		return fmt.Errorf("nap: acquiring advisory lock %d: %w", key, err)
+	}
+
+	defer func() {
+		// The lock must be released even if ctx is done.
+		_, uerr := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		if uerr == nil {
+			return
+		}
+
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		if err == nil {
+			err = fmt.Errorf("nap: releasing advisory lock %d: %w", key, uerr)
+		}
+	}()
+
+	return fn(conn)
+}
+
+// WithAdvisoryTxLock runs fn in a transaction on the master holding the
+// Postgres transaction advisory lock identified by key, acquired with
+// pg_advisory_xact_lock. The transaction is committed if fn returns nil
+// and rolled back otherwise, which releases the lock either way. The
+// transaction is started on the master even when opts are read-only.
+func (db *DB) WithAdvisoryTxLock(ctx context.Context, key int64, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	if n := db.successor(); n != nil {
+		return n.WithAdvisoryTxLock(ctx, key, opts, fn)
+	}
+
+	if err := db.writable(); err != nil {
+		return err
+	}
+
+	tx, err := db.beginMaster(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", key); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("nap: acquiring advisory lock %d: %w", key, err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,157 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// advisoryLocks fakes the Postgres advisory lock functions on SQLite.
+var advisoryLocks = struct {
+	sync.Mutex
+	held   map[int64]bool
+	failed bool // Makes unlocking fail
+}{held: map[int64]bool{}}
+
+func init() {
+	sql.Register("sqlite3_advisory", &sqlite3.SQLiteDriver{
+		ConnectHook: func(c *sqlite3.SQLiteConn) error {
+			lock := func(key int64) bool {
+				advisoryLocks.Lock()
+				defer advisoryLocks.Unlock()
+				advisoryLocks.held[key] = true
+				return true
+			}
+
+			unlock := func(key int64) (bool, error) {
+				advisoryLocks.Lock()
+				defer advisoryLocks.Unlock()
+				if advisoryLocks.failed {
+					return false, errors.New("unlock failed")
+				}
+				delete(advisoryLocks.held, key)
+				return true, nil
+			}
+
+			if err := c.RegisterFunc("pg_advisory_lock", lock, false); err != nil {
+				return err
+			}
+			if err := c.RegisterFunc("pg_advisory_xact_lock", lock, false); err != nil {
+				return err
+			}
+			return c.RegisterFunc("pg_advisory_unlock", unlock, false)
+		},
+	})
+}
+
+func held(key int64) bool {
+	advisoryLocks.Lock()
+	defer advisoryLocks.Unlock()
+	return advisoryLocks.held[key]
+}
+
+func TestWithAdvisoryLock(t *testing.T) {
+	db, err := Open("sqlite3_advisory", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	err = db.WithAdvisoryLock(ctx, 42, func(conn *sql.Conn) error {
+		if !held(42) {
+			t.Error("Advisory lock not held while running fn")
+		}
+		_, err := conn.ExecContext(ctx, "SELECT 1")
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if held(42) {
+		t.Error("Advisory lock not released")
+	}
+
+	errFn := errors.New("fn failed")
+	if err = db.WithAdvisoryLock(ctx, 42, func(*sql.Conn) error { return errFn }); err != errFn {
+		t.Errorf("Unexpected error. Got: %v, Want: %v", err, errFn)
+	}
+
+	if held(42) {
+		t.Error("Advisory lock not released after fn failed")
+	}
+
+	advisoryLocks.Lock()
+	advisoryLocks.failed = true
+	advisoryLocks.Unlock()
+
+	defer func() {
+		advisoryLocks.Lock()
+		advisoryLocks.failed = false
+		advisoryLocks.Unlock()
+	}()
+
+	if err = db.WithAdvisoryLock(ctx, 7, func(*sql.Conn) error { return nil }); err == nil {
+		t.Error("Expected error releasing the advisory lock")
+	}
+
+	if open := db.Master().Stats().OpenConnections; open != 0 {
+		t.Errorf("Connection failing to release the lock wasn't discarded: %d open", open)
+	}
+}
+
+func TestWithAdvisoryTxLock(t *testing.T) {
+	db, err := Open("sqlite3_advisory", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	err = db.WithAdvisoryTxLock(ctx, 1, nil, func(tx *sql.Tx) error {
+		if !held(1) {
+			t.Error("Advisory lock not held while running fn")
+		}
+		_, err := tx.ExecContext(ctx, "CREATE TABLE t (id INTEGER)")
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err = db.Master().QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		t.Errorf("Transaction not committed: %v", err)
+	}
+
+	slaves, err := Open("sqlite3_advisory", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slaves.Close()
+
+	slaves.SetMaxOpenConns(1)
+	if _, err = slaves.ExecContext(ctx, "CREATE TABLE master (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	err = slaves.WithAdvisoryTxLock(ctx, 2, &sql.TxOptions{ReadOnly: true}, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM master").Scan(&n)
+	})
+	if err != nil {
+		t.Errorf("Read-only transaction not started on the master: %v", err)
+	}
+
+	db.StartDrill(Drill{MasterDown: true})
+	defer db.StopDrill()
+
+	if err = db.WithAdvisoryTxLock(ctx, 1, nil, func(*sql.Tx) error { return nil }); err != ErrDrill {
+		t.Errorf("Unexpected error during drill. Got: %v, Want: %v", err, ErrDrill)
+	}
+}
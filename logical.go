@@ -0,0 +1,32 @@
+package nap
+
+// Logical replicas carry the "replication" label set to "logical".
+const logicalReplication = "logical"
+
+// SetLogicalReplica marks the slave at index i as a logical replica, or
+// unmarks it. The schema of logical replicas may lag behind or differ from
+// the master's, so statements failing to prepare on them don't fail
+// Prepare; the resulting Stmt skips them instead.
+// Logical replicas carry the label replication=logical.
+func (db *DB) SetLogicalReplica(i int, logical bool) {
+	value := ""
+	if logical {
+		value = logicalReplication
+	}
+	db.setLabel(i, "replication", value)
+}
+
+// LogicalReplica reports whether the physical db at index i is marked
+// as a logical replica.
+func (db *DB) LogicalReplica(i int) bool {
+	return i > 0 && db.labelsOf(i)["replication"] == logicalReplication
+}
+
+// prepared filters the error of preparing a statement on the physical db
+// at index i, ignoring it for logical replicas.
+func (db *DB) prepared(i int, err error) error {
+	if err != nil && db.LogicalReplica(i) {
+		return nil
+	}
+	return err
+}
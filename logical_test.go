@@ -0,0 +1,52 @@
+package nap
+
+import (
+	"testing"
+)
+
+func TestLogicalReplica(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 2; i++ {
+		if _, err = db.topology().pdbs[i].Exec("CREATE TABLE t (a INT)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = db.Prepare("SELECT a FROM t"); err == nil {
+		t.Fatal("Expected Prepare to fail on the diverging slave")
+	}
+
+	db.SetLogicalReplica(2, true)
+	if !db.LogicalReplica(2) || db.Labels(2)["replication"] != "logical" {
+		t.Fatal("Slave not marked as logical replica")
+	}
+
+	stmt, err := db.Prepare("SELECT a FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if !stmt.Eligible(1) || stmt.Eligible(2) {
+		t.Errorf("Unexpected eligibility: %t, %t", stmt.Eligible(1), stmt.Eligible(2))
+	}
+
+	for i := 0; i < 4; i++ {
+		rows, err := stmt.Query()
+		if err != nil {
+			t.Fatalf("Query routed to an ineligible slave: %s", err)
+		}
+		rows.Close()
+	}
+
+	db.SetLogicalReplica(2, false)
+	if db.LogicalReplica(2) {
+		t.Error("Slave still marked as logical replica")
+	}
+}
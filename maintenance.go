@@ -0,0 +1,127 @@
+package nap
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MaintenanceTask describes statements, such as ANALYZE or OPTIMIZE TABLE,
+// which are periodically run on physical dbs in the background.
+type MaintenanceTask struct {
+	Statements []string      // Statements run in order on each physical db
+	Selector   Selector      // Physical dbs to run on, every one if empty
+	Every      time.Duration // Interval between runs, postponed until Window opens
+	Window     Window        // Time of day runs may start at, any time if zero
+
+	// MaxInUse postpones a run on a physical db to the next interval while
+	// more than MaxInUse of its connections are in use, so maintenance
+	// doesn't compete with traffic. Zero means no limit.
+	MaxInUse int
+
+	// Report, when set, is called after each run on a physical db with
+	// the first error encountered, if any.
+	Report func(node int, err error)
+}
+
+// Window is a daily time window expressed as offsets from local midnight.
+// Windows with From after To wrap around midnight.
+type Window struct {
+	From, To time.Duration
+}
+
+// Contains reports whether t falls within w. A zero Window contains any time.
+func (w Window) Contains(t time.Time) bool {
+	if w.From == w.To {
+		return true
+	}
+
+	y, m, d := t.Date()
+	offset := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.From < w.To {
+		return offset >= w.From && offset < w.To
+	}
+	return offset >= w.From || offset < w.To
+}
+
+// next returns the first time from t, included, falling within w.
+func (w Window) next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+
+	y, m, d := t.Date()
+	open := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.From)
+	if !open.After(t) {
+		open = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(w.From)
+	}
+	return open
+}
+
+// Schedule starts running task in the background until the returned stop
+// function is called or the DB is closed. Each run starts task.Every
+// after the previous one, or after Schedule for the first run, at the
+// next opening of task.Window if that time falls outside of it. Physical
+// dbs are processed one at a time, one statement at a time. It fails if
+// task.Every <= 0.
+func (db *DB) Schedule(task MaintenanceTask) (stop func(), err error) {
+	if task.Every <= 0 {
+		return nil, fmt.Errorf("nap: maintenance interval %v not positive", task.Every)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-db.done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	go db.maintain(ctx, task)
+
+	return cancel, nil
+}
+
+func (db *DB) maintain(ctx context.Context, task MaintenanceTask) {
+	last := time.Now()
+	for {
+		// Runs start once both the interval elapsed and the window opened.
+		timer := time.NewTimer(time.Until(task.Window.next(last.Add(task.Every))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case last = <-timer.C:
+		}
+
+		t := db.topology()
+		for i, pdb := range t.pdbs {
+			if !task.Selector.Matches(t.labels[i]) {
+				continue
+			}
+
+			if task.MaxInUse > 0 && pdb.Stats().InUse > task.MaxInUse {
+				continue
+			}
+
+			err := runMaintenance(ctx, pdb, task.Statements)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if task.Report != nil {
+				task.Report(i, err)
+			}
+		}
+	}
+}
+
+func runMaintenance(ctx context.Context, ex execer, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := ex.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package nap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowContains(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2020, 1, 1, hour, 30, 0, 0, time.UTC)
+	}
+
+	night := Window{From: 22 * time.Hour, To: 5 * time.Hour}
+	day := Window{From: 9 * time.Hour, To: 17 * time.Hour}
+
+	for _, tc := range []struct {
+		w    Window
+		hour int
+		want bool
+	}{
+		{Window{}, 12, true},
+		{night, 23, true},
+		{night, 3, true},
+		{night, 12, false},
+		{day, 12, true},
+		{day, 17, false},
+		{day, 8, false},
+	} {
+		if got := tc.w.Contains(at(tc.hour)); got != tc.want {
+			t.Errorf("%+v.Contains(%d:30) = %t, want %t", tc.w, tc.hour, got, tc.want)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2020, 1, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	night := Window{From: 22 * time.Hour, To: 5 * time.Hour}
+	early := Window{From: 2 * time.Hour, To: 4 * time.Hour}
+
+	for _, tc := range []struct {
+		w    Window
+		t    time.Time
+		want time.Time
+	}{
+		{Window{}, at(1, 12), at(1, 12)},
+		{night, at(1, 23), at(1, 23)},
+		{night, at(1, 12), at(1, 22)},
+		{night, at(2, 3), at(2, 3)},
+		{early, at(1, 3), at(1, 3)},
+		{early, at(1, 1), at(1, 2)},
+		{early, at(1, 4), at(2, 2)},
+		// A daily run scheduled at 15:00 waits for the window to open.
+		{early, at(1, 15).Add(24 * time.Hour), at(3, 2)},
+		{early, at(3, 2).Add(24 * time.Hour), at(4, 2)},
+	} {
+		if got := tc.w.next(tc.t); !got.Equal(tc.want) {
+			t.Errorf("%+v.next(%s) = %s, want %s", tc.w, tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.Schedule(MaintenanceTask{Statements: []string{"ANALYZE"}}); err == nil {
+		t.Error("Task without an interval scheduled")
+	}
+
+	runs := make(chan int, 16)
+	stop, err := db.Schedule(MaintenanceTask{
+		Statements: []string{"ANALYZE"},
+		Selector:   MustParseSelector("role=slave"),
+		Every:      time.Millisecond,
+		Report: func(node int, err error) {
+			if err != nil {
+				t.Errorf("Maintenance failed on %d: %s", node, err)
+			}
+			runs <- node
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[int]bool{}
+	for len(seen) < 2 {
+		select {
+		case node := <-runs:
+			if node == 0 {
+				t.Fatal("Maintenance ran on the master")
+			}
+			seen[node] = true
+		case <-time.After(time.Second):
+			t.Fatalf("Maintenance didn't run on every slave: %v", seen)
+		}
+	}
+
+	stop()
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,211 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// AddSlave opens a slave with dsn and adds it to the physical dbs, after
+// the current ones, with the driver and the connection settings of the
+// master, such as its max open connections and resetter, and the
+// application name of its role.
+// Other pool settings must be set again. Statements are prepared again on
+// their next use, including on the new slave. It is only supported on DBs
+// opened by Open.
+func (db *DB) AddSlave(dsn string) error {
+	return db.changeSlaves(func(t *topology, c *slavesChange) error {
+		return c.add(t, dsn)
+	})
+}
+
+// RemoveSlave removes the slave at index i from the physical dbs, shifting
+// the indexes of the following ones, which settings given by index, such
+// as drills and WithNode, refer to from then on. The slave is closed in the
+// background once the queries in flight on it are done, and statements are
+// prepared again on their next use.
+func (db *DB) RemoveSlave(i int) error {
+	return db.changeSlaves(func(t *topology, c *slavesChange) error {
+		if i < 1 || i >= len(t.pdbs) {
+			return fmt.Errorf("nap: no slave at index %d", i)
+		}
+		c.remove(t, i)
+		return nil
+	})
+}
+
+// SetSlaves reconciles the slaves with dsns, removing those opened by nap
+// with other DSNs and adding the missing ones, like RemoveSlave and AddSlave,
+// so that the slaves kept don't lose their state. Slaves not opened by nap
+// are kept.
+func (db *DB) SetSlaves(dsns []string) error {
+	return db.changeSlaves(func(t *topology, c *slavesChange) error {
+		wanted := map[string]bool{}
+		for _, dsn := range dsns {
+			wanted[dsn] = true
+		}
+
+		kept := map[string]bool{}
+		for i := 1; i < len(t.pdbs); i++ {
+			conn := t.connectors[i]
+			if conn == nil {
+				continue
+			}
+
+			if wanted[conn.dsn()] && !kept[conn.dsn()] {
+				kept[conn.dsn()] = true
+				continue
+			}
+
+			c.remove(t, i)
+			i--
+		}
+
+		for _, dsn := range dsns {
+			if kept[dsn] {
+				continue
+			}
+			kept[dsn] = true
+
+			if err := c.add(t, dsn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// slavesChange tracks the physical dbs added to and removed from a topology.
+type slavesChange struct {
+	db             *DB
+	added, removed []*sql.DB
+}
+
+// changeSlaves updates the topology with fn, closing the physical dbs it
+// removed in the background once done, or the ones it added if it failed.
+func (db *DB) changeSlaves(fn func(t *topology, c *slavesChange) error) error {
+	c := slavesChange{db: db}
+	err := db.updateTopology(func(t *topology) error {
+		return fn(t, &c)
+	})
+
+	closing := c.removed
+	if err != nil {
+		closing = c.added
+	}
+
+	for _, pdb := range closing {
+		go db.closeBulk(pdb)
+		go pdb.Close() // Waits for the queries in flight
+	}
+	return err
+}
+
+// remove removes the slave at index i from t.
+func (c *slavesChange) remove(t *topology, i int) {
+	c.removed = append(c.removed, t.pdbs[i])
+	t.remove(i)
+}
+
+// add opens a slave with dsn like the master of t and adds it to t.
+func (c *slavesChange) add(t *topology, dsn string) error {
+	if len(t.connectors) == 0 || t.connectors[0] == nil {
+		return errors.New("nap: slaves can only be added to a DB opened by Open")
+	}
+
+	master := t.connectors[0]
+	pdb, conn, err := openDriver(master.driver, dsn)
+	if err != nil {
+		return err
+	}
+	c.added = append(c.added, pdb)
+
+	for _, v := range []struct{ from, to *atomic.Value }{
+		{&master.reset, &conn.reset},
+		{&master.dialMax, &conn.dialMax},
+		{&master.gc, &conn.gc},
+	} {
+		if setting := v.from.Load(); setting != nil {
+			v.to.Store(setting)
+		}
+	}
+
+	tag, _ := c.db.appNames.Load().(appNameTag)
+	tag.apply(conn, len(t.pdbs))
+
+	pdb.SetMaxOpenConns(t.pdbs[0].Stats().MaxOpenConnections)
+	t.add(pdb, conn)
+	return nil
+}
+
+// SlaveResolver returns the DSNs of the slaves, for DB.SetSlaveResolver.
+type SlaveResolver func(ctx context.Context) ([]string, error)
+
+// DNSSlaves returns a SlaveResolver looking up the addresses of host, such
+// as the DNS name of cloud managed read replicas, and returning the DSNs
+// dsn formats for each of them.
+func DNSSlaves(host string, dsn func(addr string) string) SlaveResolver {
+	return func(ctx context.Context) ([]string, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(addrs)
+		dsns := make([]string, len(addrs))
+		for k, addr := range addrs {
+			dsns[k] = dsn(addr)
+		}
+		return dsns, nil
+	}
+}
+
+// SetSlaveResolver sets the SlaveResolver the slaves are reconciled with
+// every interval in the background, like SetSlaves, until the DB is closed.
+// The slaves are kept as is when r fails. If r is nil or interval <= 0,
+// slaves are no longer resolved, which is the default.
+func (db *DB) SetSlaveResolver(r SlaveResolver, interval time.Duration) {
+	if r == nil {
+		interval = 0
+	}
+
+	db.resolver.Store(r)
+	atomic.StoreInt64(&db.refresh, int64(interval))
+	db.startSlaveResolver()
+}
+
+func (db *DB) startSlaveResolver() {
+	if atomic.LoadInt64(&db.refresh) > 0 && atomic.CompareAndSwapInt32(&db.refreshing, 0, 1) {
+		go db.resolveSlaves()
+	}
+}
+
+// resolveSlaves reconciles the slaves until disabled or the DB is closed.
+func (db *DB) resolveSlaves() {
+	for {
+		every := time.Duration(atomic.LoadInt64(&db.refresh))
+		r, _ := db.resolver.Load().(SlaveResolver)
+		if every <= 0 || r == nil {
+			atomic.StoreInt32(&db.refreshing, 0)
+			db.startSlaveResolver() // Re-enabled concurrently
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), every)
+		if dsns, err := r(ctx); err == nil {
+			db.SetSlaves(dsns)
+		}
+		cancel()
+
+		select {
+		case <-db.done():
+			return
+		case <-time.After(every):
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func memoryDSN(name string) string {
+	return "file:" + name + "?mode=memory&cache=shared"
+}
+
+func TestAddRemoveSlave(t *testing.T) {
+	db, err := Open("sqlite3", memoryDSN("master")+";"+memoryDSN("slave1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(2)
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if err = db.AddSlave(memoryDSN("slave2")); err != nil {
+		t.Fatal(err)
+	}
+
+	added := db.topology().pdbs[2]
+	if n := len(db.topology().pdbs); n != 3 || added.Stats().MaxOpenConnections != 2 {
+		t.Fatalf("Slave not added like the master: %d physical dbs", n)
+	}
+
+	if err = stmt.QueryRowContext(WithNode(context.Background(), 2)).Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if set := stmt.load(); len(set.stmts) != 3 || set.stmts[2] == nil {
+		t.Error("Statement not prepared again on the added slave")
+	}
+
+	for _, i := range []int{0, 3} {
+		if err = db.RemoveSlave(i); err == nil {
+			t.Errorf("Slave %d removed", i)
+		}
+	}
+
+	removed := db.topology().pdbs[1]
+	if err = db.RemoveSlave(1); err != nil {
+		t.Fatal(err)
+	}
+	if pdbs := db.topology().pdbs; len(pdbs) != 2 || pdbs[1] != added {
+		t.Fatal("Slave not removed")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for removed.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Removed slave not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err = wrap([]*sql.DB{removed}, nil).AddSlave(memoryDSN("slave3")); err == nil {
+		t.Error("Slave added to a DB not opened by nap")
+	}
+}
+
+func TestSetSlaves(t *testing.T) {
+	db, err := Open("sqlite3", memoryDSN("master")+";"+memoryDSN("slave1")+";"+memoryDSN("slave2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetWeight(2, 3)
+	kept := db.topology().pdbs[2]
+	if err = db.SetSlaves([]string{memoryDSN("slave2"), memoryDSN("slave3"), memoryDSN("slave3")}); err != nil {
+		t.Fatal(err)
+	}
+
+	tp := db.topology()
+	if len(tp.pdbs) != 3 || tp.pdbs[1] != kept || tp.connectors[2].dsn() != memoryDSN("slave3") {
+		t.Fatalf("Slaves not reconciled: %d physical dbs", len(tp.pdbs))
+	}
+	if db.Weight(1) != 3 || len(tp.schedule) != 4 {
+		t.Errorf("Weights not kept: %v", tp.schedule)
+	}
+
+	db.SetSlaveResolver(func(context.Context) ([]string, error) {
+		return []string{memoryDSN("slave4")}, nil
+	}, time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for tp = db.topology(); len(tp.pdbs) != 2 || tp.connectors[1].dsn() != memoryDSN("slave4"); tp = db.topology() {
+		if time.Now().After(deadline) {
+			t.Fatal("Slaves not resolved")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	db.SetSlaveResolver(nil, 0)
+}
+
+func TestDNSSlaves(t *testing.T) {
+	dsns, err := DNSSlaves("localhost", func(addr string) string { return "host=" + addr })(context.Background())
+	if err != nil {
+		t.Skip(err)
+	}
+	if len(dsns) == 0 || dsns[0][:5] != "host=" {
+		t.Errorf("Unexpected DSNs: %v", dsns)
+	}
+}
+
+func TestChangeSlavesState(t *testing.T) {
+	db, err := Open("sqlite3", memoryDSN("master")+";"+memoryDSN("slave1")+";"+memoryDSN("slave2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetApplicationName("svc", func(name string) string { return "SELECT '" + name + "'" })
+	db.SetFlightRecorder(10)
+	db.SetUtilizationWindow(time.Minute)
+
+	read := func(i int) {
+		if err := db.QueryRowContext(WithNode(context.Background(), i), "SELECT 1").Scan(new(int)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read(2)
+	if err = db.RemoveSlave(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AddSlave(memoryDSN("slave3")); err != nil {
+		t.Fatal(err)
+	}
+	read(2)
+
+	if name := db.ApplicationName(2); name != "svc/slave" {
+		t.Errorf("Unexpected application name of the added slave: %s", name)
+	}
+
+	nodes := map[int]int{}
+	for _, rec := range db.FlightRecord() {
+		nodes[rec.Node]++
+	}
+	if nodes[1] != 1 || nodes[2] != 1 {
+		t.Errorf("Records not moved with the slaves: %v", nodes)
+	}
+
+	u := db.Utilization()
+	if len(u.Nodes) != 3 || u.Nodes[1].QPS == 0 || u.Nodes[2].QPS == 0 {
+		t.Errorf("Utilization not moved with the slaves: %+v", u.Nodes)
+	}
+}
@@ -0,0 +1,300 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type memoKey struct{}
+
+// WithMemo returns a copy of ctx carrying a result memo, so that repeated
+// identical reads with it, as issued by ORMs or GraphQL resolvers within a
+// single request, are served from the memo instead of hitting any physical
+// db. Reads are identical when they have the same query and args, which must
+// be nil, strings, blobs, booleans, numbers or times; reads with other args
+// always hit a physical db. Concurrent identical reads wait for the first one.
+//
+// The first read is fully buffered, so memos are meant for requests doing
+// small reads. Since they would otherwise serve stale results, memos are
+// reset by the writes done with them through DB.Exec or Stmt.Exec, and
+// must be reset with ResetMemo after writes done otherwise, such as in
+// transactions. Failed reads aren't memoized, and reads served from the
+// memo are reported to the close hook with no attempt.
+func WithMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, memoKey{}, &memo{entries: map[string]*memoEntry{}})
+}
+
+// ResetMemo forgets the results memoized in ctx, if it carries a memo.
+func ResetMemo(ctx context.Context) {
+	if m, _ := ctx.Value(memoKey{}).(*memo); m != nil {
+		m.mu.Lock()
+		m.entries = map[string]*memoEntry{}
+		m.mu.Unlock()
+	}
+}
+
+type memo struct {
+	mu      sync.Mutex
+	entries map[string]*memoEntry
+}
+
+// memoEntry is the buffered result of a read, ready once its read is done.
+type memoEntry struct {
+	ready   chan struct{}
+	at      time.Time // Start of the read
+	node    int
+	columns []string
+	rows    [][]interface{}
+	err     error
+}
+
+// memoOf returns the memo of ctx and the key of the read of query with
+// args in it, reporting false if ctx has no memo or args can't be keyed.
+func memoOf(ctx context.Context, query string, args []interface{}) (*memo, string, bool) {
+	m, _ := ctx.Value(memoKey{}).(*memo)
+	if m == nil {
+		return nil, "", false
+	}
+
+	var b strings.Builder
+	writeMemoValue(&b, 'q', query)
+	for _, arg := range args {
+		if !writeMemoArg(&b, arg) {
+			return nil, "", false
+		}
+	}
+	return m, b.String(), true
+}
+
+func writeMemoArg(b *strings.Builder, arg interface{}) bool {
+	switch v := arg.(type) {
+	case nil:
+		writeMemoValue(b, 'n', "")
+	case string:
+		writeMemoValue(b, 's', v)
+	case []byte:
+		writeMemoValue(b, 'b', string(v))
+	case bool:
+		writeMemoValue(b, 't', strconv.FormatBool(v))
+	case int:
+		writeMemoValue(b, 'i', strconv.FormatInt(int64(v), 10))
+	case int8:
+		writeMemoValue(b, 'i', strconv.FormatInt(int64(v), 10))
+	case int16:
+		writeMemoValue(b, 'i', strconv.FormatInt(int64(v), 10))
+	case int32:
+		writeMemoValue(b, 'i', strconv.FormatInt(int64(v), 10))
+	case int64:
+		writeMemoValue(b, 'i', strconv.FormatInt(v, 10))
+	case uint:
+		writeMemoValue(b, 'u', strconv.FormatUint(uint64(v), 10))
+	case uint8:
+		writeMemoValue(b, 'u', strconv.FormatUint(uint64(v), 10))
+	case uint16:
+		writeMemoValue(b, 'u', strconv.FormatUint(uint64(v), 10))
+	case uint32:
+		writeMemoValue(b, 'u', strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		writeMemoValue(b, 'u', strconv.FormatUint(v, 10))
+	case float32:
+		writeMemoValue(b, 'f', strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		writeMemoValue(b, 'f', strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Time:
+		writeMemoValue(b, 'd', v.Format(time.RFC3339Nano))
+	default:
+		return false
+	}
+	return true
+}
+
+// writeMemoValue writes s prefixed by its tag and length, so that keys of
+// different reads never collide.
+func writeMemoValue(b *strings.Builder, tag byte, s string) {
+	b.WriteByte(tag)
+	b.WriteString(strconv.Itoa(len(s)))
+	b.WriteByte(':')
+	b.WriteString(s)
+}
+
+// load returns the entry of key, buffering the rows returned by read
+// unless memoized yet, unless expired if expired isn't nil, or being read
+// concurrently.
+func (m *memo) load(ctx context.Context, key string, expired func(*memoEntry) bool, read func() (*Rows, error)) (*memoEntry, error) {
+	m.mu.Lock()
+	e, ok := m.entries[key]
+	if ok && expired != nil {
+		select {
+		case <-e.ready:
+			ok = !expired(e)
+		default:
+		}
+	}
+	if !ok {
+		e = &memoEntry{ready: make(chan struct{}), at: time.Now()}
+		m.entries[key] = e
+	}
+	m.mu.Unlock()
+
+	if ok {
+		select {
+		case <-e.ready:
+			return e, e.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	e.fill(read())
+	if e.err != nil {
+		m.mu.Lock()
+		if m.entries[key] == e {
+			delete(m.entries, key)
+		}
+		m.mu.Unlock()
+	}
+
+	close(e.ready)
+	return e, e.err
+}
+
+// fill buffers rows, closing them.
+func (e *memoEntry) fill(rows *Rows, err error) {
+	if e.err = err; err != nil {
+		return
+	}
+	defer rows.Close()
+
+	e.node = rows.Node()
+	if e.columns, e.err = rows.Columns(); e.err != nil {
+		return
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(e.columns))
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if e.err = rows.Scan(dest...); e.err != nil {
+			return
+		}
+		e.rows = append(e.rows, values)
+	}
+	e.err = rows.Err()
+}
+
+// SetMemoLagBound bounds the age of the results served from memos by the
+// replication lag measured with SetLagProbe on the physical db they were
+// read from, so that memos never serve results staler than it would. Once
+// enabled, results read from the master or from slaves whose lag is unknown
+// are read again rather than served from memos, except for concurrent
+// identical reads. The default is false.
+func (db *DB) SetMemoLagBound(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&db.memoBound, v)
+}
+
+// memoExpiry returns the function reporting whether memoized results
+// expired, or nil if they don't.
+func (db *DB) memoExpiry() func(*memoEntry) bool {
+	if atomic.LoadInt32(&db.memoBound) == 0 {
+		return nil
+	}
+	return db.memoExpired
+}
+
+// memoExpired reports whether e is older than the lag of its physical db.
+func (db *DB) memoExpired(e *memoEntry) bool {
+	if e.node == 0 {
+		return true
+	}
+
+	lag := db.Lag(e.node)
+	return lag < 0 || time.Since(e.at) > lag
+}
+
+// memoRows returns the rows of the memoized read e.
+func (db *DB) memoRows(ctx context.Context, e *memoEntry, info *QueryInfo) (*Rows, error) {
+	rows, err := memoDB.QueryContext(ctx, "", e)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Node = e.node
+	return db.newRows(ctx, rows, info, time.Now(), nil), nil
+}
+
+// memoRow returns the row of the memoized read e, which failed with err.
+func (db *DB) memoRow(ctx context.Context, e *memoEntry, err error, info *QueryInfo) *Row {
+	if err != nil {
+		info.Err = err
+		return db.newRow(ctx, errRow(db.Master(), err), info, time.Now(), nil)
+	}
+
+	info.Node = e.node
+	return db.newRow(ctx, memoDB.QueryRowContext(ctx, "", e), info, time.Now(), nil)
+}
+
+// memoDB serves memoized results through database/sql, so that they are
+// scanned exactly like the results of a physical db.
+var memoDB = sql.OpenDB(memoConnector{})
+
+type memoConnector struct{}
+
+func (memoConnector) Connect(context.Context) (driver.Conn, error) { return memoConn{}, nil }
+func (memoConnector) Driver() driver.Driver                        { return memoDriver{} }
+
+type memoDriver struct{}
+
+func (memoDriver) Open(string) (driver.Conn, error) { return memoConn{}, nil }
+
+var errMemoConn = errors.New("nap: memoized results only support queries")
+
+// memoConn returns the rows of the *memoEntry, or the driver.Rows, passed
+// as its only arg.
+type memoConn struct{}
+
+func (memoConn) Prepare(string) (driver.Stmt, error)      { return nil, errMemoConn }
+func (memoConn) Begin() (driver.Tx, error)                { return nil, errMemoConn }
+func (memoConn) Close() error                             { return nil }
+func (memoConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (memoConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if rows, ok := args[0].Value.(driver.Rows); ok {
+		return rows, nil
+	}
+	return &memoRows{entry: args[0].Value.(*memoEntry)}, nil
+}
+
+type memoRows struct {
+	entry *memoEntry
+	next  int
+}
+
+func (r *memoRows) Columns() []string { return r.entry.columns }
+func (r *memoRows) Close() error      { return nil }
+
+func (r *memoRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.entry.rows) {
+		return io.EOF
+	}
+
+	for i, v := range r.entry.rows[r.next] {
+		dest[i] = v
+	}
+	r.next++
+	return nil
+}
@@ -0,0 +1,231 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemo(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	for _, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE t (id INTEGER, name TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pdb.Exec("INSERT INTO t VALUES (1, 'a'), (2, 'b')"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var reads int
+	db.SetQueryHook(func(ctx context.Context, info QueryInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !info.Op.write() {
+			reads++
+		}
+	})
+
+	ctx := WithMemo(context.Background())
+	for i := 0; i < 3; i++ {
+		rows, err := db.QueryContext(ctx, "SELECT id, name FROM t WHERE id >= ? ORDER BY id", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names string
+		for rows.Next() {
+			var id int
+			var name string
+			if err = rows.Scan(&id, &name); err != nil {
+				t.Fatal(err)
+			}
+			names += name
+		}
+		rows.Close()
+
+		if names != "ab" || rows.Node() != 1 {
+			t.Errorf("Unexpected memoized rows. Got: %q on %d, Want: \"ab\" on 1", names, rows.Node())
+		}
+	}
+
+	var name string
+	if err = db.QueryRowContext(ctx, "SELECT name FROM t WHERE id = ?", int64(2)).Scan(&name); err != nil || name != "b" {
+		t.Fatalf("Unexpected memoized row: %q, %v", name, err)
+	}
+	if err = db.QueryRowContext(ctx, "SELECT name FROM t WHERE id = ?", 2).Scan(&name); err != nil || name != "b" {
+		t.Fatalf("Unexpected memoized row: %q, %v", name, err)
+	}
+
+	if reads != 2 {
+		t.Errorf("Unexpected reads hitting a physical db. Got: %d, Want: 2", reads)
+	}
+
+	if err = db.QueryRowContext(ctx, "SELECT name FROM t WHERE id = ?", "2").Scan(&name); err != nil || reads != 3 {
+		t.Errorf("Read with different args served from the memo: %d reads, %v", reads, err)
+	}
+
+	if _, err = db.ExecContext(ctx, "UPDATE t SET name = 'c' WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.QueryRowContext(ctx, "SELECT name FROM t WHERE id = ?", 2).Scan(&name); err != nil || reads != 4 {
+		t.Errorf("Memo not reset by a write: %d reads, %v", reads, err)
+	}
+
+	if err = db.QueryRowContext(ctx, "SELECT name FROM t WHERE id = ?", time.Now).Scan(&name); err == nil {
+		t.Error("Expected an error for unsupported args")
+	}
+	if err = db.QueryRowContext(ctx, "SELECT name FROM missing").Scan(&name); err == nil {
+		t.Error("Expected an error reading a missing table")
+	}
+	if err = db.QueryRowContext(ctx, "SELECT name FROM missing").Scan(&name); err == nil || reads != 7 {
+		t.Errorf("Failed read memoized: %d reads, %v", reads, err)
+	}
+
+	reads = 0
+	if err = db.QueryRow("SELECT name FROM t WHERE id = ?", 1).Scan(&name); err != nil || reads != 1 {
+		t.Errorf("Read without memo not served by a physical db: %d reads, %v", reads, err)
+	}
+}
+
+func TestMemoStmt(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT ? + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var reads int
+	db.SetQueryHook(func(ctx context.Context, info QueryInfo) { reads++ })
+
+	ctx := WithMemo(context.Background())
+	for i := 0; i < 2; i++ {
+		var n int
+		if err = stmt.QueryRowContext(ctx, 1).Scan(&n); err != nil || n != 2 {
+			t.Fatalf("Unexpected memoized row: %d, %v", n, err)
+		}
+
+		rows, err := stmt.QueryContext(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	if reads != 1 {
+		t.Errorf("Unexpected reads hitting a physical db. Got: %d, Want: 1", reads)
+	}
+
+	ResetMemo(ctx)
+	if err = stmt.QueryRowContext(ctx, 1).Scan(new(int)); err != nil || reads != 2 {
+		t.Errorf("Memo not reset: %d reads, %v", reads, err)
+	}
+}
+
+func TestMemoConcurrentReads(t *testing.T) {
+	m := &memo{entries: map[string]*memoEntry{}}
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reads int
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.load(context.Background(), "key", nil, func() (*Rows, error) {
+				mu.Lock()
+				reads++
+				mu.Unlock()
+				<-release
+				return nil, context.Canceled
+			})
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if reads != 1 {
+		t.Errorf("Concurrent identical reads not coalesced: %d reads", reads)
+	}
+	if len(m.entries) != 0 {
+		t.Error("Failed read memoized")
+	}
+}
+
+func TestMemoLagBound(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var reads int32
+	db.SetRouteHook(func(op Op, _ QueryID, _ int, _ time.Duration, _ error) {
+		atomic.AddInt32(&reads, 1)
+	})
+
+	ctx := WithMemo(context.Background())
+	read := func(ctx context.Context) {
+		if err := db.QueryRowContext(ctx, "SELECT 1").Scan(new(int)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.SetMemoLagBound(true)
+	read(ctx)
+	read(ctx)
+	if n := atomic.LoadInt32(&reads); n != 2 {
+		t.Errorf("Results of a slave of unknown lag served from the memo: %d reads", n)
+	}
+
+	lag := int64(time.Hour)
+	db.SetLagProbe(func(context.Context, *sql.DB) (time.Duration, error) {
+		return time.Duration(atomic.LoadInt64(&lag)), nil
+	}, time.Millisecond, 2*time.Hour)
+	for db.Lag(1) < 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ResetMemo(ctx)
+	atomic.StoreInt32(&reads, 0)
+	read(ctx)
+	read(ctx)
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("Results younger than the lag read again: %d reads", n)
+	}
+
+	atomic.StoreInt64(&lag, 0)
+	for db.Lag(1) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	read(ctx)
+	if n := atomic.LoadInt32(&reads); n != 2 {
+		t.Errorf("Results older than the lag served from the memo: %d reads", n)
+	}
+
+	atomic.StoreInt32(&reads, 0)
+	read(UseMaster(ctx))
+	read(UseMaster(ctx))
+	if n := atomic.LoadInt32(&reads); n != 2 {
+		t.Errorf("Results of the master served from the memo: %d reads", n)
+	}
+}
@@ -0,0 +1,154 @@
+package nap
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OtherLabel replaces the values of metric labels beyond their cardinality
+// limit.
+const OtherLabel = "other"
+
+// MetricLabels are the labels of the metrics of a routed operation.
+// Labels dropped by the MetricsOptions are empty.
+type MetricLabels struct {
+	Op          string // Name of the Op
+	Node        string // Index of the physical db, or its role unless ByNode
+	Fingerprint string // Hash of the normalized SQL, if ByFingerprint
+	Caller      string // Caller label set with WithCaller, if ByCaller
+	Tags        string // Comma separated key=value pairs of the ByTags tags
+}
+
+// MetricsSink receives the metrics of every routed operation, such as an
+// adapter to a metrics backend incrementing counters and histograms by
+// labels. It must not block.
+type MetricsSink interface {
+	Observe(labels MetricLabels, d time.Duration, err error)
+}
+
+// MetricsSinkFunc is an adapter to allow the use of ordinary functions
+// as a MetricsSink.
+type MetricsSinkFunc func(labels MetricLabels, d time.Duration, err error)
+
+// Observe calls f(labels, d, err).
+func (f MetricsSinkFunc) Observe(labels MetricLabels, d time.Duration, err error) {
+	f(labels, d, err)
+}
+
+// MetricsOptions control the cardinality of the labels of emitted metrics,
+// so that metrics can be used at very large scale without blowing up the
+// metrics backend. Once a limit is reached, further values of the label
+// are reported as OtherLabel.
+type MetricsOptions struct {
+	ByNode          bool // Label by physical db index rather than by role
+	ByFingerprint   bool // Label by fingerprint of the normalized SQL
+	ByCaller        bool // Label by caller label
+	MaxFingerprints int  // Maximum number of distinct fingerprints, 0 for no limit
+	MaxCallers      int  // Maximum number of distinct callers, 0 for no limit
+
+	ByTags       []string // Keys of the tags set with WithTag to label by
+	MaxTagValues int      // Maximum number of distinct combinations of tag values, 0 for no limit
+}
+
+// SetMetrics sets the sink the metrics of every routed operation are
+// emitted to, with labels controlled by opts. If sink is nil, no metrics
+// are emitted, which is the default.
+func (db *DB) SetMetrics(sink MetricsSink, opts MetricsOptions) {
+	if sink == nil {
+		db.metrics.Store((*metrics)(nil))
+		return
+	}
+
+	db.metrics.Store(&metrics{
+		sink:         sink,
+		opts:         opts,
+		fingerprints: labelSet{max: opts.MaxFingerprints},
+		callers:      labelSet{max: opts.MaxCallers},
+		tags:         labelSet{max: opts.MaxTagValues},
+	})
+}
+
+type metrics struct {
+	sink         MetricsSink
+	opts         MetricsOptions
+	fingerprints labelSet
+	callers      labelSet
+	tags         labelSet
+}
+
+// observe emits the metrics of the operation described by info.
+func (m *metrics) observe(ctx context.Context, info *QueryInfo, d time.Duration) {
+	labels := MetricLabels{Op: info.Op.String()}
+	if m.opts.ByNode {
+		labels.Node = strconv.Itoa(info.Node)
+	} else {
+		labels.Node = roleLabels(info.Node)["role"]
+	}
+
+	if m.opts.ByFingerprint {
+		labels.Fingerprint = m.fingerprints.bound(fingerprint(info.SQL))
+	}
+
+	if m.opts.ByCaller {
+		caller, _ := ctx.Value(callerKey{}).(string)
+		labels.Caller = m.callers.bound(caller)
+	}
+
+	if len(m.opts.ByTags) > 0 {
+		labels.Tags = m.tags.bound(metricTags(Tags(ctx), m.opts.ByTags))
+	}
+
+	m.sink.Observe(labels, d, info.Err)
+}
+
+// metricTags renders the tags of keys among tags, in the order of keys.
+func metricTags(tags []Tag, keys []string) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		v, _ := tagValue(tags, key)
+		parts[i] = key + "=" + v
+	}
+	return strings.Join(parts, ",")
+}
+
+// fingerprint returns the hash of query once normalized, so that queries
+// differing by their literals share a fingerprint.
+func fingerprint(query string) string {
+	h := fnv.New64a()
+	h.Write([]byte(normalizeSQL(query)))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// labelSet bounds the distinct values of a label.
+type labelSet struct {
+	max    int
+	seen   sync.Map // Values seen so far
+	values int64    // Number of values seen
+}
+
+// bound returns value, or OtherLabel if it would exceed the maximum number
+// of distinct values.
+func (s *labelSet) bound(value string) string {
+	if s.max <= 0 || value == "" {
+		return value
+	}
+
+	if _, ok := s.seen.Load(value); ok {
+		return value
+	}
+
+	if atomic.AddInt64(&s.values, 1) > int64(s.max) {
+		atomic.AddInt64(&s.values, -1)
+		return OtherLabel
+	}
+
+	if _, loaded := s.seen.LoadOrStore(value, true); loaded {
+		atomic.AddInt64(&s.values, -1)
+	}
+	return value
+}
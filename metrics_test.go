@@ -0,0 +1,70 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var got []MetricLabels
+	sink := MetricsSinkFunc(func(labels MetricLabels, d time.Duration, err error) {
+		got = append(got, labels)
+	})
+
+	db.SetMetrics(sink, MetricsOptions{})
+	db.Exec("SELECT 1")
+	db.QueryRow("SELECT 1").Scan(new(int))
+
+	want := []MetricLabels{{Op: "exec", Node: "master"}, {Op: "query_row", Node: "slave"}}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Unexpected labels of low cardinality metrics. Got: %v, Want: %v", got, want)
+	}
+
+	got = nil
+	db.SetMetrics(sink, MetricsOptions{ByNode: true, ByFingerprint: true, ByCaller: true, MaxFingerprints: 2, MaxCallers: 1})
+
+	ctx := WithCaller(context.Background(), "api")
+	for _, q := range []string{"SELECT 1", "SELECT 2", "SELECT 'a'", "SELECT 1 + 1", "SELECT 3 + 3"} {
+		db.QueryRowContext(ctx, q).Scan(new(int))
+	}
+	db.QueryRowContext(WithCaller(ctx, "cron"), "SELECT 1").Scan(new(int))
+
+	if len(got) != 6 {
+		t.Fatalf("Unexpected number of metrics: %d", len(got))
+	}
+
+	first, second := got[0].Fingerprint, got[3].Fingerprint
+	if first == "" || first == second || got[1].Fingerprint != first || got[2].Fingerprint != first {
+		t.Errorf("Queries differing by literals not fingerprinted the same: %v", got)
+	}
+	if got[4].Fingerprint != second {
+		t.Errorf("Unexpected fingerprint. Got: %s, Want: %s", got[4].Fingerprint, second)
+	}
+
+	if got[0].Node != "1" || got[0].Caller != "api" {
+		t.Errorf("Unexpected labels: %+v", got[0])
+	}
+	if got[5].Caller != OtherLabel || got[5].Fingerprint != first {
+		t.Errorf("Caller beyond the limit not reported as other: %+v", got[5])
+	}
+
+	got = nil
+	db.QueryRowContext(ctx, "SELECT * FROM t").Scan(new(int))
+	if got[0].Fingerprint != OtherLabel {
+		t.Errorf("Fingerprint beyond the limit not reported as other: %+v", got[0])
+	}
+
+	got = nil
+	db.SetMetrics(nil, MetricsOptions{})
+	db.Exec("SELECT 1")
+	if len(got) != 0 {
+		t.Error("Metrics emitted once disabled")
+	}
+}
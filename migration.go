@@ -0,0 +1,134 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Migration routes the reads and writes of a gradual migration between two
+// clusters, each a DB with its own topology. Writes go to the old DB, which
+// stays the source of truth, and are mirrored to the new one once enabled
+// with SetMirrorWrites. Reads go to the new DB with the probability set with
+// SetCutover, and to the old one otherwise. Migration is safe for
+// concurrent use.
+type Migration struct {
+	from, to *DB
+	cutover  uint64 // math.Float64bits of the fraction of reads to the new DB
+	mirror   int32  // Set while mirroring writes, accessed atomically
+	stats    struct {
+		reads, mirrored, errors uint64
+	}
+}
+
+// MigrationStats are the stats of a Migration.
+type MigrationStats struct {
+	Reads    uint64 // Reads routed to the new DB
+	Mirrored uint64 // Writes mirrored to the new DB
+	Errors   uint64 // Mirrored writes which failed on the new DB
+}
+
+// NewMigration returns a Migration from the old DB from to the new DB to,
+// routing everything to from until SetCutover and SetMirrorWrites.
+func NewMigration(from, to *DB) *Migration {
+	return &Migration{from: from, to: to}
+}
+
+// From returns the old DB.
+func (m *Migration) From() *DB {
+	return m.from
+}
+
+// To returns the new DB.
+func (m *Migration) To() *DB {
+	return m.to
+}
+
+// SetCutover sets the fraction of reads, between 0 and 1, routed to the
+// new DB. It can be raised gradually at runtime, and lowered back to roll
+// the migration back.
+func (m *Migration) SetCutover(fraction float64) {
+	atomic.StoreUint64(&m.cutover, math.Float64bits(fraction))
+}
+
+// Cutover returns the fraction of reads routed to the new DB.
+func (m *Migration) Cutover() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.cutover))
+}
+
+// SetMirrorWrites sets whether writes done on the old DB are mirrored to
+// the new DB, right after succeeding on the old one. Mirrored writes which
+// fail only count as errors in the stats, so the new DB must be kept in
+// sync by other means, such as replication, if they can't fail.
+func (m *Migration) SetMirrorWrites(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&m.mirror, v)
+}
+
+// Stats returns the stats of m.
+func (m *Migration) Stats() MigrationStats {
+	return MigrationStats{
+		Reads:    atomic.LoadUint64(&m.stats.reads),
+		Mirrored: atomic.LoadUint64(&m.stats.mirrored),
+		Errors:   atomic.LoadUint64(&m.stats.errors),
+	}
+}
+
+// reader returns the DB a read goes to.
+func (m *Migration) reader() *DB {
+	if f := m.Cutover(); f > 0 && rand.Float64() < f {
+		atomic.AddUint64(&m.stats.reads, 1)
+		return m.to
+	}
+	return m.from
+}
+
+// Exec executes a query without returning any rows on the old DB,
+// mirroring it to the new DB if enabled.
+func (m *Migration) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return m.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext executes a query without returning any rows on the old DB,
+// mirroring it to the new DB if enabled.
+func (m *Migration) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	res, err := m.from.ExecContext(ctx, query, args...)
+	if err != nil || atomic.LoadInt32(&m.mirror) == 0 {
+		return res, err
+	}
+
+	if _, err := m.to.ExecContext(ctx, query, args...); err != nil {
+		atomic.AddUint64(&m.stats.errors, 1)
+	} else {
+		atomic.AddUint64(&m.stats.mirrored, 1)
+	}
+	return res, nil
+}
+
+// Query executes a query that returns rows on the DB picked by the cutover.
+func (m *Migration) Query(query string, args ...interface{}) (*Rows, error) {
+	return m.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext executes a query that returns rows on the DB picked by the
+// cutover.
+func (m *Migration) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return m.reader().QueryContext(ctx, query, args...)
+}
+
+// QueryRow executes a query that is expected to return at most one row on
+// the DB picked by the cutover.
+func (m *Migration) QueryRow(query string, args ...interface{}) *Row {
+	return m.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext executes a query that is expected to return at most one
+// row on the DB picked by the cutover.
+func (m *Migration) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	return m.reader().QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,85 @@
+package nap
+
+import (
+	"testing"
+)
+
+func TestMigration(t *testing.T) {
+	from, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer from.Close()
+
+	to, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.Close()
+
+	from.SetMaxOpenConns(1)
+	to.SetMaxOpenConns(1)
+	for i, db := range []*DB{from, to} {
+		if _, err = db.Exec("CREATE TABLE node (i INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Exec("INSERT INTO node VALUES (?)", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewMigration(from, to)
+	reads := func() [2]int {
+		var counts [2]int
+		for k := 0; k < 100; k++ {
+			var i int
+			if err := m.QueryRow("SELECT i FROM node").Scan(&i); err != nil {
+				t.Fatal(err)
+			}
+			counts[i]++
+		}
+		return counts
+	}
+
+	if counts := reads(); counts[1] != 0 {
+		t.Errorf("Reads routed to the new DB before the cutover: %v", counts)
+	}
+
+	m.SetCutover(0.5)
+	if counts := reads(); counts[0] == 0 || counts[1] == 0 {
+		t.Errorf("Reads not split by the cutover: %v", counts)
+	}
+
+	m.SetCutover(1)
+	if counts := reads(); counts[0] != 0 {
+		t.Errorf("Reads routed to the old DB after the cutover: %v", counts)
+	}
+
+	if _, err = m.Exec("INSERT INTO node VALUES (2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetMirrorWrites(true)
+	if _, err = m.Exec("INSERT INTO node VALUES (3)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Exec("INSERT INTO missing VALUES (4)"); err == nil {
+		t.Error("Write failing on the old DB mirrored")
+	}
+
+	for db, want := range map[*DB]int{from: 3, to: 2} {
+		var n int
+		if err = db.QueryRow("SELECT COUNT(*) FROM node").Scan(&n); err != nil || n != want {
+			t.Errorf("Unexpected rows: %d, %v", n, err)
+		}
+	}
+
+	to.Exec("DROP TABLE node")
+	if _, err = m.Exec("INSERT INTO node VALUES (5)"); err != nil {
+		t.Errorf("Write failed by its mirror: %v", err)
+	}
+
+	if s := m.Stats(); s.Reads < 100 || s.Mirrored != 1 || s.Errors != 1 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,155 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Slaves of the loadtest tier receive mirrored reads.
+const loadtestTier = "loadtest"
+
+// maxMirrored bounds the number of mirrored reads in flight.
+const maxMirrored = 64
+
+// mirrorTimeout bounds the duration of a single mirrored read.
+const mirrorTimeout = time.Minute
+
+// MirrorStats compares the latencies of mirrored reads on their primary
+// physical db and on the mirror. Latencies are measured until the first
+// result is available.
+type MirrorStats struct {
+	Mirrored uint64        // Reads mirrored
+	Dropped  uint64        // Reads not mirrored as too many were in flight
+	Errors   uint64        // Mirrored reads which failed on the mirror
+	Primary  time.Duration // Total latency of the mirrored reads on their primary
+	Mirror   time.Duration // Total latency of the mirrored reads on the mirror
+}
+
+// MeanDelta returns the mean latency difference between the mirror and the
+// primary physical dbs. Positive values mean the mirror is slower.
+func (s MirrorStats) MeanDelta() time.Duration {
+	if s.Mirrored == 0 {
+		return 0
+	}
+	return (s.Mirror - s.Primary) / time.Duration(s.Mirrored)
+}
+
+type mirror struct {
+	fraction uint64 // math.Float64bits of the fraction of reads to mirror
+	inflight int32
+	stats    struct {
+		mirrored, dropped, errors uint64
+		primary, mirror           int64
+	}
+}
+
+// SetMirror duplicates the given fraction of reads, between 0 and 1, to
+// the slaves of the "loadtest" tier, which are excluded from balancing.
+// Mirrored reads run in the background and their results are discarded,
+// so capacity planning can be done with production query shapes.
+func (db *DB) SetMirror(fraction float64) {
+	atomic.StoreUint64(&db.mirror.fraction, math.Float64bits(fraction))
+}
+
+// MirrorStats returns the stats of mirrored reads.
+func (db *DB) MirrorStats() MirrorStats {
+	s := &db.mirror.stats
+	return MirrorStats{
+		Mirrored: atomic.LoadUint64(&s.mirrored),
+		Dropped:  atomic.LoadUint64(&s.dropped),
+		Errors:   atomic.LoadUint64(&s.errors),
+		Primary:  time.Duration(atomic.LoadInt64(&s.primary)),
+		Mirror:   time.Duration(atomic.LoadInt64(&s.mirror)),
+	}
+}
+
+// mirrorNode returns the index of a physical db a read should be mirrored
+// to, or -1 if it shouldn't be.
+func (db *DB) mirrorNode() int {
+	fraction := math.Float64frombits(atomic.LoadUint64(&db.mirror.fraction))
+	if fraction <= 0 || rand.Float64() >= fraction {
+		return -1
+	}
+
+	for i, n := 1, len(db.topology().pdbs); i < n; i++ {
+		if db.inRotation(i) && db.Tier(i) == loadtestTier {
+			return i
+		}
+	}
+	return -1
+}
+
+// mirrorQuery mirrors a read whose primary took primary to the mirror
+// physical db at index node, in the background, calling done, if not nil,
+// once the mirrored read is over or dropped.
+func (db *DB) mirrorQuery(node int, primary time.Duration, query func(context.Context) (*sql.Rows, error), done func()) {
+	m := &db.mirror
+	if atomic.AddInt32(&m.inflight, 1) > maxMirrored {
+		atomic.AddInt32(&m.inflight, -1)
+		atomic.AddUint64(&m.stats.dropped, 1)
+		if done != nil {
+			done()
+		}
+		return
+	}
+
+	go func() {
+		defer atomic.AddInt32(&m.inflight, -1)
+		if done != nil {
+			defer done()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+		defer cancel()
+
+		start := time.Now()
+		rows, err := query(ctx)
+		elapsed := time.Since(start)
+
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Close()
+		}
+
+		if err != nil {
+			atomic.AddUint64(&m.stats.errors, 1)
+			return
+		}
+
+		atomic.AddUint64(&m.stats.mirrored, 1)
+		atomic.AddInt64(&m.stats.primary, int64(primary))
+		atomic.AddInt64(&m.stats.mirror, int64(elapsed))
+	}()
+}
+
+// mirrorRead mirrors a read which started on its primary at start, if sampled.
+func (db *DB) mirrorRead(start time.Time, query string, args []interface{}) {
+	if m := db.mirrorNode(); m >= 0 {
+		args := mirrorArgs(args)
+		db.mirrorQuery(m, time.Since(start), func(ctx context.Context) (*sql.Rows, error) {
+			return db.topology().pdb(m).QueryContext(ctx, query, args...)
+		}, nil)
+	}
+}
+
+// mirrorRead mirrors a read which started on its primary at start, if sampled.
+// The mirrored read holds set, so that it isn't closed before the read is over.
+func (s *Stmt) mirrorRead(start time.Time, set *stmtSet, args []interface{}) {
+	if m := s.db.mirrorNode(); m >= 0 && m < len(set.stmts) && set.stmts[m] != nil && set.acquire() {
+		args, stmt := mirrorArgs(args), set.stmts[m]
+		s.db.mirrorQuery(m, time.Since(start), func(ctx context.Context) (*sql.Rows, error) {
+			return stmt.QueryContext(ctx, args...)
+		}, set.release)
+	}
+}
+
+// mirrorArgs copies args so mirrored reads can't observe changes made
+// by the caller after returning.
+func mirrorArgs(args []interface{}) []interface{} {
+	return append([]interface{}(nil), args...)
+}
@@ -0,0 +1,99 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetLabels(2, Labels{"tier": "loadtest"})
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(context.Background()); n != 1 {
+			t.Fatalf("Read balanced to %d instead of the regular slave", n)
+		}
+	}
+
+	db.SetMirror(1)
+	for i := 0; i < 5; i++ {
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	db.QueryRow("SELECT 1").Scan(new(int))
+
+	deadline := time.Now().Add(time.Second)
+	for db.MirrorStats().Mirrored < 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Reads not mirrored: %+v", db.MirrorStats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if stats := db.MirrorStats(); stats.Errors != 0 || stats.Mirror <= 0 || stats.Primary <= 0 {
+		t.Errorf("Unexpected mirror stats: %+v", stats)
+	}
+
+	db.SetMirror(0)
+	if n := db.mirrorNode(); n != -1 {
+		t.Errorf("Mirroring not disabled, got mirror %d", n)
+	}
+}
+
+func TestMirrorStmt(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetLabels(2, Labels{"tier": "loadtest"})
+	db.SetMirror(1)
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	// Statements retired right after their reads are mirrored nonetheless.
+	for i := 0; i < 10; i++ {
+		set, err := stmt.use(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt.mirrorRead(time.Now(), set, nil)
+		set.retire()
+		set.release()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s := db.MirrorStats(); s.Mirrored+s.Errors < 10; s = db.MirrorStats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Reads not mirrored: %+v", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s := db.MirrorStats(); s.Errors != 0 {
+		t.Errorf("Mirrored reads of retired statements failed: %+v", s)
+	}
+}
+
+func TestMirrorStatsMeanDelta(t *testing.T) {
+	s := MirrorStats{Mirrored: 2, Primary: 10 * time.Millisecond, Mirror: 30 * time.Millisecond}
+	if got := s.MeanDelta(); got != 10*time.Millisecond {
+		t.Errorf("Unexpected mean delta: %s", got)
+	}
+
+	if got := (MirrorStats{}).MeanDelta(); got != 0 {
+		t.Errorf("Unexpected mean delta without samples: %s", got)
+	}
+}
@@ -0,0 +1,91 @@
+package napcheck
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/iqoption/nap"
+)
+
+// lagProbes are the lag probes selectable with the -lag flag of Main.
+var lagProbes = map[string]nap.LagProbe{
+	"postgres": nap.PostgresLag,
+	"mysql":    nap.MySQLLag,
+}
+
+// Main runs the checks as a command with args, writing the report to w,
+// and returns the exit code: 0 if every check passed, 1 if some failed and
+// 2 if the topology couldn't be opened or args are invalid. Run with -h for
+// its flags. The driver named with -driver must be registered by the main
+// package.
+func Main(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("napcheck", flag.ContinueOnError)
+	fs.SetOutput(w)
+
+	var opts Options
+	driver := fs.String("driver", "", "name of the SQL driver")
+	dsns := fs.String("dsns", "", "semicolon separated DSNs, the master first")
+	lag := fs.String("lag", "", "lag probe of the slaves: postgres or mysql")
+	timeout := fs.Duration("timeout", time.Minute, "timeout of the checks")
+	fs.StringVar(&opts.Probe, "probe", "SELECT 1", "read-only query run on the nodes")
+	fs.IntVar(&opts.Reads, "reads", 50, "reads sampled by routing checks")
+	fs.DurationVar(&opts.MaxLag, "max-lag", 0, "max lag of the slaves reads may go to")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	probe, ok := lagProbes[*lag]
+	if *driver == "" || *dsns == "" || *lag != "" && !ok {
+		fs.Usage()
+		return 2
+	}
+
+	db, err := nap.Open(*driver, *dsns)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 2
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		fmt.Fprintln(w, err)
+		return 2
+	}
+
+	if probe != nil {
+		max := opts.MaxLag
+		if max <= 0 {
+			max = math.MaxInt64
+		}
+		db.SetLagProbe(probe, time.Second, max)
+		waitLags(ctx, db)
+	}
+
+	r := Run(ctx, db, opts)
+	r.WriteTo(w)
+	if !r.Passed() {
+		return 1
+	}
+	return 0
+}
+
+// waitLags waits for the lag of every slave to be probed, until ctx is done.
+func waitLags(ctx context.Context, db *nap.DB) {
+	for i := 1; i < nodes(db); i++ {
+		for db.Lag(i) < 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package napcheck
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMainExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		code int
+		out  string
+	}{
+		{[]string{"-driver", "sqlite3", "-dsns", ":memory:;:memory:"}, 1, "FAIL\tlag policy"},
+		{[]string{"-driver", "sqlite3", "-dsns", ":memory:;:memory:", "-reads", "5", "-probe", "SELECT 2"}, 1, "PASS\tfailover"},
+		{[]string{"-driver", "sqlite3"}, 2, "Usage"},
+		{[]string{"-driver", "sqlite3", "-dsns", ":memory:", "-lag", "oracle"}, 2, "Usage"},
+		{[]string{"-driver", "unknown", "-dsns", ":memory:"}, 2, "unknown driver"},
+	} {
+		var buf bytes.Buffer
+		if code := Main(tt.args, &buf); code != tt.code || !strings.Contains(buf.String(), tt.out) {
+			t.Errorf("Main(%q) = %d, %q. Want %d with %q", tt.args, code, buf.String(), tt.code, tt.out)
+		}
+	}
+}
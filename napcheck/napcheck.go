@@ -0,0 +1,306 @@
+// Package napcheck runs behavioral checks of a nap.DB against the topology
+// it was opened on, such as routing, failover handling and lag policy
+// enforcement, so that a production cluster and its nap configuration can
+// be certified before going live.
+//
+// The checks drill failures with DB.StartDrill, so they must be run on a
+// DB serving nothing else. They only run the read-only probe query.
+//
+// A napcheck command is a main package importing the SQL driver:
+//
+//	package main
+//
+//	import (
+//		"os"
+//
+//		_ "github.com/lib/pq"
+//		"github.com/iqoption/nap/napcheck"
+//	)
+//
+//	func main() {
+//		os.Exit(napcheck.Main(os.Args[1:], os.Stdout))
+//	}
+package napcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/iqoption/nap"
+)
+
+// Options configure the checks.
+type Options struct {
+	Probe  string        // Read-only query run on the nodes, "SELECT 1" if empty
+	Reads  int           // Reads sampled by routing checks, 50 if <= 0
+	MaxLag time.Duration // Max lag of the slaves reads may go to, as set with SetLagProbe, if > 0
+}
+
+func (o Options) probe() string {
+	if o.Probe == "" {
+		return "SELECT 1"
+	}
+	return o.Probe
+}
+
+func (o Options) reads() int {
+	if o.Reads <= 0 {
+		return 50
+	}
+	return o.Reads
+}
+
+// Check is a behavioral check, failing with an error describing the
+// misbehavior found.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context, db *nap.DB, opts Options) error
+}
+
+// Routing checks that reads directed to each healthy node with WithNode
+// go to it, that those directed with UseMaster go to the master, and that
+// balanced reads go to the slaves in rotation, if any.
+var Routing = Check{Name: "routing", Run: routing}
+
+// Failover checks that reads fall back to the master when all slaves are
+// down, avoid each slave while it is down, and keep being served by the
+// slaves while writes are refused with the master down.
+var Failover = Check{Name: "failover", Run: failover}
+
+// LagPolicy checks that the lag of every slave is probed, and that reads
+// don't go to slaves lagging more than Options.MaxLag.
+var LagPolicy = Check{Name: "lag policy", Run: lagPolicy}
+
+// Checks are the checks run by default.
+var Checks = []Check{Routing, Failover, LagPolicy}
+
+// Result is the outcome of a check.
+type Result struct {
+	Check   string
+	Err     error // Nil if the check passed
+	Elapsed time.Duration
+}
+
+// Report lists the results of checks in the order they ran.
+type Report struct {
+	Results []Result
+}
+
+// Passed reports whether every check passed.
+func (r *Report) Passed() bool {
+	for _, res := range r.Results {
+		if res.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes a line per result to w.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, res := range r.Results {
+		status := "PASS"
+		if res.Err != nil {
+			status = "FAIL"
+		}
+
+		line := fmt.Sprintf("%s\t%s\t%s\n", status, res.Check, res.Elapsed.Round(time.Microsecond))
+		if res.Err != nil {
+			line = fmt.Sprintf("%s\t%s\t%s\t%v\n", status, res.Check, res.Elapsed.Round(time.Microsecond), res.Err)
+		}
+
+		k, err := io.WriteString(w, line)
+		if n += int64(k); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Run runs checks on db in order, or Checks if none, and reports their
+// results. A drill in progress is restored after each check.
+func Run(ctx context.Context, db *nap.DB, opts Options, checks ...Check) *Report {
+	if len(checks) == 0 {
+		checks = Checks
+	}
+
+	r := &Report{Results: make([]Result, 0, len(checks))}
+	for _, c := range checks {
+		start := time.Now()
+		err := run(ctx, db, opts, c)
+		r.Results = append(r.Results, Result{Check: c.Name, Err: err, Elapsed: time.Since(start)})
+	}
+	return r
+}
+
+// run runs c, restoring the drill in progress, if any, once done.
+func run(ctx context.Context, db *nap.DB, opts Options, c Check) error {
+	if d, ok := db.ActiveDrill(); ok {
+		defer db.StartDrill(d)
+	} else {
+		defer db.StopDrill()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Run(ctx, db, opts)
+}
+
+func routing(ctx context.Context, db *nap.DB, opts Options) error {
+	n := nodes(db)
+	for i := 0; i < n; i++ {
+		if i > 0 && !db.Healthy(i) {
+			continue
+		}
+		if err := readOn(nap.WithNode(ctx, i), db, opts, i); err != nil {
+			return fmt.Errorf("read with WithNode(%d): %v", i, err)
+		}
+	}
+
+	if err := readOn(nap.UseMaster(ctx), db, opts, 0); err != nil {
+		return fmt.Errorf("read with UseMaster: %v", err)
+	}
+
+	counts, err := sample(ctx, db, opts, opts.reads())
+	if err != nil {
+		return err
+	}
+
+	rotation := inRotation(db, opts)
+	for i, count := range counts {
+		switch {
+		case i == 0 && len(rotation) > 0:
+			return fmt.Errorf("%d of %d reads went to the master with slaves %v in rotation", count, opts.reads(), rotation)
+		case i > 0 && !contains(rotation, i):
+			return fmt.Errorf("%d of %d reads went to slave %d out of rotation", count, opts.reads(), i)
+		}
+	}
+	return nil
+}
+
+func failover(ctx context.Context, db *nap.DB, opts Options) error {
+	n := nodes(db)
+	slaves := make([]int, 0, n)
+	for i := 1; i < n; i++ {
+		slaves = append(slaves, i)
+	}
+
+	db.StartDrill(nap.Drill{SlavesDown: slaves})
+	counts, err := sample(ctx, db, opts, opts.reads())
+	if err != nil {
+		return fmt.Errorf("with all slaves down: %v", err)
+	}
+	if counts[0] != opts.reads() {
+		return fmt.Errorf("with all slaves down, reads went to %v instead of the master", counts)
+	}
+
+	for _, i := range slaves {
+		db.StartDrill(nap.Drill{SlavesDown: []int{i}})
+		counts, err = sample(ctx, db, opts, opts.reads())
+		if err != nil {
+			return fmt.Errorf("with slave %d down: %v", i, err)
+		}
+		if counts[i] > 0 {
+			return fmt.Errorf("with slave %d down, %d of %d reads went to it", i, counts[i], opts.reads())
+		}
+	}
+
+	db.StartDrill(nap.Drill{MasterDown: true})
+	if _, err = db.ExecContext(ctx, opts.probe()); !errors.Is(err, nap.ErrDrill) {
+		return fmt.Errorf("with the master down, want writes refused with %v, got: %v", nap.ErrDrill, err)
+	}
+	if _, err = sample(ctx, db, opts, opts.reads()); err != nil {
+		return fmt.Errorf("with the master down: %v", err)
+	}
+	return nil
+}
+
+func lagPolicy(ctx context.Context, db *nap.DB, opts Options) error {
+	n := nodes(db)
+	for i := 1; i < n; i++ {
+		if db.Healthy(i) && db.Lag(i) < 0 {
+			return fmt.Errorf("lag of slave %d unknown, set a lag probe with SetLagProbe", i)
+		}
+	}
+
+	if opts.MaxLag <= 0 {
+		return nil
+	}
+
+	counts, err := sample(ctx, db, opts, opts.reads())
+	if err != nil {
+		return err
+	}
+	for i, count := range counts {
+		if lag := db.Lag(i); i > 0 && lag > opts.MaxLag {
+			return fmt.Errorf("%d of %d reads went to slave %d lagging %s, more than %s", count, opts.reads(), i, lag, opts.MaxLag)
+		}
+	}
+	return nil
+}
+
+// nodes returns the number of physical dbs of db.
+func nodes(db *nap.DB) int {
+	return len(db.Snapshot().Nodes)
+}
+
+// inRotation returns the indexes of the slaves reads are expected to be
+// balanced across.
+func inRotation(db *nap.DB, opts Options) []int {
+	var rotation []int
+	for i := 1; i < nodes(db); i++ {
+		lag := db.Lag(i)
+		if db.Healthy(i) && db.Delay(i) <= 0 && (opts.MaxLag <= 0 || lag >= 0 && lag <= opts.MaxLag) {
+			rotation = append(rotation, i)
+		}
+	}
+	return rotation
+}
+
+// readOn runs the probe query with ctx, failing unless it went to node.
+func readOn(ctx context.Context, db *nap.DB, opts Options, node int) error {
+	rows, err := db.QueryContext(ctx, opts.probe())
+	if err != nil {
+		return err
+	}
+
+	got := rows.Node()
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	if got != node {
+		return fmt.Errorf("went to node %d instead of %d", got, node)
+	}
+	return nil
+}
+
+// sample runs the probe query n times, counting the reads by node.
+func sample(ctx context.Context, db *nap.DB, opts Options, n int) (map[int]int, error) {
+	counts := map[int]int{}
+	for k := 0; k < n; k++ {
+		rows, err := db.QueryContext(ctx, opts.probe())
+		if err != nil {
+			return counts, fmt.Errorf("read %d: %v", k+1, err)
+		}
+
+		counts[rows.Node()]++
+		if err = rows.Close(); err != nil {
+			return counts, fmt.Errorf("read %d: %v", k+1, err)
+		}
+	}
+	return counts, nil
+}
+
+func contains(s []int, i int) bool {
+	for _, j := range s {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
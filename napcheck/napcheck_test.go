@@ -0,0 +1,94 @@
+package napcheck
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iqoption/nap"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRun(t *testing.T) {
+	db, err := nap.Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r := Run(context.Background(), db, Options{})
+	if len(r.Results) != len(Checks) {
+		t.Fatalf("Want %d results, got: %+v", len(Checks), r.Results)
+	}
+	for k, res := range r.Results {
+		if res.Check != Checks[k].Name {
+			t.Errorf("Want check %q, got: %q", Checks[k].Name, res.Check)
+		}
+	}
+
+	// The lag of slaves isn't probed.
+	if r.Passed() || r.Results[0].Err != nil || r.Results[1].Err != nil || r.Results[2].Err == nil {
+		t.Errorf("Unexpected results: %+v", r.Results)
+	}
+
+	db.SetLagProbe(func(context.Context, *sql.DB) (time.Duration, error) {
+		return time.Second, nil
+	}, time.Millisecond, time.Hour)
+	for db.Lag(1) < 0 || db.Lag(2) < 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if r = Run(context.Background(), db, Options{}); !r.Passed() {
+		t.Errorf("Unexpected results: %+v", r.Results)
+	}
+
+	// Slaves lagging more than the policy of the checks receive reads.
+	r = Run(context.Background(), db, Options{MaxLag: 100 * time.Millisecond}, LagPolicy)
+	if r.Passed() || !strings.Contains(r.Results[0].Err.Error(), "lagging 1s") {
+		t.Errorf("Unexpected results: %+v", r.Results)
+	}
+
+	var buf bytes.Buffer
+	if _, err = r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "FAIL\tlag policy\t") || strings.Count(out, "\n") != 1 {
+		t.Errorf("Unexpected report: %q", out)
+	}
+}
+
+func TestRunDrill(t *testing.T) {
+	db, err := nap.Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	drill := nap.Drill{SlavesDown: []int{1}}
+	db.StartDrill(drill)
+
+	// Reads going to the master with the slave drilled down are expected.
+	r := Run(context.Background(), db, Options{}, Routing, Failover)
+	if !r.Passed() {
+		t.Errorf("Unexpected results: %+v", r.Results)
+	}
+	if d, ok := db.ActiveDrill(); !ok || len(d.SlavesDown) != 1 {
+		t.Errorf("Drill in progress not restored: %+v", d)
+	}
+
+	db.StopDrill()
+	Run(context.Background(), db, Options{}, Failover)
+	if d, ok := db.ActiveDrill(); ok {
+		t.Errorf("Drill of the checks left in progress: %+v", d)
+	}
+
+	broken := Check{Name: "broken", Run: func(ctx context.Context, db *nap.DB, opts Options) error {
+		return readOn(nap.WithNode(ctx, 1), db, opts, 0)
+	}}
+	if r = Run(context.Background(), db, Options{}, broken); r.Passed() {
+		t.Errorf("Unexpected results: %+v", r.Results)
+	}
+}
@@ -0,0 +1,218 @@
+// Package naptest provides conformance test suites for implementations of
+// the extension points of nap, such as BalancerPolicy, Hooks and
+// SlaveResolver, verifying they satisfy the concurrency and ordering
+// guarantees nap relies on:
+//
+//	func TestPolicy(t *testing.T) {
+//		naptest.RunBalancerTests(t, &MyPolicy{})
+//	}
+package naptest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iqoption/nap"
+)
+
+// Concurrency is the number of goroutines the suites call implementations
+// from at once.
+var Concurrency = 8
+
+// Timeout bounds every call of the suites, since nap calls implementations
+// on the path of operations, which they must not block.
+var Timeout = time.Second
+
+// RunBalancerTests verifies that p picks a position among its candidates
+// without modifying them, and is safe for concurrent use.
+func RunBalancerTests(t *testing.T, p nap.BalancerPolicy) {
+	t.Run("Range", func(t *testing.T) {
+		for n := 1; n <= 5; n++ {
+			candidates := Candidates(n)
+			for k := 0; k < 100; k++ {
+				var pick int
+				within(t, fmt.Sprintf("Pick of %d candidates", n), func() { pick = p.Pick(candidates) })
+				if pick < 0 || pick >= n {
+					t.Fatalf("Pick of %d candidates returned %d", n, pick)
+				}
+			}
+		}
+	})
+
+	t.Run("Immutable", func(t *testing.T) {
+		candidates := Candidates(3)
+		want := append([]nap.Candidate(nil), candidates...)
+		for k := 0; k < 100; k++ {
+			p.Pick(candidates)
+		}
+		if !reflect.DeepEqual(candidates, want) {
+			t.Fatalf("Candidates modified by Pick: %+v", candidates)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		candidates := Candidates(3)
+		concurrently(t, "Pick", func() {
+			if pick := p.Pick(candidates); pick < 0 || pick >= len(candidates) {
+				t.Errorf("Pick of %d candidates returned %d", len(candidates), pick)
+			}
+		})
+	})
+}
+
+// Candidates returns n candidates with increasing indexes, weights and
+// scores, as nap passes them in index order.
+func Candidates(n int) []nap.Candidate {
+	candidates := make([]nap.Candidate, n)
+	for k := range candidates {
+		candidates[k] = nap.Candidate{Index: k + 1, Weight: k + 1, Score: float64(k+1) / float64(n)}
+	}
+	return candidates
+}
+
+type hookKey struct{}
+
+// RunHookTests verifies that the hooks set in h return without blocking,
+// are safe for concurrent use, and that BeforeQuery returns a context
+// derived from the one it is given, since AfterQuery and OnError are
+// called with it and cancellation must still reach the operation.
+func RunHookTests(t *testing.T, h nap.Hooks) {
+	ops := []nap.Op{nap.OpExec, nap.OpQuery, nap.OpQueryRow, nap.OpStmtExec, nap.OpStmtQuery, nap.OpStmtQueryRow}
+
+	t.Run("BeforeQuery", func(t *testing.T) {
+		if h.BeforeQuery == nil {
+			t.Skip("BeforeQuery not set")
+		}
+
+		for _, op := range ops {
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), hookKey{}, op))
+			var got context.Context
+			within(t, "BeforeQuery", func() { got = h.BeforeQuery(ctx, op, "SELECT 1") })
+			cancel()
+
+			switch {
+			case got == nil:
+				t.Fatalf("BeforeQuery of %s returned a nil context", op)
+			case got.Value(hookKey{}) != op:
+				t.Fatalf("BeforeQuery of %s returned a context not derived from its own", op)
+			case got.Err() == nil:
+				t.Fatalf("BeforeQuery of %s returned a context not canceled with its own", op)
+			}
+		}
+	})
+
+	t.Run("AfterQuery", func(t *testing.T) {
+		if h.AfterQuery == nil && h.OnError == nil {
+			t.Skip("AfterQuery and OnError not set")
+		}
+
+		for _, op := range ops {
+			for _, info := range []nap.QueryInfo{
+				{},
+				{Op: op, SQL: "SELECT 1", Node: 1, Attempt: 1, Duration: time.Millisecond},
+				{Op: op, SQL: "SELECT 1", Attempt: 2, Err: context.DeadlineExceeded, CorrelationID: "42", Caller: "test"},
+			} {
+				info := info
+				if h.AfterQuery != nil {
+					within(t, "AfterQuery", func() { h.AfterQuery(context.Background(), info) })
+				}
+				if h.OnError != nil && info.Err != nil {
+					within(t, "OnError", func() { h.OnError(context.Background(), info) })
+				}
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		info := nap.QueryInfo{Op: nap.OpQuery, SQL: "SELECT 1", Attempt: 1, Err: context.Canceled}
+		concurrently(t, "hooks", func() {
+			ctx := context.Background()
+			if h.BeforeQuery != nil {
+				ctx = h.BeforeQuery(ctx, info.Op, info.SQL)
+			}
+			if h.AfterQuery != nil {
+				h.AfterQuery(ctx, info)
+			}
+			if h.OnError != nil {
+				h.OnError(ctx, info)
+			}
+			if h.OnFreeze != nil {
+				h.OnFreeze(true)
+				h.OnFreeze(false)
+			}
+		})
+	})
+}
+
+// RunResolverTests verifies that r returns non empty DSNs, each once, and
+// gives up once its context is done, since nap resolves slaves with
+// a timeout in the background, and that it is safe for concurrent use.
+func RunResolverTests(t *testing.T, r nap.SlaveResolver) {
+	t.Run("DSNs", func(t *testing.T) {
+		var dsns []string
+		var err error
+		within(t, "resolution", func() { dsns, err = r(context.Background()) })
+		if err != nil {
+			t.Fatalf("Resolution failed: %v", err)
+		}
+
+		seen := map[string]bool{}
+		for _, dsn := range dsns {
+			if dsn == "" || seen[dsn] {
+				t.Fatalf("Resolved empty or duplicate DSNs: %q", dsns)
+			}
+			seen[dsn] = true
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		within(t, "resolution with a canceled context", func() { r(ctx) })
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		concurrently(t, "resolution", func() { r(context.Background()) })
+	})
+}
+
+// within fails t if fn doesn't return within Timeout.
+func within(t *testing.T, what string, fn func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(Timeout):
+		t.Fatalf("%s blocked for more than %s", what, Timeout)
+	}
+}
+
+// concurrently calls fn from Concurrency goroutines 100 times each, within
+// Timeout.
+func concurrently(t *testing.T, what string, fn func()) {
+	t.Helper()
+
+	within(t, "concurrent "+what, func() {
+		var wg sync.WaitGroup
+		for g := 0; g < Concurrency; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 100; k++ {
+					fn()
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,60 @@
+package naptest
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iqoption/nap"
+)
+
+func TestRunBalancerTests(t *testing.T) {
+	for name, p := range map[string]nap.BalancerPolicy{
+		"round-robin": &nap.RoundRobinPolicy{},
+		"random":      nap.RandomPolicy{},
+		"weighted":    nap.WeightedPolicy{},
+		"least-conns": nap.LeastConnsPolicy{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			RunBalancerTests(t, p)
+		})
+	}
+}
+
+func TestRunHookTests(t *testing.T) {
+	type spanKey struct{}
+	var after, errs, freezes int64
+	RunHookTests(t, nap.Hooks{
+		BeforeQuery: func(ctx context.Context, op nap.Op, query string) context.Context {
+			return context.WithValue(ctx, spanKey{}, query)
+		},
+		AfterQuery: func(ctx context.Context, info nap.QueryInfo) {
+			atomic.AddInt64(&after, 1)
+		},
+		OnError: func(ctx context.Context, info nap.QueryInfo) {
+			atomic.AddInt64(&errs, 1)
+		},
+		OnFreeze: func(frozen bool) {
+			atomic.AddInt64(&freezes, 1)
+		},
+	})
+
+	if after == 0 || errs == 0 || freezes == 0 {
+		t.Errorf("Hooks not called: %d, %d, %d", after, errs, freezes)
+	}
+
+	RunHookTests(t, nap.Hooks{})
+}
+
+func TestRunResolverTests(t *testing.T) {
+	RunResolverTests(t, func(ctx context.Context) ([]string, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return []string{"slave01", "slave02"}, nil
+	})
+
+	RunResolverTests(t, nap.DNSSlaves("localhost", func(addr string) string {
+		return "tcp://" + addr + "/db"
+	}))
+}
@@ -0,0 +1,145 @@
+package nap
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ReadPreference chooses among the physical dbs a read may go to.
+type ReadPreference uint8
+
+// Read preferences.
+const (
+	// PreferBalanced balances reads across the eligible physical dbs.
+	PreferBalanced ReadPreference = iota
+
+	// PreferNearest sends reads to the eligible physical db with the lowest
+	// connection round trip time, as measured by SetRTTProbeInterval, such
+	// as for apps deployed in multiple regions against one stretched cluster.
+	// Reads are balanced until round trip times are known.
+	PreferNearest
+)
+
+// rttAlpha is the smoothing factor of round trip times.
+const rttAlpha = 0.5
+
+type preferenceKey struct{}
+
+// WithReadPreference returns a copy of ctx whose reads are routed with p,
+// overriding the preference set with DB.SetReadPreference.
+func WithReadPreference(ctx context.Context, p ReadPreference) context.Context {
+	return context.WithValue(ctx, preferenceKey{}, p)
+}
+
+// SetReadPreference sets the default preference of reads.
+// The default is PreferBalanced.
+func (db *DB) SetReadPreference(p ReadPreference) {
+	atomic.StoreInt32(&db.preference, int32(p))
+}
+
+// SetRTTProbeInterval sets how often the connection round trip time of each
+// physical db is measured, by pinging it in the background, until the DB is
+// closed. If d <= 0, round trip times are no longer measured, which is
+// the default.
+func (db *DB) SetRTTProbeInterval(d time.Duration) {
+	atomic.StoreInt64(&db.rttEvery, int64(d))
+	db.startRTTProbe()
+}
+
+// RTT returns the smoothed connection round trip time of the physical db
+// at index i, or 0 if unknown.
+func (db *DB) RTT(i int) time.Duration {
+	return time.Duration(atomic.LoadInt64(&db.rtt(i).nanos))
+}
+
+// readPreference returns the preference of a read with ctx.
+func (db *DB) readPreference(ctx context.Context) ReadPreference {
+	if p, ok := ctx.Value(preferenceKey{}).(ReadPreference); ok {
+		return p
+	}
+	return ReadPreference(atomic.LoadInt32(&db.preference))
+}
+
+// nearest returns the node with the lowest known round trip time among
+// nodes, or false if none is known.
+func (db *DB) nearest(nodes []int) (int, bool) {
+	best, min := 0, time.Duration(0)
+	for _, i := range nodes {
+		if rtt := db.RTT(i); rtt > 0 && (min == 0 || rtt < min) {
+			best, min = i, rtt
+		}
+	}
+	return best, min > 0
+}
+
+// byRTT sorts nodes by ascending round trip time, unknown ones last.
+func (db *DB) byRTT(nodes []int) {
+	sort.SliceStable(nodes, func(a, b int) bool {
+		ra, rb := db.RTT(nodes[a]), db.RTT(nodes[b])
+		return ra > 0 && (rb == 0 || ra < rb)
+	})
+}
+
+func (db *DB) startRTTProbe() {
+	if atomic.LoadInt64(&db.rttEvery) > 0 && atomic.CompareAndSwapInt32(&db.rttProbing, 0, 1) {
+		go db.probeRTT()
+	}
+}
+
+// probeRTT measures round trip times until disabled or the DB is closed.
+func (db *DB) probeRTT() {
+	for {
+		every := time.Duration(atomic.LoadInt64(&db.rttEvery))
+		if every <= 0 {
+			atomic.StoreInt32(&db.rttProbing, 0)
+			db.startRTTProbe() // Re-enabled concurrently
+			return
+		}
+
+		db.measureRTT(every)
+
+		select {
+		case <-db.done():
+			return
+		case <-time.After(every):
+		}
+	}
+}
+
+// measureRTT pings every physical db concurrently, waiting at most timeout.
+func (db *DB) measureRTT(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t := db.topology()
+	scatter(len(t.pdbs), func(i int) error {
+		start := time.Now()
+		if err := t.pdbs[i].PingContext(ctx); err == nil {
+			t.rtts[i].observe(time.Since(start))
+		}
+		return nil
+	})
+}
+
+func (db *DB) rtt(i int) *rtt {
+	if t := db.topology(); i >= 0 && i < len(t.rtts) {
+		return t.rtts[i]
+	}
+	return &rtt{}
+}
+
+// rtt is the smoothed round trip time of a physical db.
+type rtt struct {
+	nanos int64
+}
+
+func (r *rtt) observe(d time.Duration) {
+	old := atomic.LoadInt64(&r.nanos)
+	if old == 0 {
+		atomic.StoreInt64(&r.nanos, int64(d))
+		return
+	}
+	atomic.StoreInt64(&r.nanos, int64(rttAlpha*float64(d)+(1-rttAlpha)*float64(old)))
+}
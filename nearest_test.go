@@ -0,0 +1,69 @@
+package nap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNearest(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithReadPreference(context.Background(), PreferNearest)
+	if i := db.readIndex(ctx); i == 0 {
+		t.Errorf("Read without known round trip times went to the master")
+	}
+
+	db.rtt(1).observe(30 * time.Millisecond)
+	db.rtt(2).observe(10 * time.Millisecond)
+	db.rtt(2).observe(20 * time.Millisecond)
+
+	if rtt := db.RTT(2); rtt != 15*time.Millisecond {
+		t.Errorf("Unexpected smoothed round trip time. Got: %s, Want: 15ms", rtt)
+	}
+
+	for n := 0; n < 3; n++ {
+		if i := db.readIndex(ctx); i != 2 {
+			t.Errorf("Read didn't go to the nearest slave. Got: %d, Want: 2", i)
+		}
+	}
+
+	if nodes := db.readNodes(ctx); !reflect.DeepEqual(nodes, []int{2, 1, 3}) {
+		t.Errorf("Unexpected read nodes. Got: %v, Want: [2 1 3]", nodes)
+	}
+
+	db.StartDrill(Drill{SlavesDown: []int{2}})
+	if i := db.readIndex(ctx); i != 1 {
+		t.Errorf("Read didn't go to the nearest healthy slave. Got: %d, Want: 1", i)
+	}
+	db.StopDrill()
+
+	db.SetReadPreference(PreferNearest)
+	if i := db.readIndex(context.Background()); i != 2 {
+		t.Errorf("Default read preference ignored. Got: %d, Want: 2", i)
+	}
+}
+
+func TestRTTProbe(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetRTTProbeInterval(5 * time.Millisecond)
+	defer db.SetRTTProbeInterval(0)
+
+	deadline := time.Now().Add(time.Second)
+	for db.RTT(0) == 0 || db.RTT(1) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Round trip times not measured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,64 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+)
+
+// SetNoRowsRecheck sets whether a QueryRow whose slave returned no rows is
+// checked again once on the master before Scan reports sql.ErrNoRows, so
+// that rows written right before aren't reported missing because of
+// replication lag. It applies to DB and Stmt QueryRow calls and is disabled
+// by default.
+func (db *DB) SetNoRowsRecheck(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&db.norows, v)
+}
+
+// rechecks reports whether a QueryRow with ctx which ran on node should be
+// checked again on the master when it returns no rows. Reads directed to
+// a node with WithNode aren't.
+func (db *DB) rechecks(ctx context.Context, node int) bool {
+	if _, ok := db.nodeIndex(ctx); ok {
+		return false
+	}
+	return node != 0 && atomic.LoadInt32(&db.norows) != 0
+}
+
+// recheckRow returns the function running query again on the master for
+// a QueryRow with ctx which ran on node, or nil if it shouldn't be.
+// The query is rewritten as routed to the master.
+func (db *DB) recheckRow(ctx context.Context, node int, query string, args []interface{}) func() *sql.Row {
+	if _, frozen := db.Frozen(); frozen || !db.rechecks(ctx, node) && !db.proxyRechecks(ctx) {
+		return nil
+	}
+
+	copied := mirrorArgs(args) // Keeps the args of callers from escaping
+	return func() *sql.Row {
+		q := db.rewrite(WithNode(ctx, 0), OpQueryRow, query)
+		return db.Master().QueryRowContext(ctx, q, copied...)
+	}
+}
+
+// recheckRow returns the function running the statement again on the master
+// for a QueryRow with ctx which ran on node, or nil if it shouldn't be.
+func (s *Stmt) recheckRow(ctx context.Context, node int, args []interface{}) func() *sql.Row {
+	if _, frozen := s.db.Frozen(); frozen || !s.db.rechecks(ctx, node) {
+		return nil
+	}
+
+	copied := mirrorArgs(args)
+	return func() *sql.Row {
+		set, err := s.use(ctx)
+		if err != nil {
+			return errRow(s.db.Master(), err)
+		}
+		defer set.release()
+
+		return set.stmts[0].QueryRowContext(ctx, copied...)
+	}
+}
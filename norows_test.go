@@ -0,0 +1,67 @@
+package nap
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestNoRowsRecheck(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	for _, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE t (id INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = db.Exec("INSERT INTO t VALUES (?)", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.Prepare("SELECT id FROM t WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var id int
+	if err = db.QueryRow("SELECT id FROM t WHERE id = ?", 1).Scan(&id); err != sql.ErrNoRows {
+		t.Fatalf("Expected no rows from the lagging slave, got: %v", err)
+	}
+
+	db.SetNoRowsRecheck(true)
+	queries := []func() *Row{
+		func() *Row { return db.QueryRow("SELECT id FROM t WHERE id = ?", 1) },
+		func() *Row { return stmt.QueryRow(1) },
+	}
+
+	for _, query := range queries {
+		row := query()
+		if err = row.Scan(&id); err != nil || id != 1 {
+			t.Errorf("Row not rechecked on the master: %d, %v", id, err)
+		}
+
+		if row.Node() != 0 {
+			t.Errorf("Unexpected node of rechecked row. Got: %d, Want: 0", row.Node())
+		}
+	}
+
+	if err = stmt.QueryRow(2).Scan(&id); err != sql.ErrNoRows {
+		t.Errorf("Unexpected error of missing row. Got: %v, Want: %v", err, sql.ErrNoRows)
+	}
+
+	// Rechecks are reads, which the write gates don't refuse.
+	db.StartDrill(Drill{MasterDown: true})
+	defer db.StopDrill()
+	db.SetMinHealthySlaves(2)
+	for _, query := range queries {
+		if err = query().Scan(&id); err != nil || id != 1 {
+			t.Errorf("Row not rechecked on the master while writes are refused: %d, %v", id, err)
+		}
+	}
+}
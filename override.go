@@ -0,0 +1,130 @@
+package nap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// ErrBlocked is returned for operations refused by a route override.
+var ErrBlocked = errors.New("nap: query blocked by override")
+
+// BlockedError describes an operation refused by a route override.
+// It matches ErrBlocked with errors.Is.
+type BlockedError struct {
+	Fingerprint string // Fingerprint of the refused query
+}
+
+// Error implements the error interface.
+func (e *BlockedError) Error() string {
+	return ErrBlocked.Error() + ": " + e.Fingerprint
+}
+
+// Is reports whether target is ErrBlocked.
+func (e *BlockedError) Is(target error) bool {
+	return target == ErrBlocked
+}
+
+// RouteOverride forces the routing of the queries sharing a fingerprint.
+type RouteOverride string
+
+const (
+	OverrideMaster RouteOverride = "master" // Reads go to the master, as with UseMaster
+	OverrideBlock  RouteOverride = "block"  // Operations fail with a *BlockedError
+)
+
+// SetRouteOverrides replaces the route overrides by query fingerprint, the
+// hash of the normalized SQL labeling metrics and StatementUse, such that
+// incidents caused by a specific query can be mitigated without a deploy.
+// Overrides apply to the operations of DB and Stmt, prepared ones
+// included, before any other routing, before reads are served from a memo
+// and before caller quotas are charged.
+// With no overrides, queries are routed as usual, which is the default.
+func (db *DB) SetRouteOverrides(overrides map[string]RouteOverride) error {
+	all := make(map[string]RouteOverride, len(overrides))
+	for fp, o := range overrides {
+		if o != OverrideMaster && o != OverrideBlock {
+			return fmt.Errorf("nap: unknown route override %q of %s", o, fp)
+		}
+		all[fp] = o
+	}
+	db.overrides.Store(all)
+	return nil
+}
+
+// RouteOverrides returns the route overrides by query fingerprint.
+func (db *DB) RouteOverrides() map[string]RouteOverride {
+	all, _ := db.overrides.Load().(map[string]RouteOverride)
+	overrides := make(map[string]RouteOverride, len(all))
+	for fp, o := range all {
+		overrides[fp] = o
+	}
+	return overrides
+}
+
+// LoadRouteOverrides replaces the route overrides by those of the JSON file
+// at path, an object of fingerprints to overrides, such as
+// {"9f2c6a1e0b4d7e53":"master","1d0e8f3a6c2b9475":"block"}.
+func (db *DB) LoadRouteOverrides(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var overrides map[string]RouteOverride
+	if err = json.Unmarshal(b, &overrides); err != nil {
+		return fmt.Errorf("nap: route overrides of %s: %w", path, err)
+	}
+	return db.SetRouteOverrides(overrides)
+}
+
+// RouteOverrideHandler returns an admin handler replacing the route
+// overrides by those of the JSON request body on PUT, in the format of
+// LoadRouteOverrides, clearing them on DELETE, and serving them as JSON.
+func (db *DB) RouteOverrideHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var overrides map[string]RouteOverride
+			err := json.NewDecoder(r.Body).Decode(&overrides)
+			if err == nil {
+				err = db.SetRouteOverrides(overrides)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			db.SetRouteOverrides(nil)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.RouteOverrides())
+	})
+}
+
+// override applies the route override of query, if any, to an operation
+// with ctx, returning the copy of ctx it goes on with.
+func (db *DB) override(ctx context.Context, query string) (context.Context, error) {
+	all, _ := db.overrides.Load().(map[string]RouteOverride)
+	if len(all) == 0 {
+		return ctx, nil
+	}
+
+	fp := fingerprint(query)
+	switch all[fp] {
+	case OverrideMaster:
+		return UseMaster(ctx), nil
+	case OverrideBlock:
+		return ctx, &BlockedError{Fingerprint: fp}
+	}
+	return ctx, nil
+}
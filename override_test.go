@@ -0,0 +1,205 @@
+package nap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouteOverrides(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT 2 AS blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if err = db.SetRouteOverrides(map[string]RouteOverride{fingerprint("SELECT 1"): "slave"}); err == nil {
+		t.Error("Want an error setting an unknown override")
+	}
+	err = db.SetRouteOverrides(map[string]RouteOverride{
+		fingerprint("SELECT 1"):            OverrideMaster,
+		fingerprint("SELECT 2 AS blocked"): OverrideBlock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queries differing by their literals share their override.
+	for _, query := range []string{"SELECT 1", "SELECT 7", "SELECT 3 AS n"} {
+		want := 0
+		if query == "SELECT 3 AS n" {
+			want = 1
+		}
+		if row := db.QueryRow(query); row.Scan(new(int)) != nil || row.Node() != want {
+			t.Errorf("Want %q read on %d, got: %d", query, want, row.Node())
+		}
+	}
+
+	rows, err := db.Query("SELECT 2 AS blocked")
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked, got: %v", err)
+	} else if e := err.(*BlockedError); e.Fingerprint != fingerprint("SELECT 2 AS blocked") {
+		t.Errorf("Unexpected fingerprint: %s", e.Fingerprint)
+	}
+	if err == nil {
+		rows.Close()
+	}
+	if _, err = db.Exec("SELECT 2 AS blocked"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of Exec, got: %v", err)
+	}
+	if err = db.QueryRow("SELECT 2 AS blocked").Scan(new(int)); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of QueryRow, got: %v", err)
+	}
+	if err = stmt.QueryRow().Scan(new(int)); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of a statement, got: %v", err)
+	}
+	if _, err = stmt.Exec(); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of a statement Exec, got: %v", err)
+	}
+
+	db.SetRouteOverrides(nil)
+	if err = stmt.QueryRow().Scan(new(int)); err != nil {
+		t.Errorf("Statement read failed once overrides are cleared: %v", err)
+	}
+}
+
+func TestRouteOverridesMemo(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT 2 AS blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	ctx := WithMemo(context.Background())
+	if err = db.QueryRowContext(ctx, "SELECT 2 AS blocked").Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if err = stmt.QueryRowContext(ctx).Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SetRouteOverrides(map[string]RouteOverride{
+		fingerprint("SELECT 1"):            OverrideMaster,
+		fingerprint("SELECT 2 AS blocked"): OverrideBlock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Memoized reads are blocked too.
+	if _, err = db.QueryContext(ctx, "SELECT 2 AS blocked"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of a memoized Query, got: %v", err)
+	}
+	if err = db.QueryRowContext(ctx, "SELECT 2 AS blocked").Scan(new(int)); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of a memoized QueryRow, got: %v", err)
+	}
+	if _, err = stmt.QueryContext(ctx); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of a memoized statement Query, got: %v", err)
+	}
+	if err = stmt.QueryRowContext(ctx).Scan(new(int)); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Want ErrBlocked of a memoized statement QueryRow, got: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if row := db.QueryRowContext(ctx, "SELECT 1"); row.Scan(new(int)) != nil || row.Node() != 0 {
+			t.Errorf("Memoized read not sent to the master: %d", row.Node())
+		}
+	}
+}
+
+func TestLoadRouteOverrides(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "nap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "overrides.json")
+	if err = ioutil.WriteFile(path, []byte(`{"a1":"master","b2":"block"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.LoadRouteOverrides(path); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]RouteOverride{"a1": OverrideMaster, "b2": OverrideBlock}; !reflect.DeepEqual(db.RouteOverrides(), want) {
+		t.Errorf("Unexpected overrides. Got: %v, Want: %v", db.RouteOverrides(), want)
+	}
+
+	if err = ioutil.WriteFile(path, []byte(`["a1"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.LoadRouteOverrides(path); err == nil {
+		t.Error("Want an error loading malformed overrides")
+	}
+	if err = db.LoadRouteOverrides(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Want an error loading a missing file")
+	}
+	if len(db.RouteOverrides()) != 2 {
+		t.Errorf("Overrides replaced by a failed load: %v", db.RouteOverrides())
+	}
+}
+
+func TestRouteOverrideHandler(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	serve := func(method, body string) (int, map[string]RouteOverride) {
+		rec := httptest.NewRecorder()
+		db.RouteOverrideHandler().ServeHTTP(rec, httptest.NewRequest(method, "/", strings.NewReader(body)))
+
+		var overrides map[string]RouteOverride
+		if rec.Code == 200 {
+			if err := json.NewDecoder(rec.Body).Decode(&overrides); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, overrides
+	}
+
+	if code, o := serve("GET", ""); code != 200 || len(o) != 0 {
+		t.Errorf("Unexpected overrides: %d, %v", code, o)
+	}
+	if code, o := serve("PUT", `{"a1":"block"}`); code != 200 || o["a1"] != OverrideBlock {
+		t.Errorf("Unexpected overrides once put: %d, %v", code, o)
+	}
+	if code, _ := serve("PUT", `{"a1":"nowhere"}`); code != 400 {
+		t.Errorf("Unexpected status of an unknown override: %d", code)
+	}
+	if o := db.RouteOverrides(); o["a1"] != OverrideBlock {
+		t.Errorf("Overrides replaced by a bad request: %v", o)
+	}
+	if code, o := serve("DELETE", ""); code != 200 || len(o) != 0 {
+		t.Errorf("Unexpected overrides once deleted: %d, %v", code, o)
+	}
+	if code, _ := serve("POST", ""); code != 405 {
+		t.Errorf("Unexpected status of POST: %d", code)
+	}
+}
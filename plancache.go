@@ -0,0 +1,198 @@
+package nap
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of the background EXPLAINs of the plan cache.
+const (
+	maxExplained   = 4
+	explainTimeout = 5 * time.Second
+)
+
+// CachedPlan is the plan of the queries sharing a fingerprint on
+// a physical db, kept by the plan cache.
+type CachedPlan struct {
+	Fingerprint string    `json:"fingerprint"`
+	Node        int       `json:"node"`
+	SQL         string    `json:"sql"`            // Normalized SQL of the last query explained
+	Plan        []string  `json:"plan,omitempty"` // Rows returned by EXPLAIN, with columns separated by tabs
+	Cost        float64   `json:"cost,omitempty"` // Total estimated cost of the first plan node, if reported
+	Error       string    `json:"error,omitempty"`
+	Explained   time.Time `json:"explained"`
+}
+
+// SetPlanCache enables a cache of the plans of the reads run on each
+// physical db, by fingerprint, so that engineers diagnosing a slow endpoint
+// can see the current plan on the exact replica serving it. Reads are
+// explained in the background once they succeed, with their args, and
+// explained again once their plan is older than refresh, least recently
+// used plans being evicted past maxBytes of SQL and plans. Plans are served
+// by CachedPlans and PlanCacheHandler. If maxBytes <= 0, the cache is
+// disabled and its plans dropped, which is the default.
+func (db *DB) SetPlanCache(maxBytes int, refresh time.Duration) {
+	if maxBytes <= 0 {
+		db.plans.Store((*planCache)(nil))
+		return
+	}
+	db.plans.Store(&planCache{max: maxBytes, refresh: refresh, lru: list.New(), entries: map[planKey]*list.Element{}})
+}
+
+// CachedPlans returns the plans kept by the plan cache, ordered by
+// fingerprint then node. It is empty unless enabled with SetPlanCache.
+func (db *DB) CachedPlans() []CachedPlan {
+	c, _ := db.plans.Load().(*planCache)
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	var plans []CachedPlan
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*planEntry); !e.explained.IsZero() {
+			plans = append(plans, e.plan)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(plans, func(i, j int) bool {
+		if plans[i].Fingerprint != plans[j].Fingerprint {
+			return plans[i].Fingerprint < plans[j].Fingerprint
+		}
+		return plans[i].Node < plans[j].Node
+	})
+	return plans
+}
+
+// PlanCacheHandler returns a debug handler serving the plans kept by the
+// plan cache as a JSON array, restricted to those of the fingerprint and
+// node query parameters, if set.
+func (db *DB) PlanCacheHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fp, node := r.URL.Query().Get("fingerprint"), r.URL.Query().Get("node")
+		plans := []CachedPlan{}
+		for _, p := range db.CachedPlans() {
+			if (fp == "" || p.Fingerprint == fp) && (node == "" || strconv.Itoa(p.Node) == node) {
+				plans = append(plans, p)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plans)
+	})
+}
+
+type planKey struct {
+	fingerprint string
+	node        int
+}
+
+type planEntry struct {
+	key        planKey
+	plan       CachedPlan
+	explained  time.Time
+	refreshing bool
+	size       int // Bytes of SQL and plan
+}
+
+type planCache struct {
+	mu       sync.Mutex
+	max      int
+	refresh  time.Duration
+	size     int
+	lru      *list.List // Of *planEntry, most recently used first
+	entries  map[planKey]*list.Element
+	inflight int32 // Background EXPLAINs, accessed atomically
+}
+
+// cachePlan explains query, run with args on the physical db at index i,
+// in the background, unless its plan is cached and fresh.
+func (db *DB) cachePlan(i int, query string, args []interface{}) {
+	c, _ := db.plans.Load().(*planCache)
+	if c == nil {
+		return
+	}
+
+	key := planKey{fingerprint(query), i}
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+		if e := el.Value.(*planEntry); e.refreshing || time.Since(e.explained) < c.refresh {
+			c.mu.Unlock()
+			return
+		}
+	}
+
+	if atomic.AddInt32(&c.inflight, 1) > maxExplained {
+		atomic.AddInt32(&c.inflight, -1)
+		c.mu.Unlock()
+		return
+	}
+
+	if !ok {
+		el = c.lru.PushFront(&planEntry{key: key})
+		c.entries[key] = el
+	}
+	el.Value.(*planEntry).refreshing = true
+	c.mu.Unlock()
+
+	go c.explain(el, db.topology().pdb(i), query, mirrorArgs(args))
+}
+
+// explain explains query with args on pdb, keeping its plan in the entry
+// of el.
+func (c *planCache) explain(el *list.Element, pdb *sql.DB, query string, args []interface{}) {
+	defer atomic.AddInt32(&c.inflight, -1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+
+	key := el.Value.(*planEntry).key
+	plan := CachedPlan{Fingerprint: key.fingerprint, Node: key.node, SQL: normalizeSQL(query)}
+	lines, err := explain(ctx, pdb, query, args)
+	plan.Plan, plan.Cost = lines, planCost(lines)
+	if err != nil {
+		plan.Error = err.Error()
+	}
+	c.store(el, plan)
+}
+
+// store keeps plan in the entry of el, unless evicted, evicting the least
+// recently used entries past the max size of c.
+func (c *planCache) store(el *list.Element, plan CachedPlan) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := el.Value.(*planEntry)
+	e.refreshing = false
+	if c.entries[e.key] != el {
+		return
+	}
+
+	size := len(plan.SQL) + len(plan.Error)
+	for _, line := range plan.Plan {
+		size += len(line)
+	}
+
+	plan.Explained = time.Now()
+	c.size += size - e.size
+	e.plan, e.explained, e.size = plan, plan.Explained, size
+
+	for c.size > c.max {
+		back := c.lru.Back()
+		old := back.Value.(*planEntry)
+		c.lru.Remove(back)
+		delete(c.entries, old.key)
+		c.size -= old.size
+	}
+}
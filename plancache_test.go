@@ -0,0 +1,103 @@
+package nap
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// cachedPlans waits for the plan cache of db to hold n plans.
+func cachedPlans(t *testing.T, db *DB, n int) []CachedPlan {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		if plans := db.CachedPlans(); len(plans) == n {
+			return plans
+		}
+	}
+	t.Fatalf("Want %d cached plans, got: %+v", n, db.CachedPlans())
+	return nil
+}
+
+func TestPlanCache(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.QueryRow("SELECT 1").Scan(new(int))
+	if plans := db.CachedPlans(); len(plans) != 0 {
+		t.Fatalf("Unexpected plans of a disabled cache: %+v", plans)
+	}
+
+	db.SetPlanCache(1<<20, time.Hour)
+	if err = db.QueryRow("SELECT ?", 1).Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+
+	plans := cachedPlans(t, db, 1)
+	if p := plans[0]; p.Fingerprint != fingerprint("SELECT 1") || p.Node != 1 || p.SQL != "SELECT ?" || len(p.Plan) == 0 || p.Error != "" || p.Explained.IsZero() {
+		t.Errorf("Unexpected cached plan: %+v", p)
+	}
+
+	// Fresh plans aren't explained again.
+	explained := plans[0].Explained
+	stmt, err := db.Prepare("SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if err = stmt.QueryRow().Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if plans = db.CachedPlans(); len(plans) != 1 || !plans[0].Explained.Equal(explained) {
+		t.Errorf("Fresh plan explained again: %+v", plans)
+	}
+
+	rows, err := db.Query("SELECT 'a' AS s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	cachedPlans(t, db, 2)
+
+	rec := httptest.NewRecorder()
+	db.PlanCacheHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/?fingerprint="+fingerprint("SELECT 1")+"&node=1", nil))
+	var served []CachedPlan
+	if err = json.NewDecoder(rec.Body).Decode(&served); err != nil || len(served) != 1 || served[0].SQL != "SELECT ?" {
+		t.Errorf("Unexpected served plans: %+v, %v", served, err)
+	}
+
+	// Plans are explained again once stale, and evicted past the max size.
+	size := func(p CachedPlan) int {
+		n := len(p.SQL)
+		for _, line := range p.Plan {
+			n += len(line)
+		}
+		return n
+	}
+	plans = db.CachedPlans()
+	db.SetPlanCache((size(plans[0])+size(plans[1]))*3/4, 0) // Room for either plan only
+	db.QueryRow("SELECT 1").Scan(new(int))
+	explained = cachedPlans(t, db, 1)[0].Explained
+	db.QueryRow("SELECT 1").Scan(new(int))
+	for start := time.Now(); !db.CachedPlans()[0].Explained.After(explained); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("Stale plan not explained again")
+		}
+	}
+
+	db.QueryRow("SELECT 'a' AS s").Scan(new(string))
+	time.Sleep(10 * time.Millisecond)
+	if plans = db.CachedPlans(); len(plans) != 1 || plans[0].SQL != "SELECT ? AS s" {
+		t.Errorf("Unexpected plans once evicted: %+v", plans)
+	}
+
+	db.SetPlanCache(0, 0)
+	if plans = db.CachedPlans(); len(plans) != 0 {
+		t.Errorf("Unexpected plans once disabled: %+v", plans)
+	}
+}
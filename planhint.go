@@ -0,0 +1,137 @@
+package nap
+
+import (
+	"context"
+	"strings"
+)
+
+type planHintsKey struct{}
+
+// WithPlanHints returns a copy of ctx whose non prepared queries carry the
+// execution plan hints, on top of those ctx already carries, injected by
+// the PlanHinter set with SetPlanHinter, such as "INDEX(t idx_created)" for
+// MySQL or "IndexScan(t idx_created)" for pg_hint_plan.
+func WithPlanHints(ctx context.Context, hints ...string) context.Context {
+	old := contextPlanHints(ctx)
+	return context.WithValue(ctx, planHintsKey{}, append(old[:len(old):len(old)], hints...))
+}
+
+func contextPlanHints(ctx context.Context) []string {
+	hints, _ := ctx.Value(planHintsKey{}).([]string)
+	return hints
+}
+
+// PlanHinter injects execution plan hints into a query, returning it
+// unchanged when it can't.
+type PlanHinter func(query string, hints []string) string
+
+// MySQLPlanHints is a PlanHinter adding an optimizer hint comment after the
+// leading keyword of SELECT, INSERT, REPLACE, UPDATE and DELETE statements,
+// past the comments preceding it, and merged into the hint comment following
+// it already, such as the one added by MySQLTimeoutHint, since MySQL only
+// reads the first.
+func MySQLPlanHints(query string, hints []string) string {
+	start := skipComments(query)
+	trimmed := query[start:]
+	for _, keyword := range []string{"SELECT", "INSERT", "REPLACE", "UPDATE", "DELETE"} {
+		if len(trimmed) < len(keyword) || !strings.EqualFold(trimmed[:len(keyword)], keyword) {
+			continue
+		}
+
+		head, rest := query[:start+len(keyword)], trimmed[len(keyword):]
+		if hinted := strings.TrimLeft(rest, " \t\r\n"); strings.HasPrefix(hinted, "/*+") {
+			return head + " /*+ " + joinHints(hints) + hinted[3:]
+		}
+		return head + " /*+ " + joinHints(hints) + " */" + rest
+	}
+	return query
+}
+
+// skipComments returns the offset of query past its leading spaces and
+// comments, other than hint comments.
+func skipComments(query string) int {
+	i := 0
+	for {
+		for i < len(query) && isSpace(query[i]) {
+			i++
+		}
+		if !strings.HasPrefix(query[i:], "/*") || strings.HasPrefix(query[i:], "/*+") {
+			return i
+		}
+
+		end := strings.Index(query[i+2:], "*/")
+		if end < 0 {
+			return i
+		}
+		i += 2 + end + 2
+	}
+}
+
+// PgHintPlan is a PlanHinter prepending the hint comment read by the
+// pg_hint_plan extension of Postgres, which must be the first comment of
+// the query, before correlation IDs and tags.
+func PgHintPlan(query string, hints []string) string {
+	return "/*+ " + joinHints(hints) + " */ " + query
+}
+
+// joinHints joins hints, stripping what would end their comment.
+func joinHints(hints []string) string {
+	return stripComment(strings.Join(hints, " "))
+}
+
+// SetPlanHinter sets the PlanHinter injecting plan hints into the queries
+// carrying them, uniformly on every physical db. If h is nil, hints aren't
+// injected, which is the default.
+func (db *DB) SetPlanHinter(h PlanHinter) {
+	db.planHint.Store(h)
+}
+
+// SetPlanHints sets the plan hints of query and of the queries sharing its
+// fingerprint, differing from it by their literals only, prepared ones
+// included, as if their context carried them, so that plan stability
+// workarounds don't require editing their call sites. Statements already
+// prepared are hinted once prepared again, such as after
+// InvalidateStatements. With no hints, query is no longer hinted.
+func (db *DB) SetPlanHints(query string, hints ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, _ := db.planHints.Load().(map[string][]string)
+	all := make(map[string][]string, len(old)+1)
+	for k, v := range old {
+		all[k] = v
+	}
+
+	if key := fingerprint(query); len(hints) > 0 {
+		all[key] = append([]string(nil), hints...)
+	} else {
+		delete(all, key)
+	}
+	db.planHints.Store(all)
+}
+
+// planHinted injects the plan hints of query, set with SetPlanHints, and
+// those carried by ctx, if any.
+func (db *DB) planHinted(ctx context.Context, query string) string {
+	h, _ := db.planHint.Load().(PlanHinter)
+	if h == nil {
+		return query
+	}
+
+	hints := contextPlanHints(ctx)
+	if all, _ := db.planHints.Load().(map[string][]string); len(all) > 0 {
+		if set := all[fingerprint(query)]; len(set) > 0 {
+			hints = append(set[:len(set):len(set)], hints...)
+		}
+	}
+
+	if len(hints) == 0 {
+		return query
+	}
+	return h(query, hints)
+}
+
+// preparedSQL returns the SQL query is prepared with.
+func (db *DB) preparedSQL(query string) string {
+	return db.planHinted(context.Background(), query)
+}
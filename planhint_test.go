@@ -0,0 +1,87 @@
+package nap
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMySQLPlanHints(t *testing.T) {
+	hints := []string{"INDEX(t idx)", "NO_ICP(t) */"}
+	for query, want := range map[string]string{
+		"select * FROM t":                              "select /*+ INDEX(t idx) NO_ICP(t)  */ * FROM t",
+		" /* cid=1 */ UPDATE t SET a = 1":              " /* cid=1 */ UPDATE /*+ INDEX(t idx) NO_ICP(t)  */ t SET a = 1",
+		"SELECT /*+ MAX_EXECUTION_TIME(1) */ * FROM t": "SELECT /*+ INDEX(t idx) NO_ICP(t)  MAX_EXECUTION_TIME(1) */ * FROM t",
+		"SHOW TABLES":                                  "SHOW TABLES",
+		"/* unterminated SELECT":                       "/* unterminated SELECT",
+	} {
+		if got := MySQLPlanHints(query, hints); got != want {
+			t.Errorf("Unexpected hinted query. Got: %q, Want: %q", got, want)
+		}
+	}
+}
+
+func TestPlanHints(t *testing.T) {
+	db := &DB{}
+	ctx := WithPlanHints(context.Background(), "SeqScan(t)")
+	if got := db.rewrite(ctx, OpQuery, "SELECT 1"); got != "SELECT 1" {
+		t.Errorf("Query hinted without a PlanHinter: %q", got)
+	}
+
+	db.SetPlanHinter(PgHintPlan)
+	db.SetCorrelationComments(true)
+	db.SetPlanHints("SELECT * FROM t WHERE id = 1", "IndexScan(t)")
+
+	ctx = WithCorrelationID(ctx, "42")
+	for query, want := range map[string]string{
+		"SELECT * FROM t WHERE id = 2": "/*+ IndexScan(t) SeqScan(t) */ /* cid=42 */ SELECT * FROM t WHERE id = 2",
+		"SELECT * FROM u":              "/*+ SeqScan(t) */ /* cid=42 */ SELECT * FROM u",
+	} {
+		if got := db.rewrite(ctx, OpQuery, query); got != want {
+			t.Errorf("Unexpected hinted query. Got: %q, Want: %q", got, want)
+		}
+	}
+
+	db.SetPlanHints("SELECT * FROM t WHERE id = 1")
+	if got := db.rewrite(context.Background(), OpQuery, "SELECT * FROM t WHERE id = 2"); got != "SELECT * FROM t WHERE id = 2" {
+		t.Errorf("Query hinted after its hints were removed: %q", got)
+	}
+}
+
+func TestPlanHintsPrepared(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Hints replace the selected value, telling which ones were injected.
+	db.SetPlanHinter(func(query string, hints []string) string {
+		return "SELECT " + hints[len(hints)-1]
+	})
+	db.SetPlanHints("SELECT 1", "2")
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	for _, tt := range []struct {
+		row  *Row
+		want int
+	}{
+		{stmt.QueryRow(), 2},
+		{db.QueryRow("SELECT 6"), 2}, // Same fingerprint
+		{db.QueryRowContext(WithPlanHints(context.Background(), "3"), "SELECT 1"), 3},
+		{db.QueryRowContext(WithPlanHints(context.Background(), "4"), "SELECT 5 AS n"), 4},
+		{db.QueryRow("SELECT 5 AS n"), 5},
+	} {
+		var n int
+		if err = tt.row.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.want {
+			t.Errorf("Want %d, got: %d", tt.want, n)
+		}
+	}
+}
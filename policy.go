@@ -0,0 +1,205 @@
+package nap
+
+import (
+	"database/sql"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Candidate is a slave a BalancerPolicy may pick.
+type Candidate struct {
+	Index  int     // Index of the physical db
+	Weight int     // Weight set with DB.SetWeight
+	Score  float64 // Score with the ScoreFunc set with DB.SetScoring, 0 if none
+	pdb    *sql.DB
+}
+
+// Stats returns the connection pool stats of the physical db, zero for
+// candidates built outside of nap, such as in tests.
+func (c Candidate) Stats() sql.DBStats {
+	if c.pdb == nil {
+		return sql.DBStats{}
+	}
+	return c.pdb.Stats()
+}
+
+// BalancerPolicy picks the slave a read without a selector goes to.
+// Implementations must be safe for concurrent use.
+type BalancerPolicy interface {
+	// Pick returns the position among candidates of the slave picked.
+	// Candidates are the slaves in rotation eligible to balancing, in index
+	// order, and are never empty.
+	Pick(candidates []Candidate) int
+}
+
+// OpenWithPolicy is like Open, balancing reads with p.
+func OpenWithPolicy(driverName, dataSourceNames string, p BalancerPolicy) (*DB, error) {
+	db, err := Open(driverName, dataSourceNames)
+	if err != nil {
+		return nil, err
+	}
+	db.SetBalancerPolicy(p)
+	return db, nil
+}
+
+// SetBalancerPolicy sets the policy picking the slave reads without
+// a selector go to, for DB and Stmt reads alike, overriding the smooth
+// weighted round-robin of weights. Reads go to the master when no slave
+// is a candidate. If p is nil, reads are balanced by round-robin, which is
+// the default, or by weights once set. Setting a policy rolls back the
+// rollout in progress, if any.
+func (db *DB) SetBalancerPolicy(p BalancerPolicy) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.abortRollout()
+	db.balancer.Store(balancerPolicy{p})
+}
+
+// balancerPolicy wraps BalancerPolicies, so that differently typed policies
+// can be stored in the same atomic.Value.
+type balancerPolicy struct {
+	BalancerPolicy
+}
+
+// picked returns the index of the slave picked by the BalancerPolicy, if set.
+func (db *DB) picked() (int, bool) {
+	p, _ := db.balancer.Load().(balancerPolicy)
+	t := db.topology()
+	weights := t.weights
+	if ro := db.activeRollout(); ro != nil {
+		if rp, rw, ok := ro.balancing(); ok && len(rw) == len(weights) {
+			p.BalancerPolicy, weights = rp, rw
+		}
+	}
+
+	if p.BalancerPolicy == nil {
+		return 0, false
+	}
+
+	candidates := db.candidates(t, weights)
+	if len(candidates) == 0 {
+		return 0, true
+	}
+	return pick(p, candidates), true
+}
+
+// candidates returns the candidates of t balanced with weights.
+func (db *DB) candidates(t *topology, weights []int) []Candidate {
+	fn, _ := db.scoring.Load().(ScoreFunc)
+	candidates := make([]Candidate, 0, len(t.pdbs))
+	for i := 1; i < len(t.pdbs); i++ {
+		if db.balanced(i) {
+			candidates = append(candidates, Candidate{Index: i, Weight: weights[i], Score: db.scoreOf(fn, i), pdb: t.pdbs[i]})
+		}
+	}
+	return candidates
+}
+
+// pick returns the index of the slave p picks among candidates, which
+// aren't empty, the first if p picks none of them.
+func pick(p BalancerPolicy, candidates []Candidate) int {
+	if k := p.Pick(candidates); k >= 0 && k < len(candidates) {
+		return candidates[k].Index
+	}
+	return candidates[0].Index
+}
+
+// policyName returns the name of the BalancerPolicy, if set.
+func (db *DB) policyName() string {
+	p, _ := db.balancer.Load().(balancerPolicy)
+	return nameOf(p.BalancerPolicy)
+}
+
+// nameOf returns the name of p, if not nil.
+func nameOf(p BalancerPolicy) string {
+	if s, ok := p.(interface{ String() string }); ok {
+		return s.String()
+	}
+	if p != nil {
+		return "custom"
+	}
+	return ""
+}
+
+// RoundRobinPolicy is a BalancerPolicy picking candidates in turn.
+type RoundRobinPolicy struct {
+	count uint64
+}
+
+// Pick implements the BalancerPolicy interface.
+func (p *RoundRobinPolicy) Pick(candidates []Candidate) int {
+	return int(atomic.AddUint64(&p.count, 1) % uint64(len(candidates)))
+}
+
+func (p *RoundRobinPolicy) String() string {
+	return "round-robin"
+}
+
+// RandomPolicy is a BalancerPolicy picking candidates uniformly at random.
+type RandomPolicy struct{}
+
+// Pick implements the BalancerPolicy interface.
+func (RandomPolicy) Pick(candidates []Candidate) int {
+	return rand.Intn(len(candidates))
+}
+
+func (RandomPolicy) String() string {
+	return "random"
+}
+
+// WeightedPolicy is a BalancerPolicy picking candidates at random with
+// a probability proportional to their weight, such as for replicas of
+// different sizes. Candidates with a zero weight are only picked when all
+// weights are zero.
+type WeightedPolicy struct{}
+
+// Pick implements the BalancerPolicy interface.
+func (WeightedPolicy) Pick(candidates []Candidate) int {
+	total := 0
+	for _, c := range candidates {
+		total += c.Weight
+	}
+
+	if total <= 0 {
+		return rand.Intn(len(candidates))
+	}
+
+	n := rand.Intn(total)
+	for k, c := range candidates {
+		if n -= c.Weight; n < 0 {
+			return k
+		}
+	}
+	return len(candidates) - 1
+}
+
+func (WeightedPolicy) String() string {
+	return "weighted-random"
+}
+
+// LeastConnsPolicy is a BalancerPolicy picking the candidate with the
+// fewest connections in use, as reported by its sql.DBStats, breaking
+// ties at random.
+type LeastConnsPolicy struct{}
+
+// Pick implements the BalancerPolicy interface.
+func (LeastConnsPolicy) Pick(candidates []Candidate) int {
+	best, ties := 0, 0
+	least := -1
+	for k, c := range candidates {
+		switch n := c.Stats().InUse; {
+		case least < 0 || n < least:
+			best, least, ties = k, n, 1
+		case n == least:
+			if ties++; rand.Intn(ties) == 0 {
+				best = k
+			}
+		}
+	}
+	return best
+}
+
+func (LeastConnsPolicy) String() string {
+	return "least-conns"
+}
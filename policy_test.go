@@ -0,0 +1,106 @@
+package nap
+
+import (
+	"context"
+	"testing"
+)
+
+// fixedPolicy picks the candidate of index, or the first one.
+type fixedPolicy struct {
+	index int
+}
+
+func (p fixedPolicy) Pick(candidates []Candidate) int {
+	for k, c := range candidates {
+		if c.Index == p.index {
+			return k
+		}
+	}
+	return 0
+}
+
+func TestBalancerPolicy(t *testing.T) {
+	db, err := OpenWithPolicy("sqlite3", ":memory:;:memory:;:memory:;:memory:", fixedPolicy{index: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < 5; i++ {
+		if db.Slave() != db.topology().pdbs[3] || stmt.Slave() != stmt.load().stmts[3] {
+			t.Fatal("Slave not picked by the policy")
+		}
+	}
+
+	if p := db.BalancerState().Policy; p != "custom" {
+		t.Errorf("Unexpected policy: %s", p)
+	}
+
+	db.StartDrill(Drill{SlavesDown: []int{3}})
+	if i := db.readIndex(context.Background()); i != 1 {
+		t.Errorf("Read routed to %d instead of the first candidate", i)
+	}
+
+	db.StartDrill(Drill{SlavesDown: []int{1, 2, 3}})
+	if i := db.readIndex(context.Background()); i != 0 {
+		t.Errorf("Read routed to %d instead of falling back to the master", i)
+	}
+	db.StopDrill()
+
+	db.SetBalancerPolicy(nil)
+	if p := db.BalancerState().Policy; p != "round-robin" {
+		t.Errorf("Unexpected policy: %s", p)
+	}
+}
+
+func TestBuiltinPolicies(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	reads := func(p BalancerPolicy) map[int]int {
+		db.SetBalancerPolicy(p)
+		counts := map[int]int{}
+		for i := 0; i < 300; i++ {
+			counts[db.readIndex(context.Background())]++
+		}
+		return counts
+	}
+
+	if counts := reads(&RoundRobinPolicy{}); counts[1] != 100 || counts[2] != 100 || counts[3] != 100 {
+		t.Errorf("Unexpected round-robin reads: %v", counts)
+	}
+
+	if counts := reads(RandomPolicy{}); counts[0] != 0 || counts[1] == 0 || counts[2] == 0 || counts[3] == 0 {
+		t.Errorf("Unexpected random reads: %v", counts)
+	}
+
+	db.SetWeight(1, 0)
+	db.SetWeight(3, 5)
+	if counts := reads(WeightedPolicy{}); counts[1] != 0 || counts[3] < 2*counts[2] {
+		t.Errorf("Unexpected weighted reads: %v", counts)
+	}
+
+	db.SetMaxOpenConns(1)
+	conn, err := db.topology().pdbs[2].Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if counts := reads(LeastConnsPolicy{}); counts[2] != 0 || counts[1] == 0 || counts[3] == 0 {
+		t.Errorf("Unexpected least connections reads: %v", counts)
+	}
+
+	if p := db.BalancerState().Policy; p != "least-conns" {
+		t.Errorf("Unexpected policy: %s", p)
+	}
+}
@@ -0,0 +1,79 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ApplyError is returned by the context aware pool setters when the context
+// is done before a setting was applied to every physical db. The setting
+// keeps being applied to the pending ones in the background.
+type ApplyError struct {
+	Pending []int // Indexes of the physical dbs the setting wasn't applied to yet
+	Err     error // Context error
+}
+
+// Error implements the error interface.
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("nap: setting pending on physical dbs %v: %s", e.Pending, e.Err)
+}
+
+// Unwrap returns the context error.
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// SetMaxIdleConnsContext is like SetMaxIdleConns but returns an *ApplyError
+// if ctx is done before every physical db applied the setting.
+func (db *DB) SetMaxIdleConnsContext(ctx context.Context, n int) error {
+	return db.apply(ctx, func(pdb *sql.DB) { pdb.SetMaxIdleConns(n) })
+}
+
+// SetMaxOpenConnsContext is like SetMaxOpenConns but returns an *ApplyError
+// if ctx is done before every physical db applied the setting.
+func (db *DB) SetMaxOpenConnsContext(ctx context.Context, n int) error {
+	return db.apply(ctx, func(pdb *sql.DB) { pdb.SetMaxOpenConns(n) })
+}
+
+// SetConnMaxLifetimeContext is like SetConnMaxLifetime but returns an
+// *ApplyError if ctx is done before every physical db applied the setting.
+func (db *DB) SetConnMaxLifetimeContext(ctx context.Context, d time.Duration) error {
+	return db.apply(ctx, func(pdb *sql.DB) { pdb.SetConnMaxLifetime(d) })
+}
+
+// apply concurrently calls fn with each physical db, so a stalling
+// driver only delays the setting on its own physical db.
+func (db *DB) apply(ctx context.Context, fn func(*sql.DB)) error {
+	pdbs := db.topology().pdbs
+	applied := make(chan int, len(pdbs))
+	for i := range pdbs {
+		go func(i int) {
+			fn(pdbs[i])
+			applied <- i
+		}(i)
+	}
+
+	pending := make(map[int]bool, len(pdbs))
+	for i := range pdbs {
+		pending[i] = true
+	}
+
+	for len(pending) > 0 {
+		select {
+		case i := <-applied:
+			delete(pending, i)
+		case <-ctx.Done():
+			e := &ApplyError{Err: ctx.Err()}
+			for i := range pdbs {
+				if pending[i] {
+					e.Pending = append(e.Pending, i)
+				}
+			}
+			return e
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.SetMaxOpenConnsContext(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range db.topology().pdbs {
+		if got := db.topology().pdbs[i].Stats().MaxOpenConnections; got != 3 {
+			t.Errorf("Setting not applied on %d. Got: %d", i, got)
+		}
+	}
+
+	stall := make(chan struct{})
+	defer close(stall)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = db.apply(ctx, func(pdb *sql.DB) {
+		if pdb == db.topology().pdbs[1] {
+			<-stall
+		}
+	})
+
+	var e *ApplyError
+	if !errors.As(err, &e) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Want *ApplyError, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(e.Pending, []int{1}) {
+		t.Errorf("Unexpected pending physical dbs: %v", e.Pending)
+	}
+}
@@ -0,0 +1,217 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// StatementUse is the use of a prepared statement in a statement profile.
+type StatementUse struct {
+	SQL         string `json:"sql"`
+	Fingerprint string `json:"fingerprint"` // Hash of the normalized SQL
+	Uses        uint64 `json:"uses"`        // Operations run with the statement
+}
+
+// StatementProfile returns the n most used statements open on the DB, most
+// used first, or all of them if n <= 0, such as for Prewarm to prepare the
+// statements of the previous run after a deploy. Statements with the same
+// SQL are merged.
+func (db *DB) StatementProfile(n int) []StatementUse {
+	uses := map[string]uint64{}
+	db.stmts.Range(func(k, _ interface{}) bool {
+		s := k.(*Stmt)
+		uses[s.query] += atomic.LoadUint64(&s.uses)
+		return true
+	})
+
+	profile := make([]StatementUse, 0, len(uses))
+	for query, n := range uses {
+		profile = append(profile, StatementUse{SQL: query, Fingerprint: fingerprint(query), Uses: n})
+	}
+
+	sort.Slice(profile, func(i, j int) bool {
+		if profile[i].Uses != profile[j].Uses {
+			return profile[i].Uses > profile[j].Uses
+		}
+		return profile[i].SQL < profile[j].SQL
+	})
+
+	if n > 0 && n < len(profile) {
+		profile = profile[:n]
+	}
+	return profile
+}
+
+// SaveStatementProfile writes the StatementProfile of the n most used
+// statements to w, as one JSON object per line.
+func (db *DB) SaveStatementProfile(w io.Writer, n int) error {
+	enc := json.NewEncoder(w)
+	for _, use := range db.StatementProfile(n) {
+		if err := enc.Encode(use); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadStatementProfile reads a statement profile written by
+// SaveStatementProfile from r.
+func LoadStatementProfile(r io.Reader) ([]StatementUse, error) {
+	var profile []StatementUse
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		var use StatementUse
+		if err := dec.Decode(&use); err != nil {
+			return nil, err
+		}
+		profile = append(profile, use)
+	}
+	return profile, nil
+}
+
+// PrewarmOptions bound the work of Prewarm on the physical dbs.
+type PrewarmOptions struct {
+	Concurrency int  // Statements prepared at once on each physical db, 1 if <= 0
+	Explain     bool // Also EXPLAIN each statement once prepared, without args
+
+	// MaxInUse skips preparing a statement on a physical db while more than
+	// MaxInUse of its connections are in use, so warming doesn't compete
+	// with traffic. Zero means no limit.
+	MaxInUse int
+
+	// Report, when set, is called with the error of each statement which
+	// couldn't be prepared or explained on a physical db, such as those
+	// with placeholders explained without args on most drivers.
+	Report func(node int, query string, err error)
+}
+
+// Prewarm prepares the statements of profile on each physical db, such as
+// loaded with LoadStatementProfile, so that the first Prepare of each
+// statement takes its prepared statements over instead of preparing it
+// while serving traffic. It blocks until done or ctx, which bounds the time
+// spent warming, is done, and is meant to run in the background after a
+// deploy. Statements which couldn't be prepared on every physical db, or
+// were skipped, are closed. Prewarmed statements not yet taken over are
+// closed once invalidated, such as by topology changes, or by Close.
+func (db *DB) Prewarm(ctx context.Context, profile []StatementUse, opts PrewarmOptions) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	gen, pdbs := db.statementGeneration(), db.topology().pdbs
+	sets := make([]*stmtSet, len(profile))
+	prepared := make([]int32, len(profile)) // Physical dbs each statement is prepared on, -1 once failed
+	for k := range sets {
+		sets[k] = &stmtSet{
+			gen:    gen,
+			stmts:  make([]*sql.Stmt, len(pdbs)),
+			warm:   make([]uint32, len(pdbs)),
+			refs:   1,
+			closed: make(chan struct{}),
+		}
+	}
+
+	scatter(len(pdbs), func(i int) error {
+		queue := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < opts.Concurrency; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := range queue {
+					db.prewarm(ctx, i, pdbs[i], profile[k].SQL, sets[k], &prepared[k], opts)
+				}
+			}()
+		}
+
+		for k, use := range profile {
+			if _, ok := db.prewarmed.Load(use.SQL); ok || ctx.Err() != nil {
+				continue
+			}
+			if opts.MaxInUse > 0 && pdbs[i].Stats().InUse > opts.MaxInUse {
+				continue
+			}
+			queue <- k
+		}
+		close(queue)
+		wg.Wait()
+		return nil
+	})
+
+	for k, set := range sets {
+		if atomic.LoadInt32(&prepared[k]) != int32(len(pdbs)) || gen != db.statementGeneration() {
+			set.close()
+			continue
+		}
+		if _, loaded := db.prewarmed.LoadOrStore(profile[k].SQL, set); loaded {
+			set.close()
+		}
+	}
+
+	return ctx.Err()
+}
+
+// prewarm prepares query on the physical db pdb at index i, for set.
+func (db *DB) prewarm(ctx context.Context, i int, pdb *sql.DB, query string, set *stmtSet, prepared *int32, opts PrewarmOptions) {
+	report := func(err error) {
+		if err != nil && opts.Report != nil {
+			opts.Report(i, query, err)
+		}
+	}
+
+	stmt, err := pdb.PrepareContext(ctx, db.preparedSQL(query))
+	if err != nil {
+		report(err)
+		if db.prepared(i, err) != nil {
+			atomic.StoreInt32(prepared, -1)
+			return
+		}
+	}
+	set.stmts[i] = stmt
+
+	if stmt != nil && opts.Explain {
+		_, err = explain(ctx, pdb, query, nil)
+		report(err)
+	}
+
+	for {
+		n := atomic.LoadInt32(prepared)
+		if n < 0 || atomic.CompareAndSwapInt32(prepared, n, n+1) {
+			return
+		}
+	}
+}
+
+// dropPrewarmed drops the statement sets prewarmed and not yet taken over,
+// returning them.
+func (db *DB) dropPrewarmed() []*stmtSet {
+	var sets []*stmtSet
+	db.prewarmed.Range(func(k, _ interface{}) bool {
+		if v, ok := db.prewarmed.LoadAndDelete(k); ok {
+			sets = append(sets, v.(*stmtSet))
+		}
+		return true
+	})
+	return sets
+}
+
+// prewarmedSet takes the statement set prewarmed for query over, if any
+// and still valid.
+func (db *DB) prewarmedSet(query string) *stmtSet {
+	v, ok := db.prewarmed.LoadAndDelete(query)
+	if !ok {
+		return nil
+	}
+
+	set := v.(*stmtSet)
+	if set.gen != db.statementGeneration() {
+		go set.close()
+		return nil
+	}
+	return set
+}
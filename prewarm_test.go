@@ -0,0 +1,150 @@
+package nap
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatementProfile(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for k, query := range []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 1"} {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stmt.Close()
+
+		for i := 0; i <= k; i++ {
+			if err = stmt.QueryRow().Scan(new(int)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = db.SaveStatementProfile(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := LoadStatementProfile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []StatementUse{
+		{SQL: "SELECT 1", Fingerprint: fingerprint("SELECT 1"), Uses: 5},
+		{SQL: "SELECT 3", Fingerprint: fingerprint("SELECT 3"), Uses: 3},
+	}
+	if !reflect.DeepEqual(profile, want) {
+		t.Errorf("Unexpected profile. Got: %+v, Want: %+v", profile, want)
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mu sync.Mutex
+	reports := map[string]int{}
+	profile := []StatementUse{{SQL: "SELECT 1"}, {SQL: "SELECT ?"}, {SQL: "SELECT * FROM missing"}}
+	err = db.Prewarm(context.Background(), profile, PrewarmOptions{
+		Concurrency: 2,
+		Explain:     true,
+		Report: func(node int, query string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reports[query]++
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Statements with placeholders can't be explained without args.
+	if want := map[string]int{"SELECT ?": 2, "SELECT * FROM missing": 2}; !reflect.DeepEqual(reports, want) {
+		t.Errorf("Unexpected errors reported. Got: %v, Want: %v", reports, want)
+	}
+	if _, ok := db.prewarmed.Load("SELECT * FROM missing"); ok {
+		t.Error("Statement failing to prepare prewarmed")
+	}
+
+	v, ok := db.prewarmed.Load("SELECT 1")
+	if !ok {
+		t.Fatal("Statement not prewarmed")
+	}
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if stmt.load() != v.(*stmtSet) {
+		t.Error("Prewarmed statement not taken over")
+	}
+	if err = stmt.QueryRow().Scan(new(int)); err != nil {
+		t.Error(err)
+	}
+	if _, ok = db.prewarmed.Load("SELECT 1"); ok {
+		t.Error("Prewarmed statement taken over twice")
+	}
+
+	v, ok = db.prewarmed.Load("SELECT ?")
+	if !ok {
+		t.Fatal("Statement not prewarmed")
+	}
+	dropped := v.(*stmtSet)
+
+	db.InvalidateStatements()
+	if _, ok = db.prewarmed.Load("SELECT ?"); ok {
+		t.Error("Invalidated prewarmed statement kept")
+	}
+	select {
+	case <-dropped.closed:
+	case <-time.After(time.Second):
+		t.Error("Invalidated prewarmed statement not closed")
+	}
+
+	if stmt, err = db.Prepare("SELECT ?"); err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if stmt.load().gen != db.statementGeneration() {
+		t.Error("Invalidated prewarmed statement taken over")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = db.Prewarm(ctx, profile, PrewarmOptions{}); err != context.Canceled {
+		t.Errorf("Want context.Canceled from Prewarm, got: %v", err)
+	}
+	if _, ok = db.prewarmed.Load("SELECT 1"); ok {
+		t.Error("Statement prewarmed past the budget")
+	}
+
+	if err = db.Prewarm(context.Background(), []StatementUse{{SQL: "SELECT 2"}}, PrewarmOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok = db.prewarmed.Load("SELECT 2"); !ok {
+		t.Fatal("Statement not prewarmed")
+	}
+	db.Close()
+	select {
+	case <-v.(*stmtSet).closed:
+	default:
+		t.Error("Prewarmed statement not closed along with the DB")
+	}
+}
@@ -0,0 +1,86 @@
+package nap
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Promote makes the slave at index i the master, such as once it was
+// promoted by a failover, swapping its index with the one of the master,
+// which becomes a slave at index i that can be removed with RemoveSlave.
+// Both keep their labels, weights, health, flight record and utilization,
+// and are tagged with the application name of their new role. Statements
+// are prepared again on their next use.
+//
+// For grace after the promotion, reads which would go to the master, such
+// as those following writes or with no slave in rotation, go to the slave
+// in rotation lagging the least, as measured with SetLagProbe, while the
+// cache of the new master warms up. Reads directed to the master with
+// UseMaster or WithNode still go to it, and so do reads whose consistency
+// no slave meets, such as those of tables no slave is fresh enough for or
+// with a token no slave reached, and reads when the lag of no slave in
+// rotation is known.
+func (db *DB) Promote(i int, grace time.Duration) error {
+	err := db.updateTopology(func(t *topology) error {
+		if i < 1 || i >= len(t.pdbs) {
+			return fmt.Errorf("nap: no slave at index %d", i)
+		}
+
+		t.pdbs[0], t.pdbs[i] = t.pdbs[i], t.pdbs[0]
+		t.connectors[0], t.connectors[i] = t.connectors[i], t.connectors[0]
+		t.healths[0], t.healths[i] = t.healths[i], t.healths[0]
+		t.rtts[0], t.rtts[i] = t.rtts[i], t.rtts[0]
+		t.weights[0], t.weights[i] = t.weights[i], t.weights[0]
+		t.reschedule()
+
+		t.labels[0], t.labels[i] = withRole(t.labels[i], 0), withRole(t.labels[0], i)
+
+		tag, _ := db.appNames.Load().(appNameTag)
+		tag.apply(t.connectors[0], 0)
+		tag.apply(t.connectors[i], i)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&db.promoted, time.Now().Add(grace).UnixNano())
+	return nil
+}
+
+// Promoting reports whether the grace period of the last promotion is in
+// progress.
+func (db *DB) Promoting() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&db.promoted)
+}
+
+// withRole returns a copy of labels with the role of index i.
+func withRole(labels Labels, i int) Labels {
+	l := make(Labels, len(labels))
+	for k, v := range labels {
+		l[k] = v
+	}
+	l["role"] = roleLabels(i)["role"]
+	return l
+}
+
+// graceIndex returns the index of the physical db a read with ctx routed
+// to the master goes to during the grace period of a promotion.
+func (db *DB) graceIndex(ctx context.Context) int {
+	if !db.Promoting() {
+		return 0
+	}
+	if _, ok := db.nodeIndex(ctx); ok {
+		return 0
+	}
+
+	best, min := 0, time.Duration(-1)
+	for i := 1; i < len(db.topology().pdbs); i++ {
+		if lag := db.Lag(i); lag >= 0 && (min < 0 || lag < min) && db.inRotation(i) {
+			best, min = i, lag
+		}
+	}
+	return best
+}
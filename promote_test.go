@@ -0,0 +1,97 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestPromote(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pdbs := append([]*sql.DB(nil), db.topology().pdbs...)
+	lags := map[*sql.DB]time.Duration{pdbs[0]: 20 * time.Millisecond, pdbs[1]: 50 * time.Millisecond, pdbs[2]: 10 * time.Millisecond}
+	db.SetLagProbe(func(_ context.Context, pdb *sql.DB) (time.Duration, error) {
+		return lags[pdb], nil
+	}, time.Millisecond, time.Hour)
+	db.SetConsistency(ReadYourWrites, time.Hour)
+	db.SetLabels(2, Labels{"zone": "b"})
+
+	for _, i := range []int{0, 3} {
+		if err = db.Promote(i, time.Hour); err == nil {
+			t.Errorf("Want an error promoting index %d", i)
+		}
+	}
+	if db.Promoting() {
+		t.Error("Promoting without a promotion")
+	}
+
+	if err = db.Promote(2, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if db.Master() != pdbs[2] || db.topology().pdbs[2] != pdbs[0] || !db.Promoting() {
+		t.Fatal("Slave not promoted")
+	}
+	if l := db.Labels(0); l["role"] != "master" || l["zone"] != "b" {
+		t.Errorf("Unexpected labels of the new master: %v", l)
+	}
+	if l := db.Labels(2); l["role"] != "slave" {
+		t.Errorf("Unexpected labels of the old master: %v", l)
+	}
+
+	for db.Lag(2) < 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	read := func(ctx context.Context) int {
+		rows, err := db.QueryContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		return rows.Node()
+	}
+
+	if _, err = db.Exec("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if i := read(context.Background()); i != 2 {
+		t.Errorf("Read after a write went to %d instead of the slave lagging the least", i)
+	}
+	if i := read(UseMaster(context.Background())); i != 0 {
+		t.Errorf("Read with UseMaster went to %d", i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if i := read(context.Background()); i != 0 || db.Promoting() {
+		t.Errorf("Read after a write went to %d after the grace period", i)
+	}
+}
+
+func TestPromoteConsistency(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetLagProbe(func(context.Context, *sql.DB) (time.Duration, error) {
+		return 10 * time.Millisecond, nil
+	}, time.Millisecond, time.Hour)
+	for db.Lag(1) < 0 || db.Lag(2) < 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err = db.Promote(2, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), tableFreshnessKey{}, time.Millisecond)
+	if i := db.readIndex(ctx); i != 0 {
+		t.Errorf("Read of a table no slave is fresh enough for went to %d", i)
+	}
+}
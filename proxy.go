@@ -0,0 +1,97 @@
+package nap
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ProxyRoute is the role of the physical db a smart proxy should route
+// a query to.
+type ProxyRoute uint8
+
+// Proxy routes.
+const (
+	RouteMaster ProxyRoute = iota
+	RouteSlave
+)
+
+var routeNames = [...]string{"master", "slave"}
+
+// String returns the name of the role of r.
+func (r ProxyRoute) String() string {
+	if int(r) < len(routeNames) {
+		return routeNames[r]
+	}
+	return "unknown"
+}
+
+// ProxyHint rewrites a query so that a smart proxy routes it as route,
+// returning the query unchanged when it can't.
+type ProxyHint func(query string, route ProxyRoute) string
+
+// MaxScaleHint is a ProxyHint appending a MaxScale routing hint, such as
+// -- maxscale route to slave, as supported by the hintfilter.
+func MaxScaleHint(query string, route ProxyRoute) string {
+	return query + " -- maxscale route to " + route.String()
+}
+
+// CommentHint returns a ProxyHint prepending the route as a comment, such as
+// /* route=slave */ for the key route, which ProxySQL query rules can match
+// with a match_pattern to pick the hostgroup of the query.
+func CommentHint(key string) ProxyHint {
+	key = sanitizeComment(key)
+	return func(query string, route ProxyRoute) string {
+		return "/* " + key + "=" + route.String() + " */ " + query
+	}
+}
+
+// SetProxyHint enables proxy mode, for a DB opened with the single DSN of
+// a smart proxy, such as ProxySQL or MaxScale, fronting the physical dbs.
+// The routing decisions of nap are then injected into queries with h for
+// the proxy to carry out: writes go to the master, and so do reads directed
+// to the index 0 with WithNode or needing the master for their consistency,
+// such as right after a write with ReadYourWrites, reads of tables written
+// within their freshness bound or reads with a Token, since nap can't check
+// the slaves behind the proxy, while other reads go to a slave.
+// A QueryRow returning no rows is checked again on the master as set with
+// SetNoRowsRecheck, and read-only transactions, which proxies route to
+// slaves, are started even while a drill takes the master down.
+// Hints apply to non prepared queries only since prepared statements can't
+// be rewritten. If h is nil, proxy mode is disabled, which is the default.
+func (db *DB) SetProxyHint(h ProxyHint) {
+	db.proxyHint.Store(h)
+}
+
+// proxied returns the ProxyHint of proxy mode, or nil if disabled.
+func (db *DB) proxied() ProxyHint {
+	h, _ := db.proxyHint.Load().(ProxyHint)
+	return h
+}
+
+// proxyRoute returns the route of an op with ctx in proxy mode.
+func (db *DB) proxyRoute(ctx context.Context, op Op) ProxyRoute {
+	if op.write() {
+		return RouteMaster
+	}
+
+	if i, _, ok := db.consistentIndex(ctx); ok && i == 0 {
+		return RouteMaster
+	}
+	return RouteSlave
+}
+
+// routed rewrites the query of an op with ctx with its proxy route, if in
+// proxy mode.
+func (db *DB) routed(ctx context.Context, op Op, query string) string {
+	if h := db.proxied(); h != nil {
+		return h(query, db.proxyRoute(ctx, op))
+	}
+	return query
+}
+
+// proxyRechecks reports whether a QueryRow with ctx routed by the proxy
+// should be checked again on the master when it returns no rows.
+func (db *DB) proxyRechecks(ctx context.Context) bool {
+	return db.proxied() != nil && db.proxyRoute(ctx, OpQueryRow) == RouteSlave &&
+		atomic.LoadInt32(&db.norows) != 0
+}
@@ -0,0 +1,117 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestProxyHint(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var got []string
+	db.SetQueryHook(func(ctx context.Context, info QueryInfo) {
+		got = append(got, info.SQL)
+	})
+
+	db.SetProxyHint(MaxScaleHint)
+	db.Exec("SELECT 1")
+	db.QueryRow("SELECT 1").Scan(new(int))
+	db.QueryRowContext(WithNode(context.Background(), 0), "SELECT 1").Scan(new(int))
+
+	want := []string{
+		"SELECT 1 -- maxscale route to master",
+		"SELECT 1 -- maxscale route to slave",
+		"SELECT 1 -- maxscale route to master",
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected queries: %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Unexpected query %d. Got: %s, Want: %s", i, got[i], want[i])
+		}
+	}
+
+	db.SetProxyHint(nil)
+	if q := db.rewrite(context.Background(), OpQuery, "SELECT 1"); q != "SELECT 1" {
+		t.Errorf("Query hinted once proxy mode disabled: %s", q)
+	}
+}
+
+func TestCommentHint(t *testing.T) {
+	db := &DB{}
+	db.SetProxyHint(CommentHint("route*/"))
+	db.SetCorrelationComments(true)
+
+	ctx := WithCorrelationID(context.Background(), "ab")
+	if q := db.rewrite(ctx, OpQuery, "SELECT 1"); q != "/* cid=ab */ /* route=slave */ SELECT 1" {
+		t.Errorf("Unexpected hinted query: %s", q)
+	}
+	if q := db.rewrite(ctx, OpExec, "DELETE FROM t"); q != "/* cid=ab */ /* route=master */ DELETE FROM t" {
+		t.Errorf("Unexpected hinted query: %s", q)
+	}
+}
+
+func TestProxyPolicies(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	db.SetNoRowsRecheck(true)
+	if db.recheckRow(ctx, 0, "SELECT 1", nil) != nil {
+		t.Error("Reads of the master rechecked outside of proxy mode")
+	}
+
+	db.SetProxyHint(MaxScaleHint)
+	if db.recheckRow(ctx, 0, "SELECT 1", nil) == nil {
+		t.Error("Reads routed to a slave by the proxy not rechecked")
+	}
+	if db.recheckRow(WithNode(ctx, 0), 0, "SELECT 1", nil) != nil {
+		t.Error("Reads routed to the master by the proxy rechecked")
+	}
+
+	if r := db.proxyRoute(ctx, OpQuery); r != RouteSlave {
+		t.Errorf("Read routed to the %s by the proxy", r)
+	}
+	if r := db.proxyRoute(WithStickySession(ctx), OpQuery); r != RouteSlave {
+		t.Errorf("Read of a session routed to the %s by the proxy", r)
+	}
+	if r := db.proxyRoute(WithToken(ctx, Token{pos: "1"}), OpQuery); r != RouteSlave {
+		t.Errorf("Read with a token routed to the %s without token queries", r)
+	}
+	db.SetTokenQueries(PostgresTokens)
+	if r := db.proxyRoute(WithToken(ctx, Token{pos: "1"}), OpQuery); r != RouteMaster {
+		t.Errorf("Read with a token routed to the %s by the proxy", r)
+	}
+
+	db.SetConsistency(ReadYourWrites, time.Minute)
+	db.wrote(ctx)
+	if r := db.proxyRoute(ctx, OpQuery); r != RouteMaster {
+		t.Errorf("Read after a write routed to the %s by the proxy", r)
+	}
+	if r := db.proxyRoute(UseSlave(ctx), OpQuery); r != RouteSlave {
+		t.Errorf("Read with UseSlave routed to the %s by the proxy", r)
+	}
+	db.SetConsistency(Eventual, 0)
+
+	db.StartDrill(Drill{MasterDown: true})
+	if _, err = db.BeginTx(ctx, nil); err != ErrDrill {
+		t.Errorf("Want ErrDrill, got: %v", err)
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Read-only transaction refused: %v", err)
+	}
+	tx.Rollback()
+}
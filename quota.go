@@ -0,0 +1,147 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned for queries whose caller exceeded its quota.
+var ErrQuotaExceeded = errors.New("nap: caller quota exceeded")
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx labeled with caller, such as a tenant or
+// an endpoint name. Queries of labeled contexts are accounted for in
+// CallerStats and are subject to the caller's quota, if any.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerStats are the query stats of a single caller.
+// Query durations account for the time until the first result is
+// available, excluding the time spent iterating over rows.
+type CallerStats struct {
+	Queries  uint64        // Queries executed
+	Rejected uint64        // Queries rejected for exceeding the quota
+	Duration time.Duration // Total execution time
+}
+
+// Quota limits the work of a single caller within a time window.
+// Zero fields mean no limit.
+type Quota struct {
+	MaxQueries  uint64
+	MaxDuration time.Duration
+	Per         time.Duration // Window after which the budget resets, never if zero
+}
+
+type account struct {
+	mu     sync.Mutex
+	total  CallerStats
+	window CallerStats // Stats in the current quota window
+	since  time.Time   // Start of the current quota window
+	quota  Quota
+}
+
+// SetCallerQuota sets the quota of caller. Queries past its budget fail
+// with ErrQuotaExceeded until the quota window resets.
+func (db *DB) SetCallerQuota(caller string, q Quota) {
+	acct := db.account(caller)
+	acct.mu.Lock()
+	acct.quota = q
+	acct.mu.Unlock()
+}
+
+// CallerStats returns the query stats of every caller seen so far.
+func (db *DB) CallerStats() map[string]CallerStats {
+	stats := map[string]CallerStats{}
+	db.accounts.Range(func(caller, acct interface{}) bool {
+		a := acct.(*account)
+		a.mu.Lock()
+		stats[caller.(string)] = a.total
+		a.mu.Unlock()
+		return true
+	})
+	return stats
+}
+
+// ResetCallerStats discards the stats of every caller, keeping quotas.
+func (db *DB) ResetCallerStats() {
+	db.accounts.Range(func(_, acct interface{}) bool {
+		a := acct.(*account)
+		a.mu.Lock()
+		a.total, a.window, a.since = CallerStats{}, CallerStats{}, time.Now()
+		a.mu.Unlock()
+		return true
+	})
+}
+
+func (db *DB) account(caller string) *account {
+	acct, _ := db.accounts.LoadOrStore(caller, &account{since: time.Now()})
+	return acct.(*account)
+}
+
+// charge admits a query with ctx against its caller's quota, returning the
+// account its duration must be added to, if any.
+func (db *DB) charge(ctx context.Context) (*account, error) {
+	caller, ok := ctx.Value(callerKey{}).(string)
+	if !ok {
+		return nil, nil
+	}
+
+	acct := db.account(caller)
+	acct.mu.Lock()
+	defer acct.mu.Unlock()
+
+	if q := acct.quota; q.Per > 0 && time.Since(acct.since) >= q.Per {
+		acct.window, acct.since = CallerStats{}, time.Now()
+	}
+
+	q, w := acct.quota, acct.window
+	if q.MaxQueries > 0 && w.Queries >= q.MaxQueries || q.MaxDuration > 0 && w.Duration >= q.MaxDuration {
+		acct.total.Rejected++
+		acct.window.Rejected++
+		return nil, fmt.Errorf("%w: %s", ErrQuotaExceeded, caller)
+	}
+
+	acct.total.Queries++
+	acct.window.Queries++
+	return acct, nil
+}
+
+// add adds the duration of a query to a.
+func (a *account) add(d time.Duration) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	a.total.Duration += d
+	a.window.Duration += d
+	a.mu.Unlock()
+}
+
+// errContext is an already canceled context whose Err is err. Running a
+// query with it produces a *sql.Row whose Scan returns err.
+type errContext struct {
+	context.Context
+	err error
+}
+
+var canceled = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func (c errContext) Done() <-chan struct{} { return canceled }
+
+func (c errContext) Err() error { return c.err }
+
+// errRow returns a *sql.Row whose Scan returns err.
+func errRow(db *sql.DB, err error) *sql.Row {
+	return db.QueryRowContext(errContext{context.Background(), err}, "")
+}
@@ -0,0 +1,86 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallerQuota(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetCallerQuota("noisy", Quota{MaxQueries: 2})
+	noisy := WithCaller(context.Background(), "noisy")
+	quiet := WithCaller(context.Background(), "quiet")
+
+	for i := 0; i < 2; i++ {
+		if _, err = db.ExecContext(noisy, "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = db.QueryContext(noisy, "SELECT 1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Want ErrQuotaExceeded, got: %v", err)
+	}
+
+	var n int
+	if err = db.QueryRowContext(noisy, "SELECT 1").Scan(&n); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Want ErrQuotaExceeded from Scan, got: %v", err)
+	}
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if err = stmt.QueryRowContext(noisy).Scan(&n); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Want ErrQuotaExceeded from Stmt Scan, got: %v", err)
+	}
+
+	if err = stmt.QueryRowContext(quiet).Scan(&n); err != nil {
+		t.Errorf("Unexpected error for caller without quota: %s", err)
+	}
+
+	stats := db.CallerStats()
+	if got := stats["noisy"]; got.Queries != 2 || got.Rejected != 3 || got.Duration <= 0 {
+		t.Errorf("Unexpected noisy stats: %+v", got)
+	}
+
+	if got := stats["quiet"]; got.Queries != 1 || got.Rejected != 0 {
+		t.Errorf("Unexpected quiet stats: %+v", got)
+	}
+
+	db.ResetCallerStats()
+	if _, err = db.ExecContext(noisy, "SELECT 1"); err != nil {
+		t.Errorf("Quota not reset: %s", err)
+	}
+}
+
+func TestCallerQuotaWindow(t *testing.T) {
+	db := &DB{}
+	db.SetCallerQuota("a", Quota{MaxQueries: 1, Per: 10 * time.Millisecond})
+	ctx := WithCaller(context.Background(), "a")
+
+	if _, err := db.charge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.charge(ctx); err == nil {
+		t.Fatal("Expected quota to be exceeded")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := db.charge(ctx); err != nil {
+		t.Errorf("Quota window didn't reset: %s", err)
+	}
+
+	if _, err := db.charge(context.Background()); err != nil {
+		t.Errorf("Unlabeled queries must not be accounted: %s", err)
+	}
+}
@@ -0,0 +1,178 @@
+package nap
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StatementRecord is the trace of a statement kept by the flight recorder.
+type StatementRecord struct {
+	Time     time.Time         `json:"time"` // Time the statement started
+	Node     int               `json:"node"`
+	Op       string            `json:"op"`
+	SQL      string            `json:"sql"`      // Normalized SQL, without literals
+	Duration time.Duration     `json:"duration"` // Duration in nanoseconds
+	Status   string            `json:"status"`   // Either "ok" or "error"
+	Error    string            `json:"error,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// SetFlightRecorder enables a flight recorder keeping the last n statements
+// run on each physical db, for post-incident forensics without the overhead
+// of logging every query. Records are dumped as a structured JSON log by
+// DumpFlightRecord, FlightRecorderHandler and DumpOnPanic.
+// If n <= 0, the recorder is disabled and its records dropped. It is
+// disabled by default.
+func (db *DB) SetFlightRecorder(n int) {
+	if n <= 0 {
+		db.recorder.Store((*recorder)(nil))
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r := &recorder{size: n, rings: make([]*ring, len(db.topology().pdbs))}
+	for i := range r.rings {
+		r.rings[i] = &ring{records: make([]record, n)}
+	}
+	db.recorder.Store(r)
+}
+
+// FlightRecord returns the statements kept by the flight recorder, oldest
+// first. It is empty unless enabled with SetFlightRecorder.
+func (db *DB) FlightRecord() []StatementRecord {
+	r, _ := db.recorder.Load().(*recorder)
+	if r == nil {
+		return nil
+	}
+
+	var records []StatementRecord
+	for i := range r.rings {
+		records = r.rings[i].append(records, i)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records
+}
+
+// DumpFlightRecord writes the statements kept by the flight recorder to w,
+// oldest first, as one JSON object per line.
+func (db *DB) DumpFlightRecord(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range db.FlightRecord() {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlightRecorderHandler returns a debug handler serving the statements
+// kept by the flight recorder as written by DumpFlightRecord.
+func (db *DB) FlightRecorderHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		db.DumpFlightRecord(w)
+	})
+}
+
+// DumpOnPanic dumps the flight record to w before resuming a panic.
+// It must be deferred directly, as in defer db.DumpOnPanic(os.Stderr).
+func (db *DB) DumpOnPanic(w io.Writer) {
+	if v := recover(); v != nil {
+		db.DumpFlightRecord(w)
+		panic(v)
+	}
+}
+
+// record is a statement kept by the flight recorder. Normalizing its SQL
+// and rendering its error are deferred until dumped.
+type record struct {
+	start time.Time
+	op    Op
+	sql   string
+	d     time.Duration
+	err   error
+	tags  []Tag
+}
+
+type recorder struct {
+	size  int     // Statements kept per physical db
+	rings []*ring // Last statements of each physical db
+}
+
+type ring struct {
+	mu      sync.Mutex
+	records []record
+	next    int  // Index of the oldest record, overwritten next
+	full    bool // Set once records wrapped around
+}
+
+// record keeps the statement described by info on its node.
+func (r *recorder) record(info *QueryInfo, tags []Tag, start time.Time, d time.Duration) {
+	if info.Node >= len(r.rings) {
+		return
+	}
+
+	ring := r.rings[info.Node]
+	ring.mu.Lock()
+	ring.records[ring.next] = record{start: start, op: info.Op, sql: info.SQL, d: d, err: info.Err, tags: tags}
+	if ring.next++; ring.next == len(ring.records) {
+		ring.next, ring.full = 0, true
+	}
+	ring.mu.Unlock()
+}
+
+// reindexed returns a copy of r for physical dbs coming from the indexes
+// from, -1 for added ones, which keep their statements.
+func (r *recorder) reindexed(from []int) *recorder {
+	rr := &recorder{size: r.size, rings: make([]*ring, len(from))}
+	for i, j := range from {
+		if j >= 0 && j < len(r.rings) {
+			rr.rings[i] = r.rings[j]
+		} else {
+			rr.rings[i] = &ring{records: make([]record, r.size)}
+		}
+	}
+	return rr
+}
+
+// append appends the statements kept by ring of node to records, oldest first.
+func (ring *ring) append(records []StatementRecord, node int) []StatementRecord {
+	ring.mu.Lock()
+	kept := append([]record(nil), ring.records[ring.next:]...)
+	if !ring.full {
+		kept = kept[:0]
+	}
+	kept = append(kept, ring.records[:ring.next]...)
+	ring.mu.Unlock()
+
+	for _, rec := range kept {
+		s := StatementRecord{
+			Time:     rec.start,
+			Node:     node,
+			Op:       rec.op.String(),
+			SQL:      normalizeSQL(rec.sql),
+			Duration: rec.d,
+			Status:   "ok",
+		}
+		if rec.err != nil {
+			s.Status, s.Error = "error", rec.err.Error()
+		}
+		if len(rec.tags) > 0 {
+			s.Tags = make(map[string]string, len(rec.tags))
+			for _, t := range rec.tags {
+				s.Tags[t.Key] = t.Value
+			}
+		}
+		records = append(records, s)
+	}
+	return records
+}
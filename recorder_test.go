@@ -0,0 +1,89 @@
+package nap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFlightRecorder(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Exec("SELECT 0")
+	if records := db.FlightRecord(); len(records) != 0 {
+		t.Fatalf("Unexpected records of disabled recorder: %v", records)
+	}
+
+	db.SetFlightRecorder(2)
+	for _, q := range []string{"SELECT 1", "SELECT 2", "SELECT 'three'"} {
+		if _, err = db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.QueryRow("SELECT * FROM missing").Scan(new(int))
+
+	records := db.FlightRecord()
+	if len(records) != 3 {
+		t.Fatalf("Unexpected number of records. Got: %d, Want: 3", len(records))
+	}
+
+	if r := records[0]; r.Node != 0 || r.Op != "exec" || r.SQL != "SELECT ?" || r.Status != "ok" {
+		t.Errorf("Unexpected oldest record: %+v", r)
+	}
+	if records[1].SQL != "SELECT ?" || records[0].Time.After(records[1].Time) {
+		t.Errorf("Records not kept oldest first: %+v", records)
+	}
+	if r := records[2]; r.Node != 1 || r.Op != "query_row" || r.Status != "error" || r.Error == "" {
+		t.Errorf("Unexpected record of failed read: %+v", r)
+	}
+
+	rec := httptest.NewRecorder()
+	db.FlightRecorderHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Unexpected dump: %s", rec.Body)
+	}
+
+	var r StatementRecord
+	if err = json.Unmarshal([]byte(lines[2]), &r); err != nil || r.Status != "error" {
+		t.Errorf("Unexpected dumped record: %s, %v", lines[2], err)
+	}
+
+	db.SetFlightRecorder(0)
+	if records := db.FlightRecord(); len(records) != 0 {
+		t.Errorf("Records not dropped: %v", records)
+	}
+}
+
+func TestDumpOnPanic(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetFlightRecorder(10)
+	db.Exec("SELECT 1")
+
+	var buf bytes.Buffer
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("Panic not resumed, got: %v", v)
+			}
+		}()
+		defer db.DumpOnPanic(&buf)
+		panic("boom")
+	}()
+
+	if !strings.Contains(buf.String(), `"sql":"SELECT ?"`) {
+		t.Errorf("Flight record not dumped on panic: %s", buf.String())
+	}
+}
@@ -0,0 +1,58 @@
+package nap
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrNotEnoughReplicas is returned by writes refused because fewer slaves
+// are healthy than required by SetMinHealthySlaves.
+var ErrNotEnoughReplicas = errors.New("nap: not enough healthy replicas")
+
+// ReplicasError describes a write refused for lack of healthy slaves.
+// It matches ErrNotEnoughReplicas with errors.Is.
+type ReplicasError struct {
+	Required int // Minimum number of healthy slaves
+	Healthy  int // Number of healthy slaves when the write was refused
+}
+
+// Error implements the error interface.
+func (e *ReplicasError) Error() string {
+	return fmt.Sprintf("%s: %d/%d healthy", ErrNotEnoughReplicas, e.Healthy, e.Required)
+}
+
+// Is reports whether target is ErrNotEnoughReplicas.
+func (e *ReplicasError) Is(target error) bool {
+	return target == ErrNotEnoughReplicas
+}
+
+// SetMinHealthySlaves refuses writes, including transactions and scripts,
+// with a *ReplicasError unless at least n slaves are healthy, for data whose
+// loss tolerance requires replicas close behind the master.
+// Delayed replicas don't count. If n <= 0, writes are never refused for
+// lack of healthy slaves, which is the default.
+func (db *DB) SetMinHealthySlaves(n int) {
+	atomic.StoreInt32(&db.minSlaves, int32(n))
+}
+
+// replicated returns a *ReplicasError if fewer slaves are healthy than
+// required for writes.
+func (db *DB) replicated() error {
+	required := int(atomic.LoadInt32(&db.minSlaves))
+	if required <= 0 {
+		return nil
+	}
+
+	healthy := 0
+	for i, n := 1, len(db.topology().pdbs); i < n; i++ {
+		if db.Healthy(i) && !db.delayed(i) {
+			healthy++
+		}
+	}
+
+	if healthy < required {
+		return &ReplicasError{Required: required, Healthy: healthy}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package nap
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMinHealthySlaves(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMinHealthySlaves(2)
+	if _, err = db.Exec("SELECT 1"); err != nil {
+		t.Fatalf("Write refused with every slave healthy: %v", err)
+	}
+
+	db.StartDrill(Drill{SlavesDown: []int{1}})
+	_, err = db.Exec("SELECT 1")
+
+	var e *ReplicasError
+	if !errors.Is(err, ErrNotEnoughReplicas) || !errors.As(err, &e) || e.Required != 2 || e.Healthy != 1 {
+		t.Errorf("Unexpected error with a slave down: %v", err)
+	}
+
+	if _, err = db.Begin(); !errors.Is(err, ErrNotEnoughReplicas) {
+		t.Errorf("Transaction not refused: %v", err)
+	}
+	db.StopDrill()
+
+	db.SetDelay(2, time.Hour)
+	if _, err = db.Exec("SELECT 1"); !errors.Is(err, ErrNotEnoughReplicas) {
+		t.Errorf("Delayed replica counted as healthy: %v", err)
+	}
+
+	if err = db.QueryRow("SELECT 1").Scan(new(int)); err != nil {
+		t.Errorf("Read refused: %v", err)
+	}
+
+	db.SetMinHealthySlaves(0)
+	if _, err = db.Exec("SELECT 1"); err != nil {
+		t.Errorf("Write refused once disabled: %v", err)
+	}
+}
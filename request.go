@@ -0,0 +1,142 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RequestOptions builds the context of a request carrying the options of
+// nap, so that they compose and are discoverable in one place:
+//
+//	ctx = nap.NewRequest(ctx).Master().Caller("billing").Tag("feature", "invoices").Context()
+//
+// Each option is equivalent to the With function it is named after, so
+// both can be mixed. RequestOptions is immutable and safe to share.
+type RequestOptions struct {
+	ctx context.Context
+}
+
+// NewRequest returns the RequestOptions of a request with ctx.
+func NewRequest(ctx context.Context) RequestOptions {
+	return RequestOptions{ctx: ctx}
+}
+
+// Context returns the context of the request.
+func (r RequestOptions) Context() context.Context {
+	return r.ctx
+}
+
+// Master sends the reads of the request to the master, like WithNode with
+// index 0.
+func (r RequestOptions) Master() RequestOptions {
+	return r.Node(0)
+}
+
+// Slave sends the reads of the request to a slave, overriding Master and
+// the freshness window of SetConsistency, like UseSlave.
+func (r RequestOptions) Slave() RequestOptions {
+	return RequestOptions{ctx: UseSlave(r.ctx)}
+}
+
+// Node sends the reads of the request to the physical db at index i,
+// like WithNode.
+func (r RequestOptions) Node(i int) RequestOptions {
+	return RequestOptions{ctx: WithNode(r.ctx, i)}
+}
+
+// Session spreads the reads of the request across replicas, like
+// WithReadSession.
+func (r RequestOptions) Session() RequestOptions {
+	return RequestOptions{ctx: WithReadSession(r.ctx)}
+}
+
+// Sticky sends every read of the request to the same physical db, like
+// WithStickySession.
+func (r RequestOptions) Sticky() RequestOptions {
+	return RequestOptions{ctx: WithStickySession(r.ctx)}
+}
+
+// StickyMaster sends the reads of the request to the master for the
+// freshness window following its writes, like WithStickyMaster.
+func (r RequestOptions) StickyMaster() RequestOptions {
+	return RequestOptions{ctx: WithStickyMaster(r.ctx)}
+}
+
+// Selector restricts the reads of the request to the physical dbs matching
+// sel, like WithSelector.
+func (r RequestOptions) Selector(sel Selector) RequestOptions {
+	return RequestOptions{ctx: WithSelector(r.ctx, sel)}
+}
+
+// Tier sends the reads of the request to the slaves of tier, like WithTier.
+func (r RequestOptions) Tier(tier string) RequestOptions {
+	return RequestOptions{ctx: WithTier(r.ctx, tier)}
+}
+
+// Preference routes the reads of the request with p, like
+// WithReadPreference.
+func (r RequestOptions) Preference(p ReadPreference) RequestOptions {
+	return RequestOptions{ctx: WithReadPreference(r.ctx, p)}
+}
+
+// AsOf runs the reads of the request as of ts, like WithReadAsOf.
+func (r RequestOptions) AsOf(ts time.Time) RequestOptions {
+	return RequestOptions{ctx: WithReadAsOf(r.ctx, ts)}
+}
+
+// Token sends the reads of the request to the physical dbs having caught
+// up with t, like WithToken.
+func (r RequestOptions) Token(t Token) RequestOptions {
+	return RequestOptions{ctx: WithToken(r.ctx, t)}
+}
+
+// Isolation runs the reads of the request on slaves at level, like
+// WithReadIsolation.
+func (r RequestOptions) Isolation(level sql.IsolationLevel) RequestOptions {
+	return RequestOptions{ctx: WithReadIsolation(r.ctx, level)}
+}
+
+// Timeout sets the statement timeout of the request, like
+// WithStatementTimeout.
+func (r RequestOptions) Timeout(d time.Duration) RequestOptions {
+	return RequestOptions{ctx: WithStatementTimeout(r.ctx, d)}
+}
+
+// PlanHints adds execution plan hints to the queries of the request, like
+// WithPlanHints.
+func (r RequestOptions) PlanHints(hints ...string) RequestOptions {
+	return RequestOptions{ctx: WithPlanHints(r.ctx, hints...)}
+}
+
+// BulkLane runs the operations of the request in the bulk lane, like
+// WithBulkLane.
+func (r RequestOptions) BulkLane() RequestOptions {
+	return RequestOptions{ctx: WithBulkLane(r.ctx)}
+}
+
+// Caller labels the request with caller, such as a tenant, like WithCaller.
+func (r RequestOptions) Caller(caller string) RequestOptions {
+	return RequestOptions{ctx: WithCaller(r.ctx, caller)}
+}
+
+// Tag tags the queries of the request with key and value, like WithTag.
+func (r RequestOptions) Tag(key, value string) RequestOptions {
+	return RequestOptions{ctx: WithTag(r.ctx, key, value)}
+}
+
+// CorrelationID sets the correlation ID of the request, like
+// WithCorrelationID.
+func (r RequestOptions) CorrelationID(id string) RequestOptions {
+	return RequestOptions{ctx: WithCorrelationID(r.ctx, id)}
+}
+
+// QueryID sets the QueryID of the request, like WithQueryID.
+func (r RequestOptions) QueryID(id QueryID) RequestOptions {
+	return RequestOptions{ctx: WithQueryID(r.ctx, id)}
+}
+
+// Memo memoizes the reads of the request, like WithMemo.
+func (r RequestOptions) Memo() RequestOptions {
+	return RequestOptions{ctx: WithMemo(r.ctx)}
+}
@@ -0,0 +1,76 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRequestOptions(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	base := NewRequest(context.Background()).Caller("billing").Tag("team", "payments")
+	ctx := base.Master().Timeout(time.Second).CorrelationID("cid").Context()
+
+	if i, ok := db.nodeIndex(ctx); !ok || i != 0 {
+		t.Errorf("Reads not sent to the master: %d, %t", i, ok)
+	}
+	if d := db.statementTimeout(ctx, OpQuery); d != time.Second {
+		t.Errorf("Unexpected statement timeout: %s", d)
+	}
+	if id := CorrelationID(ctx); id != "cid" {
+		t.Errorf("Unexpected correlation ID: %s", id)
+	}
+	if caller, _ := ctx.Value(callerKey{}).(string); caller != "billing" {
+		t.Errorf("Unexpected caller: %s", caller)
+	}
+	if v, _ := tagValue(Tags(ctx), "team"); v != "payments" {
+		t.Errorf("Unexpected tags: %v", Tags(ctx))
+	}
+
+	if _, ok := db.nodeIndex(base.Context()); ok {
+		t.Error("Options of a derived request leaked to its base")
+	}
+
+	ctx = base.Tier("reporting").Preference(PreferNearest).Sticky().Context()
+	if sel := db.readSelector(ctx); sel.String() != "tier=reporting" {
+		t.Errorf("Unexpected selector: %s", sel)
+	}
+	if p := db.readPreference(ctx); p != PreferNearest {
+		t.Errorf("Unexpected read preference: %d", p)
+	}
+	if s, ok := ctx.Value(sessionKey{}).(*session); !ok || !s.sticky {
+		t.Error("Sticky session not set")
+	}
+
+	ctx = base.Master().Slave().StickyMaster().Token(Token{pos: "0/1"}).Context()
+	if _, ok := db.nodeIndex(ctx); ok || ctx.Value(slaveKey{}) == nil {
+		t.Error("Reads not sent to a slave")
+	}
+	if ctx.Value(stickyKey{}) == nil {
+		t.Error("Sticky master not set")
+	}
+	if ts, ok := ctx.Value(tokenKey{}).(*tokenState); !ok || ts.pos != "0/1" {
+		t.Error("Token not set")
+	}
+
+	ctx = base.Isolation(sql.LevelRepeatableRead).PlanHints("a").PlanHints("b").BulkLane().Context()
+	if level, _ := ctx.Value(isolationKey{}).(sql.IsolationLevel); level != sql.LevelRepeatableRead {
+		t.Errorf("Unexpected read isolation: %s", level)
+	}
+	if hints := contextPlanHints(ctx); len(hints) != 2 || hints[0] != "a" || hints[1] != "b" {
+		t.Errorf("Unexpected plan hints: %v", hints)
+	}
+	if ctx.Value(bulkKey{}) == nil {
+		t.Error("Bulk lane not set")
+	}
+
+	if NewRequest(ctx).Memo().Context().Value(memoKey{}) == nil {
+		t.Error("Memo not set")
+	}
+}
@@ -0,0 +1,137 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+)
+
+// RetryPolicy configures how failed reads are retried on other physical
+// dbs, by default first on the remaining eligible slaves, then on the master.
+// QueryRow reads are retried too, since their query error is checked
+// eagerly instead of waiting for Scan. Reads fail fast with no attempts.
+type RetryPolicy struct {
+	Attempts  int              // Maximum number of retries, 0 disables retries
+	Retryable func(error) bool // Reports whether a failed read may be retried, DefaultRetryable if nil
+	Fallback  []Fallback       // Order of the physical dbs retried, DefaultFallback if empty
+}
+
+// Fallback selects physical dbs a failed read may be retried on.
+type Fallback uint8
+
+// Fallbacks of failed reads, listed in a RetryPolicy in the order they are
+// tried. Physical dbs already tried are skipped.
+const (
+	// FallbackNextSlave retries on the eligible physical db following the
+	// failed one.
+	FallbackNextSlave Fallback = iota
+
+	// FallbackEligible retries on the remaining eligible physical dbs.
+	FallbackEligible
+
+	// FallbackAnySlave retries on any slave in rotation, even those not
+	// matching the selector of the read or belonging to a tier, except
+	// delayed replicas.
+	FallbackAnySlave
+
+	// FallbackMaster retries on the master.
+	FallbackMaster
+)
+
+// DefaultFallback is the fallback order of retry policies without one.
+// Listing fallbacks without FallbackMaster protects the master from the
+// reads of failing slaves, trading availability for it.
+var DefaultFallback = []Fallback{FallbackEligible, FallbackMaster}
+
+// DefaultRetryable retries every error but the ones caused by the caller,
+// such as context cancellation or exceeded quotas.
+func DefaultRetryable(err error) bool {
+	return !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded) &&
+		!errors.Is(err, ErrQuotaExceeded) &&
+		!errors.Is(err, sql.ErrNoRows)
+}
+
+// SetReadRetryPolicy sets the policy used to retry failed reads.
+// By default, reads aren't retried.
+func (db *DB) SetReadRetryPolicy(p RetryPolicy) {
+	if p.Retryable == nil {
+		p.Retryable = DefaultRetryable
+	}
+	db.retries.Store(p)
+}
+
+// retry calls read with the physical dbs a read with ctx which failed with
+// err on node may be retried on, until it succeeds or the retry policy
+// gives up. It returns the node tried last, the number of attempts made
+// including the failed one, and the last error.
+func (db *DB) retry(ctx context.Context, node int, err error, read func(i int) error) (int, int, error) {
+	p, ok := db.retries.Load().(RetryPolicy)
+	if err == nil || !ok || p.Attempts <= 0 {
+		return node, 1, err
+	}
+
+	if _, ok := db.nodeIndex(ctx); ok {
+		return node, 1, err // Explicitly directed to node
+	}
+
+	tried := map[int]bool{node: true}
+	nodes := db.fallbackNodes(ctx, node, p.Fallback)
+
+	attempts := 0
+	for attempts < p.Attempts && p.Retryable(err) && ctx.Err() == nil {
+		if len(nodes) == 0 {
+			break
+		}
+
+		i := nodes[0]
+		if nodes = nodes[1:]; tried[i] {
+			continue
+		}
+
+		tried[i] = true
+		attempts++
+		node, err = i, read(i)
+	}
+
+	return node, 1 + attempts, err
+}
+
+// fallbackNodes returns the physical dbs a read with ctx which failed on
+// node may be retried on, in the order of fallbacks, possibly repeated.
+func (db *DB) fallbackNodes(ctx context.Context, node int, fallbacks []Fallback) []int {
+	if len(fallbacks) == 0 {
+		fallbacks = DefaultFallback
+	}
+
+	var nodes []int
+	for _, f := range fallbacks {
+		switch f {
+		case FallbackNextSlave:
+			eligible := db.eligible(ctx)
+			next := 0
+			for k, i := range eligible {
+				if i == node {
+					next = (k + 1) % len(eligible)
+				}
+			}
+			nodes = append(nodes, eligible[next])
+		case FallbackEligible:
+			nodes = append(nodes, db.readNodes(ctx)...)
+		case FallbackAnySlave:
+			var slaves []int
+			for i, n := 1, len(db.topology().pdbs); i < n; i++ {
+				if db.inRotation(i) && !db.delayed(i) {
+					slaves = append(slaves, i)
+				}
+			}
+			if len(slaves) > 0 {
+				nodes = append(nodes, rotate(slaves, int(atomic.AddUint64(&db.count, 1)%uint64(len(slaves))))...)
+			}
+		case FallbackMaster:
+			nodes = append(nodes, 0)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,140 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadRetries(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.topology().pdbs[1].Close()
+
+	var failed int
+	db.SetRouteHook(func(op Op, id QueryID, node int, d time.Duration, err error) {
+		if err != nil {
+			failed++
+		}
+	})
+
+	for i := 0; i < 4; i++ {
+		db.QueryRow("SELECT 1").Scan(new(int))
+	}
+
+	if failed == 0 {
+		t.Fatal("Expected reads on the closed slave to fail without retries")
+	}
+
+	db.SetReadRetryPolicy(RetryPolicy{Attempts: 1})
+	db.topology().pdbs[2].Close()
+
+	var n int
+	for i := 0; i < 4; i++ {
+		if err = db.QueryRow("SELECT 1").Scan(&n); err == nil {
+			t.Fatal("Expected a single retry on the other closed slave to fail")
+		}
+	}
+
+	db.SetReadRetryPolicy(RetryPolicy{Attempts: 2})
+	for i := 0; i < 4; i++ {
+		if err = db.QueryRow("SELECT 1").Scan(&n); err != nil {
+			t.Fatalf("QueryRow not retried on the master: %s", err)
+		}
+
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatalf("Query not retried on the master: %s", err)
+		}
+		rows.Close()
+	}
+
+	stmt, err := db.topology().pdbs[0].Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	s := &Stmt{db: db}
+	s.set.Store(&stmtSet{stmts: []*sql.Stmt{stmt, nil, nil}, refs: 1})
+	if err = s.QueryRow().Scan(&n); err != nil {
+		t.Errorf("Stmt QueryRow not retried on the master: %s", err)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetReadRetryPolicy(RetryPolicy{Attempts: 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	_, attempt, err := db.retry(ctx, 1, context.Canceled, func(int) error {
+		attempts++
+		return nil
+	})
+
+	if attempts != 0 || attempt != 1 || err != context.Canceled {
+		t.Errorf("Canceled read retried %d times, err: %v", attempts, err)
+	}
+}
+
+func TestFallbackNodes(t *testing.T) {
+	db := wrap(make([]*sql.DB, 5), nil)
+	db.SetLabels(4, Labels{tierLabel: "batch"})
+	ctx := context.Background()
+
+	tests := []struct {
+		fallbacks []Fallback
+		want      []int
+	}{
+		{nil, []int{2, 3, 1, 0}},
+		{[]Fallback{FallbackNextSlave, FallbackMaster}, []int{3, 0}},
+		{[]Fallback{FallbackNextSlave, FallbackAnySlave}, []int{3, 2, 3, 4, 1}},
+		{[]Fallback{FallbackMaster}, []int{0}},
+	}
+
+	for _, tt := range tests {
+		db.count = 0
+		got := db.fallbackNodes(ctx, 2, tt.fallbacks)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Unexpected fallback nodes of %v. Got: %v, Want: %v", tt.fallbacks, got, tt.want)
+		}
+	}
+}
+
+func TestRetryWithoutMaster(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.topology().pdbs[1].Close()
+	db.topology().pdbs[2].Close()
+
+	db.SetReadRetryPolicy(RetryPolicy{Attempts: 3, Fallback: []Fallback{FallbackNextSlave, FallbackAnySlave}})
+	for i := 0; i < 4; i++ {
+		row := db.QueryRow("SELECT 1")
+		if err = row.Scan(new(int)); err == nil || row.Node() == 0 {
+			t.Fatalf("Read retried on the master: %d, %v", row.Node(), err)
+		}
+	}
+
+	db.SetReadRetryPolicy(RetryPolicy{Attempts: 3, Fallback: []Fallback{FallbackNextSlave, FallbackMaster}})
+	if err = db.QueryRow("SELECT 1").Scan(new(int)); err != nil {
+		t.Errorf("Read not retried on the master: %v", err)
+	}
+}
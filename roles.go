@@ -0,0 +1,123 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRoleMismatch is returned when the roles of the physical dbs don't
+// match their order, the master first.
+var ErrRoleMismatch = errors.New("nap: roles mismatch the order of the DSNs")
+
+// RoleMismatchError describes physical dbs whose roles mismatch their order.
+// It matches ErrRoleMismatch with errors.Is.
+type RoleMismatchError struct {
+	Writable []int // Indexes of the writable physical dbs
+	Replicas []int // Indexes of the read-only physical dbs
+}
+
+// Error implements the error interface.
+func (e *RoleMismatchError) Error() string {
+	return fmt.Sprintf("%s: writable %v, replicas %v", ErrRoleMismatch, e.Writable, e.Replicas)
+}
+
+// Is reports whether target is ErrRoleMismatch.
+func (e *RoleMismatchError) Is(target error) bool {
+	return target == ErrRoleMismatch
+}
+
+// RoleProbe reports whether a physical db is writable rather than
+// a read-only replica. Physical dbs whose role can't be probed are left
+// out of role checks.
+type RoleProbe func(ctx context.Context, db *sql.DB) (bool, error)
+
+// PostgresRole is a RoleProbe of Postgres, whose standbys are in recovery.
+func PostgresRole(ctx context.Context, db *sql.DB) (bool, error) {
+	var writable bool
+	err := db.QueryRowContext(ctx, "SELECT NOT pg_is_in_recovery()").Scan(&writable)
+	return writable, err
+}
+
+// MySQLRole is a RoleProbe of MySQL, whose replicas are read only.
+func MySQLRole(ctx context.Context, db *sql.DB) (bool, error) {
+	var writable bool
+	err := db.QueryRowContext(ctx, "SELECT @@global.read_only = 0").Scan(&writable)
+	return writable, err
+}
+
+// RoleCheck configures the role check of OpenWithRoleCheck.
+type RoleCheck struct {
+	Probe   RoleProbe
+	Timeout time.Duration // Timeout of the probes, none if 0
+	Warn    func(error)   // Called with the mismatch instead of refusing to open, if set
+}
+
+// OpenWithRoleCheck is like Open, then checks the roles of the physical
+// dbs with CheckRoles, so that a swapped DSN list can't route writes to
+// a replica silently until data diverges. On mismatch, the DB is closed
+// and the *RoleMismatchError, or the error of CheckRoles, returned,
+// unless c.Warn is set, in which case c.Warn is called with it and the DB
+// returned.
+func OpenWithRoleCheck(driverName, dataSourceNames string, c RoleCheck) (*DB, error) {
+	db, err := Open(driverName, dataSourceNames)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	if err = db.CheckRoles(ctx, c.Probe); err != nil {
+		if c.Warn != nil {
+			c.Warn(err)
+			return db, nil
+		}
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// CheckRoles probes the role of every physical db concurrently, and returns
+// a *RoleMismatchError unless the master is writable and every slave a
+// read-only replica, leaving out those whose role couldn't be probed. If
+// the role of none could be, the error of the master's probe is returned.
+func (db *DB) CheckRoles(ctx context.Context, probe RoleProbe) error {
+	pdbs := db.topology().pdbs
+	writable := make([]bool, len(pdbs))
+	errs := make([]error, len(pdbs))
+	scatter(len(pdbs), func(i int) error {
+		writable[i], errs[i] = probe(ctx, pdbs[i])
+		return nil
+	})
+
+	mismatch, probed := &RoleMismatchError{}, false
+	for i := range pdbs {
+		if errs[i] != nil {
+			continue
+		}
+
+		probed = true
+		if writable[i] {
+			mismatch.Writable = append(mismatch.Writable, i)
+		} else {
+			mismatch.Replicas = append(mismatch.Replicas, i)
+		}
+	}
+
+	switch {
+	case !probed:
+		return errs[0]
+	case len(mismatch.Writable) > 1 || len(mismatch.Writable) == 1 && mismatch.Writable[0] != 0,
+		len(mismatch.Replicas) > 0 && mismatch.Replicas[0] == 0:
+		return mismatch
+	}
+	return nil
+}
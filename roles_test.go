@@ -0,0 +1,85 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sqliteRole is a RoleProbe of SQLite databases, read only once queries
+// only.
+func sqliteRole(ctx context.Context, db *sql.DB) (bool, error) {
+	var queryOnly bool
+	err := db.QueryRowContext(ctx, "PRAGMA query_only").Scan(&queryOnly)
+	return !queryOnly, err
+}
+
+func TestCheckRoles(t *testing.T) {
+	const (
+		writable = "file::memory:"
+		replica  = "file::memory:?_query_only=1"
+		unknown  = "/nonexistent/nap.db"
+	)
+
+	for _, tc := range []struct {
+		dsns string
+		want *RoleMismatchError
+	}{
+		{writable + ";" + replica + ";" + replica, nil},
+		{unknown + ";" + replica, nil},
+		{writable + ";" + unknown, nil},
+		{replica + ";" + writable, &RoleMismatchError{Writable: []int{1}, Replicas: []int{0}}},
+		{writable + ";" + writable, &RoleMismatchError{Writable: []int{0, 1}}},
+		{unknown + ";" + replica + ";" + writable, &RoleMismatchError{Writable: []int{2}, Replicas: []int{1}}},
+		{replica, &RoleMismatchError{Replicas: []int{0}}},
+	} {
+		db, err := Open("sqlite3", tc.dsns)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.CheckRoles(context.Background(), sqliteRole)
+		if tc.want == nil && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.dsns, err)
+		}
+		if tc.want != nil && (!errors.Is(err, ErrRoleMismatch) || !reflect.DeepEqual(err, tc.want)) {
+			t.Errorf("%s: unexpected mismatch. Got: %v, Want: %v", tc.dsns, err, tc.want)
+		}
+		db.Close()
+	}
+
+	db, err := Open("sqlite3", "/nonexistent/nap.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.CheckRoles(context.Background(), sqliteRole); err == nil || errors.Is(err, ErrRoleMismatch) {
+		t.Errorf("Want the probe error when no role is known, got: %v", err)
+	}
+}
+
+func TestOpenWithRoleCheck(t *testing.T) {
+	swapped := "file::memory:?_query_only=1;file::memory:"
+	if _, err := OpenWithRoleCheck("sqlite3", swapped, RoleCheck{Probe: sqliteRole}); !errors.Is(err, ErrRoleMismatch) {
+		t.Errorf("Want ErrRoleMismatch opening swapped DSNs, got: %v", err)
+	}
+
+	var warned error
+	db, err := OpenWithRoleCheck("sqlite3", swapped, RoleCheck{Probe: sqliteRole, Timeout: time.Second, Warn: func(err error) { warned = err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if !errors.Is(warned, ErrRoleMismatch) {
+		t.Errorf("Want a warning of ErrRoleMismatch, got: %v", warned)
+	}
+
+	db, err = OpenWithRoleCheck("sqlite3", "file::memory:;file::memory:?_query_only=1", RoleCheck{Probe: sqliteRole})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
@@ -0,0 +1,192 @@
+package nap
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// BalancerChange is a change of the balancing of reads rolled out with
+// DB.RollOut.
+type BalancerChange struct {
+	Policy  BalancerPolicy // Policy balancing reads once rolled out, nil for the default
+	Weights map[int]int    // Weights by index set once rolled out, on top of the current ones
+}
+
+// Rollout configures the progressive delivery of a BalancerChange.
+type Rollout struct {
+	Ramp         time.Duration // Duration over which the share of reads balanced with the change grows from none to all
+	MaxErrorRate float64       // Error rate of the reads of a slave beyond which the change is rolled back, never if 0
+	MinReads     uint64        // Reads of a slave before its error rate is judged
+	Traffic      TrafficPolicy // Share of reads balanced with the change over time, instead of Ramp if set
+}
+
+// RolloutStatus is the status of the last rollout started with DB.RollOut.
+type RolloutStatus struct {
+	Started    time.Time
+	Progress   float64 // Share of reads balanced with the change, from 0 to 1
+	Done       bool    // Whether the change was rolled out to all reads
+	RolledBack bool    // Whether the change was rolled back, or superseded by SetBalancerPolicy or a change of the physical dbs
+	Node       int     // Index of the slave whose error rate rolled the change back, or -1
+}
+
+// Rollout states.
+const (
+	rollingOut int32 = iota
+	rolledOut
+	rolledBack
+)
+
+// rollout is a BalancerChange in progress.
+type rollout struct {
+	change  BalancerChange
+	policy  BalancerPolicy // Policy of the reads balanced with the change
+	weights []int          // Weights of the reads balanced with the change, by index
+	opts    Rollout
+	start   time.Time
+	jitter  float64         // Jitter of the shift of the TrafficPolicy
+	state   int32           // Accessed atomically
+	node    int32           // Index of the slave rolling the change back, accessed atomically
+	reads   []rolloutCounts // Of the reads of each physical db during the rollout
+}
+
+type rolloutCounts struct {
+	reads, errors uint64 // Accessed atomically
+}
+
+// RollOut applies c progressively rather than instantly, so that a change
+// of the balancing of reads, such as a new policy or weights, can't
+// destabilize a loaded cluster: the share of the reads balanced with c grows
+// linearly over r.Ramp, or as r.Traffic shifts them if set, the rest being
+// balanced as before, until c applies
+// to all of them as if set with SetBalancerPolicy and SetWeight. If, during
+// the ramp, the error rate of the reads of any slave exceeds r.MaxErrorRate
+// once it served r.MinReads, c is rolled back, reads being balanced as
+// before again. Weights of c apply to reads balanced with a nil policy by
+// WeightedPolicy until rolled out. Starting a rollout, or setting a policy
+// with SetBalancerPolicy, supersedes the rollout in progress, if any, and
+// changing the physical dbs, such as with AddSlave or RemoveSlave, rolls
+// it back.
+func (db *DB) RollOut(c BalancerChange, r Rollout) {
+	t := db.topology()
+	ro := &rollout{
+		change:  c,
+		policy:  c.Policy,
+		weights: append([]int(nil), t.weights...),
+		opts:    r,
+		start:   time.Now(),
+		jitter:  2*rand.Float64() - 1,
+		node:    -1,
+		reads:   make([]rolloutCounts, len(t.pdbs)),
+	}
+	for i, w := range c.Weights {
+		if i >= 0 && i < len(ro.weights) {
+			ro.weights[i] = w
+		}
+	}
+	if ro.policy == nil && len(c.Weights) > 0 {
+		ro.policy = WeightedPolicy{}
+	}
+
+	db.mu.Lock()
+	db.abortRollout()
+	db.rollout.Store(ro)
+	db.mu.Unlock()
+}
+
+// Rollout returns the status of the last rollout started with RollOut,
+// reporting false if none was.
+func (db *DB) Rollout() (RolloutStatus, bool) {
+	db.activeRollout()
+	ro, _ := db.rollout.Load().(*rollout)
+	if ro == nil {
+		return RolloutStatus{}, false
+	}
+
+	s := RolloutStatus{Started: ro.start, Node: int(atomic.LoadInt32(&ro.node))}
+	switch atomic.LoadInt32(&ro.state) {
+	case rollingOut:
+		s.Progress = ro.progress()
+	case rolledOut:
+		s.Progress, s.Done = 1, true
+	case rolledBack:
+		s.RolledBack = true
+	}
+	return s, true
+}
+
+// activeRollout returns the rollout in progress, if any, rolling it out to
+// all reads once its ramp is over.
+func (db *DB) activeRollout() *rollout {
+	ro, _ := db.rollout.Load().(*rollout)
+	if ro == nil || atomic.LoadInt32(&ro.state) != rollingOut {
+		return nil
+	}
+
+	if ro.progress() < 1 {
+		return ro
+	}
+
+	db.mu.Lock()
+	done := atomic.CompareAndSwapInt32(&ro.state, rollingOut, rolledOut)
+	if done {
+		db.balancer.Store(balancerPolicy{ro.change.Policy})
+	}
+	db.mu.Unlock()
+
+	if done {
+		for i, w := range ro.change.Weights {
+			db.SetWeight(i, w)
+		}
+	}
+	return nil
+}
+
+// abortRollout rolls back the rollout in progress, if any. db.mu must be
+// held.
+func (db *DB) abortRollout() {
+	if ro, _ := db.rollout.Load().(*rollout); ro != nil {
+		atomic.CompareAndSwapInt32(&ro.state, rollingOut, rolledBack)
+	}
+}
+
+// progress returns the share of reads balanced with the change of ro.
+func (ro *rollout) progress() float64 {
+	p := ro.opts.Traffic
+	if p == nil {
+		p = LinearRamp{Duration: ro.opts.Ramp}
+	}
+	return share(p, TrafficShift{Node: -1, Elapsed: time.Since(ro.start), Jitter: ro.jitter})
+}
+
+// balancing returns the policy and weights of a read balanced during ro.
+func (ro *rollout) balancing() (BalancerPolicy, []int, bool) {
+	if rand.Float64() < ro.progress() {
+		return ro.policy, ro.weights, true
+	}
+	return nil, nil, false
+}
+
+// observeRollout accounts for the result of a read on the slave at index
+// i in the rollout in progress, rolling it back if the slave is failing.
+func (db *DB) observeRollout(i int, err error) {
+	ro := db.activeRollout()
+	if ro == nil || i <= 0 || i >= len(ro.reads) || err == context.Canceled {
+		return
+	}
+
+	c := &ro.reads[i]
+	reads := atomic.AddUint64(&c.reads, 1)
+	errors := atomic.LoadUint64(&c.errors)
+	if err != nil {
+		errors = atomic.AddUint64(&c.errors, 1)
+	}
+
+	max := ro.opts.MaxErrorRate
+	if max > 0 && reads >= ro.opts.MinReads && float64(errors)/float64(reads) > max {
+		if atomic.CompareAndSwapInt32(&ro.state, rollingOut, rolledBack) {
+			atomic.StoreInt32(&ro.node, int32(i))
+		}
+	}
+}
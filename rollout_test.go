@@ -0,0 +1,122 @@
+package nap
+
+import (
+	"testing"
+	"time"
+)
+
+// lastPolicy is a BalancerPolicy picking the last candidate.
+type lastPolicy struct{}
+
+func (lastPolicy) Pick(candidates []Candidate) int {
+	return len(candidates) - 1
+}
+
+// firstPolicy is a BalancerPolicy picking the first candidate.
+type firstPolicy struct{}
+
+func (firstPolicy) Pick(candidates []Candidate) int {
+	return 0
+}
+
+func TestRollOut(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, ok := db.Rollout(); ok {
+		t.Error("Rollout status before any rollout")
+	}
+
+	db.SetBalancerPolicy(firstPolicy{})
+	db.RollOut(BalancerChange{Policy: lastPolicy{}}, Rollout{Ramp: 100 * time.Millisecond, MaxErrorRate: 0.5})
+	if s, ok := db.Rollout(); !ok || s.Done || s.RolledBack || s.Progress >= 1 {
+		t.Errorf("Unexpected rollout status: %+v", s)
+	}
+
+	reads := map[int]int{}
+	for start := time.Now(); time.Since(start) < 80*time.Millisecond; time.Sleep(time.Millisecond) {
+		row := db.QueryRow("SELECT 1")
+		if err = row.Scan(new(int)); err != nil {
+			t.Fatal(err)
+		}
+		reads[row.Node()]++
+	}
+	if reads[1] == 0 || reads[2] == 0 {
+		t.Errorf("Reads not shared during the ramp: %v", reads)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if s, _ := db.Rollout(); !s.Done || s.Progress != 1 || s.Node != -1 {
+		t.Errorf("Unexpected rollout status once ramped up: %+v", s)
+	}
+	for k := 0; k < 10; k++ {
+		if row := db.QueryRow("SELECT 1"); row.Scan(new(int)) != nil || row.Node() != 2 {
+			t.Fatalf("Read on %d once rolled out", row.Node())
+		}
+	}
+
+	// Weights apply once rolled out, with the policy of the change.
+	db.RollOut(BalancerChange{Weights: map[int]int{2: 0}}, Rollout{})
+	if s, _ := db.Rollout(); !s.Done || db.Weight(2) != 0 {
+		t.Errorf("Weights not rolled out: %+v, %d", s, db.Weight(2))
+	}
+	for k := 0; k < 10; k++ {
+		if row := db.QueryRow("SELECT 1"); row.Scan(new(int)) != nil || row.Node() != 1 {
+			t.Fatalf("Read on %d once weights rolled out", row.Node())
+		}
+	}
+
+	db.RollOut(BalancerChange{Policy: lastPolicy{}}, Rollout{Ramp: time.Hour})
+	db.SetBalancerPolicy(firstPolicy{})
+	if s, _ := db.Rollout(); !s.RolledBack || s.Node != -1 {
+		t.Errorf("Rollout not superseded: %+v", s)
+	}
+}
+
+func TestRollOutRollBack(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;/nonexistent/nap.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetBalancerPolicy(firstPolicy{})
+	db.RollOut(BalancerChange{Policy: lastPolicy{}}, Rollout{Ramp: 50 * time.Millisecond, MaxErrorRate: 0.5, MinReads: 2})
+
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; time.Sleep(time.Millisecond) {
+		db.QueryRow("SELECT 1").Scan(new(int))
+	}
+
+	if s, _ := db.Rollout(); !s.RolledBack || s.Node != 2 || s.Done {
+		t.Errorf("Unexpected rollout status of a failing slave: %+v", s)
+	}
+	time.Sleep(10 * time.Millisecond)
+	for k := 0; k < 10; k++ {
+		if row := db.QueryRow("SELECT 1"); row.Scan(new(int)) != nil || row.Node() != 1 {
+			t.Fatalf("Read on %d once rolled back", row.Node())
+		}
+	}
+}
+
+func TestRollOutMembership(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.RollOut(BalancerChange{Weights: map[int]int{2: 0}}, Rollout{Ramp: time.Hour, MaxErrorRate: 0.5})
+	if err = db.RemoveSlave(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, _ := db.Rollout(); !s.RolledBack || s.Done || s.Node != -1 {
+		t.Errorf("Unexpected rollout status once a slave was removed: %+v", s)
+	}
+	if w := db.Weight(1); w != 1 {
+		t.Errorf("Weight of the shifted slave changed: %d", w)
+	}
+}
@@ -0,0 +1,208 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// Rows is the result of a query. It embeds *sql.Rows, so it is a drop-in
+// replacement for it, and also tells which physical db served the query.
+// The close hook set with DB.SetCloseHook, if any, is called on Close,
+// which must be called once done with the rows.
+type Rows struct {
+	*sql.Rows
+	result
+	guard *guard // ResultGuard of the physical db, if any
+}
+
+// Next prepares the next result row for reading with Scan, like
+// (*sql.Rows).Next. It returns false once the rows exceed the ResultGuard
+// aborting them.
+func (r *Rows) Next() bool {
+	if r.guard == nil {
+		return r.Rows.Next()
+	}
+
+	if !r.guard.check(r.ctx, r.Rows) || !r.Rows.Next() {
+		return false
+	}
+
+	r.guard.progress.Rows++
+	return r.guard.check(r.ctx, r.Rows)
+}
+
+// Scan copies the columns in the current row into the values pointed at
+// by dest, like (*sql.Rows).Scan.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.guard == nil {
+		return r.Rows.Scan(dest...)
+	}
+
+	if r.guard.aborted != nil {
+		return r.guard.aborted
+	}
+
+	err := r.Rows.Scan(dest...)
+	if err == nil {
+		r.guard.scanned(dest)
+		r.guard.check(r.ctx, r.Rows)
+	}
+	return err
+}
+
+// Err returns the error, if any, that was encountered during iteration,
+// like (*sql.Rows).Err, or the *BigResultError of rows aborted by their
+// ResultGuard.
+func (r *Rows) Err() error {
+	if r.guard != nil && r.guard.aborted != nil {
+		return r.guard.aborted
+	}
+	return r.Rows.Err()
+}
+
+// Close closes the rows, calling the close hook on the first call.
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.done(r.Err())
+	return err
+}
+
+// Row is the result of calling QueryRow to select a single row.
+// It behaves like *sql.Row and also tells which physical db served the query.
+// The close hook set with DB.SetCloseHook, if any, is called on Scan.
+type Row struct {
+	row     *sql.Row
+	recheck func() *sql.Row // Runs the query on the master if no rows
+	result
+}
+
+// Scan copies the columns from the matched row into the values pointed at
+// by dest, like (*sql.Row).Scan. If more than one row matches the query,
+// Scan uses the first row and discards the rest. If no row matches the
+// query, Scan returns sql.ErrNoRows, unless the query is checked again on
+// the master as set with DB.SetNoRowsRecheck.
+func (r *Row) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if err == sql.ErrNoRows && r.recheck != nil {
+		r.row, r.node, r.recheck = r.recheck(), 0, nil
+		err = r.row.Scan(dest...)
+	}
+	r.done(err)
+	return err
+}
+
+// Err provides a way to check for query errors without calling Scan.
+// Err returns the error, if any, that was encountered while running the query.
+// If this error is not nil, this error will also be returned from Scan.
+func (r *Row) Err() error {
+	return r.row.Err()
+}
+
+// result tracks a read until its rows are released.
+// It is kept small since it is allocated on every read.
+type result struct {
+	db      *DB
+	ctx     context.Context
+	sql     string
+	op      Op
+	args    int32
+	fargs   string // Formatted args of the close hook
+	attempt int32
+	node    int
+	start   time.Time
+	cancel  context.CancelFunc // Releases the statement timeout, if any
+	elapsed int64              // Nanoseconds until released, set once
+}
+
+// Node returns the index of the physical db which served the query.
+func (r *result) Node() int {
+	return r.node
+}
+
+// Elapsed returns the time elapsed since the query started until the
+// rows were released, or until now if they weren't yet.
+func (r *result) Elapsed() time.Duration {
+	if d := atomic.LoadInt64(&r.elapsed); d > 0 {
+		return time.Duration(d)
+	}
+	return time.Since(r.start)
+}
+
+// done releases the read once its rows were consumed with err.
+// Only the first call has effect.
+func (r *result) done(err error) {
+	d := time.Since(r.start)
+	if d <= 0 {
+		d = 1
+	}
+
+	if !atomic.CompareAndSwapInt64(&r.elapsed, 0, int64(d)) {
+		return
+	}
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	id, _ := r.ctx.Value(queryIDKey{}).(QueryID)
+	if hook, _ := r.db.closeHook.Load().(RouteHook); hook != nil {
+		hook(r.op, id, r.node, d, err)
+	}
+
+	if hook, _ := r.db.closeQuery.Load().(QueryHook); hook != nil {
+		info := QueryInfo{
+			Op:            r.op,
+			ID:            id,
+			SQL:           r.sql,
+			Args:          int(r.args),
+			FormattedArgs: r.fargs,
+			Node:          r.node,
+			Attempt:       int(r.attempt),
+			Duration:      d,
+			Err:           err,
+		}
+		hook(r.ctx, describe(r.ctx, &info))
+	}
+}
+
+// SetCloseHook sets the hook called when the rows of a read are released,
+// by Rows.Close or Row.Scan, with the total duration of the read including
+// the time spent consuming its rows. If fn is nil, no hook is called.
+func (db *DB) SetCloseHook(fn RouteHook) {
+	db.closeHook.Store(fn)
+}
+
+// SetCloseQueryHook is like SetCloseHook for a QueryHook, called with
+// a QueryInfo describing the read along the hook set with SetCloseHook.
+func (db *DB) SetCloseQueryHook(fn QueryHook) {
+	db.closeQuery.Store(fn)
+}
+
+// newRows wraps the rows of a read with ctx described by info which
+// started at start.
+func (db *DB) newRows(ctx context.Context, rows *sql.Rows, info *QueryInfo, start time.Time, cancel context.CancelFunc) *Rows {
+	return &Rows{Rows: rows, result: db.newResult(ctx, info, start, cancel), guard: db.resultGuard(info.Node)}
+}
+
+// newRow wraps the row of a read with ctx described by info which
+// started at start.
+func (db *DB) newRow(ctx context.Context, row *sql.Row, info *QueryInfo, start time.Time, cancel context.CancelFunc) *Row {
+	return &Row{row: row, result: db.newResult(ctx, info, start, cancel)}
+}
+
+func (db *DB) newResult(ctx context.Context, info *QueryInfo, start time.Time, cancel context.CancelFunc) result {
+	return result{
+		db:      db,
+		ctx:     ctx,
+		sql:     info.SQL,
+		op:      info.Op,
+		args:    int32(info.Args),
+		fargs:   info.FormattedArgs,
+		attempt: int32(info.Attempt),
+		node:    info.Node,
+		start:   start,
+		cancel:  cancel,
+	}
+}
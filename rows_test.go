@@ -0,0 +1,108 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRows(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type call struct {
+		op   Op
+		node int
+		err  error
+	}
+
+	var calls []call
+	db.SetCloseHook(func(op Op, id QueryID, node int, d time.Duration, err error) {
+		calls = append(calls, call{op, node, err})
+	})
+
+	var queries []string
+	db.SetCloseQueryHook(func(ctx context.Context, info QueryInfo) {
+		queries = append(queries, info.SQL)
+	})
+
+	rows, err := db.Query("SELECT 1 UNION ALL SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rows.Node() != 1 {
+		t.Errorf("Unexpected node. Got: %d, Want: 1", rows.Node())
+	}
+
+	var n int
+	for rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n != 2 {
+		t.Errorf("Unexpected last row. Got: %d, Want: 2", n)
+	}
+
+	if len(calls) != 0 {
+		t.Fatalf("Close hook called before Close: %v", calls)
+	}
+
+	rows.Close()
+	rows.Close()
+	if elapsed := rows.Elapsed(); elapsed != rows.Elapsed() {
+		t.Errorf("Elapsed still running after Close")
+	}
+
+	if err := db.QueryRow("SELECT 1 WHERE 0").Scan(&n); err != sql.ErrNoRows {
+		t.Errorf("Unexpected error. Got: %v, Want: %v", err, sql.ErrNoRows)
+	}
+
+	want := []call{{OpQuery, 1, nil}, {OpQueryRow, 1, sql.ErrNoRows}}
+	if len(calls) != len(want) {
+		t.Fatalf("Unexpected hook calls. Got: %v, Want: %v", calls, want)
+	}
+
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("Unexpected hook call %d. Got: %v, Want: %v", i, calls[i], want[i])
+		}
+	}
+
+	if wantSQL := []string{"SELECT 1 UNION ALL SELECT 2", "SELECT 1 WHERE 0"}; !reflect.DeepEqual(queries, wantSQL) {
+		t.Errorf("Unexpected close query hook calls. Got: %q, Want: %q", queries, wantSQL)
+	}
+}
+
+func TestRowsReleaseTimeout(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithStatementTimeout(context.Background(), time.Hour)
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if rows.ctx.Err() != context.Canceled {
+		t.Errorf("Statement timeout not released on Close. Got: %v", rows.ctx.Err())
+	}
+
+	row := db.QueryRowContext(ctx, "SELECT 1")
+	row.Scan(new(int))
+
+	if row.ctx.Err() != context.Canceled {
+		t.Errorf("Statement timeout not released on Scan. Got: %v", row.ctx.Err())
+	}
+}
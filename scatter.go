@@ -1,17 +1,99 @@
 package nap
 
-func scatter(n int, fn func(i int) error) (err error) {
-	errors := make(chan error, n)
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NodeError is the failure of an aggregate operation on a physical db.
+type NodeError struct {
+	Index int
+	Role  string // "master" or "slave"
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("nap: %s %d: %v", e.Role, e.Index, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError lists the failures of an aggregate operation run on every
+// physical db, such as Open, Close, Ping or Prepare, in index order.
+type MultiError struct {
+	Errors []*NodeError
+}
+
+// Error implements the error interface.
+func (e *MultiError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	msgs := make([]string, len(e.Errors))
+	for k, err := range e.Errors {
+		msgs[k] = fmt.Sprintf("%s %d: %v", err.Role, err.Index, err.Err)
+	}
+	return fmt.Sprintf("nap: %d physical dbs failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any failure matches target.
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first failure matching target, as errors.As.
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// multiError returns a *MultiError of the failures of errs by index, or nil
+// if none.
+func multiError(errs []error) error {
+	var m MultiError
+	for i, err := range errs {
+		if err != nil {
+			m.Errors = append(m.Errors, &NodeError{Index: i, Role: roleLabels(i)["role"], Err: err})
+		}
+	}
+
+	if len(m.Errors) == 0 {
+		return nil
+	}
+	return &m
+}
+
+// scatter calls fn concurrently for the n physical dbs, returning the
+// *MultiError of those failing, if any.
+func scatter(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	done := make(chan struct{}, n)
 
 	for i := 0; i < n; i++ {
-		go func(i int) { errors <- fn(i) }(i)
+		go func(i int) {
+			errs[i] = fn(i)
+			done <- struct{}{}
+		}(i)
 	}
 
 	for i := 0; i < n; i++ {
-		if innerErr := <-errors; innerErr != nil {
-			err = innerErr
-		}
+		<-done
 	}
 
-	return err
+	return multiError(errs)
 }
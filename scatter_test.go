@@ -1,6 +1,8 @@
 package nap
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"testing"
@@ -18,8 +20,18 @@ func TestScatter(t *testing.T) {
 		return fmt.Errorf("%d is an odd fellow", seq[i])
 	})
 
-	if err == nil {
-		t.Fatal("Expected error, got nil")
+	var m *MultiError
+	if !errors.As(err, &m) || len(m.Errors) != 4 {
+		t.Fatalf("Expected the errors of the odd fellows, got: %v", err)
+	}
+	for k, err := range m.Errors {
+		role := "slave"
+		if k == 0 {
+			role = "master"
+		}
+		if err.Index != 2*k || err.Role != role {
+			t.Errorf("Unexpected error: %+v", err)
+		}
 	}
 
 	want := []int{1, 4, 3, 16, 5, 36, 7, 64}
@@ -29,3 +41,26 @@ func TestScatter(t *testing.T) {
 		}
 	}
 }
+
+func TestMultiError(t *testing.T) {
+	drill := &NodeError{Index: 0, Role: "master", Err: ErrDrill}
+	frozen := &NodeError{Index: 1, Role: "slave", Err: &FrozenError{}}
+
+	if err := (&MultiError{Errors: []*NodeError{drill}}); err.Error() != "nap: master 0: "+ErrDrill.Error() {
+		t.Errorf("Unexpected message of a single failure: %q", err)
+	}
+
+	err := error(&MultiError{Errors: []*NodeError{drill, frozen}})
+	if want := "nap: 2 physical dbs failed: master 0: " + ErrDrill.Error() + "; slave 1: " + frozen.Err.Error(); err.Error() != want {
+		t.Errorf("Unexpected message. Got: %q, Want: %q", err, want)
+	}
+
+	if !errors.Is(err, ErrDrill) || !errors.Is(err, ErrFrozen) || errors.Is(err, context.Canceled) {
+		t.Error("Unexpected matches of the failures")
+	}
+
+	var f *FrozenError
+	if !errors.As(err, &f) || f != frozen.Err {
+		t.Errorf("Failure not found: %v", f)
+	}
+}
@@ -0,0 +1,143 @@
+package nap
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// latencyAlpha is the smoothing factor of read latencies.
+const latencyAlpha = 0.2
+
+// scoreLatency is the read latency halving the DefaultScore of a node.
+const scoreLatency = 10 * time.Millisecond
+
+// NodeSignals are the signals a ScoreFunc scores a physical db from.
+type NodeSignals struct {
+	Index      int           // Index of the physical db
+	Health     float64       // Smoothed health check success rate, between 0 and 1
+	Lag        time.Duration // Replication lag probed with DB.SetLagProbe, negative if unknown
+	Latency    time.Duration // Smoothed latency of reads, 0 if unknown
+	Saturation float64       // Fraction of the max open connections in use, 0 if unlimited
+}
+
+// ScoreFunc scores a physical db from its signals. Scores are positive or
+// zero, higher scores receiving more reads. Implementations must be safe
+// for concurrent use.
+type ScoreFunc func(NodeSignals) float64
+
+// DefaultScore is the ScoreFunc combining all signals as
+//
+//	Health × (1 - Saturation) / (1 + Lag in seconds) / (1 + Latency / 10ms)
+//
+// so that a slave a second behind, or 10ms slower, gets half the reads.
+func DefaultScore(s NodeSignals) float64 {
+	score := s.Health * (1 - s.Saturation)
+	if s.Lag > 0 {
+		score /= 1 + s.Lag.Seconds()
+	}
+	if s.Latency > 0 {
+		score /= 1 + float64(s.Latency)/float64(scoreLatency)
+	}
+	return score
+}
+
+// SetScoring sets the ScoreFunc balancing reads without a selector, such
+// as DefaultScore. Slaves in rotation are then picked at random with a
+// probability proportional to their score times their weight, unless a
+// BalancerPolicy is set, whose candidates carry their score instead. If
+// fn is nil, reads aren't balanced by score, which is the default.
+func (db *DB) SetScoring(fn ScoreFunc) {
+	db.scoring.Store(fn)
+}
+
+// Signals returns the signals of the physical db at index i.
+func (db *DB) Signals(i int) NodeSignals {
+	s := NodeSignals{
+		Index:   i,
+		Health:  db.HealthScore(i),
+		Lag:     db.Lag(i),
+		Latency: time.Duration(atomic.LoadInt64(&db.health(i).latency)),
+	}
+
+	if pdb := db.topology().pdb(i); pdb != nil {
+		if stats := pdb.Stats(); stats.MaxOpenConnections > 0 {
+			s.Saturation = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+		}
+	}
+	return s
+}
+
+// Score returns the score of the physical db at index i, with the ScoreFunc
+// set with SetScoring, or DefaultScore if none.
+func (db *DB) Score(i int) float64 {
+	fn, _ := db.scoring.Load().(ScoreFunc)
+	if fn == nil {
+		fn = DefaultScore
+	}
+	return fn(db.Signals(i))
+}
+
+// scoreOf returns the score of the physical db at index i with fn, if set.
+func (db *DB) scoreOf(fn ScoreFunc, i int) float64 {
+	if fn == nil {
+		return 0
+	}
+	if score := fn(db.Signals(i)); score > 0 {
+		return score
+	}
+	return 0
+}
+
+// scored returns the index of the slave picked by score, if scoring is set
+// and any balanced slave has a positive score.
+func (db *DB) scored() (int, bool) {
+	fn, _ := db.scoring.Load().(ScoreFunc)
+	if fn == nil {
+		return 0, false
+	}
+
+	t := db.topology()
+	scores := make([]float64, len(t.pdbs))
+	total := 0.0
+	for i := 1; i < len(t.pdbs); i++ {
+		if db.balanced(i) {
+			scores[i] = db.scoreOf(fn, i) * float64(t.weights[i])
+			total += scores[i]
+		}
+	}
+
+	if total <= 0 {
+		return 0, false
+	}
+
+	n := rand.Float64() * total
+	last := 0
+	for i, score := range scores {
+		if score <= 0 {
+			continue
+		}
+		if n -= score; n < 0 {
+			return i, true
+		}
+		last = i
+	}
+	return last, true
+}
+
+// recordLatency smooths the latency of a read which took d on the physical
+// db at index node.
+func (db *DB) recordLatency(node int, d time.Duration) {
+	t := db.topology()
+	if node < 0 || node >= len(t.healths) {
+		return
+	}
+
+	h := t.healths[node]
+	old := atomic.LoadInt64(&h.latency)
+	if old == 0 {
+		atomic.StoreInt64(&h.latency, int64(d))
+		return
+	}
+	atomic.StoreInt64(&h.latency, int64(latencyAlpha*float64(d)+(1-latencyAlpha)*float64(old)))
+}
@@ -0,0 +1,77 @@
+package nap
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDefaultScore(t *testing.T) {
+	for _, tt := range []struct {
+		signals NodeSignals
+		score   float64
+	}{
+		{NodeSignals{Health: 1, Lag: -1}, 1},
+		{NodeSignals{Health: 0.5}, 0.5},
+		{NodeSignals{Health: 1, Lag: time.Second}, 0.5},
+		{NodeSignals{Health: 1, Latency: 10 * time.Millisecond}, 0.5},
+		{NodeSignals{Health: 1, Saturation: 0.75}, 0.25},
+		{NodeSignals{Health: 1, Saturation: 1}, 0},
+	} {
+		if score := DefaultScore(tt.signals); math.Abs(score-tt.score) > 1e-9 {
+			t.Errorf("Unexpected score of %+v: %v", tt.signals, score)
+		}
+	}
+}
+
+func TestScoring(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if s := db.Signals(rows.Node()); s.Latency <= 0 || s.Health != 1 || s.Lag >= 0 {
+		t.Errorf("Unexpected signals: %+v", s)
+	}
+
+	db.SetScoring(func(s NodeSignals) float64 {
+		return float64(s.Index - 1)
+	})
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(context.Background()); n != 2 {
+			t.Fatalf("Read routed to %d instead of the only slave scored", n)
+		}
+	}
+
+	state := db.BalancerState()
+	if state.Policy != "scored" || state.Nodes[1].Score != 0 || state.Nodes[2].Score != 1 {
+		t.Errorf("Unexpected balancer state: %+v", state)
+	}
+
+	db.SetBalancerPolicy(fixedPolicy{index: 1})
+	if n := db.readIndex(context.Background()); n != 1 {
+		t.Errorf("Read routed to %d instead of the slave picked by the policy", n)
+	}
+	db.SetBalancerPolicy(nil)
+
+	db.SetScoring(func(NodeSignals) float64 { return 0 })
+	counts := map[int]int{}
+	for i := 0; i < 10; i++ {
+		counts[db.readIndex(context.Background())]++
+	}
+	if counts[1] != 5 || counts[2] != 5 {
+		t.Errorf("Reads not balanced by round-robin without scores: %v", counts)
+	}
+
+	db.SetScoring(nil)
+	if p := db.BalancerState().Policy; p != "round-robin" {
+		t.Errorf("Unexpected policy: %s", p)
+	}
+}
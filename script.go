@@ -0,0 +1,166 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ScriptError reports the statement of a script that failed.
+type ScriptError struct {
+	Index     int    // Zero based index of the statement in the script
+	Line      int    // Line of the statement start, starting at 1
+	Column    int    // Column of the statement start in bytes, starting at 1
+	Statement string // Statement text
+	Err       error  // Underlying error
+}
+
+// Error implements the error interface.
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("nap: statement %d at line %d, column %d: %s", e.Index+1, e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// ExecScript splits script into statements on semicolons and executes
+// them in order on the master, stopping at the first failing one which
+// is reported as a *ScriptError.
+// Semicolons inside quoted strings and identifiers, comments and
+// dollar-quoted bodies don't split statements.
+func (db *DB) ExecScript(ctx context.Context, script string) error {
+	if n := db.successor(); n != nil {
+		return n.ExecScript(ctx, script)
+	}
+
+	if err := db.writable(); err != nil {
+		return err
+	}
+	return execScript(ctx, db.Master(), script)
+}
+
+// ExecScriptTx is like ExecScript but runs the whole script within a
+// single transaction on the master, which is rolled back on failure.
+// The provided TxOptions is optional and may be nil if defaults should be used.
+func (db *DB) ExecScriptTx(ctx context.Context, script string, opts *sql.TxOptions) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if err = execScript(ctx, tx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func execScript(ctx context.Context, ex execer, script string) error {
+	for i, stmt := range splitScript(script) {
+		if _, err := ex.ExecContext(ctx, stmt.text); err != nil {
+			line := 1 + strings.Count(script[:stmt.offset], "\n")
+			column := stmt.offset - strings.LastIndex(script[:stmt.offset], "\n")
+			return &ScriptError{Index: i, Line: line, Column: column, Statement: stmt.text, Err: err}
+		}
+	}
+	return nil
+}
+
+type scriptStmt struct {
+	text   string
+	offset int // Byte offset of text in the script
+}
+
+// splitScript splits script into its non empty statements.
+func splitScript(script string) (stmts []scriptStmt) {
+	start := 0
+	emit := func(end int) {
+		text := strings.TrimSpace(script[start:end])
+		if text != "" && !onlyComments(text) {
+			offset := start + strings.Index(script[start:end], text)
+			stmts = append(stmts, scriptStmt{text: text, offset: offset})
+		}
+		start = end + 1
+	}
+
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == ';':
+			emit(i)
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(script, i, c)
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			i = skipUntil(script, i, "\n")
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			i = skipUntil(script, i+1, "*/")
+		case c == '$':
+			if tag := dollarTag(script[i:]); tag != "" {
+				i = skipUntil(script, i+len(tag)-1, tag)
+			}
+		}
+	}
+	emit(len(script))
+
+	return stmts
+}
+
+// skipQuoted returns the index of the quote closing the one at i.
+// Doubled quotes are escapes.
+func skipQuoted(s string, i int, quote byte) int {
+	for i++; i < len(s); i++ {
+		if s[i] == quote {
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(s) - 1
+}
+
+// skipUntil returns the index of the last byte of the first occurrence of
+// delim in s after i, or the index of the last byte of s.
+func skipUntil(s string, i int, delim string) int {
+	if j := strings.Index(s[i+1:], delim); j >= 0 {
+		return i + j + len(delim)
+	}
+	return len(s) - 1
+}
+
+// dollarTag returns the dollar quote tag s starts with, such as $$ or $body$.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 1 && '0' <= c && c <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+func onlyComments(text string) bool {
+	for text != "" {
+		switch {
+		case strings.HasPrefix(text, "--"):
+			text = text[skipUntil(text, 0, "\n")+1:]
+		case strings.HasPrefix(text, "/*"):
+			text = text[skipUntil(text, 1, "*/")+1:]
+		default:
+			return false
+		}
+		text = strings.TrimSpace(text)
+	}
+	return true
+}
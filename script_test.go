@@ -0,0 +1,69 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitScript(t *testing.T) {
+	script := `CREATE TABLE t (a TEXT); -- first; comment
+INSERT INTO t VALUES ('a;b'), ('it''s;');
+/* block; comment */ INSERT INTO "we;ird" VALUES (1);
+CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;
+SELECT $1, $$;$$;
+-- trailing comment only
+;;`
+
+	want := []string{
+		"CREATE TABLE t (a TEXT)",
+		"-- first; comment\nINSERT INTO t VALUES ('a;b'), ('it''s;')",
+		`/* block; comment */ INSERT INTO "we;ird" VALUES (1)`,
+		"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql",
+		"SELECT $1, $$;$$",
+	}
+
+	var got []string
+	for _, stmt := range splitScript(script) {
+		if script[stmt.offset:stmt.offset+len(stmt.text)] != stmt.text {
+			t.Errorf("Wrong offset %d for statement %q", stmt.offset, stmt.text)
+		}
+		got = append(got, stmt.text)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected statements.\nGot:  %q\nWant: %q", got, want)
+	}
+}
+
+func TestExecScript(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	err = db.ExecScript(ctx, "CREATE TABLE t (a TEXT);\nINSERT INTO t VALUES ('x;y');")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.ExecScriptTx(ctx, "INSERT INTO t VALUES ('z');\n  INSERT INTO nope VALUES (1);", nil)
+
+	var serr *ScriptError
+	if !errors.As(err, &serr) {
+		t.Fatalf("Want *ScriptError, got: %v", err)
+	}
+
+	if serr.Index != 1 || serr.Line != 2 || serr.Column != 3 {
+		t.Errorf("Wrong error position: %+v", serr)
+	}
+
+	var n int
+	if err = db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil || n != 1 {
+		t.Errorf("Transaction not rolled back. Got %d rows, err: %v", n, err)
+	}
+}
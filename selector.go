@@ -0,0 +1,390 @@
+package nap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Labels are key value pairs describing a physical db, such as its
+// zone or replication tier. The "role" label is always set to either
+// "master" or "slave".
+type Labels map[string]string
+
+// Requirement is a single constraint on a label.
+type Requirement struct {
+	Key   string
+	Value string
+	Not   bool // Requires the label to be absent or to have a different value
+}
+
+// Matches reports whether labels satisfy r.
+func (r Requirement) Matches(labels Labels) bool {
+	return (labels[r.Key] == r.Value) != r.Not
+}
+
+// String returns r in the same form accepted by ParseSelector.
+func (r Requirement) String() string {
+	if r.Not {
+		return r.Key + "!=" + r.Value
+	}
+	return r.Key + "=" + r.Value
+}
+
+// Selector selects physical dbs whose labels satisfy all of its requirements.
+// An empty Selector matches every physical db.
+type Selector []Requirement
+
+// ParseSelector parses a comma separated list of key=value or key!=value
+// requirements, such as "role=slave,zone=eu-west-1,tier!=async".
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		var r Requirement
+		i := strings.Index(part, "=")
+		switch {
+		case i <= 0:
+			return nil, fmt.Errorf("nap: invalid selector requirement %q", part)
+		case part[i-1] == '!':
+			r = Requirement{Key: part[:i-1], Value: part[i+1:], Not: true}
+		default:
+			r = Requirement{Key: part[:i], Value: strings.TrimPrefix(part[i+1:], "=")}
+		}
+
+		r.Key, r.Value = strings.TrimSpace(r.Key), strings.TrimSpace(r.Value)
+		if r.Key == "" {
+			return nil, fmt.Errorf("nap: invalid selector requirement %q", part)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// MustParseSelector is like ParseSelector but panics on invalid input.
+func MustParseSelector(s string) Selector {
+	sel, err := ParseSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// Matches reports whether labels satisfy every requirement of s.
+func (s Selector) Matches(labels Labels) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns s in the same form accepted by ParseSelector.
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (s Selector) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface so
+// selectors can be read straight from configuration files.
+func (s *Selector) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseSelector(string(text))
+	return err
+}
+
+type selectorKey struct{}
+
+// WithSelector returns a copy of ctx which directs reads to the physical
+// dbs matching sel, overriding the selector set with DB.SetReadSelector.
+// If no physical db matches, reads are routed as if no selector was set.
+func WithSelector(ctx context.Context, sel Selector) context.Context {
+	return context.WithValue(ctx, selectorKey{}, sel)
+}
+
+// SetLabels sets the labels of the physical db at index i.
+// The "role" label can't be overridden.
+func (db *DB) SetLabels(i int, labels Labels) {
+	db.updateLabels(i, func(old Labels) Labels { return labels })
+}
+
+// updateLabels replaces the labels of the physical db at index i
+// with the ones returned by fn, which must not modify old.
+func (db *DB) updateLabels(i int, fn func(old Labels) Labels) {
+	db.updateTopology(func(t *topology) error {
+		if i >= len(t.labels) {
+			return nil
+		}
+
+		labels := fn(t.labels[i])
+		t.labels[i] = make(Labels, len(labels)+1)
+		for k, v := range labels {
+			t.labels[i][k] = v
+		}
+		t.labels[i]["role"] = roleLabels(i)["role"]
+		return nil
+	})
+}
+
+// setLabel sets a single label of the physical db at index i,
+// removing it if value is empty.
+func (db *DB) setLabel(i int, key, value string) {
+	db.updateLabels(i, func(old Labels) Labels {
+		labels := Labels{}
+		for k, v := range old {
+			labels[k] = v
+		}
+
+		if value != "" {
+			labels[key] = value
+		} else {
+			delete(labels, key)
+		}
+		return labels
+	})
+}
+
+// Labels returns a copy of the labels of the physical db at index i.
+func (db *DB) Labels(i int) Labels {
+	labels := Labels{}
+	for k, v := range db.labelsOf(i) {
+		labels[k] = v
+	}
+	return labels
+}
+
+var (
+	masterLabels = Labels{"role": "master"}
+	slaveLabels  = Labels{"role": "slave"}
+)
+
+func roleLabels(i int) Labels {
+	if i == 0 {
+		return masterLabels
+	}
+	return slaveLabels
+}
+
+// labelsOf returns the labels of the physical db at index i,
+// which must not be modified.
+func (db *DB) labelsOf(i int) Labels {
+	if t := db.topology(); i >= 0 && i < len(t.labels) {
+		return t.labels[i]
+	}
+	return roleLabels(i)
+}
+
+// SetReadSelector sets the default selector used to direct reads
+// that don't carry one in their context. By default, reads go to slaves.
+func (db *DB) SetReadSelector(sel Selector) {
+	db.selector.Store(sel)
+}
+
+// readSelector returns the selector that applies to a read with ctx.
+func (db *DB) readSelector(ctx context.Context) Selector {
+	sel, ok := ctx.Value(selectorKey{}).(Selector)
+	if !ok {
+		sel, _ = db.selector.Load().(Selector)
+	}
+	return withTier(ctx, sel)
+}
+
+// matching returns the indexes of the physical dbs in rotation matching sel.
+// Delayed replicas only match selectors requiring the delay label, and
+// slaves in a tier those requiring their tier.
+func (db *DB) matching(sel Selector) []int {
+	var nodes []int
+	delayed, tiered := requiresDelay(sel), requiresTier(sel)
+	for i := range db.topology().pdbs {
+		if db.inRotation(i) && db.delayed(i) == delayed && (tiered || db.Tier(i) == "") && sel.Matches(db.labelsOf(i)) {
+			nodes = append(nodes, i)
+		}
+	}
+	return nodes
+}
+
+// readIndex returns the index of the physical db a read with ctx goes to.
+func (db *DB) readIndex(ctx context.Context) int {
+	i, _ := db.preferredIndex(ctx, nil)
+	return i
+}
+
+// preferredIndex is like readIndex, sending the read to the physical db
+// picked by prefer, if any, once its consistency allows any eligible one.
+// It reports whether the read is pinned there, like routeIndex.
+func (db *DB) preferredIndex(ctx context.Context, prefer func() (int, bool)) (int, bool) {
+	if i, pinned := db.routeIndex(ctx, prefer); i != 0 || pinned {
+		return i, pinned
+	}
+	return db.graceIndex(ctx), false
+}
+
+// routeIndex returns the index of the physical db a read with ctx goes to,
+// preferring the one picked by prefer, if any, regardless of promotions.
+// It reports whether the read is pinned there by its consistency, such as
+// to the master when no slave is fresh enough, which promotions can't move.
+func (db *DB) routeIndex(ctx context.Context, prefer func() (int, bool)) (int, bool) {
+	if i, pinned, ok := db.consistentIndex(ctx); ok {
+		return i, pinned
+	}
+
+	if prefer != nil {
+		if i, ok := prefer(); ok {
+			return i, false
+		}
+	}
+
+	if db.readPreference(ctx) == PreferNearest {
+		if i, ok := db.nearest(db.eligible(ctx)); ok {
+			return i, false
+		}
+	}
+
+	if sel := db.readSelector(ctx); len(sel) > 0 {
+		if nodes := db.matching(sel); len(nodes) > 0 {
+			nodes = db.failingBack(nodes)
+			return nodes[atomic.AddUint64(&db.count, 1)%uint64(len(nodes))], false
+		}
+		if requiresTier(sel) {
+			return 0, true
+		}
+	}
+
+	if readsAsOf(ctx) {
+		nodes := db.eligible(ctx)
+		return nodes[atomic.AddUint64(&db.count, 1)%uint64(len(nodes))], false
+	}
+
+	i := db.balancedIndex()
+	db.shadowPick(i)
+	return i, false
+}
+
+// consistentIndex returns the index of the physical db a read with ctx must
+// go to for its consistency, such as the one it's directed to with WithNode
+// or the master right after a write, reporting whether it must go to any
+// and whether it's pinned there.
+func (db *DB) consistentIndex(ctx context.Context) (i int, pinned, ok bool) {
+	if i, ok := db.nodeIndex(ctx); ok {
+		return i, true, true
+	}
+
+	if db.fresh(ctx) && ctx.Value(slaveKey{}) == nil {
+		return 0, false, true
+	}
+
+	if i, ok := db.freshIndex(ctx); ok && ctx.Value(slaveKey{}) == nil {
+		return i, true, true
+	}
+
+	// Sessions are left to the proxy, which knows about the slaves
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok && db.proxied() == nil {
+		return db.sessionIndex(ctx, s), true, true
+	}
+
+	if ts, ok := ctx.Value(tokenKey{}).(*tokenState); ok {
+		if i, ok := db.tokenIndex(ctx, ts); ok {
+			return i, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// balancedIndex returns the index of the physical db a balanced read goes
+// to.
+func (db *DB) balancedIndex() int {
+	if i, ok := db.picked(); ok {
+		return i
+	}
+
+	if i, ok := db.scored(); ok {
+		return i
+	}
+
+	if s := db.topology().schedule; len(s) > 0 {
+		if i, ok := db.scheduled(s); ok {
+			return i
+		}
+	}
+
+	n := len(db.topology().pdbs)
+	i := db.slave(n)
+	if i == 0 || db.balanced(i) {
+		return i
+	}
+
+	for k := 1; k < n-1; k++ {
+		if j := 1 + (i-1+k)%(n-1); db.balanced(j) {
+			return j
+		}
+	}
+	return 0
+}
+
+// readNodes returns the indexes of the physical dbs a read with ctx may
+// go to, starting with the preferred one.
+func (db *DB) readNodes(ctx context.Context) []int {
+	nodes := db.eligible(ctx)
+	nodes = rotate(nodes, int(atomic.AddUint64(&db.count, 1)%uint64(len(nodes))))
+	if db.readPreference(ctx) == PreferNearest {
+		db.byRTT(nodes)
+	}
+	return nodes
+}
+
+// eligible returns the indexes of the physical dbs a read with ctx may go to.
+func (db *DB) eligible(ctx context.Context) []int {
+	if i, ok := db.nodeIndex(ctx); ok {
+		return []int{i}
+	}
+
+	if sel := db.readSelector(ctx); len(sel) > 0 {
+		if nodes := db.matching(sel); len(nodes) > 0 {
+			return nodes
+		}
+		if requiresTier(sel) {
+			return []int{0}
+		}
+	}
+
+	var nodes []int
+	if readsAsOf(ctx) {
+		nodes = append(nodes, 0)
+	}
+
+	for i, n := 1, len(db.topology().pdbs); i < n; i++ {
+		if db.balanced(i) {
+			nodes = append(nodes, i)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return []int{0}
+	}
+	return nodes
+}
+
+// balanced reports whether reads without a selector may go to the slave
+// at index i, which must be in rotation, not belong to a tier and not be
+// a delayed replica.
+func (db *DB) balanced(i int) bool {
+	return db.inRotation(i) && db.Tier(i) == "" && !db.delayed(i)
+}
+
+// rotate returns nodes starting at index first, wrapping around.
+func rotate(nodes []int, first int) []int {
+	rotated := make([]int, 0, len(nodes))
+	return append(append(rotated, nodes[first:]...), nodes[:first]...)
+}
@@ -0,0 +1,78 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	sel, err := ParseSelector("role=slave, zone == eu-west-1,tier!=async")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Selector{
+		{Key: "role", Value: "slave"},
+		{Key: "zone", Value: "eu-west-1"},
+		{Key: "tier", Value: "async", Not: true},
+	}
+	if !reflect.DeepEqual(sel, want) {
+		t.Errorf("Unexpected selector. Got: %+v, Want: %+v", sel, want)
+	}
+
+	if got := sel.String(); got != "role=slave,zone=eu-west-1,tier!=async" {
+		t.Errorf("Unexpected string form: %s", got)
+	}
+
+	for _, invalid := range []string{"role", "=slave", "!=x"} {
+		if _, err := ParseSelector(invalid); err == nil {
+			t.Errorf("Expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestSelectorUnmarshalText(t *testing.T) {
+	var config struct{ Reads Selector }
+	if err := json.Unmarshal([]byte(`{"Reads": "zone=eu"}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.Reads.Matches(Labels{"zone": "eu"}) || config.Reads.Matches(Labels{"zone": "us"}) {
+		t.Errorf("Unexpected selector from config: %v", config.Reads)
+	}
+}
+
+func TestReadSelector(t *testing.T) {
+	db := wrap(make([]*sql.DB, 4), nil)
+	db.SetLabels(1, Labels{"zone": "us"})
+	db.SetLabels(2, Labels{"zone": "eu", "role": "master"})
+	db.SetLabels(3, Labels{"zone": "eu"})
+
+	if got := db.Labels(2)["role"]; got != "slave" {
+		t.Errorf("Role label was overridden: %s", got)
+	}
+
+	ctx := WithSelector(context.Background(), MustParseSelector("role=slave,zone=eu"))
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(ctx); n != 2 && n != 3 {
+			t.Fatalf("Read routed to non matching db %d", n)
+		}
+	}
+
+	db.SetReadSelector(MustParseSelector("role=master"))
+	if n := db.readIndex(context.Background()); n != 0 {
+		t.Errorf("Default read selector ignored, got db %d", n)
+	}
+
+	ctx = WithSelector(context.Background(), MustParseSelector("zone=asia"))
+	if n := db.readIndex(ctx); n == 0 {
+		t.Errorf("Unmatched selector should fall back to slaves, got db %d", n)
+	}
+
+	if nodes := db.readNodes(ctx); len(nodes) != 3 {
+		t.Errorf("Expected every slave as a read candidate, got: %v", nodes)
+	}
+}
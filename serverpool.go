@@ -0,0 +1,90 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Queries listing the server side connections of each application, as rows
+// of an application name and a count, for DB.SetServerPoolQuery.
+const (
+	PostgresConnsByApp = "SELECT application_name, count(*) FROM pg_stat_activity GROUP BY application_name"
+	MySQLConnsByApp    = "SELECT ATTR_VALUE, count(*) FROM performance_schema.session_connect_attrs WHERE ATTR_NAME = 'program_name' GROUP BY ATTR_VALUE"
+)
+
+// PoolView is the client side and server side view of the connection pool
+// of a physical db.
+type PoolView struct {
+	Index  int
+	Client sql.DBStats    // Client side pool statistics
+	Server int            // Server side connections of the application, -1 if unknown
+	Apps   map[string]int // Server side connections of each application, nil if unknown
+	Err    error          // Error collecting the server side view, if any
+}
+
+type serverPool struct {
+	query string
+	app   string
+}
+
+// SetServerPoolQuery enables collecting the server side view of the pool
+// by running query on each physical db, which must return rows of an
+// application name and its number of connections, such as
+// PostgresConnsByApp or MySQLConnsByApp. The connections of
+// app are those of the application, which must set it as its application
+// name on the server side. If app is empty, those of each physical db are
+// the ones tagged with its name by DB.SetApplicationName. If query is
+// empty, collection is disabled, which is the default.
+func (db *DB) SetServerPoolQuery(query, app string) {
+	db.serverPool.Store(serverPool{query: query, app: app})
+}
+
+// PoolViews returns the pool view of each physical db, collecting their
+// server side views concurrently, so that operators can compare the client
+// side and server side views of the pools in one place and catch
+// connection leaks of other applications.
+func (db *DB) PoolViews(ctx context.Context) []PoolView {
+	pdbs := db.topology().pdbs
+	views := make([]PoolView, len(pdbs))
+	for i, pdb := range pdbs {
+		views[i] = PoolView{Index: i, Client: pdb.Stats(), Server: -1}
+	}
+
+	p, _ := db.serverPool.Load().(serverPool)
+	if p.query == "" {
+		return views
+	}
+
+	scatter(len(pdbs), func(i int) error {
+		apps, err := connsByApp(ctx, pdbs[i], p.query)
+		if views[i].Apps, views[i].Err = apps, err; err == nil {
+			if app := p.app; app != "" {
+				views[i].Server = apps[app]
+			} else {
+				views[i].Server = apps[db.ApplicationName(i)]
+			}
+		}
+		return nil
+	})
+	return views
+}
+
+// connsByApp runs query on pdb, returning the connections of each application.
+func connsByApp(ctx context.Context, pdb *sql.DB, query string) (map[string]int, error) {
+	rows, err := pdb.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apps := map[string]int{}
+	for rows.Next() {
+		var app sql.NullString
+		var n int
+		if err = rows.Scan(&app, &n); err != nil {
+			return nil, err
+		}
+		apps[app.String] += n
+	}
+	return apps, rows.Err()
+}
@@ -0,0 +1,36 @@
+package nap
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPoolViews(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Ping()
+	for _, v := range db.PoolViews(context.Background()) {
+		if v.Server != -1 || v.Apps != nil || v.Client.OpenConnections != 1 {
+			t.Errorf("Unexpected view without server side collection: %+v", v)
+		}
+	}
+
+	db.SetServerPoolQuery("SELECT 'api', 3 UNION ALL SELECT 'cron', 2 UNION ALL SELECT NULL, 1", "api")
+	for _, v := range db.PoolViews(context.Background()) {
+		if v.Err != nil {
+			t.Fatal(v.Err)
+		}
+		if v.Server != 3 || v.Apps["cron"] != 2 || v.Apps[""] != 1 {
+			t.Errorf("Unexpected server side view of %d: %+v", v.Index, v)
+		}
+	}
+
+	db.SetServerPoolQuery("SELECT * FROM pg_stat_activity", "api")
+	if v := db.PoolViews(context.Background())[1]; v.Err == nil || v.Server != -1 {
+		t.Errorf("Expected an error collecting the server side view, got: %+v", v)
+	}
+}
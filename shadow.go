@@ -0,0 +1,121 @@
+package nap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowStats are the evaluation of the BalancerPolicy set with
+// SetShadowPolicy against the active balancing, since it was set.
+type ShadowStats struct {
+	Policy        string         // Name of the policy, "custom" if it has no String method
+	Since         time.Time      // Time the policy was set at
+	Reads         uint64         // Balanced reads evaluated
+	Disagreements uint64         // Reads the policy would have sent to another slave
+	Active        map[int]uint64 // Reads by index of the slave they went to
+	Shadow        map[int]uint64 // Reads by index of the slave the policy picked
+	ActiveLatency time.Duration  // Mean smoothed latency of the slaves reads went to
+	ShadowLatency time.Duration  // Mean smoothed latency of the slaves the policy picked, predicting its latency
+}
+
+// shadowSampling is the number of balanced reads per read evaluated by the
+// shadow policy, so that evaluating it doesn't add to the cost of each read.
+const shadowSampling = 8
+
+// SetShadowPolicy evaluates p in shadow of the active balancing, such as
+// a candidate BalancerPolicy, so that a routing change can be validated on
+// production traffic before being switched on with SetBalancerPolicy or
+// RollOut. Balanced reads, without a selector, session or other routing,
+// are still balanced as before, while for one in every 8 of them, starting
+// with the first, p picks among the same candidates the slave it would have
+// gone to, recording in ShadowStats whether they disagree and the smoothed
+// read latency of both slaves. Setting a policy resets the stats. If p is
+// nil, no policy is evaluated, which is the default.
+func (db *DB) SetShadowPolicy(p BalancerPolicy) {
+	if p == nil {
+		db.shadow.Store((*shadow)(nil))
+		return
+	}
+	db.shadow.Store(&shadow{policy: p, since: time.Now()})
+}
+
+// ShadowStats returns the evaluation of the policy set with
+// SetShadowPolicy, reporting false if none is.
+func (db *DB) ShadowStats() (ShadowStats, bool) {
+	sh, _ := db.shadow.Load().(*shadow)
+	if sh == nil {
+		return ShadowStats{}, false
+	}
+
+	s := ShadowStats{
+		Policy:        nameOf(sh.policy),
+		Since:         sh.since,
+		Reads:         atomic.LoadUint64(&sh.reads),
+		Disagreements: atomic.LoadUint64(&sh.disagreed),
+		Active:        shadowCounts(&sh.active),
+		Shadow:        shadowCounts(&sh.picks),
+	}
+	if s.Reads > 0 {
+		s.ActiveLatency = time.Duration(atomic.LoadInt64(&sh.activeLat) / int64(s.Reads))
+		s.ShadowLatency = time.Duration(atomic.LoadInt64(&sh.shadowLat) / int64(s.Reads))
+	}
+	return s, true
+}
+
+// shadow is a BalancerPolicy evaluated in shadow.
+type shadow struct {
+	seen      uint64 // Balanced reads, accessed atomically
+	reads     uint64 // Balanced reads evaluated, accessed atomically
+	disagreed uint64 // Accessed atomically
+	activeLat int64  // Sum of the latencies of the slaves reads went to, accessed atomically
+	shadowLat int64  // Sum of the latencies of the slaves picked, accessed atomically
+	policy    BalancerPolicy
+	since     time.Time
+	active    sync.Map // Index of the slave reads went to to its *uint64 count
+	picks     sync.Map // Index of the slave picked to its *uint64 count
+}
+
+// shadowPick evaluates the shadow policy, if set, for a balanced read which
+// went to the physical db at index i, if sampled.
+func (db *DB) shadowPick(i int) {
+	sh, _ := db.shadow.Load().(*shadow)
+	if sh == nil || atomic.AddUint64(&sh.seen, 1)%shadowSampling != 1 {
+		return
+	}
+
+	t := db.topology()
+	candidates := db.candidates(t, t.weights)
+	if len(candidates) == 0 {
+		return
+	}
+
+	j := pick(sh.policy, candidates)
+	atomic.AddUint64(&sh.reads, 1)
+	if i != j {
+		atomic.AddUint64(&sh.disagreed, 1)
+	}
+	shadowCount(&sh.active, i)
+	shadowCount(&sh.picks, j)
+	atomic.AddInt64(&sh.activeLat, atomic.LoadInt64(&t.health(i).latency))
+	atomic.AddInt64(&sh.shadowLat, atomic.LoadInt64(&t.health(j).latency))
+}
+
+// shadowCount increments the count of index i in m.
+func shadowCount(m *sync.Map, i int) {
+	n, ok := m.Load(i)
+	if !ok {
+		n, _ = m.LoadOrStore(i, new(uint64))
+	}
+	atomic.AddUint64(n.(*uint64), 1)
+}
+
+// shadowCounts returns the counts by index of m.
+func shadowCounts(m *sync.Map) map[int]uint64 {
+	all := map[int]uint64{}
+	m.Range(func(i, n interface{}) bool {
+		all[i.(int)] = atomic.LoadUint64(n.(*uint64))
+		return true
+	})
+	return all
+}
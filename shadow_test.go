@@ -0,0 +1,54 @@
+package nap
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShadowPolicy(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, ok := db.ShadowStats(); ok {
+		t.Error("Shadow stats without shadow policy")
+	}
+
+	db.SetBalancerPolicy(firstPolicy{})
+	db.SetShadowPolicy(lastPolicy{})
+	atomic.StoreInt64(&db.health(2).latency, int64(5*time.Millisecond))
+	for k := 0; k < 10*shadowSampling; k++ {
+		if row := db.QueryRow("SELECT 1"); row.Scan(new(int)) != nil || row.Node() != 1 {
+			t.Fatalf("Read on %d with a shadow policy", row.Node())
+		}
+	}
+
+	db.SetLabels(2, Labels{"zone": "eu"})
+	ctx := WithSelector(context.Background(), MustParseSelector("zone=eu"))
+	if err = db.QueryRowContext(ctx, "SELECT 1").Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := db.ShadowStats()
+	if !ok || s.Policy != "custom" || s.Reads != 10 || s.Disagreements != 10 || s.Active[1] != 10 || s.Shadow[2] != 10 {
+		t.Errorf("Unexpected shadow stats: %+v", s)
+	}
+	if s.ShadowLatency != 5*time.Millisecond || s.ActiveLatency <= 0 {
+		t.Errorf("Unexpected latencies: %s, %s", s.ActiveLatency, s.ShadowLatency)
+	}
+
+	db.SetShadowPolicy(firstPolicy{})
+	db.QueryRow("SELECT 1").Scan(new(int))
+	if s, _ = db.ShadowStats(); s.Reads != 1 || s.Disagreements != 0 {
+		t.Errorf("Unexpected shadow stats once reset: %+v", s)
+	}
+
+	db.SetShadowPolicy(nil)
+	if _, ok = db.ShadowStats(); ok {
+		t.Error("Shadow stats once shadow policy unset")
+	}
+}
@@ -0,0 +1,114 @@
+package nap
+
+import (
+	"sync"
+	"time"
+)
+
+// ProbeKey returns the key identifying the host of a physical db from its
+// DSN, such as its address, for DB.SetProbeSharing. An empty key disables
+// sharing for the physical db.
+type ProbeKey func(dsn string) string
+
+// SameDSN is the ProbeKey sharing the probes of physical dbs opened with
+// the same DSN.
+func SameDSN(dsn string) string {
+	return dsn
+}
+
+// SetProbeSharing shares the health checks of SetHealthCheck and the lag
+// probes of SetLagProbe across the DBs of the process enabling it, such as
+// multi-tenant services opening many DBs against the same hosts. Physical
+// dbs opened by nap whose DSNs have the same key are probed once per
+// interval of the DB probing them, and concurrent probes are coalesced,
+// the other DBs using the result, which is kept for a few intervals. If
+// key is nil, probes aren't shared, which is the default.
+func (db *DB) SetProbeSharing(key ProbeKey) {
+	db.probeKey.Store(key)
+}
+
+// sharedProbe returns the result of the probe kind of the physical db at
+// index i of t, running fn unless a DB sharing it did during the last
+// interval or is doing it.
+func (db *DB) sharedProbe(t *topology, i int, kind string, interval time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	key, _ := db.probeKey.Load().(ProbeKey)
+	if key == nil || i >= len(t.connectors) || t.connectors[i] == nil {
+		return fn()
+	}
+
+	k := key(t.connectors[i].dsn())
+	if k == "" {
+		return fn()
+	}
+	return probes.do(kind+"\x00"+k, interval, fn)
+}
+
+// probes is the registry of the probes shared across DBs.
+var probes = &probeRegistry{results: map[string]*probeResult{}}
+
+// probeRetention is the number of their intervals the results of shared
+// probes are kept for, so that those of hosts no DB probes anymore, such
+// as removed slaves, are evicted.
+const probeRetention = 3
+
+// probeRegistry holds the last result of each shared probe, by key.
+type probeRegistry struct {
+	mu      sync.Mutex
+	results map[string]*probeResult
+	swept   time.Time // Last eviction of the expired results
+}
+
+// probeResult is the result of a shared probe, set once done is closed.
+type probeResult struct {
+	done   chan struct{}
+	at     time.Time
+	maxAge time.Duration // Interval of the probe
+	value  interface{}
+	err    error
+}
+
+// do returns the result of the probe of key, running fn unless it ran
+// within maxAge or is running.
+func (r *probeRegistry) do(key string, maxAge time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	r.mu.Lock()
+	if p := r.results[key]; p != nil {
+		select {
+		case <-p.done:
+			if time.Since(p.at) < maxAge {
+				r.mu.Unlock()
+				return p.value, p.err
+			}
+		default:
+			r.mu.Unlock()
+			<-p.done
+			return p.value, p.err
+		}
+	}
+
+	if now := time.Now(); now.Sub(r.swept) >= maxAge {
+		r.sweep(now)
+	}
+	p := &probeResult{done: make(chan struct{}), maxAge: maxAge}
+	r.results[key] = p
+	r.mu.Unlock()
+
+	p.value, p.err = fn()
+	p.at = time.Now()
+	close(p.done)
+	return p.value, p.err
+}
+
+// sweep evicts the results older than probeRetention of their intervals
+// at now. r.mu must be held.
+func (r *probeRegistry) sweep(now time.Time) {
+	r.swept = now
+	for key, p := range r.results {
+		select {
+		case <-p.done:
+			if now.Sub(p.at) >= probeRetention*p.maxAge {
+				delete(r.results, key)
+			}
+		default:
+		}
+	}
+}
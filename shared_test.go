@@ -0,0 +1,102 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeSharing(t *testing.T) {
+	dsns := memoryDSN("shared_master") + ";" + memoryDSN("shared_slave")
+	var dbs []*DB
+	for i := 0; i < 3; i++ {
+		db, err := Open("sqlite3", dsns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		dbs = append(dbs, db)
+	}
+
+	var probed int32
+	probe := func(context.Context, *sql.DB) (time.Duration, error) {
+		atomic.AddInt32(&probed, 1)
+		return time.Second, nil
+	}
+
+	dbs[0].measureLag(probe, time.Hour, time.Second)
+	dbs[1].measureLag(probe, time.Hour, time.Second)
+	if probed != 2 {
+		t.Errorf("Lag probed %d times without sharing", probed)
+	}
+
+	probes.mu.Lock()
+	probes.results = map[string]*probeResult{}
+	probes.mu.Unlock()
+
+	probed = 0
+	for _, db := range dbs {
+		db.SetProbeSharing(SameDSN)
+		db.measureLag(probe, time.Hour, time.Second)
+	}
+	if probed != 1 {
+		t.Errorf("Lag probed %d times with sharing", probed)
+	}
+	if lag := dbs[2].Lag(1); lag != time.Second {
+		t.Errorf("Unexpected shared lag: %v", lag)
+	}
+
+	dbs[0].measureLag(probe, time.Nanosecond, time.Second)
+	if probed != 2 {
+		t.Error("Stale lag shared")
+	}
+}
+
+func TestProbeRegistry(t *testing.T) {
+	r := &probeRegistry{results: map[string]*probeResult{}}
+	release := make(chan struct{})
+	var runs int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := r.do("k", time.Hour, func() (interface{}, error) {
+				atomic.AddInt32(&runs, 1)
+				<-release
+				return 42, nil
+			})
+			if v != 42 || err != nil {
+				t.Errorf("Unexpected result: %v, %v", v, err)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("Concurrent probes ran %d times", runs)
+	}
+}
+
+func TestProbeRegistryEviction(t *testing.T) {
+	r := &probeRegistry{results: map[string]*probeResult{}}
+	probe := func() (interface{}, error) { return 1, nil }
+
+	r.do("removed", time.Millisecond, probe)
+	r.do("kept", time.Hour, probe)
+	time.Sleep(probeRetention * time.Millisecond)
+	r.do("added", time.Millisecond, probe)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.results["removed"]; ok || len(r.results) != 2 {
+		t.Errorf("Expired results not evicted: %v", r.results)
+	}
+}
@@ -0,0 +1,179 @@
+package nap
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SnapshotVersion is the version of the schema of Snapshot. It is only
+// bumped by incompatible changes, such as removing or redefining a field,
+// while fields may be added to any version.
+const SnapshotVersion = 1
+
+// Snapshot is the state of a DB in a stable, versioned schema, so that
+// external monitoring agents can consume it as JSON without linking against
+// nap. Durations are in nanoseconds.
+type Snapshot struct {
+	Version int            `json:"version"`
+	Time    time.Time      `json:"time"`
+	Nodes   []NodeSnapshot `json:"nodes"`
+	Frozen  bool           `json:"frozen"` // Whether writes are refused by DB.Freeze
+}
+
+// NodeSnapshot is the state of a physical db in a Snapshot.
+type NodeSnapshot struct {
+	Index   int               `json:"index"`
+	Role    string            `json:"role"`
+	Labels  map[string]string `json:"labels"`
+	Healthy bool              `json:"healthy"`
+	Health  float64           `json:"health"`   // Smoothed health check success rate
+	Weight  int               `json:"weight"`   // Weight of weighted selection
+	RTT     int64             `json:"rtt_ns"`   // Smoothed round trip time, 0 if unknown
+	Pool    PoolSnapshot      `json:"pool"`     // Client side connection pool
+	Reads   uint64            `json:"reads"`    // Reads over the fairness window, if enabled
+	Delay   int64             `json:"delay_ns"` // Intentional delay of delayed replicas
+	Tier    string            `json:"tier,omitempty"`
+}
+
+// PoolSnapshot is the state of the connection pool of a physical db in
+// a Snapshot.
+type PoolSnapshot struct {
+	MaxOpen      int   `json:"max_open"`
+	Open         int   `json:"open"`
+	InUse        int   `json:"in_use"`
+	Idle         int   `json:"idle"`
+	WaitCount    int64 `json:"wait_count"`
+	WaitDuration int64 `json:"wait_ns"`
+	Dials        int64 `json:"dials"`       // Dials of new connections, if opened by nap
+	DialErrors   int64 `json:"dial_errors"` // Dials failed, timed out or abandoned
+	DialLatency  int64 `json:"dial_ns"`     // Smoothed dial latency
+}
+
+// SnapshotSink receives the snapshots pushed with SetSnapshotPush. Pushes
+// are serialized, and a slow sink delays the next pushes.
+type SnapshotSink interface {
+	Push(s Snapshot) error
+}
+
+// SnapshotSinkFunc is an adapter to allow the use of ordinary functions
+// as a SnapshotSink.
+type SnapshotSinkFunc func(s Snapshot) error
+
+// Push calls f(s).
+func (f SnapshotSinkFunc) Push(s Snapshot) error {
+	return f(s)
+}
+
+// JSONSnapshotSink returns a SnapshotSink writing snapshots to w as JSON
+// lines, such as to a pipe or a socket read by an agent.
+func JSONSnapshotSink(w io.Writer) SnapshotSink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return SnapshotSinkFunc(func(s Snapshot) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(s)
+	})
+}
+
+// Snapshot returns the state of the DB.
+func (db *DB) Snapshot() Snapshot {
+	t := db.topology()
+	s := Snapshot{
+		Version: SnapshotVersion,
+		Time:    time.Now(),
+		Nodes:   make([]NodeSnapshot, len(t.pdbs)),
+	}
+	_, s.Frozen = db.Frozen()
+
+	var reads []uint64
+	if f, _ := db.fairness.Load().(*fairness); f != nil {
+		reads = f.reads(s.Time)
+	}
+
+	for i, pdb := range t.pdbs {
+		stats := pdb.Stats()
+		n := NodeSnapshot{
+			Index:   i,
+			Role:    roleLabels(i)["role"],
+			Labels:  db.Labels(i),
+			Healthy: db.Healthy(i),
+			Health:  db.HealthScore(i),
+			Weight:  db.Weight(i),
+			RTT:     int64(db.RTT(i)),
+			Delay:   int64(db.Delay(i)),
+			Tier:    db.Tier(i),
+			Pool: PoolSnapshot{
+				MaxOpen:      stats.MaxOpenConnections,
+				Open:         stats.OpenConnections,
+				InUse:        stats.InUse,
+				Idle:         stats.Idle,
+				WaitCount:    stats.WaitCount,
+				WaitDuration: int64(stats.WaitDuration),
+			},
+		}
+
+		if c := t.connectors[i]; c != nil {
+			n.Pool.Dials, n.Pool.DialErrors = int64(atomic.LoadUint64(&c.dials.count)), int64(atomic.LoadUint64(&c.dials.errors))
+			n.Pool.DialLatency = atomic.LoadInt64(&c.dials.latency)
+		}
+
+		if i < len(reads) {
+			n.Reads = reads[i]
+		}
+		s.Nodes[i] = n
+	}
+
+	return s
+}
+
+// SetSnapshotPush pushes a Snapshot to sink every d in the background,
+// until the DB is closed. Errors of the sink are ignored, so it must
+// handle them itself. If d <= 0 or sink is nil, snapshots are no longer
+// pushed, which is the default.
+func (db *DB) SetSnapshotPush(d time.Duration, sink SnapshotSink) {
+	if sink == nil {
+		d = 0
+	}
+
+	db.pushSink.Store(snapshotSink{sink})
+	atomic.StoreInt64(&db.pushEvery, int64(d))
+	db.startPush()
+}
+
+// snapshotSink wraps SnapshotSinks, so that differently typed sinks can be
+// stored in the same atomic.Value.
+type snapshotSink struct {
+	SnapshotSink
+}
+
+func (db *DB) startPush() {
+	if atomic.LoadInt64(&db.pushEvery) > 0 && atomic.CompareAndSwapInt32(&db.pushing, 0, 1) {
+		go db.push()
+	}
+}
+
+// push pushes snapshots until disabled or the DB is closed.
+func (db *DB) push() {
+	for {
+		every := time.Duration(atomic.LoadInt64(&db.pushEvery))
+		if every <= 0 {
+			atomic.StoreInt32(&db.pushing, 0)
+			db.startPush() // Re-enabled concurrently
+			return
+		}
+
+		select {
+		case <-db.done():
+			return
+		case <-time.After(every):
+		}
+
+		if s, _ := db.pushSink.Load().(snapshotSink); s.SnapshotSink != nil {
+			s.Push(db.Snapshot())
+		}
+	}
+}
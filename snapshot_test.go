@@ -0,0 +1,91 @@
+package nap
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetTier(1, "reporting")
+	db.SetWeight(1, 3)
+
+	s := db.Snapshot()
+	if s.Version != SnapshotVersion || len(s.Nodes) != 2 || s.Time.IsZero() {
+		t.Fatalf("Unexpected snapshot: %+v", s)
+	}
+
+	n := s.Nodes[1]
+	if n.Index != 1 || n.Role != "slave" || n.Tier != "reporting" || n.Weight != 3 || !n.Healthy || n.Labels["tier"] != "reporting" {
+		t.Errorf("Unexpected node snapshot: %+v", n)
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wire struct {
+		Version int `json:"version"`
+		Nodes   []struct {
+			Role string `json:"role"`
+			Pool struct {
+				Open int `json:"open"`
+			} `json:"pool"`
+		} `json:"nodes"`
+	}
+	if err = json.Unmarshal(b, &wire); err != nil || wire.Version != 1 || wire.Nodes[0].Role != "master" {
+		t.Errorf("Unexpected wire snapshot: %s", b)
+	}
+}
+
+func TestSnapshotPush(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pushed := make(chan Snapshot, 10)
+	db.SetSnapshotPush(time.Millisecond, SnapshotSinkFunc(func(s Snapshot) error {
+		pushed <- s
+		return nil
+	}))
+
+	select {
+	case s := <-pushed:
+		if len(s.Nodes) != 2 {
+			t.Errorf("Unexpected pushed snapshot: %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No snapshot pushed")
+	}
+
+	db.SetSnapshotPush(0, nil)
+	time.Sleep(10 * time.Millisecond)
+	for len(pushed) > 0 {
+		<-pushed
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if len(pushed) != 0 {
+		t.Error("Snapshots pushed once disabled")
+	}
+}
+
+func TestJSONSnapshotSink(t *testing.T) {
+	r, w := io.Pipe()
+	go JSONSnapshotSink(w).Push(Snapshot{Version: SnapshotVersion})
+
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil || s.Version != SnapshotVersion {
+		t.Errorf("Unexpected snapshot: %+v, %v", s, err)
+	}
+}
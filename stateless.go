@@ -0,0 +1,79 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// StatelessTx is a read-only transaction whose statements may each run on
+// a different slave, trading snapshot consistency for load spreading, such
+// as for batch readers tolerating staleness per statement. Each statement
+// is routed and retried like a read of the DB with its context.
+type StatelessTx struct {
+	db   *DB
+	ctx  context.Context
+	done int32
+}
+
+// BeginStateless starts a StatelessTx. The provided context is used until
+// the transaction is committed or rolled back, and its statements fail
+// once it is done.
+func (db *DB) BeginStateless(ctx context.Context) (*StatelessTx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &StatelessTx{db: db, ctx: ctx}, nil
+}
+
+// Commit ends the transaction. Since it is read-only, it is the same as
+// Rollback.
+func (tx *StatelessTx) Commit() error {
+	return tx.Rollback()
+}
+
+// Rollback ends the transaction, returning sql.ErrTxDone if already ended.
+func (tx *StatelessTx) Rollback() error {
+	if !atomic.CompareAndSwapInt32(&tx.done, 0, 1) {
+		return sql.ErrTxDone
+	}
+	return nil
+}
+
+// err returns the error statements fail with once tx is done.
+func (tx *StatelessTx) err() error {
+	if atomic.LoadInt32(&tx.done) != 0 {
+		return sql.ErrTxDone
+	}
+	return tx.ctx.Err()
+}
+
+// Query executes a query that returns rows on a slave, like DB.Query.
+func (tx *StatelessTx) Query(query string, args ...interface{}) (*Rows, error) {
+	return tx.QueryContext(tx.ctx, query, args...)
+}
+
+// QueryContext executes a query that returns rows on a slave, like
+// DB.QueryContext.
+func (tx *StatelessTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if err := tx.err(); err != nil {
+		return nil, err
+	}
+	return tx.db.QueryContext(ctx, query, args...)
+}
+
+// QueryRow executes a query that is expected to return at most one row on
+// a slave, like DB.QueryRow.
+func (tx *StatelessTx) QueryRow(query string, args ...interface{}) *Row {
+	return tx.QueryRowContext(tx.ctx, query, args...)
+}
+
+// QueryRowContext executes a query that is expected to return at most one
+// row on a slave, like DB.QueryRowContext.
+func (tx *StatelessTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	if err := tx.err(); err != nil {
+		return tx.db.newRow(ctx, errRow(tx.db.Master(), err), &QueryInfo{Op: OpQueryRow, SQL: query, Args: len(args), FormattedArgs: tx.db.formatArgs(args), Err: err}, time.Now(), nil)
+	}
+	return tx.db.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,65 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestStatelessTx(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tx, err := db.BeginStateless(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := map[int]bool{}
+	for i := 0; i < 4; i++ {
+		rows, err := tx.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[rows.Node()] = true
+		rows.Close()
+
+		row := tx.QueryRow("SELECT 1")
+		if err := row.Scan(new(int)); err != nil {
+			t.Fatal(err)
+		}
+		nodes[row.Node()] = true
+	}
+
+	if len(nodes) != 2 || nodes[0] {
+		t.Errorf("Statements not spread across the slaves: %v", nodes)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != sql.ErrTxDone {
+		t.Errorf("Want sql.ErrTxDone, got: %v", err)
+	}
+	if _, err = tx.Query("SELECT 1"); err != sql.ErrTxDone {
+		t.Errorf("Want sql.ErrTxDone, got: %v", err)
+	}
+	if err = tx.QueryRow("SELECT 1").Scan(new(int)); err != sql.ErrTxDone {
+		t.Errorf("Want sql.ErrTxDone, got: %v", err)
+	}
+
+	tx, _ = db.BeginStateless(ctx)
+	cancel()
+	if _, err = tx.Query("SELECT 1"); err != context.Canceled {
+		t.Errorf("Want context.Canceled, got: %v", err)
+	}
+	if _, err = db.BeginStateless(ctx); err != context.Canceled {
+		t.Errorf("Want context.Canceled, got: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package nap
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+)
+
+// NodeStats are the stats of a physical db.
+type NodeStats struct {
+	sql.DBStats
+	Index   int
+	Role    string  // "master" or "slave"
+	DSNHash string  // Hash of the DSN identifying the physical db without leaking credentials, empty if not opened by nap
+	Healthy bool    // Whether in rotation
+	Health  float64 // Smoothed health check success rate
+	Frozen  bool    // Whether writes are refused by DB.Freeze, for the master
+
+	// Dials of new connections, if opened by nap.
+	Dials       uint64
+	DialErrors  uint64        // Dials failed, timed out or abandoned
+	DialLatency time.Duration // Smoothed dial latency
+
+	// Orphaned server side statements deallocated, if collected.
+	Orphans uint64
+}
+
+// Stats returns the stats of each physical db, by index.
+func (db *DB) Stats() []NodeStats {
+	t := db.topology()
+	stats := make([]NodeStats, len(t.pdbs))
+	_, frozen := db.Frozen()
+	for i, pdb := range t.pdbs {
+		s := &stats[i]
+		s.Index, s.Role = i, roleLabels(i)["role"]
+		s.Healthy, s.Health = db.Healthy(i), db.HealthScore(i)
+		s.Frozen = frozen && i == 0
+		if pdb != nil {
+			s.DBStats = pdb.Stats()
+		}
+		if c := t.connectors[i]; c != nil {
+			s.DSNHash = dsnHash(c.dsn())
+			s.Dials, s.DialErrors = atomic.LoadUint64(&c.dials.count), atomic.LoadUint64(&c.dials.errors)
+			s.DialLatency = time.Duration(atomic.LoadInt64(&c.dials.latency))
+			s.Orphans = atomic.LoadUint64(&c.orphans)
+		}
+	}
+	return stats
+}
+
+// dsnHash returns a short hash of dsn.
+func dsnHash(dsn string) string {
+	sum := sha256.Sum256([]byte(dsn))
+	return hex.EncodeToString(sum[:8])
+}
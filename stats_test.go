@@ -0,0 +1,33 @@
+package nap
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;file:slave?mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(3)
+	db.StartDrill(Drill{SlavesDown: []int{1}})
+	defer db.StopDrill()
+
+	stats := db.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	for i, s := range stats {
+		if s.Index != i || s.Role != []string{"master", "slave"}[i] || s.Healthy != (i == 0) || s.MaxOpenConnections != 3 {
+			t.Errorf("Unexpected stats of %d: %+v", i, s)
+		}
+		if len(s.DSNHash) != 16 {
+			t.Errorf("Unexpected DSN hash: %q", s.DSNHash)
+		}
+	}
+
+	if stats[0].DSNHash == stats[1].DSNHash {
+		t.Error("Same hash of different DSNs")
+	}
+}
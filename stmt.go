@@ -3,75 +3,464 @@ package nap
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Stmt is an aggregate prepared statement.
-// It holds a prepared statement for each underlying physical db.
+// It holds a prepared statement for each underlying physical db
+// it could be prepared on.
+// Once invalidated by DB.InvalidateStatements, it is prepared again on its
+// next use, while the statements being used are closed once done.
 type Stmt struct {
-	db    *DB
-	stmts []*sql.Stmt
+	uses   uint64 // Operations run, accessed atomically
+	db     *DB
+	query  string
+	class  sqlClass
+	set    atomic.Value // *stmtSet of the current generation
+	mu     sync.Mutex   // Serializes preparing again
+	closed int32
+	next   atomic.Value // *Stmt handed over to
 }
 
 // Close closes the statement by concurrently closing all underlying
-// statements concurrently, returning the first non nil error.
+// statements concurrently, returning the *MultiError of those failing.
 func (s *Stmt) Close() error {
-	return scatter(len(s.stmts), func(i int) error {
-		return s.stmts[i].Close()
-	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	atomic.StoreInt32(&s.closed, 1)
+	s.db.stmts.Delete(s)
+	if n := s.successor(); n != nil {
+		n.Close()
+	}
+	return s.load().close()
+}
+
+// Eligible reports whether the statement is prepared on the physical db
+// at index i. It is false for logical replicas which failed to prepare it.
+func (s *Stmt) Eligible(i int) bool {
+	stmts := s.load().stmts
+	return i < len(stmts) && stmts[i] != nil
 }
 
 // Exec executes a prepared statement with the given arguments
 // and returns a Result summarizing the effect of the statement.
 // Exec uses the master as the underlying physical db.
 func (s *Stmt) Exec(args ...interface{}) (sql.Result, error) {
-	return s.Master().Exec(args...)
+	return s.ExecContext(context.Background(), args...)
 }
 
 // ExecContext executes a prepared statement with the given arguments
 // and returns a Result summarizing the effect of the statement.
 // Exec uses the master as the underlying physical db.
 func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
-	return s.Master().ExecContext(ctx, args...)
+	if n := s.forwarded(ctx); n != nil {
+		return n.ExecContext(ctx, args...)
+	}
+
+	if err := s.db.writable(); err != nil {
+		return nil, err
+	}
+
+	ctx, err := s.db.override(ctx, s.query)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := s.db.charge(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := s.use(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer set.release()
+
+	ctx = s.db.correlate(ctx)
+	ctx = s.db.beforeQuery(ctx, OpStmtExec, s.query)
+	ctx, cancel := s.db.statementContext(ctx, OpStmtExec)
+	defer cancel()
+
+	start := time.Now()
+	res, err := s.execMaster(ctx, set, args)
+	err = queryError(ctx, 0, err)
+	s.db.finish(ctx, acct, &QueryInfo{Op: OpStmtExec, SQL: s.query, Args: len(args), FormattedArgs: s.db.formatArgs(args), Attempt: 1, Err: err}, start)
+	s.db.wrote(ctx)
+	ResetMemo(ctx)
+
+	return res, err
 }
 
 // Query executes a prepared query statement with the given
-// arguments and returns the query results as a *sql.Rows.
+// arguments and returns the query results as a *Rows.
 // Query uses a slave as the underlying physical db.
-func (s *Stmt) Query(args ...interface{}) (*sql.Rows, error) {
-	return s.Slave().Query(args...)
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	return s.QueryContext(context.Background(), args...)
 }
 
 // QueryContext executes a query that returns rows, typically a SELECT.
 // The args are for any placeholder parameters in the query.
 // QueryContext uses a slave as the physical db.
-func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
-	return s.Slave().QueryContext(ctx, args...)
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	if n := s.forwarded(ctx); n != nil {
+		return n.QueryContext(ctx, args...)
+	}
+
+	ctx, err := s.db.override(ctx, s.query)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, key, ok := memoOf(ctx, s.query, args); ok {
+		e, err := m.load(ctx, key, s.db.memoExpiry(), func() (*Rows, error) { return s.queryContext(ctx, args) })
+		if err != nil {
+			return nil, err
+		}
+		return s.db.memoRows(ctx, e, &QueryInfo{Op: OpStmtQuery, SQL: s.query, Args: len(args), FormattedArgs: s.db.formatArgs(args)})
+	}
+	return s.queryContext(ctx, args)
+}
+
+// queryContext runs the statement with ctx, whose route overrides are
+// applied.
+func (s *Stmt) queryContext(ctx context.Context, args []interface{}) (*Rows, error) {
+	acct, err := s.db.charge(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := s.use(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer set.release()
+
+	ctx = s.db.correlate(ctx)
+	ctx = s.db.beforeQuery(ctx, OpStmtQuery, s.query)
+	ctx = s.db.tablesFreshness(ctx, s.class.tables)
+	ctx, cancel := s.db.statementContext(ctx, OpStmtQuery)
+
+	start, node := time.Now(), s.readIndex(ctx, set)
+	rows, err := s.queryNode(ctx, set, node, args)
+	node, attempt, err := s.db.retry(ctx, node, err, func(i int) (err error) {
+		if set.stmts[i] == nil {
+			return err
+		}
+		rows, err = s.queryNode(ctx, set, i, args)
+		return err
+	})
+
+	info := QueryInfo{Op: OpStmtQuery, SQL: s.query, Args: len(args), FormattedArgs: s.db.formatArgs(args), Node: node, Attempt: attempt}
+	if err != nil {
+		cancel()
+		info.Err = queryError(ctx, node, err)
+		s.db.finish(ctx, acct, &info, start)
+		return nil, info.Err
+	}
+
+	set.warmUp(node)
+	s.mirrorRead(start, set, args)
+	s.db.cachePlan(node, s.db.preparedSQL(s.query), args)
+	s.db.finish(ctx, acct, &info, start)
+
+	return s.db.newRows(ctx, rows, &info, start, cancel), nil
 }
 
 // QueryRow executes a prepared query statement with the given arguments.
 // If an error occurs during the execution of the statement, that error
 // will be returned by a call to Scan on the returned *Row, which is always non-nil.
 // If the query selects no rows, the *Row's Scan will return ErrNoRows.
-// Otherwise, the *sql.Row's Scan scans the first selected row and discards the rest.
+// Otherwise, the *Row's Scan scans the first selected row and discards the rest.
 // QueryRow uses a slave as the underlying physical db.
-func (s *Stmt) QueryRow(args ...interface{}) *sql.Row {
-	return s.Slave().QueryRow(args...)
+func (s *Stmt) QueryRow(args ...interface{}) *Row {
+	return s.QueryRowContext(context.Background(), args...)
 }
 
 // QueryRowContext executes a query that is expected to return at most one row.
 // QueryRowContext always return a non-nil value.
 // Errors are deferred until Row's Scan method is called.
 // QueryRowContext uses a slave as the physical db.
-func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
-	return s.Slave().QueryRowContext(ctx, args...)
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *Row {
+	if n := s.forwarded(ctx); n != nil {
+		return n.QueryRowContext(ctx, args...)
+	}
+
+	ctx, err := s.db.override(ctx, s.query)
+	if m, key, ok := memoOf(ctx, s.query, args); ok && err == nil {
+		e, err := m.load(ctx, key, s.db.memoExpiry(), func() (*Rows, error) { return s.queryContext(ctx, args) })
+		return s.db.memoRow(ctx, e, err, &QueryInfo{Op: OpStmtQueryRow, SQL: s.query, Args: len(args), FormattedArgs: s.db.formatArgs(args)})
+	}
+
+	var acct *account
+	if err == nil {
+		acct, err = s.db.charge(ctx)
+	}
+	if err == nil {
+		var set *stmtSet
+		if set, err = s.use(ctx); err == nil {
+			defer set.release()
+			return s.queryRow(ctx, acct, set, args)
+		}
+	}
+
+	return s.db.newRow(ctx, errRow(s.db.Master(), err), &QueryInfo{Op: OpStmtQueryRow, SQL: s.query, Args: len(args), FormattedArgs: s.db.formatArgs(args), Err: err}, time.Now(), nil)
+}
+
+func (s *Stmt) queryRow(ctx context.Context, acct *account, set *stmtSet, args []interface{}) *Row {
+	ctx = s.db.correlate(ctx)
+	ctx = s.db.beforeQuery(ctx, OpStmtQueryRow, s.query)
+	ctx = s.db.tablesFreshness(ctx, s.class.tables)
+	ctx, cancel := s.db.statementContext(ctx, OpStmtQueryRow)
+
+	start, node := time.Now(), s.readIndex(ctx, set)
+	row := s.queryRowNode(ctx, set, node, args)
+	node, attempt, err := s.db.retry(ctx, node, row.Err(), func(i int) error {
+		if set.stmts[i] != nil {
+			row = s.queryRowNode(ctx, set, i, args)
+		}
+		return row.Err()
+	})
+
+	info := QueryInfo{Op: OpStmtQueryRow, SQL: s.query, Args: len(args), FormattedArgs: s.db.formatArgs(args), Node: node, Attempt: attempt, Err: err}
+	if err == nil {
+		set.warmUp(node)
+		s.db.cachePlan(node, s.db.preparedSQL(s.query), args)
+	}
+	s.mirrorRead(start, set, args)
+	s.db.finish(ctx, acct, &info, start)
+
+	r := s.db.newRow(ctx, row, &info, start, cancel)
+	r.recheck = s.recheckRow(ctx, node, args)
+	return r
 }
 
 // Master returns the master stmt physical database
 func (s *Stmt) Master() *sql.Stmt {
-	return s.stmts[0]
+	return s.load().stmts[0]
 }
 
 // Slave returns one of the stmt physical databases which is a slave
 func (s *Stmt) Slave() *sql.Stmt {
-	return s.stmts[s.db.slave(len(s.db.pdbs))]
+	set := s.load()
+	return set.stmts[s.readIndex(context.Background(), set)]
+}
+
+// readIndex returns the index of the physical db a read with ctx goes to
+// among those set is prepared on. Reads pinned by their consistency to
+// a physical db set isn't prepared on go to the master.
+func (s *Stmt) readIndex(ctx context.Context, set *stmtSet) int {
+	prefer := func() (int, bool) {
+		return s.warmIndex(ctx, set)
+	}
+	i, pinned := s.db.preferredIndex(ctx, prefer)
+	if i < len(set.stmts) && set.stmts[i] != nil {
+		return i
+	}
+	if pinned {
+		return 0
+	}
+
+	for _, i := range s.db.readNodes(ctx) {
+		if i < len(set.stmts) && set.stmts[i] != nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// load returns the current statement set, even if invalidated.
+func (s *Stmt) load() *stmtSet {
+	return s.set.Load().(*stmtSet)
+}
+
+// use returns the statement set of the current generation, preparing it
+// again if invalidated or drained. It must be released once done.
+func (s *Stmt) use(ctx context.Context) (*stmtSet, error) {
+	for {
+		set := s.load()
+		stale := set.gen != s.db.statementGeneration() || atomic.LoadInt32(&set.retired) != 0
+		if stale && atomic.LoadInt32(&s.closed) == 0 {
+			if err := s.prepareAgain(ctx, set); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if set.acquire() {
+			atomic.AddUint64(&s.uses, 1)
+			return set, nil
+		}
+
+		if atomic.LoadInt32(&s.closed) != 0 {
+			return nil, errStmtClosed
+		}
+	}
+}
+
+// errStmtClosed is returned by the statements closed once drained.
+var errStmtClosed = errors.New("sql: statement is closed")
+
+// prepareAgain replaces the invalidated statement set old, retiring it.
+func (s *Stmt) prepareAgain(ctx context.Context, old *stmtSet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.load() != old || atomic.LoadInt32(&s.closed) != 0 {
+		return nil // Prepared again concurrently, or closed
+	}
+
+	set, err := s.db.prepareSet(ctx, s.query)
+	if err != nil {
+		return err
+	}
+
+	s.set.Store(set)
+	old.retire()
+	return nil
+}
+
+// InvalidateStatements invalidates every statement prepared with Prepare,
+// such as after a topology change or a schema change invalidating their
+// plans. Statements are prepared again lazily on their next use, and the
+// statements they replace are closed once no longer in use, so no query
+// runs against a closed statement. Statements prewarmed with Prewarm and
+// not yet taken over are closed.
+func (db *DB) InvalidateStatements() {
+	atomic.AddUint64(&db.generation, 1)
+	for _, set := range db.dropPrewarmed() {
+		go set.close()
+	}
+}
+
+// PrepareDrainAll invalidates every statement prepared with Prepare, like
+// InvalidateStatements, and closes their server side prepared statements
+// right away rather than on their next use, such as ahead of a rolling
+// restart of the physical dbs, after which statements are prepared again
+// lazily instead of failing because their server side statements are gone.
+// The statements in use are closed once done, which PrepareDrainAll waits
+// for until ctx is done, returning the first error closing them.
+func (db *DB) PrepareDrainAll(ctx context.Context) error {
+	db.InvalidateStatements()
+
+	var sets []*stmtSet
+	db.stmts.Range(func(k, _ interface{}) bool {
+		sets = append(sets, k.(*Stmt).drain())
+		return true
+	})
+
+	var err error
+	for _, set := range sets {
+		select {
+		case <-set.closed:
+			if err == nil {
+				err = set.err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// drain retires the statement set of s, which is prepared again on its
+// next use, and returns it.
+func (s *Stmt) drain() *stmtSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set := s.load()
+	set.retire()
+	return set
+}
+
+func (db *DB) statementGeneration() uint64 {
+	return atomic.LoadUint64(&db.generation)
+}
+
+// prepareSet prepares query on each physical db of the current generation.
+func (db *DB) prepareSet(ctx context.Context, query string) (*stmtSet, error) {
+	gen := db.statementGeneration()
+	pdbs := db.topology().pdbs
+	set := &stmtSet{
+		gen:    gen,
+		stmts:  make([]*sql.Stmt, len(pdbs)),
+		warm:   make([]uint32, len(pdbs)),
+		refs:   1,
+		closed: make(chan struct{}),
+	}
+
+	query = db.preparedSQL(query)
+	err := scatter(len(pdbs), func(i int) (err error) {
+		set.stmts[i], err = pdbs[i].PrepareContext(ctx, query)
+		return db.prepared(i, err)
+	})
+
+	if err != nil {
+		set.close()
+		return nil, err
+	}
+	return set, nil
+}
+
+// stmtSet holds the statements of a Stmt prepared for a generation.
+// It is closed once retired and no longer in use.
+type stmtSet struct {
+	gen     uint64
+	stmts   []*sql.Stmt // nil for logical replicas which failed to prepare it
+	warm    []uint32    // Set for the statements which ran, by StmtPreferWarm
+	refs    int64       // Uses in progress, plus one until retired
+	retired int32
+	closing sync.Once
+	closed  chan struct{} // Closed once closed
+	err     error         // Error closing the statements
+}
+
+// acquire marks a use of set in progress, reporting false if set was
+// already closed.
+func (set *stmtSet) acquire() bool {
+	for {
+		n := atomic.LoadInt64(&set.refs)
+		if n == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&set.refs, n, n+1) {
+			return true
+		}
+	}
+}
+
+// release ends a use of set, closing it when retired and unused.
+func (set *stmtSet) release() {
+	if atomic.AddInt64(&set.refs, -1) == 0 {
+		set.close()
+	}
+}
+
+// retire releases set once it is replaced, only the first call having
+// effect.
+func (set *stmtSet) retire() {
+	if atomic.CompareAndSwapInt32(&set.retired, 0, 1) {
+		set.release()
+	}
+}
+
+// close closes the statements of set once.
+func (set *stmtSet) close() error {
+	set.closing.Do(func() {
+		set.err = scatter(len(set.stmts), func(i int) error {
+			if set.stmts[i] == nil {
+				return nil
+			}
+			return set.stmts[i].Close()
+		})
+		if set.closed != nil {
+			close(set.closed)
+		}
+	})
+	return set.err
 }
@@ -0,0 +1,127 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInvalidateStatements(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var n int
+	old := stmt.load()
+	if !old.acquire() { // In use while invalidated
+		t.Fatal("Statement set closed before use")
+	}
+
+	db.InvalidateStatements()
+	if err = stmt.QueryRow().Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if stmt.load() == old {
+		t.Fatal("Statement not prepared again after invalidation")
+	}
+
+	if err = old.stmts[1].QueryRow().Scan(&n); err != nil {
+		t.Errorf("Statement closed while in use: %v", err)
+	}
+
+	old.release()
+	if err = old.stmts[1].QueryRow().Scan(&n); err == nil {
+		t.Error("Replaced statement not closed once unused")
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInvalidateClosedStatement(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := stmt.load()
+	stmt.Close()
+	db.InvalidateStatements()
+
+	if err = stmt.QueryRow().Scan(new(int)); err == nil {
+		t.Error("Closed statement prepared again")
+	}
+
+	if stmt.load() != old {
+		t.Error("Closed statement set replaced")
+	}
+}
+
+func TestPrepareDrainAll(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	idle, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	busy, err := db.Prepare("SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	old, inUse := idle.load(), busy.load()
+	if !inUse.acquire() {
+		t.Fatal("Statement set closed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = db.PrepareDrainAll(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Drained statements in use: %v", err)
+	}
+
+	select {
+	case <-old.closed:
+	default:
+		t.Error("Idle statement not closed")
+	}
+
+	inUse.release()
+	if err = db.PrepareDrainAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err = idle.QueryRow().Scan(&n); err != nil || n != 1 || idle.load() == old {
+		t.Errorf("Drained statement not prepared again: %d, %v", n, err)
+	}
+
+	idle.drain()
+	idle.Close()
+	if err = idle.QueryRow().Scan(&n); err != errStmtClosed {
+		t.Errorf("Want errStmtClosed, got: %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStatement is a statement prepared server side in the session of
+// a connection.
+type ServerStatement struct {
+	Name string
+	SQL  string
+}
+
+// StatementCollector lists and deallocates the statements prepared server
+// side in the session of a driver connection.
+type StatementCollector struct {
+	List       func(ctx context.Context, conn driver.Conn) ([]ServerStatement, error)
+	Deallocate func(ctx context.Context, conn driver.Conn, name string) error
+
+	// Managed, when set, reports whether the statement named name is
+	// managed by the driver itself, such as by a statement cache, so that
+	// it's never deallocated even when prepared with the SQL of a statement
+	// closed by the driver.
+	Managed func(name string) bool
+}
+
+// PostgresStatements is a StatementCollector of the pg_prepared_statements
+// view of Postgres sessions.
+var PostgresStatements = StatementCollector{
+	List: func(ctx context.Context, conn driver.Conn) ([]ServerStatement, error) {
+		rows, err := queryDriver(ctx, conn, "SELECT name, statement FROM pg_prepared_statements")
+		if err != nil {
+			return nil, err
+		}
+
+		stmts := make([]ServerStatement, len(rows))
+		for k, row := range rows {
+			stmts[k] = ServerStatement{Name: row[0], SQL: row[1]}
+		}
+		return stmts, nil
+	},
+	Deallocate: func(ctx context.Context, conn driver.Conn, name string) error {
+		return execDriver(ctx, conn, `DEALLOCATE "`+strings.Replace(name, `"`, `""`, -1)+`"`)
+	},
+	Managed: func(name string) bool {
+		// Statement caches of pgx v4 and v5
+		return strings.HasPrefix(name, "lrupsc_") || strings.HasPrefix(name, "stmtcache_")
+	},
+}
+
+// statementGC is the statement collection of the connections of a physical db.
+type statementGC struct {
+	collector StatementCollector
+}
+
+// SetStatementGC sets the collector reconciling, every interval on each
+// idle connection to the physical dbs opened by Open, the statements
+// prepared server side in its session with those prepared by the driver,
+// and deallocating the orphans: statements closed by the driver but still
+// prepared server side, such as after their deallocation failed, which
+// would otherwise leak server memory across long-lived pools. Connections
+// are reconciled in the background, while idle, once their statements are
+// tracked from their dial on, so that checkouts don't wait for it.
+// Statements of the session which weren't prepared by the driver, or are
+// managed by it, are left untouched. If interval <= 0, connections are no
+// longer reconciled, which is the default.
+func (db *DB) SetStatementGC(c StatementCollector, interval time.Duration) {
+	gc := &statementGC{collector: c}
+	if interval <= 0 || c.List == nil || c.Deallocate == nil {
+		gc, interval = nil, 0
+	}
+
+	for _, conn := range db.topology().connectors {
+		if conn != nil {
+			conn.gc.Store(gc)
+		}
+	}
+
+	atomic.StoreInt64(&db.gcEvery, int64(interval))
+	db.startStatementGC()
+}
+
+func (db *DB) startStatementGC() {
+	if atomic.LoadInt64(&db.gcEvery) > 0 && atomic.CompareAndSwapInt32(&db.collecting, 0, 1) {
+		go db.collectStatements()
+	}
+}
+
+// collectStatements reconciles the statements of the idle connections of
+// the physical dbs until disabled or the DB is closed.
+func (db *DB) collectStatements() {
+	for {
+		every := time.Duration(atomic.LoadInt64(&db.gcEvery))
+		if every <= 0 {
+			atomic.StoreInt32(&db.collecting, 0)
+			db.startStatementGC() // Re-enabled concurrently
+			return
+		}
+
+		select {
+		case <-db.done():
+			return
+		case <-time.After(every):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), every)
+		for _, pdb := range db.topology().pdbs {
+			collectIdle(ctx, pdb)
+		}
+		cancel()
+	}
+}
+
+// collectIdle reconciles the statements of the idle connections of pdb,
+// checking them out together so that each is reconciled once.
+func collectIdle(ctx context.Context, pdb *sql.DB) {
+	idle := pdb.Stats().Idle
+	conns := make([]*sql.Conn, 0, idle)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	for len(conns) < idle {
+		c, err := pdb.Conn(ctx)
+		if err != nil {
+			return
+		}
+		conns = append(conns, c)
+
+		c.Raw(func(dc interface{}) error {
+			if cn, ok := dc.(*conn); ok {
+				cn.collect(ctx) // Collected again at the next interval on failure
+			}
+			return nil
+		})
+	}
+}
+
+// stmtTracker tracks the statements prepared by the driver on a connection.
+type stmtTracker struct {
+	mu     sync.Mutex
+	open   map[string]int  // SQL of the open statements to their count
+	closed map[string]bool // SQL of the statements closed since the last collection
+}
+
+func newStmtTracker() *stmtTracker {
+	return &stmtTracker{open: map[string]int{}, closed: map[string]bool{}}
+}
+
+func (t *stmtTracker) prepared(query string) {
+	t.mu.Lock()
+	t.open[query]++
+	t.mu.Unlock()
+}
+
+func (t *stmtTracker) closing(query string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.open[query]--; t.open[query] <= 0 {
+		delete(t.open, query)
+		t.closed[query] = true
+	}
+}
+
+// orphans returns the names of the statements of stmts closed by the
+// driver, except those managed by it, resetting those closed.
+func (t *stmtTracker) orphans(stmts []ServerStatement, managed func(string) bool) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var names []string
+	for _, s := range stmts {
+		if managed != nil && managed(s.Name) {
+			continue
+		}
+		if t.closed[s.SQL] && t.open[s.SQL] == 0 {
+			names = append(names, s.Name)
+		}
+	}
+	t.closed = map[string]bool{}
+	return names
+}
+
+// track returns si prepared with query on c, tracked if collected.
+func (c *conn) track(si driver.Stmt, query string) driver.Stmt {
+	if _, ok := si.(driver.ColumnConverter); ok || c.stmts == nil {
+		return si
+	}
+	c.stmts.prepared(query)
+	return &trackedStmt{Stmt: si, query: query, tracker: c.stmts}
+}
+
+// collect deallocates the orphaned statements of c, if collected.
+func (c *conn) collect(ctx context.Context) error {
+	gc, _ := c.connector.gc.Load().(*statementGC)
+	if gc == nil || c.stmts == nil {
+		return nil
+	}
+
+	stmts, err := gc.collector.List(ctx, c.Conn)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range c.stmts.orphans(stmts, gc.collector.Managed) {
+		if err = gc.collector.Deallocate(ctx, c.Conn, name); err != nil {
+			return err
+		}
+		atomic.AddUint64(&c.connector.orphans, 1)
+	}
+	return nil
+}
+
+// trackedStmt is a driver statement tracked by the stmtTracker of its
+// connection, forwarding the optional driver interfaces to it the same way
+// database/sql would when they aren't implemented. Statements implementing
+// driver.ColumnConverter aren't tracked since it can't be forwarded.
+type trackedStmt struct {
+	driver.Stmt
+	query   string
+	tracker *stmtTracker
+	closed  int32
+}
+
+// Close implements the driver.Stmt interface.
+func (s *trackedStmt) Close() error {
+	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		s.tracker.closing(s.query)
+	}
+	return s.Stmt.Close()
+}
+
+// ExecContext implements the driver.StmtExecContext interface.
+func (s *trackedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(dargs)
+}
+
+// QueryContext implements the driver.StmtQueryContext interface.
+func (s *trackedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(dargs)
+}
+
+// CheckNamedValue implements the driver.NamedValueChecker interface.
+func (s *trackedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// queryDriver runs a query without arguments directly on a driver
+// connection, returning its rows as strings.
+func queryDriver(ctx context.Context, ci driver.Conn, query string) ([][]string, error) {
+	var rows driver.Rows
+	var err error
+	if q, ok := ci.(driver.QueryerContext); ok {
+		rows, err = q.QueryContext(ctx, query, nil)
+	} else {
+		err = driver.ErrSkip
+	}
+
+	if err == driver.ErrSkip {
+		var stmt driver.Stmt
+		if stmt, err = (&conn{Conn: ci}).PrepareContext(ctx, query); err != nil {
+			return nil, err
+		}
+		defer stmt.Close()
+
+		if s, ok := stmt.(driver.StmtQueryContext); ok {
+			rows, err = s.QueryContext(ctx, nil)
+		} else {
+			rows, err = stmt.Query(nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all [][]string
+	values := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err = rows.Next(values); err == io.EOF {
+			return all, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(values))
+		for k, v := range values {
+			switch v := v.(type) {
+			case nil:
+			case []byte:
+				row[k] = string(v)
+			default:
+				row[k] = fmt.Sprint(v)
+			}
+		}
+		all = append(all, row)
+	}
+}
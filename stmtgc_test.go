@@ -0,0 +1,105 @@
+package nap
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestStatementGC(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mu sync.Mutex
+	var deallocated []string
+	db.SetMaxOpenConns(1)
+	db.SetStatementGC(StatementCollector{
+		List: func(ctx context.Context, conn driver.Conn) ([]ServerStatement, error) {
+			return []ServerStatement{{"s1", "SELECT 1"}, {"s2", "SELECT 2"}, {"s3", "SELECT 3"}, {"cached", "SELECT 2"}}, nil
+		},
+		Deallocate: func(ctx context.Context, conn driver.Conn, name string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			deallocated = append(deallocated, name)
+			return nil
+		},
+		Managed: func(name string) bool {
+			return name == "cached"
+		},
+	}, time.Millisecond)
+
+	open, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+
+	closed, err := db.Prepare("SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = closed.QueryRow().Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	for deadline := time.Now().Add(time.Second); db.Stats()[0].Orphans == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	if want := []string{"s2"}; !reflect.DeepEqual(deallocated, want) {
+		t.Errorf("Unexpected statements deallocated. Got: %v, Want: %v", deallocated, want)
+	}
+	mu.Unlock()
+	if n := db.Stats()[0].Orphans; n != 1 {
+		t.Errorf("Want 1 orphan collected, got: %d", n)
+	}
+
+	// Orphans are only deallocated once.
+	time.Sleep(10 * time.Millisecond)
+	if err = open.QueryRow().Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats()[0].Orphans; n != 1 {
+		t.Errorf("Want 1 orphan collected, got: %d", n)
+	}
+
+	if !PostgresStatements.Managed("stmtcache_5f2c") || PostgresStatements.Managed("pgx_1") {
+		t.Error("Statements of the pgx cache not told apart")
+	}
+
+	db.SetStatementGC(StatementCollector{}, 0)
+	if atomic.LoadInt64(&db.gcEvery) != 0 {
+		t.Error("Statements still collected once disabled")
+	}
+}
+
+func TestQueryDriver(t *testing.T) {
+	ci, err := (&sqlite3.SQLiteDriver{}).Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ci.Close()
+
+	rows, err := queryDriver(context.Background(), ci, "SELECT 'a', 1, NULL UNION ALL SELECT 'b', 2.5, x'63'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"a", "1", ""}, {"b", "2.5", "c"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("Unexpected rows. Got: %q, Want: %q", rows, want)
+	}
+
+	if _, err = queryDriver(context.Background(), ci, "SELECT * FROM missing"); err == nil {
+		t.Error("Want an error querying a missing table")
+	}
+}
@@ -0,0 +1,89 @@
+package nap
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+)
+
+// Tag is a key value pair attributing queries, such as to a feature or team.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+type tagsKey struct{}
+
+// WithTag returns a copy of ctx whose queries are tagged with key and value,
+// on top of the tags ctx already carries, replacing the value of key if
+// tagged already. Tags are reported to metrics and the flight recorder,
+// optionally added to SQL comments, and available to a QueryHook with Tags,
+// so that database costs can be attributed per feature or per team through
+// one mechanism.
+func WithTag(ctx context.Context, key, value string) context.Context {
+	old := Tags(ctx)
+	tags := make([]Tag, 0, len(old)+1)
+	for _, t := range old {
+		if t.Key != key {
+			tags = append(tags, t)
+		}
+	}
+	return context.WithValue(ctx, tagsKey{}, append(tags, Tag{Key: key, Value: value}))
+}
+
+// Tags returns the tags carried by ctx in the order they were added,
+// which must not be modified.
+func Tags(ctx context.Context) []Tag {
+	tags, _ := ctx.Value(tagsKey{}).([]Tag)
+	return tags
+}
+
+// SetTagComments sets whether the tags of non prepared queries are prepended
+// to their SQL as a comment, such as /* team=billing feature=invoices */,
+// so they show up in the server logs and statement statistics of the
+// physical db that ran them.
+func (db *DB) SetTagComments(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&db.tagComment, v)
+}
+
+// tagValue returns the value of key among tags, if tagged.
+func tagValue(tags []Tag, key string) (string, bool) {
+	for _, t := range tags {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// commentTags appends the tags of ctx to the comment parts of a query.
+func commentTags(ctx context.Context, parts []string) []string {
+	for _, t := range Tags(ctx) {
+		parts = append(parts, sanitizeComment(t.Key)+"="+sanitizeComment(t.Value))
+	}
+	return parts
+}
+
+// sanitizeComment strips what would end a SQL comment or split its parts.
+func sanitizeComment(s string) string {
+	s = stripComment(s)
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// stripComment strips what would end or nest a SQL comment from s,
+// until none is left, such that "**//" can't leave "*/" behind.
+func stripComment(s string) string {
+	for strings.Contains(s, "*/") || strings.Contains(s, "/*") {
+		s = strings.Replace(strings.Replace(s, "*/", "", -1), "/*", "", -1)
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+package nap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithTag(t *testing.T) {
+	parent := WithTag(WithTag(context.Background(), "team", "billing"), "feature", "invoices")
+	ctx := WithTag(parent, "team", "payments")
+
+	want := []Tag{{"feature", "invoices"}, {"team", "payments"}}
+	if got := Tags(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected tags. Got: %v, Want: %v", got, want)
+	}
+
+	if v, _ := tagValue(Tags(parent), "team"); v != "billing" {
+		t.Errorf("Tags of the parent context modified: %v", Tags(parent))
+	}
+
+	if tags := Tags(context.Background()); tags != nil {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+}
+
+func TestTagComments(t *testing.T) {
+	db := &DB{}
+	ctx := WithTag(WithCorrelationID(context.Background(), "ab"), "team", "bill ing*/")
+
+	if got := db.rewrite(ctx, OpQuery, "SELECT 1"); got != "SELECT 1" {
+		t.Errorf("Comment added while disabled: %s", got)
+	}
+
+	db.SetTagComments(true)
+	if got := db.rewrite(ctx, OpQuery, "SELECT 1"); got != "/* team=bill_ing */ SELECT 1" {
+		t.Errorf("Unexpected commented query: %s", got)
+	}
+
+	db.SetCorrelationComments(true)
+	if got := db.rewrite(ctx, OpQuery, "SELECT 1"); got != "/* cid=ab team=bill_ing */ SELECT 1" {
+		t.Errorf("Unexpected commented query: %s", got)
+	}
+}
+
+func TestCommentInjection(t *testing.T) {
+	db := &DB{}
+	db.SetTagComments(true)
+	db.SetCorrelationComments(true)
+	ctx := WithTag(WithCorrelationID(context.Background(), "a**//b"), "team", "x**//; DROP TABLE t; --")
+
+	if got := db.rewrite(ctx, OpQuery, "SELECT 1"); got != "/* cid=ab team=x;_DROP_TABLE_t;_-- */ SELECT 1" {
+		t.Errorf("Unexpected commented query: %s", got)
+	}
+	if got := PgHintPlan("SELECT 1", []string{"SeqScan(t) **//", "/*/**/"}); got != "/*+ SeqScan(t)   */ SELECT 1" {
+		t.Errorf("Unexpected hinted query: %s", got)
+	}
+}
+
+func TestTagMetrics(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var got []string
+	db.SetMetrics(MetricsSinkFunc(func(labels MetricLabels, d time.Duration, err error) {
+		got = append(got, labels.Tags)
+	}), MetricsOptions{ByTags: []string{"team", "feature"}, MaxTagValues: 2})
+	db.SetFlightRecorder(8)
+
+	ctx := WithTag(context.Background(), "team", "billing")
+	db.QueryRowContext(ctx, "SELECT 1").Scan(new(int))
+	db.QueryRowContext(WithTag(ctx, "feature", "invoices"), "SELECT 1").Scan(new(int))
+	db.QueryRowContext(WithTag(ctx, "feature", "refunds"), "SELECT 1").Scan(new(int))
+
+	want := []string{"team=billing,feature=", "team=billing,feature=invoices", OtherLabel}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected tag labels. Got: %v, Want: %v", got, want)
+	}
+
+	records := db.FlightRecord()
+	if len(records) != 3 || records[1].Tags["feature"] != "invoices" || records[1].Tags["team"] != "billing" {
+		t.Errorf("Unexpected tags of flight records: %+v", records)
+	}
+}
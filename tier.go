@@ -0,0 +1,47 @@
+package nap
+
+import "context"
+
+// Slaves are assigned to a tier with the "tier" label. Slaves in a tier,
+// such as heavyweight reporting replicas, are excluded from balancing and
+// only receive the reads of contexts set up with WithTier.
+const tierLabel = "tier"
+
+type tierKey struct{}
+
+// WithTier returns a copy of ctx whose reads go to the slaves of tier, such
+// as "reporting", in addition to satisfying the selector in effect.
+// If no slave of the tier is in rotation, reads go to the master rather
+// than to the slaves of interactive traffic.
+func WithTier(ctx context.Context, tier string) context.Context {
+	return context.WithValue(ctx, tierKey{}, tier)
+}
+
+// SetTier assigns the slave at index i to tier, or back to regular
+// balancing if tier is empty.
+func (db *DB) SetTier(i int, tier string) {
+	db.setLabel(i, tierLabel, tier)
+}
+
+// Tier returns the tier of the physical db at index i, if any.
+func (db *DB) Tier(i int) string {
+	return db.labelsOf(i)[tierLabel]
+}
+
+// withTier adds the tier requirement of ctx, if any, to sel.
+func withTier(ctx context.Context, sel Selector) Selector {
+	if tier, ok := ctx.Value(tierKey{}).(string); ok && tier != "" {
+		return append(sel[:len(sel):len(sel)], Requirement{Key: tierLabel, Value: tier})
+	}
+	return sel
+}
+
+// requiresTier reports whether sel requires slaves of a tier.
+func requiresTier(sel Selector) bool {
+	for _, r := range sel {
+		if r.Key == tierLabel && !r.Not && r.Value != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestTier(t *testing.T) {
+	db := wrap(make([]*sql.DB, 4), nil)
+	db.SetTier(3, "reporting")
+
+	if db.Tier(3) != "reporting" || db.Labels(3)["tier"] != "reporting" {
+		t.Fatalf("Tier not set: %v", db.Labels(3))
+	}
+
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(context.Background()); n == 3 {
+			t.Fatal("Interactive read balanced to the reporting tier")
+		}
+	}
+
+	ctx := WithTier(context.Background(), "reporting")
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(ctx); n != 3 {
+			t.Fatalf("Reporting read routed to %d", n)
+		}
+	}
+
+	ctx = WithTier(WithSelector(context.Background(), MustParseSelector("zone=eu")), "reporting")
+	if n := db.readIndex(ctx); n != 0 {
+		t.Errorf("Tier read matching no slave routed to %d instead of the master", n)
+	}
+
+	db.SetLabels(3, Labels{"tier": "reporting", "zone": "eu"})
+	db.SetLabels(1, Labels{"zone": "eu"})
+	ctx = WithSelector(context.Background(), MustParseSelector("zone=eu"))
+	for i := 0; i < 10; i++ {
+		if n := db.readIndex(ctx); n != 1 {
+			t.Fatalf("Read with a selector requiring no tier routed to %d", n)
+		}
+	}
+	if n := db.readIndex(WithTier(ctx, "reporting")); n != 3 {
+		t.Errorf("Tier read with a selector routed to %d", n)
+	}
+
+	db.SetTier(3, "")
+	if db.Tier(3) != "" {
+		t.Error("Tier not cleared")
+	}
+}
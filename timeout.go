@@ -0,0 +1,107 @@
+package nap
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type timeoutKey struct{}
+
+// WithStatementTimeout returns a copy of ctx whose queries time out after d,
+// overriding the default set with DB.SetStatementTimeout. A d <= 0 disables
+// the default statement timeout for these queries.
+func WithStatementTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+// SetStatementTimeout sets the default duration after which queries time
+// out. It is applied client side by bounding the query context and server
+// side by the hint set with SetTimeoutHint, if any.
+// Since the rows of a read must stay usable after it returns, their
+// timeout keeps running until they are closed.
+// If d <= 0, queries don't time out by default. The default is 0.
+func (db *DB) SetStatementTimeout(d time.Duration) {
+	atomic.StoreInt64(&db.timeout, int64(d))
+}
+
+// SetReadTimeout sets the default timeout of reads, overriding the statement
+// timeout, since reporting reads may legitimately run much longer than
+// writes. If d == 0, reads use the statement timeout, which is the default.
+// If d < 0, reads don't time out by default.
+func (db *DB) SetReadTimeout(d time.Duration) {
+	atomic.StoreInt64(&db.rtimeout, int64(d))
+}
+
+// SetWriteTimeout sets the default timeout of writes, overriding the
+// statement timeout. If d == 0, writes use the statement timeout, which is
+// the default. If d < 0, writes don't time out by default.
+func (db *DB) SetWriteTimeout(d time.Duration) {
+	atomic.StoreInt64(&db.wtimeout, int64(d))
+}
+
+// TimeoutHint rewrites a query so that the server enforces a timeout of d
+// on it, returning the query unchanged when it can't.
+type TimeoutHint func(query string, d time.Duration) string
+
+// MySQLTimeoutHint is a TimeoutHint adding a MAX_EXECUTION_TIME optimizer
+// hint to SELECT statements, as supported by MySQL 5.7.8 and later.
+func MySQLTimeoutHint(query string, d time.Duration) string {
+	trimmed := strings.TrimLeft(query, " \t\r\n")
+	if len(trimmed) < 6 || !strings.EqualFold(trimmed[:6], "SELECT") {
+		return query
+	}
+
+	ms := strconv.FormatInt(int64(d/time.Millisecond), 10)
+	return "SELECT /*+ MAX_EXECUTION_TIME(" + ms + ") */" + trimmed[6:]
+}
+
+// SetTimeoutHint sets the TimeoutHint used to enforce statement timeouts
+// server side. It applies to non prepared queries only since prepared
+// statements can't be rewritten. If h is nil, no hint is added.
+func (db *DB) SetTimeoutHint(h TimeoutHint) {
+	db.hint.Store(h)
+}
+
+// statementTimeout returns the statement timeout of an op with ctx.
+func (db *DB) statementTimeout(ctx context.Context, op Op) time.Duration {
+	if d, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		return d
+	}
+
+	split := &db.rtimeout
+	if op.write() {
+		split = &db.wtimeout
+	}
+
+	if d := atomic.LoadInt64(split); d != 0 {
+		return time.Duration(d)
+	}
+	return time.Duration(atomic.LoadInt64(&db.timeout))
+}
+
+// statementContext bounds ctx with the statement timeout of its op, and
+// makes it cancelable for reads with a read isolation to end their
+// transactions.
+func (db *DB) statementContext(ctx context.Context, op Op) (context.Context, context.CancelFunc) {
+	if d := db.statementTimeout(ctx, op); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	if _, ok := db.readIsolation(ctx); ok && !op.write() {
+		return context.WithCancel(ctx)
+	}
+	return ctx, func() {}
+}
+
+// hinted rewrites the query of an op with ctx so the server enforces its
+// statement timeout, if a TimeoutHint is set.
+func (db *DB) hinted(ctx context.Context, op Op, query string) string {
+	if d := db.statementTimeout(ctx, op); d > 0 {
+		if hint, _ := db.hint.Load().(TimeoutHint); hint != nil {
+			return hint(query, d)
+		}
+	}
+	return query
+}
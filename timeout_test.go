@@ -0,0 +1,92 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const endless = "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT COUNT(*) FROM c"
+
+func TestStatementTimeout(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetStatementTimeout(20 * time.Millisecond)
+
+	start := time.Now()
+	if err = db.QueryRow(endless).Scan(new(int)); err == nil {
+		t.Fatal("Expected the endless query to time out")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Statement timeout not enforced, took %s", elapsed)
+	}
+
+	ctx := WithStatementTimeout(context.Background(), time.Minute)
+	if d := db.statementTimeout(ctx, OpQuery); d != time.Minute {
+		t.Errorf("Context override ignored, got %s", d)
+	}
+
+	var n int
+	if err = db.QueryRowContext(ctx, "SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Errorf("Unexpected result with overridden timeout: %d, %v", n, err)
+	}
+}
+
+func TestTimeoutHint(t *testing.T) {
+	db := &DB{}
+	db.SetTimeoutHint(MySQLTimeoutHint)
+
+	if got := db.hinted(context.Background(), OpQuery, "SELECT 1"); got != "SELECT 1" {
+		t.Errorf("Query hinted without a timeout: %s", got)
+	}
+
+	ctx := WithStatementTimeout(context.Background(), 1500*time.Millisecond)
+	for query, want := range map[string]string{
+		"  select * FROM t":  "SELECT /*+ MAX_EXECUTION_TIME(1500) */ * FROM t",
+		"UPDATE t SET a = 1": "UPDATE t SET a = 1",
+	} {
+		if got := db.hinted(ctx, OpQuery, query); got != want {
+			t.Errorf("Unexpected hinted query. Got: %q, Want: %q", got, want)
+		}
+	}
+}
+
+func TestReadWriteTimeouts(t *testing.T) {
+	db := &DB{}
+	db.SetStatementTimeout(time.Second)
+	ctx := context.Background()
+
+	if r, w := db.statementTimeout(ctx, OpQuery), db.statementTimeout(ctx, OpExec); r != time.Second || w != time.Second {
+		t.Errorf("Statement timeout not inherited. Got: %s, %s", r, w)
+	}
+
+	db.SetReadTimeout(time.Minute)
+	db.SetWriteTimeout(-1)
+
+	for op, want := range map[Op]time.Duration{
+		OpQuery:        time.Minute,
+		OpQueryRow:     time.Minute,
+		OpStmtQuery:    time.Minute,
+		OpStmtQueryRow: time.Minute,
+		OpExec:         -1,
+		OpStmtExec:     -1,
+	} {
+		if d := db.statementTimeout(ctx, op); d != want {
+			t.Errorf("Unexpected timeout of %s. Got: %s, Want: %s", op, d, want)
+		}
+	}
+
+	if _, cancel := db.statementContext(ctx, OpExec); cancel == nil {
+		t.Error("Missing cancel func")
+	}
+
+	ctx = WithStatementTimeout(ctx, time.Hour)
+	if d := db.statementTimeout(ctx, OpExec); d != time.Hour {
+		t.Errorf("Context override ignored, got %s", d)
+	}
+}
@@ -0,0 +1,223 @@
+package nap
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned when parsing a malformed Token.
+var ErrInvalidToken = errors.New("nap: invalid consistency token")
+
+// tokenPrefix versions the encoding of tokens.
+const tokenPrefix = "1."
+
+// tokenPoll is the interval at which WaitForReplication checks the slaves.
+const tokenPoll = 10 * time.Millisecond
+
+// Token is an opaque consistency token wrapping a replication position of
+// the master, such as an LSN or a GTID set. Reads carrying a token with
+// WithToken only go to the slaves which replicated up to its position, so
+// that reads observe the writes done before the token was taken, across
+// requests and across application instances. The zero Token carries no
+// position.
+type Token struct {
+	pos string
+}
+
+// String encodes t, so it can be stored client side, such as in a cookie.
+func (t Token) String() string {
+	if t.pos == "" {
+		return ""
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(t.pos))
+}
+
+// ParseToken decodes a Token encoded by Token.String.
+// An empty s decodes to the zero Token.
+func ParseToken(s string) (Token, error) {
+	if s == "" {
+		return Token{}, nil
+	}
+
+	if !strings.HasPrefix(s, tokenPrefix) {
+		return Token{}, ErrInvalidToken
+	}
+
+	pos, err := base64.RawURLEncoding.DecodeString(s[len(tokenPrefix):])
+	if err != nil || len(pos) == 0 {
+		return Token{}, ErrInvalidToken
+	}
+	return Token{pos: string(pos)}, nil
+}
+
+// TokenQueries are the queries taking and checking consistency tokens, for
+// DB.SetTokenQueries.
+type TokenQueries struct {
+	Position string // Returns the replication position of the master
+	Reached  string // Returns whether a slave replicated up to the position given as its only arg
+}
+
+// Queries of consistency tokens of common engines.
+var (
+	PostgresTokens = TokenQueries{
+		Position: "SELECT pg_current_wal_lsn()::text",
+		Reached:  "SELECT pg_last_wal_replay_lsn() >= $1::pg_lsn",
+	}
+
+	MySQLTokens = TokenQueries{
+		Position: "SELECT @@GLOBAL.gtid_executed",
+		Reached:  "SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)",
+	}
+)
+
+// SetTokenQueries sets the queries taking and checking consistency tokens,
+// such as PostgresTokens or MySQLTokens. If q has no queries, tokens are
+// disabled, which is the default.
+func (db *DB) SetTokenQueries(q TokenQueries) {
+	db.tokens.Store(q)
+}
+
+// Token returns a consistency token of the writes done on the master so
+// far, such as right after a write, for later reads to observe it with
+// WithToken.
+func (db *DB) Token(ctx context.Context) (Token, error) {
+	if n := db.successor(); n != nil {
+		return n.Token(ctx)
+	}
+
+	q, _ := db.tokens.Load().(TokenQueries)
+	if q.Position == "" {
+		return Token{}, errors.New("nap: no consistency token queries")
+	}
+
+	var pos string
+	if err := db.Master().QueryRowContext(ctx, q.Position).Scan(&pos); err != nil {
+		return Token{}, err
+	}
+	return Token{pos: pos}, nil
+}
+
+type tokenKey struct{}
+
+// WithToken returns a copy of ctx whose reads only go to the physical dbs
+// which replicated up to the position of t, among those they may go to
+// otherwise, falling back to the master. Whether a slave reached it is
+// checked with the Reached query set with SetTokenQueries, until it did
+// for the reads of ctx. The reads of sessions and of contexts directed
+// with WithNode aren't constrained by t, nor are retries.
+func WithToken(ctx context.Context, t Token) context.Context {
+	if t.pos == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tokenKey{}, &tokenState{pos: t.pos})
+}
+
+// tokenState caches whether slaves reached the position of a token.
+type tokenState struct {
+	pos     string
+	reached sync.Map // Indexes of the physical dbs which reached pos
+}
+
+// tokenIndex returns the index of the physical db a read with ctx carrying
+// ts goes to, if tokens are enabled.
+func (db *DB) tokenIndex(ctx context.Context, ts *tokenState) (int, bool) {
+	q, _ := db.tokens.Load().(TokenQueries)
+	if q.Reached == "" {
+		return 0, false
+	}
+
+	for _, i := range db.readNodes(ctx) {
+		if i == 0 || db.reached(ctx, q.Reached, ts, i) {
+			return i, true
+		}
+	}
+	return 0, true
+}
+
+// reached reports whether the physical db at index i reached the position
+// of ts, checking it until it did.
+func (db *DB) reached(ctx context.Context, query string, ts *tokenState, i int) bool {
+	if ok, cached := ts.reached.Load(i); cached {
+		return ok.(bool)
+	}
+
+	var ok bool
+	if err := db.topology().pdb(i).QueryRowContext(ctx, query, ts.pos).Scan(&ok); err != nil {
+		return false // Checked again by the next read
+	}
+
+	if ok {
+		ts.reached.Store(i, true)
+	}
+	return ok
+}
+
+// WaitForReplication blocks until the slaves at the indexes of nodes, or all
+// of them if none, replicated up to the position of t, as checked with the
+// Reached query set with SetTokenQueries, such as before starting read
+// heavy jobs which must observe the writes done before t was taken. Slaves
+// are checked regardless of rotation, failed checks being retried. If ctx
+// is done first, the error wraps ctx.Err() and lists the slaves not caught
+// up. The zero Token is replicated everywhere.
+func (db *DB) WaitForReplication(ctx context.Context, t Token, nodes ...int) error {
+	if n := db.successor(); n != nil {
+		return n.WaitForReplication(ctx, t, nodes...)
+	}
+
+	q, _ := db.tokens.Load().(TokenQueries)
+	if t.pos == "" {
+		return nil
+	}
+	if q.Reached == "" {
+		return errors.New("nap: no consistency token queries")
+	}
+
+	n := len(db.topology().pdbs)
+	if len(nodes) == 0 {
+		for i := 1; i < n; i++ {
+			nodes = append(nodes, i)
+		}
+	}
+
+	var pending []int
+	for _, i := range nodes {
+		if i < 0 || i >= n {
+			return fmt.Errorf("nap: no physical db at index %d", i)
+		}
+		if i != 0 {
+			pending = append(pending, i)
+		}
+	}
+
+	ts := &tokenState{pos: t.pos}
+	tick := time.NewTicker(tokenPoll)
+	defer tick.Stop()
+	for {
+		reached := make([]bool, len(pending))
+		scatter(len(pending), func(k int) error {
+			reached[k] = db.reached(ctx, q.Reached, ts, pending[k])
+			return nil
+		})
+
+		var left []int
+		for k, i := range pending {
+			if !reached[k] {
+				left = append(left, i)
+			}
+		}
+		if pending = left; len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("nap: slaves %v not caught up: %w", pending, ctx.Err())
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package nap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseToken(t *testing.T) {
+	tok := Token{pos: "0/16B3748"}
+	parsed, err := ParseToken(tok.String())
+	if err != nil || parsed != tok {
+		t.Errorf("Unexpected parsed token: %+v, %v", parsed, err)
+	}
+
+	if parsed, err = ParseToken(""); err != nil || parsed != (Token{}) || parsed.String() != "" {
+		t.Errorf("Unexpected parsed empty token: %+v, %v", parsed, err)
+	}
+
+	for _, invalid := range []string{"0/16B3748", "2.MC8x", "1.!!", "1."} {
+		if _, err = ParseToken(invalid); err != ErrInvalidToken {
+			t.Errorf("Want ErrInvalidToken for %q, got: %v", invalid, err)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err = db.Token(ctx); err == nil {
+		t.Error("Token taken without token queries")
+	}
+
+	db.SetMaxOpenConns(1)
+	for i, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE pos (lsn INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pdb.Exec("INSERT INTO pos VALUES (?)", []int{10, 5, 10}[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.SetTokenQueries(TokenQueries{
+		Position: "SELECT CAST(lsn AS TEXT) FROM pos",
+		Reached:  "SELECT CAST(? AS INTEGER) <= lsn FROM pos",
+	})
+
+	tok, err := db.Token(ctx)
+	if err != nil || tok.pos != "10" {
+		t.Fatalf("Unexpected token: %+v, %v", tok, err)
+	}
+
+	if tok, err = ParseToken(tok.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	tctx := WithToken(ctx, tok)
+	for i := 0; i < 4; i++ {
+		if n := db.readIndex(tctx); n != 2 {
+			t.Fatalf("Read routed to %d instead of the slave which reached the token", n)
+		}
+	}
+
+	db.topology().pdbs[2].Exec("UPDATE pos SET lsn = 1")
+	if n := db.readIndex(tctx); n != 2 {
+		t.Errorf("Read routed to %d although the slave was known to reach the token", n)
+	}
+
+	if n := db.readIndex(WithToken(ctx, tok)); n != 0 {
+		t.Errorf("Read routed to %d instead of falling back to the master", n)
+	}
+
+	db.topology().pdbs[1].Exec("UPDATE pos SET lsn = 11")
+	if n := db.readIndex(WithToken(ctx, tok)); n != 1 {
+		t.Errorf("Read routed to %d instead of the slave which caught up", n)
+	}
+
+	if WithToken(ctx, Token{}) != ctx {
+		t.Error("Empty token attached")
+	}
+}
+
+func TestWaitForReplication(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	tok := Token{pos: "10"}
+	if err = db.WaitForReplication(ctx, Token{}); err != nil {
+		t.Errorf("Waited for the empty token: %v", err)
+	}
+	if err = db.WaitForReplication(ctx, tok); err == nil {
+		t.Error("Waited without token queries")
+	}
+
+	db.SetMaxOpenConns(1)
+	for i, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE pos (lsn INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pdb.Exec("INSERT INTO pos VALUES (?)", []int{10, 5, 10}[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.SetTokenQueries(TokenQueries{
+		Position: "SELECT CAST(lsn AS TEXT) FROM pos",
+		Reached:  "SELECT CAST(? AS INTEGER) <= lsn FROM pos",
+	})
+
+	if err = db.WaitForReplication(ctx, tok, 3); err == nil {
+		t.Error("Waited for a slave out of range")
+	}
+	if err = db.WaitForReplication(ctx, tok, 2); err != nil {
+		t.Errorf("Unexpected error waiting for the slave caught up: %v", err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	err = db.WaitForReplication(tctx, tok)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "[1]") {
+		t.Errorf("Unexpected error waiting for a lagging slave: %v", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		db.topology().pdbs[1].Exec("UPDATE pos SET lsn = 11")
+	}()
+	start := time.Now()
+	if err = db.WaitForReplication(ctx, tok); err != nil {
+		t.Errorf("Unexpected error waiting for the slave catching up: %v", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("Returned before the slave caught up")
+	}
+}
@@ -0,0 +1,176 @@
+package nap
+
+import "database/sql"
+
+// topology is an immutable snapshot of the physical dbs of a DB along with
+// their per node state. Topology mutations are serialized by updateTopology,
+// which replaces the snapshot as a whole, so that reads load it without
+// taking any lock and never observe a partially updated topology.
+type topology struct {
+	pdbs       []*sql.DB    // Physical databases, the first one being the master
+	connectors []*connector // Connectors of the physical databases opened by nap
+	healths    []*health    // Health signal of each physical db
+	rtts       []*rtt       // Round trip time of each physical db
+	labels     []Labels     // Labels of each physical db
+	weights    []int        // Weight of each physical db
+	schedule   []int        // Weighted schedule of the slaves, once weights are set
+}
+
+// newTopology returns the topology of pdbs, opened with connectors unless nil,
+// with their per node state reset.
+func newTopology(pdbs []*sql.DB, connectors []*connector) *topology {
+	t := &topology{
+		pdbs:       pdbs,
+		connectors: connectors,
+		healths:    make([]*health, len(pdbs)),
+		rtts:       make([]*rtt, len(pdbs)),
+		labels:     make([]Labels, len(pdbs)),
+		weights:    make([]int, len(pdbs)),
+	}
+
+	if t.connectors == nil {
+		t.connectors = make([]*connector, len(pdbs))
+	}
+
+	for i := range pdbs {
+		t.healths[i] = &health{rate: 1}
+		t.rtts[i] = &rtt{}
+		t.labels[i] = roleLabels(i)
+		t.weights[i] = 1
+	}
+	return t
+}
+
+// emptyTopology is the topology of a DB not opened yet.
+var emptyTopology = newTopology(nil, nil)
+
+// clone returns a copy of t whose slices may be modified.
+func (t *topology) clone() *topology {
+	return &topology{
+		pdbs:       append([]*sql.DB(nil), t.pdbs...),
+		connectors: append([]*connector(nil), t.connectors...),
+		healths:    append([]*health(nil), t.healths...),
+		rtts:       append([]*rtt(nil), t.rtts...),
+		labels:     append([]Labels(nil), t.labels...),
+		weights:    append([]int(nil), t.weights...),
+		schedule:   t.schedule,
+	}
+}
+
+// add appends the slave pdb, opened with c unless nil, with its per node
+// state reset.
+func (t *topology) add(pdb *sql.DB, c *connector) {
+	t.pdbs = append(t.pdbs, pdb)
+	t.connectors = append(t.connectors, c)
+	t.healths = append(t.healths, &health{rate: 1})
+	t.rtts = append(t.rtts, &rtt{})
+	t.labels = append(t.labels, roleLabels(len(t.pdbs)-1))
+	t.weights = append(t.weights, 1)
+	t.reschedule()
+}
+
+// remove removes the slave at index i, shifting the following ones.
+func (t *topology) remove(i int) {
+	t.pdbs = append(t.pdbs[:i], t.pdbs[i+1:]...)
+	t.connectors = append(t.connectors[:i], t.connectors[i+1:]...)
+	t.healths = append(t.healths[:i], t.healths[i+1:]...)
+	t.rtts = append(t.rtts[:i], t.rtts[i+1:]...)
+	t.labels = append(t.labels[:i], t.labels[i+1:]...)
+	t.weights = append(t.weights[:i], t.weights[i+1:]...)
+	t.reschedule()
+}
+
+// reschedule computes the weighted schedule again, once weights are set.
+func (t *topology) reschedule() {
+	if t.schedule != nil {
+		t.schedule = smoothWeighted(slaveWeights(t.weights))
+	}
+}
+
+// pdb returns the physical db at index i, or the master if i is out of
+// range, such as for an index of a previous topology, or nil if t is empty.
+func (t *topology) pdb(i int) *sql.DB {
+	switch {
+	case i >= 0 && i < len(t.pdbs):
+		return t.pdbs[i]
+	case len(t.pdbs) > 0:
+		return t.pdbs[0]
+	}
+	return nil
+}
+
+// topology returns a snapshot of the current topology. Operations routed
+// across several steps should load it once so that they see indexes of
+// the same topology.
+func (db *DB) topology() *topology {
+	if t, _ := db.topo.Load().(*topology); t != nil {
+		return t
+	}
+	return emptyTopology
+}
+
+// updateTopology replaces the topology by the one fn derives from a copy
+// of the current one, unless fn fails. Updates are serialized, and since
+// prepared statements are bound to the physical dbs they were prepared
+// on, they are invalidated once the physical dbs change.
+func (db *DB) updateTopology(fn func(t *topology) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old := db.topology()
+	t := old.clone()
+	if err := fn(t); err != nil {
+		return err
+	}
+
+	db.topo.Store(t)
+	if !samePDBs(old.pdbs, t.pdbs) {
+		db.InvalidateStatements()
+		db.reindex(old.pdbs, t.pdbs)
+	}
+	return nil
+}
+
+// reindex moves the flight record, utilization and fairness counts of the
+// physical dbs of old to their indexes in pdbs, rolling back the rollout
+// in progress, if any. db.mu must be held.
+func (db *DB) reindex(old, pdbs []*sql.DB) {
+	indexes := make(map[*sql.DB]int, len(old))
+	for j, pdb := range old {
+		indexes[pdb] = j
+	}
+
+	from := make([]int, len(pdbs))
+	for i, pdb := range pdbs {
+		if j, ok := indexes[pdb]; ok {
+			from[i] = j
+		} else {
+			from[i] = -1
+		}
+	}
+
+	if r, _ := db.recorder.Load().(*recorder); r != nil {
+		db.recorder.Store(r.reindexed(from))
+	}
+	if u, _ := db.usage.Load().(*utilization); u != nil {
+		db.usage.Store(u.reindexed(from))
+	}
+	if f, _ := db.fairness.Load().(*fairness); f != nil {
+		db.fairness.Store(f.reindexed(from))
+	}
+
+	// The weights and error counts of rollouts are by index.
+	db.abortRollout()
+}
+
+func samePDBs(a, b []*sql.DB) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpdateTopology(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetLabels(1, Labels{"zone": "eu"})
+	old, gen := db.topology(), db.statementGeneration()
+
+	err = db.updateTopology(func(t *topology) error {
+		t.pdbs = t.pdbs[:1]
+		return errors.New("failed")
+	})
+	if err == nil || db.topology() != old || db.statementGeneration() != gen {
+		t.Fatalf("Failed update applied: %v", err)
+	}
+
+	db.SetWeight(1, 2)
+	if db.statementGeneration() != gen {
+		t.Error("Statements invalidated without physical dbs changes")
+	}
+	if len(old.pdbs) != 2 || old.weights[1] != 1 || db.Labels(1)["zone"] != "eu" {
+		t.Error("Previous topology modified by an update")
+	}
+
+	pdb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+
+	db.updateTopology(func(t *topology) error {
+		next := newTopology(append(t.pdbs, pdb), append(t.connectors, nil))
+		copy(next.labels, t.labels)
+		*t = *next
+		return nil
+	})
+
+	if n := len(db.topology().pdbs); n != 3 || db.statementGeneration() == gen {
+		t.Errorf("Physical db not added: %d, generation %d", n, db.statementGeneration())
+	}
+	if db.Labels(1)["zone"] != "eu" || db.Labels(2)["role"] != "slave" || db.Weight(2) != 1 {
+		t.Errorf("Unexpected per node state of the new topology: %v, %v", db.Labels(1), db.Labels(2))
+	}
+}
+
+func TestTopologyOutOfRange(t *testing.T) {
+	db := wrap(make([]*sql.DB, 2), nil)
+	if db.Healthy(5) || db.Weight(5) != 1 || db.Labels(5)["role"] != "slave" || db.RTT(5) != 0 {
+		t.Error("Unexpected state of a physical db out of the topology")
+	}
+
+	db.health(5).observe(DefaultHealthPolicy, true)
+	if db.Healthy(5) {
+		t.Error("Physical db out of the topology put in rotation")
+	}
+
+	if db.topology().pdb(5) != db.topology().pdbs[0] {
+		t.Error("Index out of the topology not served by the master")
+	}
+}
+
+func TestTopologyStress(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	spare := db.topology().pdbs[2]
+	var stop int32
+	var mutator, readers sync.WaitGroup
+
+	mutator.Add(1)
+	go func() {
+		defer mutator.Done()
+		for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+			db.updateTopology(func(t *topology) error {
+				if len(t.pdbs) == 3 {
+					*t = *newTopology(t.pdbs[:2], t.connectors[:2])
+				} else {
+					*t = *newTopology(append(t.pdbs, spare), append(t.connectors, nil))
+				}
+				return nil
+			})
+			db.SetWeight(1+i%2, i%3)
+			db.SetLabels(1, Labels{"zone": "eu"})
+		}
+	}()
+
+	errs := make(chan error, 4)
+	for c := 0; c < 4; c++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; i < 200; i++ {
+				var n int
+				if err := db.QueryRow("SELECT 1").Scan(&n); err != nil {
+					errs <- err
+					return
+				}
+				if err := stmt.QueryRow().Scan(&n); err != nil {
+					errs <- err
+					return
+				}
+
+				db.BalancerState()
+				db.readIndex(WithSelector(context.Background(), MustParseSelector("zone=eu")))
+			}
+		}()
+	}
+
+	readers.Wait()
+	atomic.StoreInt32(&stop, 1)
+	mutator.Wait()
+
+	close(errs)
+	for err := range errs {
+		t.Errorf("Read failed during topology changes: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package nap
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// TrafficShift is a shift of reads in progress, such as to a slave
+// readmitted by the health subsystem or to a BalancerChange rolled out.
+type TrafficShift struct {
+	Node    int           // Index of the slave the reads shift to, or -1 for a BalancerChange
+	Elapsed time.Duration // Time since the shift started
+	Jitter  float64       // Random in [-1, 1], drawn once per shift
+}
+
+// TrafficPolicy decides how reads shift over time, such as ramping up
+// linearly or by steps. Implementations must be safe for concurrent use.
+type TrafficPolicy interface {
+	// Share returns the share of the reads shifted, from 0 to 1. The shift
+	// is over once the share reaches 1.
+	Share(s TrafficShift) float64
+}
+
+// TrafficFunc is a TrafficPolicy function.
+type TrafficFunc func(s TrafficShift) float64
+
+// Share implements TrafficPolicy.
+func (f TrafficFunc) Share(s TrafficShift) float64 {
+	return f(s)
+}
+
+// LinearRamp is a TrafficPolicy shifting reads linearly over Duration,
+// randomly stretched or shrunk for each shift by up to the Jitter fraction,
+// such as 0.2 for 20%, so that shifts starting together don't progress in
+// lockstep. If Duration <= 0, reads shift instantly.
+type LinearRamp struct {
+	Duration time.Duration
+	Jitter   float64
+}
+
+// Share implements TrafficPolicy.
+func (r LinearRamp) Share(s TrafficShift) float64 {
+	d := float64(r.Duration) * (1 + math.Max(0, math.Min(r.Jitter, 1))*s.Jitter)
+	if d <= 0 {
+		return 1
+	}
+	return float64(s.Elapsed) / d
+}
+
+// StepRamp is a TrafficPolicy shifting reads by steps, such as canaries
+// getting 1%, then 10% and 50% of the reads, each step lasting Interval,
+// until all reads shift after the last.
+type StepRamp struct {
+	Steps    []float64 // Shares of the reads of the steps
+	Interval time.Duration
+}
+
+// Share implements TrafficPolicy.
+func (r StepRamp) Share(s TrafficShift) float64 {
+	if r.Interval <= 0 || s.Elapsed < 0 {
+		return 1
+	}
+	if k := s.Elapsed / r.Interval; k < time.Duration(len(r.Steps)) {
+		return r.Steps[k]
+	}
+	return 1
+}
+
+// trafficPolicy wraps TrafficPolicies, so that differently typed policies
+// can be stored in the same atomic.Value.
+type trafficPolicy struct {
+	TrafficPolicy
+}
+
+// share returns the share of the reads shifted by p during s, from 0 to 1,
+// or 1 if p is nil.
+func share(p TrafficPolicy, s TrafficShift) float64 {
+	if p == nil {
+		return 1
+	}
+	return math.Max(0, math.Min(p.Share(s), 1))
+}
+
+// shifted reports whether a read shifts during s, at random with the share
+// of the reads p shifted.
+func shifted(p TrafficPolicy, s TrafficShift) bool {
+	sh := share(p, s)
+	return sh >= 1 || rand.Float64() < sh
+}
@@ -0,0 +1,102 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLinearRamp(t *testing.T) {
+	r := LinearRamp{Duration: time.Hour, Jitter: 0.5}
+	for _, c := range []struct {
+		s    TrafficShift
+		want float64
+	}{
+		{TrafficShift{Elapsed: 0}, 0},
+		{TrafficShift{Elapsed: 30 * time.Minute}, 0.5},
+		{TrafficShift{Elapsed: 30 * time.Minute, Jitter: 1}, 1.0 / 3},
+		{TrafficShift{Elapsed: 30 * time.Minute, Jitter: -1}, 1},
+		{TrafficShift{Elapsed: 2 * time.Hour}, 1},
+	} {
+		if got := share(r, c.s); got < c.want-1e-9 || got > c.want+1e-9 {
+			t.Errorf("Share of %+v: %v, want %v", c.s, got, c.want)
+		}
+	}
+
+	if got := share(LinearRamp{}, TrafficShift{}); got != 1 {
+		t.Errorf("Reads not shifted instantly without duration: %v", got)
+	}
+}
+
+func TestStepRamp(t *testing.T) {
+	r := StepRamp{Steps: []float64{0.01, 0.1, 0.5}, Interval: time.Minute}
+	for elapsed, want := range map[time.Duration]float64{
+		0:                0.01,
+		90 * time.Second: 0.1,
+		2 * time.Minute:  0.5,
+		3 * time.Minute:  1,
+	} {
+		if got := share(r, TrafficShift{Elapsed: elapsed}); got != want {
+			t.Errorf("Share after %s: %v, want %v", elapsed, got, want)
+		}
+	}
+}
+
+func TestFailbackPolicy(t *testing.T) {
+	db := wrap(make([]*sql.DB, 3), nil)
+	db.SetLabels(1, Labels{"zone": "eu"})
+	ctx := WithSelector(context.Background(), MustParseSelector("zone=eu"))
+
+	h := db.health(1)
+	for h.observe(DefaultHealthPolicy, false); db.Healthy(1); h.observe(DefaultHealthPolicy, false) {
+	}
+	for !db.Healthy(1) {
+		h.observe(DefaultHealthPolicy, true)
+	}
+
+	var shifts []TrafficShift
+	db.SetFailbackPolicy(TrafficFunc(func(s TrafficShift) float64 {
+		shifts = append(shifts, s)
+		return 0
+	}))
+	if i := db.readIndex(ctx); i == 1 {
+		t.Fatal("Read shifted back against the policy")
+	}
+	if len(shifts) != 1 || shifts[0].Node != 1 || shifts[0].Elapsed <= 0 || shifts[0].Elapsed > time.Minute ||
+		shifts[0].Jitter != math.Float64frombits(atomic.LoadUint64(&h.jitter)) {
+		t.Errorf("Unexpected shifts: %+v", shifts)
+	}
+
+	db.SetFailbackPolicy(StepRamp{Steps: []float64{0}, Interval: time.Hour})
+	atomic.StoreInt64(&h.readmitted, time.Now().Add(-2*time.Hour).UnixNano())
+	if i := db.readIndex(ctx); i != 1 {
+		t.Errorf("Read not shifted back once stepped up: %d", i)
+	}
+}
+
+func TestRollOutTraffic(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetBalancerPolicy(firstPolicy{})
+	db.RollOut(BalancerChange{Policy: lastPolicy{}}, Rollout{
+		Ramp:    time.Hour,
+		Traffic: StepRamp{Steps: []float64{0}, Interval: 20 * time.Millisecond},
+	})
+	for k := 0; k < 10; k++ {
+		if row := db.QueryRow("SELECT 1"); row.Scan(new(int)) != nil || row.Node() != 1 {
+			t.Fatalf("Read on %d before the first step", row.Node())
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if s, _ := db.Rollout(); !s.Done || s.Progress != 1 {
+		t.Errorf("Unexpected rollout status once stepped up: %+v", s)
+	}
+}
@@ -0,0 +1,177 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTooManyTx is returned by transactions refused because too many are in
+// flight on the master.
+var ErrTooManyTx = errors.New("nap: too many transactions in flight")
+
+// TxLimitError describes a transaction refused by SetMaxInFlightTx.
+// It matches ErrTooManyTx with errors.Is.
+type TxLimitError struct {
+	Limit  int           // Max transactions in flight
+	Waited time.Duration // Time spent queued before the refusal
+}
+
+// Error implements the error interface.
+func (e *TxLimitError) Error() string {
+	return fmt.Sprintf("%s: %d in flight after %s", ErrTooManyTx, e.Limit, e.Waited)
+}
+
+// Is reports whether target is ErrTooManyTx.
+func (e *TxLimitError) Is(target error) bool {
+	return target == ErrTooManyTx
+}
+
+// TxStats are the stats of the transactions in flight on the master.
+type TxStats struct {
+	InFlight int    // Transactions begun and not yet committed or rolled back
+	Queued   int    // Transactions waiting for one in flight to end
+	Rejected uint64 // Transactions refused with a *TxLimitError
+}
+
+// txLimit tracks the transactions in flight on the master.
+type txLimit struct {
+	mu       sync.Mutex
+	max      int
+	wait     time.Duration
+	inFlight int
+	queued   int
+	freed    chan struct{} // Closed when a transaction ends
+	rejected uint64
+}
+
+type txSlotKey struct{}
+
+// txSlot is the place of a transaction in flight, freed once.
+type txSlot struct {
+	once    sync.Once
+	limit   *txLimit
+	claimed bool // Set by the connection freeing the slot once the transaction ends
+}
+
+// SetMaxInFlightTx caps the transactions in flight on the master, begun and
+// not yet committed or rolled back, to n, such that a slow dependency called
+// from within application transactions can't pile them up on the master.
+// Transactions past the cap are queued for at most wait, until one in flight
+// ends, then refused with a *TxLimitError. If wait <= 0, they are refused
+// right away. If n <= 0, transactions are uncapped, which is the default.
+// Read-only transactions aren't capped, unless they go to the master. Only
+// the transactions of physical dbs opened by nap are capped and counted,
+// since those of wrapped ones can't be told apart once they end.
+func (db *DB) SetMaxInFlightTx(n int, wait time.Duration) {
+	db.txs.mu.Lock()
+	db.txs.max, db.txs.wait = n, wait
+	db.txs.notify()
+	db.txs.mu.Unlock()
+}
+
+// TxStats returns the stats of the transactions in flight on the master.
+func (db *DB) TxStats() TxStats {
+	db.txs.mu.Lock()
+	defer db.txs.mu.Unlock()
+	return TxStats{InFlight: db.txs.inFlight, Queued: db.txs.queued, Rejected: db.txs.rejected}
+}
+
+// acquire admits a transaction with ctx, returning a copy of ctx holding its
+// slot, which connections free once it ends.
+func (l *txLimit) acquire(ctx context.Context) (context.Context, *txSlot, error) {
+	start := time.Now()
+	l.mu.Lock()
+	for l.max > 0 && l.inFlight >= l.max {
+		waited := time.Since(start)
+		if waited >= l.wait {
+			l.rejected++
+			err := &TxLimitError{Limit: l.max, Waited: waited}
+			l.mu.Unlock()
+			return nil, nil, err
+		}
+
+		if l.freed == nil {
+			l.freed = make(chan struct{})
+		}
+		freed, timer := l.freed, time.NewTimer(l.wait-waited)
+		l.queued++
+		l.mu.Unlock()
+
+		var err error
+		select {
+		case <-freed:
+		case <-timer.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		timer.Stop()
+
+		l.mu.Lock()
+		l.queued--
+		if err != nil {
+			l.mu.Unlock()
+			return nil, nil, err
+		}
+	}
+	l.inFlight++
+	l.mu.Unlock()
+
+	slot := &txSlot{limit: l}
+	return context.WithValue(ctx, txSlotKey{}, slot), slot, nil
+}
+
+// notify wakes up the queued transactions. l.mu must be held.
+func (l *txLimit) notify() {
+	if l.freed != nil {
+		close(l.freed)
+		l.freed = nil
+	}
+}
+
+// release frees the slot of s, once.
+func (s *txSlot) release() {
+	s.once.Do(func() {
+		s.limit.mu.Lock()
+		s.limit.inFlight--
+		s.limit.notify()
+		s.limit.mu.Unlock()
+	})
+}
+
+// guardedTx is a driver transaction freeing its slot once it ends.
+type guardedTx struct {
+	driver.Tx
+	slot *txSlot
+}
+
+// Commit implements the driver.Tx interface.
+func (t *guardedTx) Commit() error {
+	defer t.slot.release()
+	return t.Tx.Commit()
+}
+
+// Rollback implements the driver.Tx interface.
+func (t *guardedTx) Rollback() error {
+	defer t.slot.release()
+	return t.Tx.Rollback()
+}
+
+// beginMaster begins a transaction on the master once admitted by the cap
+// of transactions in flight.
+func (db *DB) beginMaster(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tctx, slot, err := db.txs.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.lanePDB(ctx, 0).BeginTx(tctx, opts)
+	if err != nil || !slot.claimed {
+		slot.release()
+	}
+	return tx, err
+}
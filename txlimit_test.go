@@ -0,0 +1,98 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxInFlightTx(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := db.TxStats(); s.InFlight != 1 {
+		t.Errorf("Want 1 transaction in flight, got: %+v", s)
+	}
+
+	db.SetMaxInFlightTx(1, 0)
+	_, err = db.Begin()
+	var limitErr *TxLimitError
+	if !errors.Is(err, ErrTooManyTx) || !errors.As(err, &limitErr) || limitErr.Limit != 1 {
+		t.Errorf("Want a *TxLimitError, got: %v", err)
+	}
+	if _, err = db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true}); !errors.Is(err, ErrTooManyTx) {
+		t.Errorf("Want ErrTooManyTx for a read-only transaction on the master, got: %v", err)
+	}
+
+	// Queued transactions begin once one in flight ends.
+	db.SetMaxInFlightTx(1, time.Second)
+	go func() {
+		for db.TxStats().Queued == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		tx.Commit()
+	}()
+
+	if tx, err = db.Begin(); err != nil {
+		t.Fatalf("Queued transaction failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = db.BeginTx(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("Want context.DeadlineExceeded, got: %v", err)
+	}
+
+	db.SetMaxInFlightTx(1, 10*time.Millisecond)
+	if _, err = db.Begin(); !errors.Is(err, ErrTooManyTx) || !errors.As(err, &limitErr) || limitErr.Waited < 10*time.Millisecond {
+		t.Errorf("Want a *TxLimitError once queued, got: %v", err)
+	}
+
+	tx.Rollback()
+	if s := db.TxStats(); s.InFlight != 0 || s.Queued != 0 || s.Rejected != 3 {
+		t.Errorf("Unexpected transaction stats: %+v", s)
+	}
+
+	db.SetMaxInFlightTx(0, 0)
+	for k := 0; k < 2; k++ {
+		if tx, err = db.Begin(); err != nil {
+			t.Fatalf("Uncapped transaction failed: %v", err)
+		}
+		defer tx.Rollback()
+	}
+}
+
+func TestMaxInFlightTxWrapped(t *testing.T) {
+	pdb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Wrap(pdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxInFlightTx(2, 0)
+	for k := 0; k < 3; k++ {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Transaction of a wrapped DB refused: %v", err)
+		}
+		if err = tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := db.TxStats(); s.InFlight != 0 {
+		t.Errorf("Transactions of a wrapped DB left in flight: %+v", s)
+	}
+}
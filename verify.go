@@ -0,0 +1,104 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+)
+
+// verifyConcurrency bounds the statements VerifyStatements prepares at once
+// on each physical db.
+const verifyConcurrency = 4
+
+// BrokenStatement is a prepared statement which failed to prepare on
+// a physical db.
+type BrokenStatement struct {
+	Query string
+	Node  int // Index of the physical db
+	Err   error
+}
+
+// VerifyStatements prepares again every open statement prepared with
+// Prepare on every healthy physical db, concurrently with at most four
+// statements prepared at once on each, and returns those
+// which failed, ordered by query then index, or nil if none did, such as in
+// deploy smoke tests after schema changes. Logical replicas on which a
+// statement wasn't prepared in the first place aren't checked again.
+// The statements in use are left untouched.
+func (db *DB) VerifyStatements(ctx context.Context) []BrokenStatement {
+	pdbs := db.topology().pdbs
+
+	var queries []string
+	eligible := map[string][]bool{}
+	db.stmts.Range(func(k, _ interface{}) bool {
+		s := k.(*Stmt)
+		nodes, ok := eligible[s.query]
+		if !ok {
+			queries = append(queries, s.query)
+			nodes = make([]bool, len(pdbs))
+			eligible[s.query] = nodes
+		}
+
+		for i := range nodes {
+			nodes[i] = nodes[i] || s.Eligible(i)
+		}
+		return true
+	})
+	sort.Strings(queries)
+
+	errs := make([][]error, len(queries))
+	for q := range errs {
+		errs[q] = make([]error, len(pdbs))
+	}
+
+	scatter(len(pdbs), func(i int) error {
+		if !db.inRotation(i) {
+			return nil
+		}
+
+		sem := make(chan struct{}, verifyConcurrency)
+		var wg sync.WaitGroup
+		for q, query := range queries {
+			if db.LogicalReplica(i) && !eligible[query][i] {
+				continue
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[q][i] = ctx.Err()
+				continue
+			}
+
+			wg.Add(1)
+			go func(q int, query string) {
+				defer func() { <-sem; wg.Done() }()
+				errs[q][i] = db.verifyStatement(ctx, pdbs[i], query)
+			}(q, query)
+		}
+		wg.Wait()
+		return nil
+	})
+
+	var broken []BrokenStatement
+	for q, nodes := range errs {
+		for i, err := range nodes {
+			if err != nil {
+				broken = append(broken, BrokenStatement{Query: queries[q], Node: i, Err: err})
+			}
+		}
+	}
+	return broken
+}
+
+// verifyStatement prepares query again on pdb, returning the error
+// preparing it.
+func (db *DB) verifyStatement(ctx context.Context, pdb *sql.DB, query string) error {
+	stmt, err := pdb.PrepareContext(ctx, db.preparedSQL(query))
+	if err != nil {
+		return err
+	}
+	stmt.Close()
+	return nil
+}
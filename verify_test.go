@@ -0,0 +1,130 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// preparingDriver opens SQLite in-memory connections whose prepares take
+// a while, tracking how many run at once.
+type preparingDriver struct {
+	running int32 // Accessed atomically
+	max     int32 // Accessed atomically
+}
+
+func (d *preparingDriver) Open(name string) (driver.Conn, error) {
+	c, err := (&sqlite3.SQLiteDriver{}).Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return preparingConn{c.(*sqlite3.SQLiteConn), d}, nil
+}
+
+type preparingConn struct {
+	*sqlite3.SQLiteConn
+	driver *preparingDriver
+}
+
+func (c preparingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	n := atomic.AddInt32(&c.driver.running, 1)
+	defer atomic.AddInt32(&c.driver.running, -1)
+	for max := atomic.LoadInt32(&c.driver.max); n > max && !atomic.CompareAndSwapInt32(&c.driver.max, max, n); {
+		max = atomic.LoadInt32(&c.driver.max)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	return c.SQLiteConn.PrepareContext(ctx, query)
+}
+
+var preparing = &preparingDriver{}
+
+func init() {
+	sql.Register("sqlite3_preparing", preparing)
+}
+
+func TestVerifyStatements(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	for _, pdb := range db.topology().pdbs {
+		if _, err = pdb.Exec("CREATE TABLE t (id INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queries := []string{"SELECT id FROM t", "SELECT id FROM t WHERE id = ?", "SELECT 1"}
+	for _, q := range queries {
+		stmt, err := db.Prepare(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stmt.Close()
+	}
+
+	ctx := context.Background()
+	if broken := db.VerifyStatements(ctx); broken != nil {
+		t.Fatalf("Unexpected broken statements: %+v", broken)
+	}
+
+	if _, err = db.topology().pdb(2).Exec("DROP TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := db.VerifyStatements(ctx)
+	if len(broken) != 2 {
+		t.Fatalf("Unexpected broken statements: %+v", broken)
+	}
+
+	for k, want := range []string{"SELECT id FROM t", "SELECT id FROM t WHERE id = ?"} {
+		if b := broken[k]; b.Query != want || b.Node != 2 || b.Err == nil {
+			t.Errorf("Unexpected broken statement %d: %+v", k, b)
+		}
+	}
+
+	db.StartDrill(Drill{SlavesDown: []int{2}})
+	if broken := db.VerifyStatements(ctx); broken != nil {
+		t.Errorf("Unhealthy physical dbs verified: %+v", broken)
+	}
+	db.StopDrill()
+
+	stmt, _ := db.Prepare("SELECT 2")
+	stmt.Close()
+	if n := len(db.VerifyStatements(ctx)); n != 2 {
+		t.Errorf("Closed statements verified: %d broken", n)
+	}
+}
+
+func TestVerifyStatementsConcurrency(t *testing.T) {
+	db, err := Open("sqlite3_preparing", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for k := 0; k < 3*verifyConcurrency; k++ {
+		stmt, err := db.Prepare(fmt.Sprintf("SELECT %d", k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stmt.Close()
+	}
+
+	atomic.StoreInt32(&preparing.max, 0)
+	if broken := db.VerifyStatements(context.Background()); broken != nil {
+		t.Fatalf("Unexpected broken statements: %+v", broken)
+	}
+	if max := atomic.LoadInt32(&preparing.max); max > verifyConcurrency || max < 2 {
+		t.Errorf("Unexpected statements prepared at once: %d", max)
+	}
+}
@@ -0,0 +1,61 @@
+package nap
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// StmtRouting chooses how reads of prepared statements are routed,
+// while ad-hoc reads may always go to any eligible physical db.
+type StmtRouting uint8
+
+// Routing policies of prepared statements.
+const (
+	// StmtBalanced balances the reads of prepared statements like ad-hoc
+	// reads, across the eligible physical dbs they are prepared on.
+	StmtBalanced StmtRouting = iota
+
+	// StmtPreferWarm sends the reads of a prepared statement to the
+	// eligible physical dbs it already ran on since it was last prepared,
+	// balancing across them, so that statements stick to the physical dbs
+	// where they are warm instead of being prepared again on every
+	// connection of every physical db. A physical db is only warmed up
+	// when none is eligible, which suits statements with moderate rates
+	// against many replicas rather than the hottest ones. Reads whose
+	// consistency restricts where they go, such as those reading their
+	// writes or carrying a token, are routed like ad-hoc reads.
+	StmtPreferWarm
+)
+
+// SetStmtRouting sets the routing policy of prepared statements.
+// The default is StmtBalanced.
+func (db *DB) SetStmtRouting(r StmtRouting) {
+	atomic.StoreInt32(&db.stmtRoute, int32(r))
+}
+
+// warmIndex returns the index of the warm physical db a read of set with
+// ctx goes to, among the eligible ones, reporting false if there is none or
+// if set isn't routed with StmtPreferWarm.
+func (s *Stmt) warmIndex(ctx context.Context, set *stmtSet) (int, bool) {
+	if StmtRouting(atomic.LoadInt32(&s.db.stmtRoute)) != StmtPreferWarm {
+		return 0, false
+	}
+
+	for _, i := range s.db.readNodes(ctx) {
+		if set.isWarm(i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// warmUp marks the statement of set at index i as warm, once it ran.
+func (set *stmtSet) warmUp(i int) {
+	if i < len(set.warm) && atomic.LoadUint32(&set.warm[i]) == 0 {
+		atomic.StoreUint32(&set.warm[i], 1)
+	}
+}
+
+func (set *stmtSet) isWarm(i int) bool {
+	return i < len(set.warm) && set.stmts[i] != nil && atomic.LoadUint32(&set.warm[i]) != 0
+}
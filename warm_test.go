@@ -0,0 +1,98 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStmtPreferWarm(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	nodes := map[int]bool{}
+	for i := 0; i < 4; i++ {
+		row := stmt.QueryRow()
+		row.Scan(new(int))
+		nodes[row.Node()] = true
+	}
+
+	if len(nodes) != 2 {
+		t.Errorf("Balanced statement not spread across slaves: %v", nodes)
+	}
+
+	db.SetStmtRouting(StmtPreferWarm)
+	db.InvalidateStatements()
+
+	row := stmt.QueryRow()
+	if err = row.Scan(new(int)); err != nil {
+		t.Fatal(err)
+	}
+
+	warm := row.Node()
+	for i := 0; i < 4; i++ {
+		rows, err := stmt.Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+
+		if rows.Node() != warm {
+			t.Errorf("Read of warm statement not routed to %d, got: %d", warm, rows.Node())
+		}
+	}
+
+	other := 3 - warm
+	row = stmt.QueryRowContext(WithNode(context.Background(), other))
+	if row.Scan(new(int)); row.Node() != other {
+		t.Errorf("Read directed to a node routed to the warm one: %d", row.Node())
+	}
+
+	nodes = map[int]bool{}
+	for i := 0; i < 4; i++ {
+		row := stmt.QueryRow()
+		row.Scan(new(int))
+		nodes[row.Node()] = true
+	}
+
+	if len(nodes) != 2 {
+		t.Errorf("Reads not balanced across warm slaves: %v", nodes)
+	}
+}
+
+func TestStmtPreferWarmConsistency(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetStmtRouting(StmtPreferWarm)
+	stmt, err := db.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	row := stmt.QueryRow()
+	if err = row.Scan(new(int)); err != nil || row.Node() == 0 {
+		t.Fatalf("Read of statement not routed to a slave: %d, %v", row.Node(), err)
+	}
+
+	db.SetConsistency(ReadYourWrites, time.Hour)
+	if _, err = db.Exec("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if row = stmt.QueryRow(); row.Scan(new(int)) != nil || row.Node() != 0 {
+		t.Errorf("Read of warm statement following a write routed to %d", row.Node())
+	}
+}
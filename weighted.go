@@ -0,0 +1,105 @@
+package nap
+
+import "sync/atomic"
+
+// maxSchedule bounds the length of a weighted schedule. Larger weights are
+// scaled down, approximating their ratios.
+const maxSchedule = 1024
+
+// smoothWeighted returns the smooth weighted round-robin schedule of the
+// physical dbs with the given weights, as implemented by nginx: a period in
+// which each physical db appears in proportion to its weight, interleaved
+// as evenly as possible rather than in bursts. Physical dbs with a zero
+// weight don't appear, so the schedule is empty if all weights are zero.
+//
+// Since the schedule is computed once per weights change, picking from it
+// only takes an atomic increment, without any state shared across reads.
+func smoothWeighted(weights []int) []int {
+	weights = reduceWeights(weights)
+
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	schedule := make([]int, 0, total)
+	current := make([]int, len(weights))
+	for len(schedule) < total {
+		best := -1
+		for i, w := range weights {
+			if w == 0 {
+				continue
+			}
+			if current[i] += w; best < 0 || current[i] > current[best] {
+				best = i
+			}
+		}
+
+		current[best] -= total
+		schedule = append(schedule, best)
+	}
+	return schedule
+}
+
+// reduceWeights divides weights by their greatest common divisor, scaling
+// them down further if their sum exceeds maxSchedule. Non-zero weights stay
+// non-zero.
+func reduceWeights(weights []int) []int {
+	d := 0
+	for _, w := range weights {
+		d = gcd(d, w)
+	}
+
+	reduced := make([]int, len(weights))
+	if d == 0 {
+		return reduced
+	}
+
+	total := 0
+	for i, w := range weights {
+		reduced[i] = w / d
+		total += reduced[i]
+	}
+
+	if total > maxSchedule {
+		for i, w := range reduced {
+			if w > 0 {
+				reduced[i] = w * maxSchedule / total
+				if reduced[i] == 0 {
+					reduced[i] = 1
+				}
+			}
+		}
+	}
+	return reduced
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// slaveWeights returns weights with the weight of the master zeroed, since
+// it isn't balanced against the slaves.
+func slaveWeights(weights []int) []int {
+	slaves := append([]int(nil), weights...)
+	if len(slaves) > 0 {
+		slaves[0] = 0
+	}
+	return slaves
+}
+
+// scheduled returns the next slave of schedule which is balanced, reporting
+// false if there is none.
+func (db *DB) scheduled(schedule []int) (int, bool) {
+	n := uint64(len(schedule))
+	c := atomic.AddUint64(&db.count, 1)
+	for k := uint64(0); k < n; k++ {
+		if i := schedule[(c+k)%n]; db.balanced(i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
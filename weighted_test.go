@@ -0,0 +1,104 @@
+package nap
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSmoothWeighted(t *testing.T) {
+	tests := []struct {
+		weights []int
+		want    []int
+	}{
+		{[]int{5, 1, 1}, []int{0, 0, 1, 0, 2, 0, 0}},
+		{[]int{0, 2, 2}, []int{1, 2}},
+		{[]int{0, 1, 3}, []int{2, 1, 2, 2}},
+		{[]int{0, 0, 0}, []int{}},
+	}
+
+	for _, tt := range tests {
+		if got := smoothWeighted(tt.weights); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Unexpected schedule of %v. Got: %v, Want: %v", tt.weights, got, tt.want)
+		}
+	}
+
+	if s := smoothWeighted([]int{0, 3000, 1000}); len(s) > maxSchedule || len(s) != 4 {
+		t.Errorf("Schedule not reduced: %d long", len(s))
+	}
+
+	s := smoothWeighted([]int{0, 100000, 1})
+	if len(s) > maxSchedule {
+		t.Errorf("Schedule not bounded: %d long", len(s))
+	}
+
+	counts := map[int]int{}
+	for _, i := range s {
+		counts[i]++
+	}
+	if counts[2] != 1 {
+		t.Errorf("Scaled down weight dropped: %v", counts)
+	}
+}
+
+func TestWeightedBalancing(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.BalancerState().Policy != "round-robin" {
+		t.Error("Unexpected policy without weights")
+	}
+
+	db.SetWeight(1, 1)
+	db.SetWeight(2, 3)
+
+	if db.BalancerState().Policy != "smooth-weighted" {
+		t.Error("Unexpected policy with weights")
+	}
+
+	const callers, reads = 8, 100
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	counts := make([]int, 3)
+	for c := 0; c < callers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]int, 3)
+			for i := 0; i < reads; i++ {
+				local[db.readIndex(context.Background())]++
+			}
+
+			mu.Lock()
+			for i, n := range local {
+				counts[i] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if counts[0] != 0 || counts[1] != callers*reads/4 || counts[2] != callers*reads*3/4 {
+		t.Errorf("Reads of concurrent callers not distributed by weight: %v", counts)
+	}
+
+	atomic.StoreInt32(&db.health(2).evicted, 1)
+	for i := 0; i < 4; i++ {
+		if node := db.readIndex(context.Background()); node != 1 {
+			t.Errorf("Read scheduled on a slave out of rotation: %d", node)
+		}
+	}
+
+	atomic.StoreInt32(&db.health(2).evicted, 0)
+	db.SetWeight(1, 0)
+	for i := 0; i < 4; i++ {
+		if node := db.readIndex(context.Background()); node != 2 {
+			t.Errorf("Read scheduled on a slave with a zero weight: %d", node)
+		}
+	}
+}